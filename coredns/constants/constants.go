@@ -25,4 +25,54 @@ const (
 	LabelIsHeadless          = "lighthouse.submariner.io/is-headless"
 	PublishNotReadyAddresses = "lighthouse.submariner.io/publish-not-ready-addresses"
 	GlobalnetEnabled         = "lighthouse.submariner.io/globalnet-enabled"
+
+	LoadBalancerWeightAnnotationPrefix = "lighthouse.submariner.io/serviceimport.weight"
+	LoadBalancerMinShareAnnotation     = "lighthouse.submariner.io/serviceimport.min-share"
+	MaxInFlightAnnotationPrefix        = "lighthouse.submariner.io/serviceimport.max-in-flight"
+	RecordTTLAnnotationPrefix          = "lighthouse.submariner.io/serviceimport.ttl"
+	RecordTTLAnnotation                = "lighthouse.submariner.io/ttl"
+	CostAnnotationPrefix               = "lighthouse.submariner.io/serviceimport.cost"
+	ClusterLabelsAnnotationPrefix      = "lighthouse.submariner.io/serviceimport.cluster-labels"
+	LoadBalancerPolicyAnnotation       = "lighthouse.submariner.io/serviceimport.loadbalancer-policy"
+	PortMergeAnnotation                = "lighthouse.submariner.io/serviceimport.port-merge"
+	RegionAnnotationPrefix             = "lighthouse.submariner.io/serviceimport.region"
+	PreferLocalAnnotation              = "lighthouse.submariner.io/serviceimport.prefer-local"
+	WeightByEndpointsAnnotation        = "lighthouse.submariner.io/weight-by-endpoints"
+	OnNoHealthyAnnotation              = "lighthouse.submariner.io/on-no-healthy"
+	OnAllDrainedAnnotation             = "lighthouse.submariner.io/on-all-drained"
+)
+
+// Values of the OnNoHealthyAnnotation. Services without the annotation return no record if no cluster is healthy.
+const (
+	OnNoHealthyReturnEmpty = "return-empty"
+	// OnNoHealthyServeLast returns the record of the highest weight cluster regardless of its health.
+	OnNoHealthyServeLast = "serve-last"
+)
+
+// Values of the OnAllDrainedAnnotation, which applies if every healthy cluster has a zero load balancing weight, eg due
+// to a traffic shift. Services without the annotation ignore the drain and select among the drained clusters.
+const (
+	OnAllDrainedIgnoreDrain = "ignore-drain"
+	OnAllDrainedReturnEmpty = "return-empty"
+	// OnAllDrainedServeLeastDrained returns the record of the drained cluster with the highest configured weight.
+	OnAllDrainedServeLeastDrained = "serve-least-drained"
+)
+
+// Values of the LoadBalancerPolicyAnnotation registered by the loadbalancer package. Services without the annotation use
+// the smooth weighted round robin policy. Other values name policies registered via loadbalancer.Register.
+const (
+	LoadBalancerPolicySmoothWeightedRR = "smooth-weighted-roundrobin"
+	LoadBalancerPolicyRoundRobin       = "roundrobin"
+	LoadBalancerPolicyRandom           = "random"
+	// LoadBalancerPolicyConsistentHash maps each client IP to the same cluster while that cluster remains available.
+	LoadBalancerPolicyConsistentHash = "consistent-hash"
+	// LoadBalancerPolicyMaglev is like LoadBalancerPolicyConsistentHash but spreads the client IPs more evenly.
+	LoadBalancerPolicyMaglev = "maglev"
+)
+
+// Values of the PortMergeAnnotation. Services without the annotation use the intersection strategy.
+const (
+	PortMergeIntersection = "intersection"
+	PortMergeUnion        = "union"
+	PortMergeFirst        = "first"
 )
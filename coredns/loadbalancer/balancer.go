@@ -18,6 +18,18 @@ limitations under the License.
 
 package loadbalancer
 
+import "errors"
+
+// Errors returned by Add for an item that can never be added as given, so retrying is futile.
+var (
+	ErrNilItem        = errors.New("item cannot be nil")
+	ErrNegativeWeight = errors.New("item weight cannot be negative")
+	ErrDuplicateItem  = errors.New("item already present")
+)
+
+// ErrItemNotFound is returned by Update for an item that isn't present.
+var ErrItemNotFound = errors.New("item not found")
+
 // Interface - general interface explaining the API of all load balancers available in the package.
 type Interface interface {
 	// Next returns next item accordingly or nil if none present.
@@ -31,3 +43,18 @@ type Interface interface {
 	// The number of items in this instance.
 	ItemCount() int
 }
+
+// Updater is implemented by load balancers that can change the weight of an item in place. Users of load balancers that
+// don't implement it have to remove all the items and add them again with the new weights.
+type Updater interface {
+	// Update changes the weight of the given item, if present, preserving the selection state of the items.
+	Update(item interface{}, weight int64) (err error)
+}
+
+// HashInterface is implemented by load balancers that can also select items deterministically from a hash key.
+type HashInterface interface {
+	Interface
+	// ItemsFor returns the items with a positive weight in their order of preference for the given key. The order for a
+	// key only changes for the items that are added or removed.
+	ItemsFor(key string) []interface{}
+}
@@ -0,0 +1,167 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+
+Copyright Contributors to the Submariner project.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package loadbalancer
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"sort"
+)
+
+// virtualNodesPerItem is the number of points on the ring of an item with the average weight.
+const virtualNodesPerItem = 100
+
+type ringPoint struct {
+	hash uint64
+	item interface{}
+}
+
+// Consistent hashing load balancer implementation. Selections without a key are delegated to a smooth weighted round
+// robin load balancer with the same items.
+type consistentHash struct {
+	*smoothWeightedRR
+	items     []*weightedItem
+	ring      []ringPoint
+	ringItems int
+	isDirty   bool
+}
+
+// NewConsistentHash returns a load balancer that maps keys to items via a hash ring on which each item has a number of
+// points proportional to its weight. Removing an item thus only remaps the keys that were mapped to it.
+func NewConsistentHash() HashInterface {
+	return &consistentHash{smoothWeightedRR: newSmoothWeightedRR()}
+}
+
+// Add - adds a new unique item to the list.
+func (lb *consistentHash) Add(item interface{}, weight int64) error {
+	if err := lb.smoothWeightedRR.Add(item, weight); err != nil {
+		return err
+	}
+
+	lb.items = append(lb.items, &weightedItem{item: item, weight: weight})
+	lb.isDirty = true
+
+	return nil
+}
+
+// Update - changes the weight of an item. The ring is rebuilt, which only remaps the keys of the item's added or removed
+// points.
+func (lb *consistentHash) Update(item interface{}, weight int64) error {
+	if err := lb.smoothWeightedRR.Update(item, weight); err != nil {
+		return err
+	}
+
+	for _, ringItem := range lb.items {
+		if ringItem.item == item {
+			ringItem.weight = weight
+		}
+	}
+
+	lb.isDirty = true
+
+	return nil
+}
+
+// RemoveAll - removes all items and reset state.
+func (lb *consistentHash) RemoveAll() {
+	lb.smoothWeightedRR.RemoveAll()
+	lb.items = lb.items[:0]
+	lb.ring = nil
+	lb.ringItems = 0
+	lb.isDirty = false
+}
+
+// ItemsFor - returns the distinct items in the order their points follow the key's hash on the ring.
+func (lb *consistentHash) ItemsFor(key string) []interface{} {
+	if lb.isDirty {
+		lb.buildRing()
+	}
+
+	if len(lb.ring) == 0 {
+		return nil
+	}
+
+	hash := hashOf(key)
+	start := sort.Search(len(lb.ring), func(i int) bool {
+		return lb.ring[i].hash >= hash
+	})
+
+	var items []interface{}
+
+	seen := map[interface{}]bool{}
+
+	for j := 0; j < len(lb.ring) && len(items) < lb.ringItems; j++ {
+		point := lb.ring[(start+j)%len(lb.ring)]
+		if !seen[point.item] {
+			seen[point.item] = true
+			items = append(items, point.item)
+		}
+	}
+
+	return items
+}
+
+func (lb *consistentHash) itemsWithWeight() []*weightedItem {
+	var items []*weightedItem
+
+	for _, item := range lb.items {
+		if item.weight > 0 {
+			items = append(items, item)
+		}
+	}
+
+	return items
+}
+
+// buildRing places each item's points on the ring. The points of an item are identified by their index so the points
+// of the other items don't move when an item is added or removed, unless the relative weights change.
+func (lb *consistentHash) buildRing() {
+	items := lb.itemsWithWeight()
+	total := int64(0)
+
+	for _, item := range items {
+		total += item.weight
+	}
+
+	lb.ring = lb.ring[:0]
+
+	for _, item := range items {
+		points := item.weight * virtualNodesPerItem * int64(len(items)) / total
+		if points < 1 {
+			points = 1
+		}
+
+		for j := int64(0); j < points; j++ {
+			lb.ring = append(lb.ring, ringPoint{hash: hashOf(fmt.Sprintf("%v#%d", item.item, j)), item: item.item})
+		}
+	}
+
+	sort.Slice(lb.ring, func(i, j int) bool {
+		return lb.ring[i].hash < lb.ring[j].hash
+	})
+
+	lb.ringItems = len(items)
+	lb.isDirty = false
+}
+
+func hashOf(s string) uint64 {
+	sum := sha256.Sum256([]byte(s))
+	return binary.BigEndian.Uint64(sum[:8])
+}
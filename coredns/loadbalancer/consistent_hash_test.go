@@ -0,0 +1,182 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+
+Copyright Contributors to the Submariner project.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package loadbalancer_test
+
+import (
+	"fmt"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/submariner-io/lighthouse/coredns/loadbalancer"
+)
+
+var _ = Describe("Consistent Hash", func() {
+	const numKeys = 1000
+
+	var lb loadbalancer.HashInterface
+
+	addServers := func(servers ...server) {
+		for _, s := range servers {
+			Expect(lb.Add(s.name, s.weight)).To(Succeed())
+		}
+	}
+
+	mapKeys := func() map[string]interface{} {
+		mapping := map[string]interface{}{}
+
+		for i := 0; i < numKeys; i++ {
+			key := fmt.Sprintf("10.0.%d.%d", i/256, i%256)
+
+			items := lb.ItemsFor(key)
+			Expect(items).ToNot(BeEmpty())
+			mapping[key] = items[0]
+		}
+
+		return mapping
+	}
+
+	BeforeEach(func() {
+		lb = loadbalancer.NewConsistentHash()
+	})
+
+	When("first created", func() {
+		It("should have an empty state", func() {
+			Expect(lb.ItemCount()).To(Equal(0))
+			Expect(lb.Next()).To(BeNil())
+			Expect(lb.ItemsFor("key")).To(BeEmpty())
+		})
+	})
+
+	When("items are added", func() {
+		BeforeEach(func() {
+			addServers(server{name: "server1", weight: 1}, server{name: "server2", weight: 1}, server{name: "server3", weight: 1})
+		})
+
+		It("should return the same items for a key on every call", func() {
+			expected := lb.ItemsFor("10.0.0.1")
+			Expect(expected).To(ConsistOf("server1", "server2", "server3"))
+
+			for i := 0; i < 10; i++ {
+				Expect(lb.ItemsFor("10.0.0.1")).To(Equal(expected))
+			}
+		})
+
+		It("should spread the keys across the items", func() {
+			counts := map[interface{}]int{}
+			for _, item := range mapKeys() {
+				counts[item]++
+			}
+
+			for _, name := range []string{"server1", "server2", "server3"} {
+				Expect(float64(counts[name]) / numKeys).To(BeNumerically("~", 1.0/3, 0.1))
+			}
+		})
+
+		It("should also select items without a key", func() {
+			Expect(lb.ItemCount()).To(Equal(3))
+			Expect([]interface{}{lb.Next(), lb.Next(), lb.Next()}).To(ConsistOf("server1", "server2", "server3"))
+		})
+
+		Context("and one is removed", func() {
+			It("should only remap the keys that were mapped to it", func() {
+				before := mapKeys()
+
+				lb.RemoveAll()
+				addServers(server{name: "server1", weight: 1}, server{name: "server3", weight: 1})
+
+				after := mapKeys()
+
+				for key, item := range before {
+					if item != "server2" {
+						Expect(after[key]).To(Equal(item), "Key %q was remapped", key)
+					} else {
+						Expect(after[key]).To(Or(Equal("server1"), Equal("server3")))
+					}
+				}
+			})
+		})
+
+		Context("and another is added", func() {
+			It("should only remap keys to it", func() {
+				before := mapKeys()
+
+				addServers(server{name: "server4", weight: 1})
+
+				for key, item := range mapKeys() {
+					if item != "server4" {
+						Expect(item).To(Equal(before[key]), "Key %q was remapped", key)
+					}
+				}
+			})
+		})
+	})
+
+	When("items have different weights", func() {
+		It("should map keys in proportion to the weights", func() {
+			addServers(server{name: "server1", weight: 3}, server{name: "server2", weight: 1})
+
+			counts := map[interface{}]int{}
+			for _, item := range mapKeys() {
+				counts[item]++
+			}
+
+			Expect(float64(counts["server1"]) / numKeys).To(BeNumerically("~", 0.75, 0.1))
+		})
+	})
+
+	When("an item's weight is updated", func() {
+		It("should map keys in proportion to the new weights", func() {
+			addServers(server{name: "server1", weight: 1}, server{name: "server2", weight: 1})
+			mapKeys()
+
+			Expect(lb.(loadbalancer.Updater).Update("server1", 3)).To(Succeed())
+
+			counts := map[interface{}]int{}
+			for _, item := range mapKeys() {
+				counts[item]++
+			}
+
+			Expect(float64(counts["server1"]) / numKeys).To(BeNumerically("~", 0.75, 0.1))
+		})
+
+		It("should return an error if the item isn't present", func() {
+			Expect(lb.(loadbalancer.Updater).Update("server1", 1)).To(MatchError(loadbalancer.ErrItemNotFound))
+		})
+	})
+
+	When("an item has zero weight", func() {
+		It("should not be returned for any key", func() {
+			addServers(server{name: "drained", weight: 0}, server{name: "server1", weight: 1})
+
+			Expect(lb.ItemsFor("10.0.0.1")).To(Equal([]interface{}{"server1"}))
+		})
+	})
+
+	When("invalid items are added", func() {
+		It("should return an error", func() {
+			Expect(lb.Add(nil, 1)).To(MatchError(loadbalancer.ErrNilItem))
+			Expect(lb.Add("server1", -1)).To(MatchError(loadbalancer.ErrNegativeWeight))
+
+			Expect(lb.Add("server1", 1)).To(Succeed())
+			Expect(lb.Add("server1", 1)).To(MatchError(loadbalancer.ErrDuplicateItem))
+			Expect(lb.ItemCount()).To(Equal(1))
+			Expect(lb.ItemsFor("key")).To(Equal([]interface{}{"server1"}))
+		})
+	})
+})
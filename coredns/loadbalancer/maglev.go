@@ -0,0 +1,211 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+
+Copyright Contributors to the Submariner project.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package loadbalancer
+
+import (
+	"fmt"
+	"sort"
+)
+
+// maglevTableSize is the number of entries of the lookup table, which must be prime. Each item's share of the keys
+// deviates from its share of the weights by at most about one entry per item.
+const maglevTableSize = 4099
+
+type maglevItem struct {
+	name   string
+	item   interface{}
+	weight int64
+	offset uint64
+	skip   uint64
+}
+
+// Maglev hashing load balancer implementation. Selections without a key are delegated to a smooth weighted round robin
+// load balancer with the same items.
+type maglev struct {
+	*smoothWeightedRR
+	items      []*maglevItem
+	table      []int
+	tableItems int
+	isDirty    bool
+}
+
+// NewMaglev returns a load balancer that maps keys to items via a Maglev lookup table, in which each item fills a number
+// of entries proportional to its weight. The keys are thus spread across the items nearly exactly per their weights,
+// even for few items, and removing an item remaps few keys other than those that were mapped to it.
+func NewMaglev() HashInterface {
+	return &maglev{smoothWeightedRR: newSmoothWeightedRR()}
+}
+
+// Add - adds a new unique item to the list.
+func (lb *maglev) Add(item interface{}, weight int64) error {
+	if err := lb.smoothWeightedRR.Add(item, weight); err != nil {
+		return err
+	}
+
+	name := fmt.Sprintf("%v", item)
+	lb.items = append(lb.items, &maglevItem{
+		name:   name,
+		item:   item,
+		weight: weight,
+		offset: hashOf(name+"#offset") % maglevTableSize,
+		skip:   hashOf(name+"#skip")%(maglevTableSize-1) + 1,
+	})
+	lb.isDirty = true
+
+	return nil
+}
+
+// Update - changes the weight of an item. The lookup table is rebuilt.
+func (lb *maglev) Update(item interface{}, weight int64) error {
+	if err := lb.smoothWeightedRR.Update(item, weight); err != nil {
+		return err
+	}
+
+	for _, tableItem := range lb.items {
+		if tableItem.item == item {
+			tableItem.weight = weight
+		}
+	}
+
+	lb.isDirty = true
+
+	return nil
+}
+
+// RemoveAll - removes all items and reset state.
+func (lb *maglev) RemoveAll() {
+	lb.smoothWeightedRR.RemoveAll()
+	lb.items = lb.items[:0]
+	lb.table = nil
+	lb.tableItems = 0
+	lb.isDirty = false
+}
+
+// ItemsFor - returns the distinct items in the order their entries follow the key's entry in the lookup table.
+func (lb *maglev) ItemsFor(key string) []interface{} {
+	if lb.isDirty {
+		lb.buildTable()
+	}
+
+	if len(lb.table) == 0 {
+		return nil
+	}
+
+	start := hashOf(key) % maglevTableSize
+
+	var items []interface{}
+
+	seen := map[int]bool{}
+
+	for j := uint64(0); j < maglevTableSize && len(items) < lb.tableItems; j++ {
+		index := lb.table[(start+j)%maglevTableSize]
+		if index >= 0 && !seen[index] {
+			seen[index] = true
+			items = append(items, lb.items[index].item)
+		}
+	}
+
+	return items
+}
+
+// buildTable fills the lookup table by letting each item in turn claim the next free entry in its own permutation of
+// the entries until it has claimed its share per its weight. The items are sorted by name so the table doesn't depend on
+// the order they were added.
+func (lb *maglev) buildTable() {
+	sort.Slice(lb.items, func(i, j int) bool {
+		return lb.items[i].name < lb.items[j].name
+	})
+
+	lb.table = make([]int, maglevTableSize)
+	for j := range lb.table {
+		lb.table[j] = -1
+	}
+
+	quotas := lb.quotas()
+	if quotas == nil {
+		lb.table = nil
+		lb.tableItems = 0
+		lb.isDirty = false
+
+		return
+	}
+
+	lb.tableItems = 0
+
+	for _, quota := range quotas {
+		if quota > 0 {
+			lb.tableItems++
+		}
+	}
+
+	next := make([]uint64, len(lb.items))
+	filled := make([]int64, len(lb.items))
+
+	for claimed := 0; claimed < maglevTableSize; {
+		for index, item := range lb.items {
+			if filled[index] >= quotas[index] {
+				continue
+			}
+
+			entry := (item.offset + next[index]*item.skip) % maglevTableSize
+			for lb.table[entry] >= 0 {
+				next[index]++
+				entry = (item.offset + next[index]*item.skip) % maglevTableSize
+			}
+
+			lb.table[entry] = index
+			next[index]++
+			filled[index]++
+			claimed++
+		}
+	}
+
+	lb.isDirty = false
+}
+
+// quotas returns the number of entries each item claims, in proportion to its weight, or nil if no item has a weight.
+// The entries left over by rounding down go to the items in order.
+func (lb *maglev) quotas() []int64 {
+	total := int64(0)
+
+	for _, item := range lb.items {
+		total += item.weight
+	}
+
+	if total == 0 {
+		return nil
+	}
+
+	quotas := make([]int64, len(lb.items))
+	assigned := int64(0)
+
+	for index, item := range lb.items {
+		quotas[index] = item.weight * maglevTableSize / total
+		assigned += quotas[index]
+	}
+
+	for index := 0; assigned < maglevTableSize; index = (index + 1) % len(lb.items) {
+		if lb.items[index].weight > 0 {
+			quotas[index]++
+			assigned++
+		}
+	}
+
+	return quotas
+}
@@ -0,0 +1,181 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+
+Copyright Contributors to the Submariner project.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package loadbalancer_test
+
+import (
+	"fmt"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/submariner-io/lighthouse/coredns/loadbalancer"
+)
+
+var _ = Describe("Maglev", func() {
+	const numKeys = 10000
+
+	var lb loadbalancer.HashInterface
+
+	addServers := func(servers ...server) {
+		for _, s := range servers {
+			Expect(lb.Add(s.name, s.weight)).To(Succeed())
+		}
+	}
+
+	mapKeys := func() map[string]interface{} {
+		mapping := map[string]interface{}{}
+
+		for i := 0; i < numKeys; i++ {
+			key := fmt.Sprintf("10.%d.%d.%d", i/65536, (i/256)%256, i%256)
+
+			items := lb.ItemsFor(key)
+			Expect(items).ToNot(BeEmpty())
+			mapping[key] = items[0]
+		}
+
+		return mapping
+	}
+
+	shares := func() map[interface{}]float64 {
+		shares := map[interface{}]float64{}
+		for _, item := range mapKeys() {
+			shares[item] += 1.0 / numKeys
+		}
+
+		return shares
+	}
+
+	BeforeEach(func() {
+		lb = loadbalancer.NewMaglev()
+	})
+
+	When("first created", func() {
+		It("should have an empty state", func() {
+			Expect(lb.ItemCount()).To(Equal(0))
+			Expect(lb.Next()).To(BeNil())
+			Expect(lb.ItemsFor("key")).To(BeEmpty())
+		})
+	})
+
+	When("items are added", func() {
+		BeforeEach(func() {
+			addServers(server{name: "server1", weight: 1}, server{name: "server2", weight: 1}, server{name: "server3", weight: 1})
+		})
+
+		It("should return the same items for a key on every call", func() {
+			expected := lb.ItemsFor("10.0.0.1")
+			Expect(expected).To(ConsistOf("server1", "server2", "server3"))
+
+			for i := 0; i < 10; i++ {
+				Expect(lb.ItemsFor("10.0.0.1")).To(Equal(expected))
+			}
+		})
+
+		It("should spread the keys uniformly across the items", func() {
+			for _, name := range []string{"server1", "server2", "server3"} {
+				Expect(shares()[name]).To(BeNumerically("~", 1.0/3, 0.02))
+			}
+		})
+
+		It("should map the keys regardless of the order the items were added", func() {
+			before := mapKeys()
+
+			lb = loadbalancer.NewMaglev()
+			addServers(server{name: "server3", weight: 1}, server{name: "server1", weight: 1}, server{name: "server2", weight: 1})
+
+			Expect(mapKeys()).To(Equal(before))
+		})
+
+		It("should also select items without a key", func() {
+			Expect(lb.ItemCount()).To(Equal(3))
+			Expect([]interface{}{lb.Next(), lb.Next(), lb.Next()}).To(ConsistOf("server1", "server2", "server3"))
+		})
+
+		Context("and one is removed", func() {
+			It("should remap the keys that were mapped to it and few others", func() {
+				before := mapKeys()
+
+				lb.RemoveAll()
+				addServers(server{name: "server1", weight: 1}, server{name: "server3", weight: 1})
+
+				after := mapKeys()
+				remapped := 0
+
+				for key, item := range before {
+					if item == "server2" {
+						Expect(after[key]).To(Or(Equal("server1"), Equal("server3")))
+					} else if after[key] != item {
+						remapped++
+					}
+				}
+
+				Expect(float64(remapped) / numKeys).To(BeNumerically("<", 0.05))
+			})
+		})
+	})
+
+	When("items have different weights", func() {
+		It("should map keys in proportion to the weights", func() {
+			addServers(server{name: "server1", weight: 3}, server{name: "server2", weight: 1})
+
+			Expect(shares()["server1"]).To(BeNumerically("~", 0.75, 0.02))
+		})
+	})
+
+	When("an item's weight is updated", func() {
+		It("should map keys in proportion to the new weights", func() {
+			addServers(server{name: "server1", weight: 1}, server{name: "server2", weight: 1})
+			Expect(shares()["server1"]).To(BeNumerically("~", 0.5, 0.02))
+
+			Expect(lb.(loadbalancer.Updater).Update("server1", 3)).To(Succeed())
+			Expect(shares()["server1"]).To(BeNumerically("~", 0.75, 0.02))
+		})
+
+		It("should return an error if the item isn't present", func() {
+			Expect(lb.(loadbalancer.Updater).Update("server1", 1)).To(MatchError(loadbalancer.ErrItemNotFound))
+		})
+	})
+
+	When("an item has zero weight", func() {
+		It("should not be returned for any key", func() {
+			addServers(server{name: "drained", weight: 0}, server{name: "server1", weight: 1})
+
+			Expect(lb.ItemsFor("10.0.0.1")).To(Equal([]interface{}{"server1"}))
+		})
+	})
+
+	When("all items have zero weight", func() {
+		It("should not return any items for a key", func() {
+			addServers(server{name: "server1", weight: 0})
+
+			Expect(lb.ItemsFor("10.0.0.1")).To(BeEmpty())
+		})
+	})
+
+	When("invalid items are added", func() {
+		It("should return an error", func() {
+			Expect(lb.Add(nil, 1)).To(MatchError(loadbalancer.ErrNilItem))
+			Expect(lb.Add("server1", -1)).To(MatchError(loadbalancer.ErrNegativeWeight))
+
+			Expect(lb.Add("server1", 1)).To(Succeed())
+			Expect(lb.Add("server1", 1)).To(MatchError(loadbalancer.ErrDuplicateItem))
+			Expect(lb.ItemCount()).To(Equal(1))
+			Expect(lb.ItemsFor("key")).To(Equal([]interface{}{"server1"}))
+		})
+	})
+})
@@ -0,0 +1,175 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+
+Copyright Contributors to the Submariner project.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package loadbalancer
+
+import (
+	"fmt"
+	"math/rand"
+	"time"
+)
+
+type randomItem struct {
+	item   interface{}
+	weight int64
+}
+
+// Weighted random load balancer implementation.
+type random struct {
+	items   []*randomItem
+	itemMap map[interface{}]*randomItem
+	skipped map[interface{}]int
+	rand    *rand.Rand
+}
+
+// NewRandom returns a load balancer that selects items at random in proportion to their weights.
+func NewRandom() Interface {
+	return NewRandomFrom(rand.NewSource(time.Now().UnixNano()))
+}
+
+// NewRandomFrom returns a load balancer like NewRandom that draws from the given source, which needn't be safe for
+// concurrent use.
+func NewRandomFrom(source rand.Source) Interface {
+	return &random{
+		items:   make([]*randomItem, 0),
+		itemMap: make(map[interface{}]*randomItem),
+		skipped: make(map[interface{}]int),
+		rand:    rand.New(source), //nolint:gosec // Cryptographically secure randomness isn't needed here
+	}
+}
+
+// Skip - excludes the item from the next ItemCount selections.
+func (lb *random) Skip(item interface{}) {
+	if _, ok := lb.itemMap[item]; ok {
+		lb.skipped[item] = len(lb.items)
+	} else {
+		logger.Errorf(nil, "Could not find item to skip: %v", item)
+	}
+}
+
+// Number of Items added.
+func (lb *random) ItemCount() int {
+	return len(lb.items)
+}
+
+// Add - adds a new unique item to the list.
+func (lb *random) Add(item interface{}, weight int64) (err error) {
+	if item == nil {
+		return ErrNilItem
+	}
+
+	if weight < 0 {
+		return fmt.Errorf("%w: %v", ErrNegativeWeight, weight)
+	}
+
+	if lb.itemMap[item] != nil {
+		return fmt.Errorf("%w: %v", ErrDuplicateItem, item)
+	}
+
+	randomItem := &randomItem{item: item, weight: weight}
+
+	lb.itemMap[item] = randomItem
+	lb.items = append(lb.items, randomItem)
+
+	return nil
+}
+
+// Update - changes the weight of an item.
+func (lb *random) Update(item interface{}, weight int64) error {
+	if weight < 0 {
+		return fmt.Errorf("%w: %v", ErrNegativeWeight, weight)
+	}
+
+	randomItem, ok := lb.itemMap[item]
+	if !ok {
+		return fmt.Errorf("%w: %v", ErrItemNotFound, item)
+	}
+
+	randomItem.weight = weight
+
+	return nil
+}
+
+// RemoveAll - removes all items and reset state.
+func (lb *random) RemoveAll() {
+	lb.items = lb.items[:0]
+	lb.itemMap = make(map[interface{}]*randomItem)
+	lb.skipped = make(map[interface{}]int)
+}
+
+// Next - fetches a random item with probability proportional to its weight among the items that aren't skipped. If all
+// the items with a positive weight are skipped, one of those is selected, and if all items have zero weight, any is.
+func (lb *random) Next() interface{} {
+	if len(lb.items) == 0 {
+		return nil
+	}
+
+	item := lb.nextWeightedItem(func(item interface{}) bool {
+		return lb.skipped[item] == 0
+	})
+
+	if item == nil {
+		item = lb.nextWeightedItem(func(_ interface{}) bool {
+			return true
+		})
+	}
+
+	if item == nil {
+		item = lb.items[lb.rand.Intn(len(lb.items))].item
+	}
+
+	for skippedItem, remaining := range lb.skipped {
+		if remaining <= 1 {
+			delete(lb.skipped, skippedItem)
+		} else {
+			lb.skipped[skippedItem] = remaining - 1
+		}
+	}
+
+	return item
+}
+
+func (lb *random) nextWeightedItem(isEligible func(interface{}) bool) interface{} {
+	total := int64(0)
+
+	for _, randomItem := range lb.items {
+		if isEligible(randomItem.item) {
+			total += randomItem.weight
+		}
+	}
+
+	if total == 0 {
+		return nil
+	}
+
+	r := lb.rand.Int63n(total)
+
+	for _, randomItem := range lb.items {
+		if !isEligible(randomItem.item) {
+			continue
+		}
+
+		if r < randomItem.weight {
+			return randomItem.item
+		}
+
+		r -= randomItem.weight
+	}
+
+	return nil
+}
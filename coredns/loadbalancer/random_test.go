@@ -0,0 +1,159 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+
+Copyright Contributors to the Submariner project.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package loadbalancer_test
+
+import (
+	"math/rand"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/submariner-io/lighthouse/coredns/loadbalancer"
+)
+
+var _ = Describe("Random", func() {
+	const rounds = 10000
+
+	var lb loadbalancer.Interface
+
+	servers := []server{
+		{name: "server1", weight: 5},
+		{name: "server2", weight: 1},
+		{name: "server3", weight: 4},
+	}
+
+	addAllServers := func() {
+		for _, s := range servers {
+			Expect(lb.Add(s.name, s.weight)).To(Succeed())
+		}
+	}
+
+	countSelections := func(n int) map[string]int {
+		counts := map[string]int{}
+		for i := 0; i < n; i++ {
+			counts[lb.Next().(string)]++
+		}
+
+		return counts
+	}
+
+	BeforeEach(func() {
+		lb = loadbalancer.NewRandomFrom(rand.NewSource(1))
+	})
+
+	When("first created", func() {
+		It("should have an empty state", func() {
+			Expect(lb.ItemCount()).To(Equal(0))
+			Expect(lb.Next()).To(BeNil())
+		})
+	})
+
+	When("items are added with different weights", func() {
+		It("should select them in proportion to their weights", func() {
+			addAllServers()
+			Expect(lb.ItemCount()).To(Equal(3))
+
+			counts := countSelections(rounds)
+			for _, s := range servers {
+				Expect(float64(counts[s.name])/rounds).To(BeNumerically("~", float64(s.weight)/10, 0.02),
+					"Unexpected share for %q - counts: %v", s.name, counts)
+			}
+		})
+
+		It("should produce the same sequence from the same source", func() {
+			addAllServers()
+			first := countSelections(100)
+
+			lb = loadbalancer.NewRandomFrom(rand.NewSource(1))
+			addAllServers()
+			Expect(countSelections(100)).To(Equal(first))
+		})
+	})
+
+	When("all items are removed", func() {
+		It("should have an empty state", func() {
+			addAllServers()
+			lb.RemoveAll()
+
+			Expect(lb.ItemCount()).To(Equal(0))
+			Expect(lb.Next()).To(BeNil())
+		})
+	})
+
+	When("an item's weight is updated", func() {
+		It("should select it in proportion to the new weight", func() {
+			addAllServers()
+			Expect(lb.(loadbalancer.Updater).Update("server2", 0)).To(Succeed())
+			Expect(lb.(loadbalancer.Updater).Update("server3", 5)).To(Succeed())
+
+			counts := countSelections(rounds)
+			Expect(counts).ToNot(HaveKey("server2"))
+			Expect(float64(counts["server1"]) / rounds).To(BeNumerically("~", 0.5, 0.02))
+		})
+
+		It("should return an error if the item isn't present", func() {
+			Expect(lb.(loadbalancer.Updater).Update("server1", 1)).To(MatchError(loadbalancer.ErrItemNotFound))
+		})
+	})
+
+	When("an item is skipped", func() {
+		It("should be excluded for a full round", func() {
+			addAllServers()
+
+			lb.Skip("server1")
+			Expect(countSelections(len(servers))).ToNot(HaveKey("server1"))
+			Expect(countSelections(rounds)).To(HaveKey("server1"))
+		})
+	})
+
+	When("all items are skipped", func() {
+		It("should still select one", func() {
+			addAllServers()
+
+			for _, s := range servers {
+				lb.Skip(s.name)
+			}
+
+			Expect(lb.Next()).ToNot(BeNil())
+		})
+	})
+
+	When("an item has zero weight", func() {
+		It("should only be selected if no other item is available", func() {
+			Expect(lb.Add("drained", int64(0))).To(Succeed())
+			addAllServers()
+
+			Expect(countSelections(rounds)).ToNot(HaveKey("drained"))
+
+			lb.RemoveAll()
+			Expect(lb.Add("drained", int64(0))).To(Succeed())
+			Expect(lb.Next()).To(Equal("drained"))
+		})
+	})
+
+	When("invalid items are added", func() {
+		It("should return an error", func() {
+			Expect(lb.Add(nil, 1)).To(MatchError(loadbalancer.ErrNilItem))
+			Expect(lb.Add("server1", -1)).To(MatchError(loadbalancer.ErrNegativeWeight))
+
+			Expect(lb.Add("server1", 1)).To(Succeed())
+			Expect(lb.Add("server1", 1)).To(MatchError(loadbalancer.ErrDuplicateItem))
+			Expect(lb.ItemCount()).To(Equal(1))
+		})
+	})
+})
@@ -0,0 +1,76 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+
+Copyright Contributors to the Submariner project.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package loadbalancer
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// Names of the load balancing policies registered by default.
+const (
+	PolicySmoothWeightedRR = "smooth-weighted-roundrobin"
+	PolicyRoundRobin       = "roundrobin"
+	PolicyRandom           = "random"
+	PolicyConsistentHash   = "consistent-hash"
+	PolicyMaglev           = "maglev"
+)
+
+// ErrUnknownPolicy is returned by New for a policy name that isn't registered.
+var ErrUnknownPolicy = errors.New("unknown load balancing policy")
+
+var registry = struct {
+	sync.RWMutex
+	factories map[string]func() Interface
+}{
+	factories: map[string]func() Interface{
+		PolicySmoothWeightedRR: NewSmoothWeightedRR,
+		PolicyRoundRobin:       NewRoundRobin,
+		PolicyRandom:           NewRandom,
+		PolicyConsistentHash: func() Interface {
+			return NewConsistentHash()
+		},
+		PolicyMaglev: func() Interface {
+			return NewMaglev()
+		},
+	},
+}
+
+// Register registers the constructor of the load balancer for the given policy name, replacing any previously
+// registered for the name. It's safe to call concurrently with New.
+func Register(name string, factory func() Interface) {
+	registry.Lock()
+	defer registry.Unlock()
+
+	registry.factories[name] = factory
+}
+
+// New returns a new load balancer for the given registered policy name.
+func New(name string) (Interface, error) {
+	registry.RLock()
+	factory, ok := registry.factories[name]
+	registry.RUnlock()
+
+	if !ok {
+		return nil, fmt.Errorf("%w: %q", ErrUnknownPolicy, name)
+	}
+
+	return factory(), nil
+}
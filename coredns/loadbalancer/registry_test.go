@@ -0,0 +1,78 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+
+Copyright Contributors to the Submariner project.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package loadbalancer_test
+
+import (
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/submariner-io/lighthouse/coredns/loadbalancer"
+)
+
+var _ = Describe("Registry", func() {
+	When("a built-in policy is requested", func() {
+		It("should return a new load balancer", func() {
+			for _, name := range []string{
+				loadbalancer.PolicySmoothWeightedRR, loadbalancer.PolicyRoundRobin,
+				loadbalancer.PolicyRandom, loadbalancer.PolicyConsistentHash, loadbalancer.PolicyMaglev,
+			} {
+				lb, err := loadbalancer.New(name)
+				Expect(err).To(Succeed(), "Policy %q", name)
+				Expect(lb).ToNot(BeNil(), "Policy %q", name)
+			}
+
+			lb, err := loadbalancer.New(loadbalancer.PolicyConsistentHash)
+			Expect(err).To(Succeed())
+			Expect(lb).To(BeAssignableToTypeOf(loadbalancer.NewConsistentHash()))
+		})
+
+		It("should return a distinct instance each time", func() {
+			lb1, err := loadbalancer.New(loadbalancer.PolicyRoundRobin)
+			Expect(err).To(Succeed())
+
+			lb2, err := loadbalancer.New(loadbalancer.PolicyRoundRobin)
+			Expect(err).To(Succeed())
+
+			Expect(lb1.Add("server1", 1)).To(Succeed())
+			Expect(lb2.ItemCount()).To(Equal(0))
+		})
+	})
+
+	When("a custom policy is registered", func() {
+		It("should return a load balancer from its factory", func() {
+			created := 0
+
+			loadbalancer.Register("registry-test", func() loadbalancer.Interface {
+				created++
+				return loadbalancer.NewRoundRobin()
+			})
+
+			lb, err := loadbalancer.New("registry-test")
+			Expect(err).To(Succeed())
+			Expect(lb).ToNot(BeNil())
+			Expect(created).To(Equal(1))
+		})
+	})
+
+	When("an unknown policy is requested", func() {
+		It("should return an error", func() {
+			_, err := loadbalancer.New("bogus")
+			Expect(err).To(MatchError(loadbalancer.ErrUnknownPolicy))
+		})
+	})
+})
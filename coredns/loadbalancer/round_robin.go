@@ -0,0 +1,142 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+
+Copyright Contributors to the Submariner project.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package loadbalancer
+
+import "fmt"
+
+type roundRobinItem struct {
+	item    interface{}
+	weight  int64
+	skipped bool
+}
+
+// Plain Round Robin load balancer implementation.
+type roundRobin struct {
+	items   []*roundRobinItem
+	itemMap map[interface{}]*roundRobinItem
+	next    int
+}
+
+// NewRoundRobin returns a Round Robin load balancer that rotates through the items in the order they were added,
+// regardless of their weights. Items with zero weight are only selected if no other item is available.
+func NewRoundRobin() Interface {
+	return &roundRobin{
+		items:   make([]*roundRobinItem, 0),
+		itemMap: make(map[interface{}]*roundRobinItem),
+	}
+}
+
+func (lb *roundRobin) Skip(item interface{}) {
+	if rrItem, ok := lb.itemMap[item]; ok {
+		rrItem.skipped = true
+	} else {
+		logger.Errorf(nil, "Could not find item to skip: %v", item)
+	}
+}
+
+// Number of Items added.
+func (lb *roundRobin) ItemCount() int {
+	return len(lb.items)
+}
+
+// Add - adds a new unique item to the list.
+func (lb *roundRobin) Add(item interface{}, weight int64) (err error) {
+	if item == nil {
+		return ErrNilItem
+	}
+
+	if weight < 0 {
+		return fmt.Errorf("%w: %v", ErrNegativeWeight, weight)
+	}
+
+	if lb.itemMap[item] != nil {
+		return fmt.Errorf("%w: %v", ErrDuplicateItem, item)
+	}
+
+	rrItem := &roundRobinItem{item: item, weight: weight}
+
+	lb.itemMap[item] = rrItem
+	lb.items = append(lb.items, rrItem)
+
+	return nil
+}
+
+// Update - changes the weight of an item, which only matters as to whether it's zero.
+func (lb *roundRobin) Update(item interface{}, weight int64) error {
+	if weight < 0 {
+		return fmt.Errorf("%w: %v", ErrNegativeWeight, weight)
+	}
+
+	rrItem, ok := lb.itemMap[item]
+	if !ok {
+		return fmt.Errorf("%w: %v", ErrItemNotFound, item)
+	}
+
+	rrItem.weight = weight
+
+	return nil
+}
+
+// RemoveAll - removes all items and reset state.
+func (lb *roundRobin) RemoveAll() {
+	lb.items = lb.items[:0]
+	lb.itemMap = make(map[interface{}]*roundRobinItem)
+	lb.next = 0
+}
+
+// Next - fetches the item following the previously returned one. Skipped items are passed over once, and are only
+// returned if all the other items with a positive weight are also skipped.
+func (lb *roundRobin) Next() interface{} {
+	skipped, drained := -1, -1
+
+	for j := range lb.items {
+		index := (lb.next + j) % len(lb.items)
+		rrItem := lb.items[index]
+
+		switch {
+		case rrItem.weight == 0:
+			if drained < 0 {
+				drained = index
+			}
+		case rrItem.skipped:
+			rrItem.skipped = false
+
+			if skipped < 0 {
+				skipped = index
+			}
+		default:
+			return lb.selectAt(index)
+		}
+	}
+
+	if skipped >= 0 {
+		return lb.selectAt(skipped)
+	}
+
+	if drained >= 0 {
+		return lb.selectAt(drained)
+	}
+
+	return nil
+}
+
+func (lb *roundRobin) selectAt(index int) interface{} {
+	lb.next = (index + 1) % len(lb.items)
+	return lb.items[index].item
+}
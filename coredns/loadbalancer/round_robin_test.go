@@ -0,0 +1,154 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+
+Copyright Contributors to the Submariner project.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package loadbalancer_test
+
+import (
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/submariner-io/lighthouse/coredns/loadbalancer"
+)
+
+var _ = Describe("Round Robin", func() {
+	var lb loadbalancer.Interface
+
+	servers := []server{
+		{name: "server1", weight: 5},
+		{name: "server2", weight: 1},
+		{name: "server3", weight: 3},
+	}
+
+	addAllServers := func() {
+		for _, s := range servers {
+			Expect(lb.Add(s.name, s.weight)).To(Succeed())
+		}
+	}
+
+	nextN := func(n int) []string {
+		names := make([]string, 0, n)
+		for i := 0; i < n; i++ {
+			names = append(names, lb.Next().(string))
+		}
+
+		return names
+	}
+
+	BeforeEach(func() {
+		lb = loadbalancer.NewRoundRobin()
+	})
+
+	When("first created", func() {
+		It("should have an empty state", func() {
+			Expect(lb.ItemCount()).To(Equal(0))
+			Expect(lb.Next()).To(BeNil())
+		})
+	})
+
+	When("items are added with different weights", func() {
+		It("should rotate through them strictly in order", func() {
+			addAllServers()
+			Expect(lb.ItemCount()).To(Equal(3))
+
+			for i := 0; i < 10; i++ {
+				Expect(nextN(3)).To(Equal([]string{"server1", "server2", "server3"}))
+			}
+		})
+	})
+
+	When("all items are removed", func() {
+		It("should have an empty state and restart the rotation when re-added", func() {
+			addAllServers()
+			nextN(2)
+
+			lb.RemoveAll()
+			Expect(lb.ItemCount()).To(Equal(0))
+			Expect(lb.Next()).To(BeNil())
+
+			addAllServers()
+			Expect(nextN(3)).To(Equal([]string{"server1", "server2", "server3"}))
+		})
+	})
+
+	When("an item is skipped", func() {
+		It("should be passed over once", func() {
+			addAllServers()
+
+			lb.Skip("server2")
+			Expect(nextN(5)).To(Equal([]string{"server1", "server3", "server1", "server2", "server3"}))
+		})
+	})
+
+	When("all items are skipped", func() {
+		It("should still return the next one", func() {
+			addAllServers()
+
+			for _, s := range servers {
+				lb.Skip(s.name)
+			}
+
+			Expect(nextN(4)).To(Equal([]string{"server1", "server2", "server3", "server1"}))
+		})
+	})
+
+	When("an item has zero weight", func() {
+		It("should only be returned if no other item is available", func() {
+			Expect(lb.Add("drained", int64(0))).To(Succeed())
+			addAllServers()
+
+			Expect(nextN(6)).To(Equal([]string{"server1", "server2", "server3", "server1", "server2", "server3"}))
+
+			for _, s := range servers {
+				lb.Skip(s.name)
+			}
+
+			Expect(lb.Next()).To(Equal("server1"))
+
+			lb.RemoveAll()
+			Expect(lb.Add("drained", int64(0))).To(Succeed())
+			Expect(lb.Next()).To(Equal("drained"))
+		})
+	})
+
+	When("invalid items are added", func() {
+		It("should return an error", func() {
+			Expect(lb.Add(nil, 1)).To(MatchError(loadbalancer.ErrNilItem))
+			Expect(lb.Add("server1", -1)).To(MatchError(loadbalancer.ErrNegativeWeight))
+
+			Expect(lb.Add("server1", 1)).To(Succeed())
+			Expect(lb.Add("server1", 1)).To(MatchError(loadbalancer.ErrDuplicateItem))
+			Expect(lb.ItemCount()).To(Equal(1))
+		})
+	})
+
+	When("an item's weight is updated", func() {
+		It("should continue the rotation", func() {
+			addAllServers()
+			Expect(nextN(1)).To(Equal([]string{"server1"}))
+
+			Expect(lb.(loadbalancer.Updater).Update("server2", 0)).To(Succeed())
+			Expect(nextN(3)).To(Equal([]string{"server3", "server1", "server3"}))
+
+			Expect(lb.(loadbalancer.Updater).Update("server2", 1)).To(Succeed())
+			Expect(nextN(3)).To(Equal([]string{"server1", "server2", "server3"}))
+		})
+
+		It("should return an error if the item isn't present", func() {
+			Expect(lb.(loadbalancer.Updater).Update("server1", 1)).To(MatchError(loadbalancer.ErrItemNotFound))
+		})
+	})
+})
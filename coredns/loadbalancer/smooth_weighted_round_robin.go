@@ -42,6 +42,10 @@ type smoothWeightedRR struct {
 
 // NewSmoothWeightedRR returns a Smooth Weighted Round Robin load balancer.
 func NewSmoothWeightedRR() Interface {
+	return newSmoothWeightedRR()
+}
+
+func newSmoothWeightedRR() *smoothWeightedRR {
 	return &smoothWeightedRR{
 		items:   make([]*weightedItem, 0),
 		itemMap: make(map[interface{}]*weightedItem),
@@ -67,15 +71,15 @@ func (lb *smoothWeightedRR) ItemCount() int {
 // Add - adds a new unique item to the list.
 func (lb *smoothWeightedRR) Add(item interface{}, weight int64) (err error) {
 	if item == nil {
-		return fmt.Errorf("item cannot be nil")
+		return ErrNilItem
 	}
 
 	if weight < 0 {
-		return fmt.Errorf("item weight %v cannot be negative", weight)
+		return fmt.Errorf("%w: %v", ErrNegativeWeight, weight)
 	}
 
 	if lb.itemMap[item] != nil {
-		return fmt.Errorf("item %v already present", item)
+		return fmt.Errorf("%w: %v", ErrDuplicateItem, item)
 	}
 
 	weightedItem := &weightedItem{item: item, weight: weight, currentWeight: 0, effectiveWeight: weight}
@@ -86,6 +90,30 @@ func (lb *smoothWeightedRR) Add(item interface{}, weight int64) (err error) {
 	return nil
 }
 
+// Update - changes the weight of an item. Its current weight is kept, and its effective weight changes by as much as its
+// weight so a Skip penalty still applies.
+func (lb *smoothWeightedRR) Update(item interface{}, weight int64) error {
+	if weight < 0 {
+		return fmt.Errorf("%w: %v", ErrNegativeWeight, weight)
+	}
+
+	wt, ok := lb.itemMap[item]
+	if !ok {
+		return fmt.Errorf("%w: %v", ErrItemNotFound, item)
+	}
+
+	wt.effectiveWeight += weight - wt.weight
+	wt.weight = weight
+
+	if wt.effectiveWeight < 0 {
+		wt.effectiveWeight = 0
+	} else if wt.effectiveWeight > weight {
+		wt.effectiveWeight = weight
+	}
+
+	return nil
+}
+
 // RemoveAll - removes all items and reset state.
 func (lb *smoothWeightedRR) RemoveAll() {
 	lb.items = lb.items[:0]
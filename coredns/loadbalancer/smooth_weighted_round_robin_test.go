@@ -165,7 +165,7 @@ var _ = Describe("Smooth Weighted RR", func() {
 	When("a nil is added", func() {
 		It("should return an error", func() {
 			err := lb.Add(nil, 100)
-			Expect(err).ToNot(BeNil())
+			Expect(err).To(MatchError(loadbalancer.ErrNilItem))
 			validateEmptyLBState()
 		})
 	})
@@ -173,7 +173,7 @@ var _ = Describe("Smooth Weighted RR", func() {
 	When("an item is added with a negative weight", func() {
 		It("should return an error", func() {
 			err := lb.Add(servers[0], -100)
-			Expect(err).ToNot(BeNil())
+			Expect(err).To(MatchError(loadbalancer.ErrNegativeWeight))
 			validateEmptyLBState()
 		})
 	})
@@ -193,7 +193,7 @@ var _ = Describe("Smooth Weighted RR", func() {
 			s := servers[0]
 			addServer(s)
 			err := lb.Add(s.name, s.weight)
-			Expect(err).ToNot(BeNil())
+			Expect(err).To(MatchError(loadbalancer.ErrDuplicateItem))
 			Expect(lb.ItemCount()).To(Equal(1))
 		})
 	})
@@ -267,4 +267,28 @@ var _ = Describe("Smooth Weighted RR", func() {
 			validateLoadBalancingByCount(100, smoothTestingServers)
 		})
 	})
+
+	When("an item's weight is updated while balancing", func() {
+		It("should keep the selection state and balance per the new weight", func() {
+			addAllServers(roundRobinServers)
+			Expect(lb.Next().(string)).To(Equal(roundRobinServers[0].name))
+
+			roundRobinServers[1].weight = 2
+			Expect(lb.(loadbalancer.Updater).Update(roundRobinServers[1].name, roundRobinServers[1].weight)).To(Succeed())
+			Expect(lb.ItemCount()).To(Equal(3))
+
+			// A reset would restart the rotation from the first item.
+			Expect(lb.Next().(string)).To(Equal(roundRobinServers[1].name))
+
+			validateLoadBalancingByCount(100, roundRobinServers)
+		})
+	})
+
+	When("an item that isn't present is updated", func() {
+		It("should return an error", func() {
+			addAllServers(roundRobinServers)
+			Expect(lb.(loadbalancer.Updater).Update("unknown", 1)).To(MatchError(loadbalancer.ErrItemNotFound))
+			Expect(lb.(loadbalancer.Updater).Update(roundRobinServers[0].name, -1)).To(MatchError(loadbalancer.ErrNegativeWeight))
+		})
+	})
 })
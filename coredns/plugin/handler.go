@@ -26,6 +26,8 @@ import (
 	"github.com/coredns/coredns/plugin"
 	"github.com/coredns/coredns/request"
 	"github.com/miekg/dns"
+	"github.com/submariner-io/lighthouse/coredns/resolver"
+	discovery "k8s.io/api/discovery/v1"
 )
 
 const PluginName = "lighthouse"
@@ -69,19 +71,20 @@ func (lh *Lighthouse) ServeDNS(ctx context.Context, w dns.ResponseWriter, r *dns
 func (lh *Lighthouse) getDNSRecord(ctx context.Context, zone string, state *request.Request, w dns.ResponseWriter,
 	r *dns.Msg, pReq *recordRequest,
 ) (int, error) {
-	dnsRecords, isHeadless, found := lh.Resolver.GetDNSRecords(pReq.namespace, pReq.service, pReq.cluster, pReq.hostname)
-	if !found {
+	dnsRecords, isHeadless, status := lh.Resolver.LookupDNSRecordsOfAddressType(pReq.namespace, pReq.service, pReq.cluster,
+		pReq.hostname, state.IP(), addressTypeFor(state.QType()))
+	if status == resolver.LookupNotFound {
 		log.Debugf("No record found for %q", state.QName())
 		return lh.nextOrFailure(ctx, state, r, dns.RcodeNameError)
 	}
 
-	if len(dnsRecords) == 0 {
-		log.Debugf("Couldn't find a connected cluster or valid IPs for %q", state.QName())
-		return lh.emptyResponse(state)
+	if status == resolver.LookupUnhealthy {
+		log.Debugf("The endpoints of all the clusters of %q are unhealthy", state.QName())
+		return dns.RcodeServerFailure, lh.error("all clusters are unhealthy")
 	}
 
-	if state.QType() == dns.TypeAAAA {
-		log.Debugf("Returning empty response for TypeAAAA query")
+	if len(dnsRecords) == 0 {
+		log.Debugf("Couldn't find a connected cluster or valid IPs for %q", state.QName())
 		return lh.emptyResponse(state)
 	}
 
@@ -93,9 +96,12 @@ func (lh *Lighthouse) getDNSRecord(ctx context.Context, zone string, state *requ
 
 	records := make([]dns.RR, 0)
 
-	if state.QType() == dns.TypeA {
-		records = lh.createARecords(dnsRecords, state)
-	} else if state.QType() == dns.TypeSRV {
+	switch state.QType() {
+	case dns.TypeA:
+		records = lh.createARecords(dnsRecords, state, pReq)
+	case dns.TypeAAAA:
+		records = lh.createAAAARecords(dnsRecords, state, pReq)
+	case dns.TypeSRV:
 		records = lh.createSRVRecords(dnsRecords, state, pReq, zone, isHeadless)
 	}
 
@@ -122,6 +128,19 @@ func (lh *Lighthouse) getDNSRecord(ctx context.Context, zone string, state *requ
 	return dns.RcodeSuccess, nil
 }
 
+// addressTypeFor returns the address type of the records that answer a query of the given type, so that eg an AAAA
+// query only considers clusters with an IPv6 service IP. SRV queries consider any record.
+func addressTypeFor(qtype uint16) discovery.AddressType {
+	switch qtype {
+	case dns.TypeA:
+		return discovery.AddressTypeIPv4
+	case dns.TypeAAAA:
+		return discovery.AddressTypeIPv6
+	default:
+		return ""
+	}
+}
+
 func (lh *Lighthouse) emptyResponse(state *request.Request) (int, error) {
 	a := new(dns.Msg)
 	a.SetReply(state.Req)
@@ -21,6 +21,7 @@ package lighthouse_test
 import (
 	"context"
 	"fmt"
+	"time"
 
 	"github.com/coredns/coredns/plugin"
 	"github.com/coredns/coredns/plugin/pkg/dnstest"
@@ -39,6 +40,7 @@ import (
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/client-go/dynamic/fake"
 	"k8s.io/client-go/kubernetes/scheme"
+	testingclock "k8s.io/utils/clock/testing"
 	mcsv1a1 "sigs.k8s.io/mcs-api/pkg/apis/v1alpha1"
 )
 
@@ -48,6 +50,7 @@ const (
 	namespace2  = "namespace2"
 	serviceIP   = "100.96.156.101"
 	serviceIP2  = "100.96.156.102"
+	serviceIPv6 = "fd00:10:96::65"
 	clusterID   = "cluster1"
 	clusterID2  = "cluster2"
 	endpointIP  = "100.96.157.101"
@@ -77,6 +80,9 @@ var _ = Describe("Lighthouse DNS plugin Handler", func() {
 	Context("Headless services", testHeadlessService)
 	Context("Local services", testLocalService)
 	Context("Service with multiple ports", testSRVMultiplePorts)
+	Context("Dual-stack services", testDualStackService)
+	Context("Per-record-type TTLs", testRecordTypeTTLs)
+	Context("Dynamic TTLs", testDynamicTTLs)
 })
 
 type FailingResponseWriter struct {
@@ -562,6 +568,26 @@ func testClusterStatus() {
 			})
 		})
 	})
+
+	When("the endpoints of all the service's clusters are unhealthy", func() {
+		JustBeforeEach(func() {
+			t.lh.Resolver.PutEndpointSlices(newEndpointSlice(namespace1, service1, clusterID, []mcsv1a1.ServicePort{port1},
+				newEndpoint(serviceIP, "", false)))
+
+			t.lh.Resolver.PutEndpointSlices(newEndpointSlice(namespace1, service1, clusterID2, []mcsv1a1.ServicePort{port1, port2},
+				newEndpoint(serviceIP2, "", false)))
+		})
+
+		qname := fmt.Sprintf("%s.%s.svc.clusterset.local.", service1, namespace1)
+
+		It("should return RcodeServerFailure for A record query", func() {
+			t.executeTestCase(rec, test.Case{
+				Qname: qname,
+				Qtype: dns.TypeA,
+				Rcode: dns.RcodeServerFailure,
+			})
+		})
+	})
 }
 
 func testHeadlessService() {
@@ -716,6 +742,58 @@ func testHeadlessService() {
 		})
 	})
 
+	When("headless service has two endpoints with the same hostname", func() {
+		BeforeEach(func() {
+			endpoints = append(endpoints, newEndpoint(endpointIP, hostName1, true), newEndpoint(endpointIP2, hostName1, true))
+		})
+
+		qname := fmt.Sprintf("%s.%s.svc.clusterset.local.", service1, namespace1)
+
+		It("should succeed and write a single SRV record for the shared target with the summed weight", func() {
+			t.executeTestCase(rec, test.Case{
+				Qname: qname,
+				Qtype: dns.TypeSRV,
+				Rcode: dns.RcodeSuccess,
+				Answer: []dns.RR{
+					test.SRV(fmt.Sprintf("%s    5    IN    SRV  0 100 %d %s.%s.%s", qname, port1.Port, hostName1, clusterID, qname)),
+				},
+			})
+		})
+
+		It("should still write an A record for each endpoint", func() {
+			t.executeTestCase(rec, test.Case{
+				Qname: qname,
+				Qtype: dns.TypeA,
+				Rcode: dns.RcodeSuccess,
+				Answer: []dns.RR{
+					test.A(fmt.Sprintf("%s    5    IN    A    %s", qname, endpointIP)),
+					test.A(fmt.Sprintf("%s    5    IN    A    %s", qname, endpointIP2)),
+				},
+			})
+		})
+	})
+
+	When("headless service has a dual-stack endpoint", func() {
+		const endpointIPv6 = "fd00::10"
+
+		BeforeEach(func() {
+			endpoints = append(endpoints, newEndpoint(endpointIP, hostName1, true), newEndpoint(endpointIPv6, hostName1, true))
+		})
+
+		qname := fmt.Sprintf("%s.%s.svc.clusterset.local.", service1, namespace1)
+
+		It("should succeed and write a single SRV record for its target with the weight of one endpoint", func() {
+			t.executeTestCase(rec, test.Case{
+				Qname: qname,
+				Qtype: dns.TypeSRV,
+				Rcode: dns.RcodeSuccess,
+				Answer: []dns.RR{
+					test.SRV(fmt.Sprintf("%s    5    IN    SRV  0 50 %d %s.%s.%s", qname, port1.Port, hostName1, clusterID, qname)),
+				},
+			})
+		})
+	})
+
 	When("headless service is present in two clusters", func() {
 		BeforeEach(func() {
 			t.lh.Resolver.PutServiceImport(newServiceImport(namespace1, service1, mcsv1a1.Headless))
@@ -963,6 +1041,236 @@ type handlerTestDriver struct {
 	lh     *lighthouse.Lighthouse
 }
 
+func testDualStackService() {
+	var (
+		rec *dnstest.Recorder
+		t   *handlerTestDriver
+	)
+
+	BeforeEach(func() {
+		t = newHandlerTestDriver()
+		t.mockCs.ConnectClusterID(clusterID)
+
+		t.lh.Resolver.PutServiceImport(newServiceImport(namespace1, service1, mcsv1a1.ClusterSetIP))
+
+		endpoint := newEndpoint(serviceIP, "", true)
+		endpoint.Addresses = append(endpoint.Addresses, serviceIPv6)
+		t.lh.Resolver.PutEndpointSlices(newEndpointSlice(namespace1, service1, clusterID, []mcsv1a1.ServicePort{port1}, endpoint))
+
+		t.lh.Resolver.PutServiceImport(newServiceImport(namespace2, service1, mcsv1a1.ClusterSetIP))
+		t.lh.Resolver.PutEndpointSlices(newEndpointSlice(namespace2, service1, clusterID, []mcsv1a1.ServicePort{port1},
+			newEndpoint(serviceIP, "", true)))
+
+		rec = dnstest.NewRecorder(&test.ResponseWriter{})
+	})
+
+	Context("DNS query for a dual-stack service", func() {
+		qname := fmt.Sprintf("%s.%s.svc.clusterset.local.", service1, namespace1)
+
+		Specify("of Type A record should write an A record response with the IPv4 address", func() {
+			t.executeTestCase(rec, test.Case{
+				Qname: qname,
+				Qtype: dns.TypeA,
+				Rcode: dns.RcodeSuccess,
+				Answer: []dns.RR{
+					test.A(fmt.Sprintf("%s    5    IN    A    %s", qname, serviceIP)),
+				},
+			})
+		})
+
+		Specify("of Type AAAA record should write an AAAA record response with the IPv6 address", func() {
+			t.executeTestCase(rec, test.Case{
+				Qname: qname,
+				Qtype: dns.TypeAAAA,
+				Rcode: dns.RcodeSuccess,
+				Answer: []dns.RR{
+					test.AAAA(fmt.Sprintf("%s    5    IN    AAAA    %s", qname, serviceIPv6)),
+				},
+			})
+		})
+	})
+
+	Context("DNS query of Type AAAA for an IPv4-only service", func() {
+		qname := fmt.Sprintf("%s.%s.svc.clusterset.local.", service1, namespace2)
+
+		Specify("should return an empty response", func() {
+			t.executeTestCase(rec, test.Case{
+				Qname:  qname,
+				Qtype:  dns.TypeAAAA,
+				Rcode:  dns.RcodeSuccess,
+				Answer: []dns.RR{},
+			})
+		})
+	})
+}
+
+func testRecordTypeTTLs() {
+	var (
+		rec *dnstest.Recorder
+		t   *handlerTestDriver
+		si  *mcsv1a1.ServiceImport
+	)
+
+	qname := fmt.Sprintf("%s.%s.svc.clusterset.local.", service1, namespace1)
+
+	BeforeEach(func() {
+		t = newHandlerTestDriver()
+		t.mockCs.ConnectClusterID(clusterID)
+
+		si = newServiceImport(namespace1, service1, mcsv1a1.ClusterSetIP)
+		rec = dnstest.NewRecorder(&test.ResponseWriter{})
+	})
+
+	JustBeforeEach(func() {
+		t.lh.Resolver.PutServiceImport(si)
+
+		t.lh.Resolver.PutEndpointSlices(newEndpointSlice(namespace1, service1, clusterID, []mcsv1a1.ServicePort{port1},
+			newEndpoint(serviceIP, "", true)))
+	})
+
+	When("TTLs are configured for the A and SRV record types", func() {
+		BeforeEach(func() {
+			si.Annotations = map[string]string{
+				constants.RecordTTLAnnotationPrefix + "/a":   "300",
+				constants.RecordTTLAnnotationPrefix + "/SRV": "10",
+			}
+		})
+
+		It("should write an A record response with the A TTL", func() {
+			t.executeTestCase(rec, test.Case{
+				Qtype: dns.TypeA,
+				Qname: qname,
+				Rcode: dns.RcodeSuccess,
+				Answer: []dns.RR{
+					test.A(fmt.Sprintf("%s    300    IN    A    %s", qname, serviceIP)),
+				},
+			})
+		})
+
+		It("should write an SRV record response with the SRV TTL", func() {
+			t.executeTestCase(rec, test.Case{
+				Qtype: dns.TypeSRV,
+				Qname: qname,
+				Rcode: dns.RcodeSuccess,
+				Answer: []dns.RR{
+					test.SRV(fmt.Sprintf("%s    10    IN    SRV 0 50 %d %s", qname, port1.Port, qname)),
+				},
+			})
+		})
+	})
+
+	When("a TTL is configured only for the SRV record type", func() {
+		BeforeEach(func() {
+			si.Annotations = map[string]string{constants.RecordTTLAnnotationPrefix + "/srv": "10"}
+		})
+
+		It("should write an A record response with the default TTL", func() {
+			t.executeTestCase(rec, test.Case{
+				Qtype: dns.TypeA,
+				Qname: qname,
+				Rcode: dns.RcodeSuccess,
+				Answer: []dns.RR{
+					test.A(fmt.Sprintf("%s    5    IN    A    %s", qname, serviceIP)),
+				},
+			})
+		})
+	})
+
+	When("a TTL is configured for the service's records", func() {
+		BeforeEach(func() {
+			si.Annotations = map[string]string{constants.RecordTTLAnnotation: "60"}
+		})
+
+		It("should write an A record response with the service's TTL", func() {
+			t.executeTestCase(rec, test.Case{
+				Qtype: dns.TypeA,
+				Qname: qname,
+				Rcode: dns.RcodeSuccess,
+				Answer: []dns.RR{
+					test.A(fmt.Sprintf("%s    60    IN    A    %s", qname, serviceIP)),
+				},
+			})
+		})
+
+		Context("and for the A record type", func() {
+			BeforeEach(func() {
+				si.Annotations[constants.RecordTTLAnnotationPrefix+"/a"] = "300"
+			})
+
+			It("should write an A record response with the A TTL", func() {
+				t.executeTestCase(rec, test.Case{
+					Qtype: dns.TypeA,
+					Qname: qname,
+					Rcode: dns.RcodeSuccess,
+					Answer: []dns.RR{
+						test.A(fmt.Sprintf("%s    300    IN    A    %s", qname, serviceIP)),
+					},
+				})
+			})
+		})
+	})
+}
+
+func testDynamicTTLs() {
+	var (
+		rec       *dnstest.Recorder
+		t         *handlerTestDriver
+		si        *mcsv1a1.ServiceImport
+		fakeClock *testingclock.FakeClock
+	)
+
+	qname := fmt.Sprintf("%s.%s.svc.clusterset.local.", service1, namespace1)
+
+	BeforeEach(func() {
+		t = newHandlerTestDriver()
+		t.mockCs.ConnectClusterID(clusterID)
+
+		fakeClock = testingclock.NewFakeClock(time.Now())
+		t.lh.Resolver = resolver.New(t.mockCs, fake.NewSimpleDynamicClient(scheme.Scheme), resolver.WithClock(fakeClock),
+			resolver.WithDynamicTTL(10*time.Second, time.Minute))
+
+		si = newServiceImport(namespace1, service1, mcsv1a1.ClusterSetIP)
+		rec = dnstest.NewRecorder(&test.ResponseWriter{})
+	})
+
+	JustBeforeEach(func() {
+		t.lh.Resolver.PutServiceImport(si)
+
+		t.lh.Resolver.PutEndpointSlices(newEndpointSlice(namespace1, service1, clusterID, []mcsv1a1.ServicePort{port1},
+			newEndpoint(serviceIP, "", true)))
+
+		fakeClock.Step(30 * time.Second)
+	})
+
+	It("should write an A record response with the TTL derived from the time since the service changed", func() {
+		t.executeTestCase(rec, test.Case{
+			Qtype: dns.TypeA,
+			Qname: qname,
+			Rcode: dns.RcodeSuccess,
+			Answer: []dns.RR{
+				test.A(fmt.Sprintf("%s    30    IN    A    %s", qname, serviceIP)),
+			},
+		})
+	})
+
+	When("a TTL is also configured for the record type", func() {
+		BeforeEach(func() {
+			si.Annotations = map[string]string{constants.RecordTTLAnnotationPrefix + "/a": "300"}
+		})
+
+		It("should write an A record response with the record type's TTL", func() {
+			t.executeTestCase(rec, test.Case{
+				Qtype: dns.TypeA,
+				Qname: qname,
+				Rcode: dns.RcodeSuccess,
+				Answer: []dns.RR{
+					test.A(fmt.Sprintf("%s    300    IN    A    %s", qname, serviceIP)),
+				},
+			})
+		})
+	})
+}
+
 func newHandlerTestDriver() *handlerTestDriver {
 	t := &handlerTestDriver{
 		mockCs: fakecs.NewClusterStatus(""),
@@ -20,6 +20,7 @@ package lighthouse
 
 import (
 	"github.com/prometheus/client_golang/prometheus"
+	"github.com/submariner-io/lighthouse/coredns/resolver/metrics"
 )
 
 const (
@@ -32,7 +33,10 @@ const (
 	ServiceDiscoveryQueryCounterName = "submariner_service_discovery_query"
 )
 
-var dnsQueryCounter *prometheus.GaugeVec
+var (
+	dnsQueryCounter  *prometheus.GaugeVec
+	selectionMetrics = metrics.NewSelections()
+)
 
 func init() {
 	dnsQueryCounter = prometheus.NewGaugeVec(
@@ -43,7 +47,7 @@ func init() {
 		[]string{srcClusterKey, dstClusterKey, dstSvcNameKey, dstSvcNamespaceKey, dstSvcIPKey},
 	)
 
-	prometheus.MustRegister(dnsQueryCounter)
+	prometheus.MustRegister(dnsQueryCounter, selectionMetrics)
 }
 
 func incDNSQueryCounter(srcCluster, dstCluster, dstSvcName, dstSvcNamespace, dstSvcIP string) {
@@ -19,6 +19,7 @@ limitations under the License.
 package lighthouse
 
 import (
+	"math"
 	"net"
 	"strings"
 
@@ -28,14 +29,58 @@ import (
 	"sigs.k8s.io/mcs-api/pkg/apis/v1alpha1"
 )
 
-func (lh *Lighthouse) createARecords(dnsrecords []resolver.DNSRecord, state *request.Request) []dns.RR {
+const srvWeight = 50
+
+type srvTargetKey struct {
+	target string
+	port   uint16
+}
+
+// recordTTL returns the TTL for answers of the given record type for the requested service, using the TTL configured
+// for that record type on the service, if any, otherwise the service's dynamic TTL, if enabled, otherwise the TTL of
+// the selected records, if specified, otherwise the plugin's TTL.
+func (lh *Lighthouse) recordTTL(pReq *recordRequest, recordType uint16, dnsrecords []resolver.DNSRecord) uint32 {
+	if ttl, found := lh.Resolver.RecordTypeTTL(pReq.namespace, pReq.service, dns.TypeToString[recordType]); found {
+		return ttl
+	}
+
+	if ttl, found := lh.Resolver.RecordTTL(pReq.namespace, pReq.service); found {
+		return uint32(ttl.Seconds())
+	}
+
+	for i := range dnsrecords {
+		if dnsrecords[i].TTL > 0 {
+			return dnsrecords[i].TTL
+		}
+	}
+
+	return lh.TTL
+}
+
+func (lh *Lighthouse) createARecords(dnsrecords []resolver.DNSRecord, state *request.Request, pReq *recordRequest) []dns.RR {
 	records := make([]dns.RR, 0)
+	ttl := lh.recordTTL(pReq, dns.TypeA, dnsrecords)
 
 	for _, record := range dnsrecords {
 		dnsRecord := &dns.A{Hdr: dns.RR_Header{
 			Name: state.QName(), Rrtype: dns.TypeA, Class: state.QClass(),
-			Ttl: lh.TTL,
-		}, A: net.ParseIP(record.IP).To4()}
+			Ttl: ttl,
+		}, A: net.ParseIP(record.IPv4()).To4()}
+		records = append(records, dnsRecord)
+	}
+
+	return records
+}
+
+func (lh *Lighthouse) createAAAARecords(dnsrecords []resolver.DNSRecord, state *request.Request, pReq *recordRequest) []dns.RR {
+	records := make([]dns.RR, 0)
+	ttl := lh.recordTTL(pReq, dns.TypeAAAA, dnsrecords)
+
+	for _, record := range dnsrecords {
+		dnsRecord := &dns.AAAA{Hdr: dns.RR_Header{
+			Name: state.QName(), Rrtype: dns.TypeAAAA, Class: state.QClass(),
+			Ttl: ttl,
+		}, AAAA: net.ParseIP(record.IPv6())}
 		records = append(records, dnsRecord)
 	}
 
@@ -47,6 +92,10 @@ func (lh *Lighthouse) createSRVRecords(dnsrecords []resolver.DNSRecord, state *r
 ) []dns.RR {
 	var records []dns.RR
 
+	ttl := lh.recordTTL(pReq, dns.TypeSRV, dnsrecords)
+	recordsByTarget := map[srvTargetKey]*dns.SRV{}
+	endpointsByTarget := map[srvTargetKey]map[bool]int{}
+
 	for _, dnsRecord := range dnsrecords {
 		var reqPorts []v1alpha1.ServicePort
 
@@ -81,17 +130,49 @@ func (lh *Lighthouse) createSRVRecords(dnsrecords []resolver.DNSRecord, state *r
 			target = dnsRecord.HostName + "." + target
 		}
 
+		isIPv4 := net.ParseIP(dnsRecord.IP).To4() != nil
+
 		for _, port := range reqPorts {
+			key := srvTargetKey{target: target, port: uint16(port.Port)}
+
+			// Equivalent targets, eg shared by multiple endpoints, are de-duplicated by summing their weights. A dual-stack
+			// endpoint has a record per address family so the endpoints are counted per family to weigh it only once.
+			endpoints := endpointsByTarget[key]
+			if endpoints == nil {
+				endpoints = map[bool]int{}
+				endpointsByTarget[key] = endpoints
+			}
+
+			endpoints[isIPv4]++
+
+			if existing, found := recordsByTarget[key]; found {
+				if weight := srvWeightFor(endpoints[isIPv4]); weight > existing.Weight {
+					existing.Weight = weight
+				}
+
+				continue
+			}
+
 			record := &dns.SRV{
-				Hdr:      dns.RR_Header{Name: state.QName(), Rrtype: dns.TypeSRV, Class: state.QClass(), Ttl: lh.TTL},
+				Hdr:      dns.RR_Header{Name: state.QName(), Rrtype: dns.TypeSRV, Class: state.QClass(), Ttl: ttl},
 				Priority: 0,
-				Weight:   50,
-				Port:     uint16(port.Port),
+				Weight:   srvWeight,
+				Port:     key.port,
 				Target:   target,
 			}
+			recordsByTarget[key] = record
 			records = append(records, record)
 		}
 	}
 
 	return records
 }
+
+// srvWeightFor returns the weight of an SRV target shared by the given number of endpoints.
+func srvWeightFor(endpoints int) uint16 {
+	if endpoints > math.MaxUint16/srvWeight {
+		return math.MaxUint16
+	}
+
+	return uint16(endpoints * srvWeight)
+}
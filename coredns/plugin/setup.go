@@ -21,6 +21,7 @@ package lighthouse
 import (
 	"flag"
 	"strconv"
+	"time"
 
 	"github.com/coredns/caddy"
 	"github.com/coredns/coredns/core/dnsserver"
@@ -89,40 +90,11 @@ func lighthouseParse(c *caddy.Controller) (*Lighthouse, error) {
 		return nil, errors.Wrap(err, "error building kubeconfig")
 	}
 
-	gwController := gateway.NewController()
-
-	localClient, err := newDynamicClient(cfg)
-	if err != nil {
-		return nil, errors.Wrap(err, "error creating local client")
-	}
-
 	lh := &Lighthouse{
-		TTL:           defaultTTL,
-		ClusterStatus: gwController,
-		Resolver:      resolver.New(gwController, localClient),
-	}
-
-	err = gwController.Start(localClient)
-	if err != nil {
-		return nil, errors.Wrap(err, "error starting the Gateway controller")
-	}
-
-	resolverController := resolver.NewController(lh.Resolver)
-
-	err = resolverController.Start(watcher.Config{
-		RestConfig: cfg,
-		Client:     localClient,
-		RestMapper: restMapper,
-	})
-	if err != nil {
-		return nil, errors.Wrap(err, "error starting the resolver controller")
+		TTL: defaultTTL,
 	}
 
-	c.OnShutdown(func() error {
-		gwController.Stop()
-		resolverController.Stop()
-		return nil
-	})
+	resolverOptions := []resolver.Option{resolver.WithResolutionSink(selectionMetrics)}
 
 	// Changed `for` to `if` to satisfy golint:
 	//	 SA4004: the surrounding loop is unconditionally terminated (staticcheck)
@@ -155,6 +127,13 @@ func lighthouseParse(c *caddy.Controller) (*Lighthouse, error) {
 				}
 
 				lh.TTL = t
+			case "dynamic_ttl":
+				minTTL, maxTTL, err := parseDynamicTTL(c)
+				if err != nil {
+					return nil, err
+				}
+
+				resolverOptions = append(resolverOptions, resolver.WithDynamicTTL(minTTL, maxTTL))
 			default:
 				if c.Val() != "}" {
 					return nil, c.Errf("unknown property '%s'", c.Val()) //nolint:wrapcheck // No need to wrap this.
@@ -163,6 +142,40 @@ func lighthouseParse(c *caddy.Controller) (*Lighthouse, error) {
 		}
 	}
 
+	gwController := gateway.NewController()
+
+	localClient, err := newDynamicClient(cfg)
+	if err != nil {
+		return nil, errors.Wrap(err, "error creating local client")
+	}
+
+	lh.ClusterStatus = gwController
+	lh.Resolver = resolver.New(gwController, localClient, resolverOptions...)
+
+	selectionMetrics.Track(lh.Resolver)
+
+	err = gwController.Start(localClient)
+	if err != nil {
+		return nil, errors.Wrap(err, "error starting the Gateway controller")
+	}
+
+	resolverController := resolver.NewController(lh.Resolver)
+
+	err = resolverController.Start(watcher.Config{
+		RestConfig: cfg,
+		Client:     localClient,
+		RestMapper: restMapper,
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "error starting the resolver controller")
+	}
+
+	c.OnShutdown(func() error {
+		gwController.Stop()
+		resolverController.Stop()
+		return nil
+	})
+
 	return lh, nil
 }
 
@@ -185,6 +198,32 @@ func parseTTL(c *caddy.Controller) (uint32, error) {
 	return uint32(t), nil
 }
 
+// parseDynamicTTL parses the minimum and maximum of the "dynamic_ttl" property, eg "dynamic_ttl 5s 5m", which are
+// positive durations of at most an hour, the maximum being no less than the minimum.
+func parseDynamicTTL(c *caddy.Controller) (time.Duration, time.Duration, error) {
+	args := c.RemainingArgs()
+	if len(args) != 2 {
+		return 0, 0, c.ArgErr() //nolint:wrapcheck // No need to wrap this.
+	}
+
+	minTTL, err := time.ParseDuration(args[0])
+	if err != nil {
+		return 0, 0, errors.Wrap(err, "error parsing the minimum dynamic TTL")
+	}
+
+	maxTTL, err := time.ParseDuration(args[1])
+	if err != nil {
+		return 0, 0, errors.Wrap(err, "error parsing the maximum dynamic TTL")
+	}
+
+	if minTTL <= 0 || maxTTL < minTTL || maxTTL > time.Hour {
+		//nolint:wrapcheck // No need to wrap this.
+		return 0, 0, c.Errf("dynamic_ttl must satisfy 0 < min <= max <= 1h: %s %s", args[0], args[1])
+	}
+
+	return minTTL, maxTTL, nil
+}
+
 func init() {
 	flag.StringVar(&kubeconfig, "kubeconfig", "", "Path to a kubeconfig. Only required if out-of-cluster.")
 	flag.StringVar(&masterURL, "master", "",
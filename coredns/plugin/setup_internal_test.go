@@ -21,6 +21,7 @@ package lighthouse
 import (
 	"context"
 	"errors"
+	"time"
 
 	"github.com/coredns/caddy"
 	"github.com/coredns/coredns/core/dnsserver"
@@ -30,6 +31,7 @@ import (
 	. "github.com/onsi/gomega"
 	"github.com/submariner-io/admiral/pkg/syncer/test"
 	discovery "k8s.io/api/discovery/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime/schema"
 	"k8s.io/client-go/dynamic"
 	fakeClient "k8s.io/client-go/dynamic/fake"
@@ -146,6 +148,25 @@ func testCorrectConfig() {
 		})
 	})
 
+	When("dynamic_ttl arguments are specified", func() {
+		BeforeEach(func() {
+			config = `lighthouse {
+			    dynamic_ttl 10s 1m
+            }`
+		})
+
+		It("should enable the resolver's dynamic TTL with the bounds", func() {
+			lh.Resolver.PutServiceImport(&mcsv1a1.ServiceImport{
+				ObjectMeta: metav1.ObjectMeta{Name: "nginx", Namespace: "test"},
+				Spec:       mcsv1a1.ServiceImportSpec{Type: mcsv1a1.ClusterSetIP},
+			})
+
+			ttl, found := lh.Resolver.RecordTTL("test", "nginx")
+			Expect(found).To(BeTrue())
+			Expect(ttl).To(Equal(10 * time.Second))
+		})
+	})
+
 	It("Should handle missing optional fields", func() {
 		config := `lighthouse`
 		c := caddy.NewTestController("dns", config)
@@ -217,6 +238,38 @@ func testIncorrectConfig() {
 		})
 	})
 
+	When("dynamic_ttl is specified with a missing argument", func() {
+		BeforeEach(func() {
+			config = `lighthouse {
+                dynamic_ttl 10s
+		    } noplugin`
+
+			buildKubeConfigFunc = func(masterUrl, kubeconfigPath string) (*rest.Config, error) {
+				return &rest.Config{}, nil
+			}
+		})
+
+		It("should return an appropriate plugin error", func() {
+			verifyPluginError(setupErr, "Wrong argument count")
+		})
+	})
+
+	When("dynamic_ttl is specified with a maximum below the minimum", func() {
+		BeforeEach(func() {
+			config = `lighthouse {
+                dynamic_ttl 1m 10s
+		    } noplugin`
+
+			buildKubeConfigFunc = func(masterUrl, kubeconfigPath string) (*rest.Config, error) {
+				return &rest.Config{}, nil
+			}
+		})
+
+		It("should return an appropriate plugin error", func() {
+			verifyPluginError(setupErr, "dynamic_ttl must satisfy 0 < min <= max <= 1h: 1m 10s")
+		})
+	})
+
 	When("building the kubeconfig fails", func() {
 		BeforeEach(func() {
 			config = PluginName
@@ -0,0 +1,870 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+
+Copyright Contributors to the Submariner project.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package resolver
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/submariner-io/lighthouse/coredns/constants"
+	mcsv1a1 "sigs.k8s.io/mcs-api/pkg/apis/v1alpha1"
+)
+
+// ClustersByWeight returns the clusters backing the given service sorted by descending weight, with ties broken by
+// cluster name.
+func (i *Interface) ClustersByWeight(namespace, name string) []ClusterWeight {
+	key, shard := i.rlockService(namespace, name)
+	defer i.runlockShard(shard)
+
+	serviceInfo, found := shard.services[key]
+	if !found {
+		return nil
+	}
+
+	return serviceInfo.clustersByWeight()
+}
+
+// PortContributors returns, for each port advertised by any of the given service's clusters, the names of the clusters
+// that advertise it. The map is keyed by "<name>/<protocol>/<port>".
+func (i *Interface) PortContributors(namespace, name string) (map[string][]string, bool) {
+	key, shard := i.rlockService(namespace, name)
+	defer i.runlockShard(shard)
+
+	serviceInfo, found := shard.services[key]
+	if !found {
+		return nil, false
+	}
+
+	return serviceInfo.portContributors(), true
+}
+
+// ClusterUniquePorts returns, for each cluster of the given ClusterIP service, the ports it advertises that aren't in
+// the merged set of ports. Clusters that advertise no such ports are omitted.
+func (i *Interface) ClusterUniquePorts(namespace, name string) (map[string][]mcsv1a1.ServicePort, bool) {
+	key, shard := i.rlockService(namespace, name)
+	defer i.runlockShard(shard)
+
+	serviceInfo, found := shard.services[key]
+	if !found || serviceInfo.isHeadless {
+		return nil, false
+	}
+
+	return serviceInfo.clusterUniquePorts(), true
+}
+
+// GetBestDNSRecord returns the record of the local cluster if it's healthy and preferred, otherwise the record of the
+// healthy cluster with the highest weight, with ties broken by cluster name. Evicted clusters, see
+// WithEmptyEndpointsEviction, are skipped. Unlike GetDNSRecords, the result is deterministic across calls. No record is
+// returned for a headless service.
+func (i *Interface) GetBestDNSRecord(namespace, name string) (*DNSRecord, bool) {
+	key, shard := i.rlockService(namespace, name)
+	defer i.runlockShard(shard)
+
+	serviceInfo, found := shard.services[key]
+	if !found {
+		return nil, false
+	}
+
+	if serviceInfo.isHeadless {
+		return nil, true
+	}
+
+	localClusterID := i.getLocalClusterID()
+	if localClusterID != "" && !serviceInfo.ignoreLocal {
+		clusterInfo, found := serviceInfo.clusters[localClusterID]
+		if found && clusterInfo.isServing() && clusterInfo.hasRecord() && !i.isEvicted(clusterInfo) {
+			return serviceInfo.newRecordFrom(&clusterInfo.endpointRecords[0]).clone(), true
+		}
+	}
+
+	for _, c := range serviceInfo.clustersByWeight() {
+		info := serviceInfo.clusters[c.Cluster]
+		if i.isClusterHealthy(c.Cluster, info) && info.hasRecord() && !i.isEvicted(info) {
+			return serviceInfo.newRecordFrom(&info.endpointRecords[0]).clone(), true
+		}
+	}
+
+	return nil, true
+}
+
+// PortsVersion returns a counter that's incremented each time the given service's merged ports change. Callers can
+// compare versions to cheaply detect port changes.
+func (i *Interface) PortsVersion(namespace, name string) (uint64, bool) {
+	key, shard := i.rlockService(namespace, name)
+	defer i.runlockShard(shard)
+
+	serviceInfo, found := shard.services[key]
+	if !found {
+		return 0, false
+	}
+
+	return serviceInfo.portsVersion, true
+}
+
+// SelectionProbabilities returns the probability of each cluster being selected for the given ClusterIP service when no
+// specific cluster is requested, considering only the clusters that pass the given endpoint check, if any. The
+// candidates are those a lookup selects among, ie the cluster the service is pinned to or the healthy local cluster if
+// preferred, otherwise the healthy clusters of the local region and cheapest cost tier, if configured, in proportion to
+// their load balancer weights. The policies for when no cluster is healthy or every healthy cluster is drained apply as
+// for a lookup. Clusters that can't be selected are omitted.
+func (i *Interface) SelectionProbabilities(namespace, name string, checkEndpoint func(namespace, name, clusterID string) bool,
+) map[string]float64 {
+	key, shard := i.rlockService(namespace, name)
+	defer i.runlockShard(shard)
+
+	serviceInfo, found := shard.services[key]
+	if !found || serviceInfo.isHeadless {
+		return nil
+	}
+
+	filter := &selectionFilter{checkCluster: checkClusters(namespace, name, serviceInfo, EndpointsCheckFrom(checkEndpoint))}
+
+	if info, found := serviceInfo.clusters[serviceInfo.pinnedCluster]; found && filter.allows(info) {
+		return map[string]float64{serviceInfo.pinnedCluster: 1}
+	}
+
+	localClusterID := i.getLocalClusterID()
+	if info, found := serviceInfo.clusters[localClusterID]; found && !serviceInfo.ignoreLocal && info.isServing() &&
+		filter.allows(info) {
+		return map[string]float64{localClusterID: 1}
+	}
+
+	isSelectable := func(name string) bool {
+		info := serviceInfo.clusters[name]
+		return i.clusterStatus.IsConnected(name) && filter.allows(info) && info.isServing() && !i.isEvicted(info)
+	}
+
+	if drained := serviceInfo.drainedClusters(isSelectable); drained != nil {
+		return i.drainedProbabilities(serviceInfo, drained)
+	}
+
+	candidates := i.selectionCandidates(serviceInfo, isSelectable)
+
+	if len(candidates) == 0 {
+		if serviceInfo.serveLastResort {
+			if record := i.selectLastResort(serviceInfo, filter); record != nil {
+				return map[string]float64{record.ClusterName: 1}
+			}
+		}
+
+		return map[string]float64{}
+	}
+
+	total := int64(0)
+	for _, name := range candidates {
+		total += serviceInfo.balancedWeights[name]
+	}
+
+	probabilities := make(map[string]float64, len(candidates))
+
+	for _, name := range candidates {
+		probabilities[name] = float64(serviceInfo.balancedWeights[name]) / float64(total)
+	}
+
+	return probabilities
+}
+
+// selectionCandidates returns the selectable clusters with a positive load balancer weight that a balanced selection
+// chooses among, ie those in the local region, if configured and any are selectable, and then those in the cheapest
+// cost tier, if cost-aware selection is enabled.
+func (i *Interface) selectionCandidates(serviceInfo *serviceInfo, isSelectable func(string) bool) []string {
+	if i.localRegion != "" {
+		if candidates := i.selectionCandidatesByCost(serviceInfo,
+			serviceInfo.clustersInRegion(i.localRegion, isSelectable)); len(candidates) > 0 {
+			return candidates
+		}
+	}
+
+	return i.selectionCandidatesByCost(serviceInfo, isSelectable)
+}
+
+func (i *Interface) selectionCandidatesByCost(serviceInfo *serviceInfo, isSelectable func(string) bool) []string {
+	if i.costAwareSelection {
+		tiers := serviceInfo.costTiers(isSelectable)
+		if len(tiers) == 0 {
+			return nil
+		}
+
+		isSelectable = serviceInfo.clustersCosting(tiers[0], isSelectable)
+	}
+
+	var candidates []string
+
+	for _, name := range serviceInfo.clusterNames() {
+		if serviceInfo.balancedWeights[name] > 0 && serviceInfo.clusters[name].hasRecord() && isSelectable(name) {
+			candidates = append(candidates, name)
+		}
+	}
+
+	return candidates
+}
+
+// drainedProbabilities returns the selection probabilities of the given drained clusters per the service's
+// "lighthouse.submariner.io/on-all-drained" policy.
+func (i *Interface) drainedProbabilities(serviceInfo *serviceInfo, drained []string) map[string]float64 {
+	switch serviceInfo.onAllDrained {
+	case constants.OnAllDrainedReturnEmpty:
+		return map[string]float64{}
+	case constants.OnAllDrainedServeLeastDrained:
+		best := drained[0]
+		for _, name := range drained[1:] {
+			if serviceInfo.clusters[name].weight > serviceInfo.clusters[best].weight {
+				best = name
+			}
+		}
+
+		return map[string]float64{best: 1}
+	}
+
+	probabilities := make(map[string]float64, len(drained))
+	for _, name := range drained {
+		probabilities[name] = 1 / float64(len(drained))
+	}
+
+	return probabilities
+}
+
+// WeightShares returns the percentage of the total load balancing weight of the given ClusterIP service configured for
+// each of its clusters, eg for display. Unlike SelectionProbabilities, the health of the clusters isn't considered.
+func (i *Interface) WeightShares(namespace, name string) map[string]float64 {
+	key, shard := i.rlockService(namespace, name)
+	defer i.runlockShard(shard)
+
+	serviceInfo, found := shard.services[key]
+	if !found || serviceInfo.isHeadless {
+		return nil
+	}
+
+	total := int64(0)
+	for _, info := range serviceInfo.clusters {
+		total += info.weight
+	}
+
+	shares := make(map[string]float64, len(serviceInfo.clusters))
+
+	for name, info := range serviceInfo.clusters {
+		if total > 0 {
+			shares[name] = 100 * float64(info.weight) / float64(total)
+		}
+	}
+
+	return shares
+}
+
+// EffectiveWeights returns the weight each cluster of the given ClusterIP service has in its load balancer, ie after
+// the annotation weights are clamped and adjusted for health decay, the local weight multiplier, the ready endpoints,
+// the minimum share and any traffic shift, eg to confirm the annotations took effect. Clusters that couldn't be added
+// to the load balancer are omitted. Returns false if the service isn't found or is headless.
+func (i *Interface) EffectiveWeights(namespace, name string) (map[string]int64, bool) {
+	key, shard := i.rlockService(namespace, name)
+	defer i.runlockShard(shard)
+
+	serviceInfo, found := shard.services[key]
+	if !found || serviceInfo.isHeadless {
+		return nil, false
+	}
+
+	weights := make(map[string]int64, len(serviceInfo.balancedWeights))
+	for name, weight := range serviceInfo.balancedWeights {
+		weights[name] = weight
+	}
+
+	return weights, true
+}
+
+// ReleaseDNSRecord releases an in-flight selection of the given cluster previously returned by GetDNSRecords before
+// its lease expires. This only has an effect if in-flight limits are enabled via WithInFlightLimits.
+func (i *Interface) ReleaseDNSRecord(namespace, name, clusterID string) {
+	key, shard := i.rlockService(namespace, name)
+	defer i.runlockShard(shard)
+
+	serviceInfo, found := shard.services[key]
+	if !found {
+		return
+	}
+
+	serviceInfo.release(i.normalizeClusterName(clusterID))
+}
+
+// RecordTypeTTL returns the TTL, in seconds, configured for the given record type, eg "A" or "SRV", of the given
+// service's answers. Returns false if none is configured.
+func (i *Interface) RecordTypeTTL(namespace, name, recordType string) (uint32, bool) {
+	key, shard := i.rlockService(namespace, name)
+	defer i.runlockShard(shard)
+
+	serviceInfo, found := shard.services[key]
+	if !found {
+		return 0, false
+	}
+
+	ttl, found := serviceInfo.recordTTLs[strings.ToUpper(recordType)]
+
+	return ttl, found
+}
+
+// LocalClusterID returns the cluster preferred as local when resolving ClusterIP services. This is the value set via
+// SetLocalClusterID, if any, otherwise the local cluster reported by the ClusterStatus.
+func (i *Interface) LocalClusterID() string {
+	i.mutex.RLock()
+	defer i.mutex.RUnlock()
+
+	return i.getLocalClusterID()
+}
+
+// SetLocalClusterID overrides the cluster preferred as local for subsequent resolutions. An empty ID reverts to the
+// local cluster reported by the ClusterStatus.
+func (i *Interface) SetLocalClusterID(id string) {
+	i.mutex.Lock()
+	defer i.mutex.Unlock()
+
+	i.localClusterID = id
+}
+
+// RecordTTL returns the TTL for the given service's records derived from the time since it last changed, bounded by
+// the limits configured via WithDynamicTTL. Returns false if dynamic TTLs aren't enabled or the service doesn't exist.
+func (i *Interface) RecordTTL(namespace, name string) (time.Duration, bool) {
+	if i.maxTTL <= 0 {
+		return 0, false
+	}
+
+	key, shard := i.rlockService(namespace, name)
+	defer i.runlockShard(shard)
+
+	serviceInfo, found := shard.services[key]
+	if !found {
+		return 0, false
+	}
+
+	ttl := i.clock.Since(serviceInfo.lastChanged)
+
+	switch {
+	case ttl < i.minTTL:
+		ttl = i.minTTL
+	case ttl > i.maxTTL:
+		ttl = i.maxTTL
+	}
+
+	return ttl, true
+}
+
+// QueryCount returns the number of DNS record lookups for the given service, regardless of whether any records were
+// returned. Lookups for a service that doesn't exist aren't counted.
+func (i *Interface) QueryCount(namespace, name string) uint64 {
+	key, shard := i.rlockService(namespace, name)
+	defer i.runlockShard(shard)
+
+	serviceInfo, found := shard.services[key]
+	if !found {
+		return 0
+	}
+
+	return atomic.LoadUint64(&serviceInfo.queryCount)
+}
+
+// LastSelectedTimes returns the time each cluster of the given ClusterIP service was last selected by the load
+// balancer. Clusters that have never been selected are omitted.
+func (i *Interface) LastSelectedTimes(namespace, name string) map[string]time.Time {
+	key, shard := i.rlockService(namespace, name)
+	defer i.runlockShard(shard)
+
+	serviceInfo, found := shard.services[key]
+	if !found || serviceInfo.isHeadless {
+		return nil
+	}
+
+	times := map[string]time.Time{}
+
+	for name, info := range serviceInfo.clusters {
+		if lastSelected := atomic.LoadInt64(&info.lastSelected); lastSelected != 0 {
+			times[name] = time.Unix(0, lastSelected)
+		}
+	}
+
+	return times
+}
+
+// IsRemoteOnly returns whether the given ClusterIP service is currently only present in remote clusters, ie it's
+// absent from the known local cluster but present in others.
+func (i *Interface) IsRemoteOnly(namespace, name string) bool {
+	key, shard := i.rlockService(namespace, name)
+	defer i.runlockShard(shard)
+
+	serviceInfo, found := shard.services[key]
+	if !found || serviceInfo.isHeadless || len(serviceInfo.clusters) == 0 {
+		return false
+	}
+
+	localClusterID := i.getLocalClusterID()
+	if localClusterID == "" {
+		return false
+	}
+
+	_, found = serviceInfo.clusters[localClusterID]
+
+	return !found
+}
+
+// RemoteOnlyResolutions returns the number of times the given ClusterIP service was resolved to a remote cluster
+// while absent from the known local cluster.
+func (i *Interface) RemoteOnlyResolutions(namespace, name string) int64 {
+	key, shard := i.rlockService(namespace, name)
+	defer i.runlockShard(shard)
+
+	serviceInfo, found := shard.services[key]
+	if !found {
+		return 0
+	}
+
+	return atomic.LoadInt64(&serviceInfo.remoteOnlyResolutions)
+}
+
+// SelectionCounts returns the number of times the given ClusterIP service was resolved to the local cluster and to a
+// remote cluster, ie fell back to remote, when not requesting a specific cluster while the local cluster is known.
+func (i *Interface) SelectionCounts(namespace, name string) (local, remote int64) {
+	key, shard := i.rlockService(namespace, name)
+	defer i.runlockShard(shard)
+
+	serviceInfo, found := shard.services[key]
+	if !found {
+		return 0, 0
+	}
+
+	return atomic.LoadInt64(&serviceInfo.localSelections), atomic.LoadInt64(&serviceInfo.remoteSelections)
+}
+
+// RemoteFallbackRatio returns the fraction of the selections counted by SelectionCounts that were of a remote cluster,
+// or zero if there were none. A rising ratio indicates that the local service is saturated or unhealthy, which can be
+// used to drive its autoscaling.
+func (i *Interface) RemoteFallbackRatio(namespace, name string) float64 {
+	local, remote := i.SelectionCounts(namespace, name)
+	if local+remote == 0 {
+		return 0
+	}
+
+	return float64(remote) / float64(local+remote)
+}
+
+// SetTrafficShift shifts the given percentage of the load balanced traffic of every ClusterIP service backed by the
+// target cluster to it, on top of the per-service weights. Note that a healthy local cluster is still preferred. A
+// percentage of zero disables the shift.
+func (i *Interface) SetTrafficShift(cluster string, percent int64) error {
+	if percent < 0 || percent > 100 {
+		return fmt.Errorf("traffic shift percentage %d must be in the range [0, 100]", percent)
+	}
+
+	i.mutex.Lock()
+	defer i.mutex.Unlock()
+
+	i.trafficShift = trafficShift{cluster: i.normalizeClusterName(cluster), percent: percent}
+
+	i.forEachService(func(_ string, serviceInfo *serviceInfo) {
+		if !serviceInfo.isHeadless {
+			serviceInfo.version++
+			serviceInfo.resetLoadBalancing()
+		}
+	})
+
+	logger.Infof("Set the global traffic shift to %d%% for cluster %q", percent, cluster)
+
+	return nil
+}
+
+// DownServices returns the keys, in the form "<namespace>/<name>", of the services of which every cluster fails the
+// given health check, sorted. A service without any clusters is also considered down.
+func (i *Interface) DownServices(checkEndpoint func(namespace, name, clusterID string) bool) []string {
+	i.mutex.RLock()
+	defer i.mutex.RUnlock()
+
+	down := []string{}
+
+	i.forEachService(func(key string, serviceInfo *serviceInfo) {
+		namespace, name, _ := strings.Cut(key, "/")
+
+		isDown := true
+
+		for clusterID := range serviceInfo.clusters {
+			if checkEndpoint(namespace, name, clusterID) {
+				isDown = false
+				break
+			}
+		}
+
+		if isDown {
+			down = append(down, key)
+		}
+	})
+
+	sort.Strings(down)
+
+	return down
+}
+
+// ServiceClusterCounts returns the number of clusters currently backing each service, keyed by "<namespace>/<name>".
+func (i *Interface) ServiceClusterCounts() map[string]int {
+	i.mutex.RLock()
+	defer i.mutex.RUnlock()
+
+	counts := make(map[string]int, i.serviceCount())
+	i.forEachService(func(key string, serviceInfo *serviceInfo) {
+		counts[key] = len(serviceInfo.clusters)
+	})
+
+	return counts
+}
+
+// UnbalancedClusters returns the clusters of the given ClusterIP service that failed to be added to its load balancer,
+// after any configured retries, the last time it was reset, sorted.
+func (i *Interface) UnbalancedClusters(namespace, name string) []string {
+	key, shard := i.rlockService(namespace, name)
+	defer i.runlockShard(shard)
+
+	serviceInfo, found := shard.services[key]
+	if !found {
+		return nil
+	}
+
+	unbalanced := append([]string(nil), serviceInfo.unbalancedClusters...)
+	sort.Strings(unbalanced)
+
+	return unbalanced
+}
+
+// GlobalSummary returns the service and cluster counts across all services, with the health of each service's clusters
+// determined by the given health check.
+func (i *Interface) GlobalSummary(checkEndpoint func(namespace, name, clusterID string) bool) GlobalStats {
+	i.mutex.RLock()
+	defer i.mutex.RUnlock()
+
+	stats := GlobalStats{Services: i.serviceCount()}
+
+	i.forEachService(func(key string, serviceInfo *serviceInfo) {
+		if serviceInfo.isHeadless {
+			stats.HeadlessServices++
+		} else {
+			stats.ClusterSetIPServices++
+		}
+
+		namespace, name, _ := strings.Cut(key, "/")
+
+		for clusterID := range serviceInfo.clusters {
+			stats.Clusters++
+
+			if checkEndpoint(namespace, name, clusterID) {
+				stats.HealthyClusters++
+			} else {
+				stats.UnhealthyClusters++
+			}
+		}
+	})
+
+	return stats
+}
+
+// ServiceCount returns the number of services.
+func (i *Interface) ServiceCount() int {
+	i.mutex.RLock()
+	defer i.mutex.RUnlock()
+
+	return i.serviceCount()
+}
+
+// ClusterRecordCount returns the number of DNS records of the clusters across all services.
+func (i *Interface) ClusterRecordCount() int {
+	return i.Stats().ClusterRecords
+}
+
+// Stats returns the number of services and their clusters' DNS records, without checking the clusters' health as
+// GlobalSummary does.
+func (i *Interface) Stats() ServiceStats {
+	i.mutex.RLock()
+	defer i.mutex.RUnlock()
+
+	stats := ServiceStats{}
+
+	i.forEachService(func(_ string, serviceInfo *serviceInfo) {
+		stats.Services++
+
+		if serviceInfo.isHeadless {
+			stats.HeadlessServices++
+		} else {
+			stats.ClusterSetIPServices++
+		}
+
+		for _, info := range serviceInfo.clusters {
+			stats.ClusterRecords += len(info.endpointRecords)
+		}
+	})
+
+	return stats
+}
+
+// Pin forces the resolution of the given ClusterIP service, when no specific cluster is requested, to the given
+// cluster's record, if present, regardless of the weights and health, until Unpin is called. This is intended to
+// temporarily freeze the selection while investigating an incident.
+func (i *Interface) Pin(namespace, name, cluster string) {
+	i.mutex.RLock()
+	defer i.mutex.RUnlock()
+
+	key := i.serviceKey(namespace, name)
+	shard := i.shardFor(key)
+
+	shard.mutex.Lock()
+	defer shard.mutex.Unlock()
+
+	serviceInfo, found := shard.services[key]
+	if !found {
+		logger.Warningf("Cannot pin non-existent service %q to cluster %q", key, cluster)
+		return
+	}
+
+	serviceInfo.pinnedCluster = i.normalizeClusterName(cluster)
+	serviceInfo.version++
+
+	logger.Infof("Pinned service %q to cluster %q", key, cluster)
+}
+
+// Unpin restores the normal resolution of the given service after Pin.
+func (i *Interface) Unpin(namespace, name string) {
+	i.mutex.RLock()
+	defer i.mutex.RUnlock()
+
+	key := i.serviceKey(namespace, name)
+	shard := i.shardFor(key)
+
+	shard.mutex.Lock()
+	defer shard.mutex.Unlock()
+
+	serviceInfo, found := shard.services[key]
+	if !found || serviceInfo.pinnedCluster == "" {
+		return
+	}
+
+	serviceInfo.pinnedCluster = ""
+	serviceInfo.version++
+
+	logger.Infof("Unpinned service %q", key)
+}
+
+// PortConflicts returns whether the clusters backing the given ClusterIP service advertise different ports, in which case
+// the merged ports, and thus the SRV records, don't reflect some clusters' ports. Headless services aren't checked as
+// their records carry their own cluster's ports.
+func (i *Interface) PortConflicts(namespace, name string) bool {
+	key, shard := i.rlockService(namespace, name)
+	defer i.runlockShard(shard)
+
+	serviceInfo, found := shard.services[key]
+
+	return found && serviceInfo.portConflict
+}
+
+// ClusterPorts returns the ports advertised by the given cluster for the given service, ie not merged with those of the
+// other clusters. For a headless service, these are the union of the ports of the cluster's EndpointSlices.
+func (i *Interface) ClusterPorts(namespace, name, cluster string) ([]mcsv1a1.ServicePort, bool) {
+	key, shard := i.rlockService(namespace, name)
+	defer i.runlockShard(shard)
+
+	serviceInfo, found := shard.services[key]
+	if !found {
+		return nil, false
+	}
+
+	clusterInfo, found := serviceInfo.clusters[i.normalizeClusterName(cluster)]
+	if !found {
+		return nil, false
+	}
+
+	return append([]mcsv1a1.ServicePort{}, clusterInfo.ports...), true
+}
+
+// GetPorts returns the merged ports of the given ClusterIP service, as computed when its clusters were last updated. No
+// ports are returned for a headless service as they vary per endpoint - use SRVRecords instead.
+func (i *Interface) GetPorts(namespace, name string) ([]mcsv1a1.ServicePort, bool) {
+	key, shard := i.rlockService(namespace, name)
+	defer i.runlockShard(shard)
+
+	serviceInfo, found := shard.services[key]
+	if !found || serviceInfo.isHeadless {
+		return nil, false
+	}
+
+	return append([]mcsv1a1.ServicePort{}, serviceInfo.ports...), true
+}
+
+// SRVRecords returns the records from which to build SRV answers for the given service, sorted by cluster name. For a
+// ClusterIP service, there's one record per healthy cluster with the merged ports, consistent with the records returned
+// by GetDNSRecords. For a headless service, there's one record per endpoint address of each connected cluster.
+func (i *Interface) SRVRecords(namespace, name string) ([]DNSRecord, bool) {
+	key, shard := i.rlockService(namespace, name)
+	defer i.runlockShard(shard)
+
+	serviceInfo, found := shard.services[key]
+	if !found {
+		return nil, false
+	}
+
+	records := make([]DNSRecord, 0, len(serviceInfo.clusters))
+
+	if serviceInfo.isHeadless {
+		all, _ := i.getHeadlessRecords(serviceInfo, "", "")
+		records = append(records, all...)
+	} else {
+		for clusterID, info := range serviceInfo.clusters {
+			if info.hasRecord() && i.isClusterHealthy(clusterID, info) && !i.isEvicted(info) {
+				records = append(records, *serviceInfo.newRecordFrom(&info.endpointRecords[0]))
+			}
+		}
+	}
+
+	for j := range records {
+		records[j].Ports = append([]mcsv1a1.ServicePort{}, records[j].Ports...)
+	}
+
+	sort.SliceStable(records, func(x, y int) bool {
+		return records[x].ClusterName < records[y].ClusterName
+	})
+
+	return records, true
+}
+
+// ListClusters returns the clusters backing the given service, sorted by name, with their configured weights and
+// whether each is the local cluster.
+func (i *Interface) ListClusters(namespace, name string) ([]ClusterInfo, bool) {
+	key, shard := i.rlockService(namespace, name)
+	defer i.runlockShard(shard)
+
+	serviceInfo, found := shard.services[key]
+	if !found {
+		return nil, false
+	}
+
+	localClusterID := i.getLocalClusterID()
+	clusters := make([]ClusterInfo, 0, len(serviceInfo.clusters))
+
+	for name, info := range serviceInfo.clusters {
+		clusters = append(clusters, ClusterInfo{
+			Cluster: name,
+			Weight:  info.weight,
+			IsLocal: localClusterID != "" && name == localClusterID,
+		})
+	}
+
+	sort.Slice(clusters, func(x, y int) bool {
+		return clusters[x].Cluster < clusters[y].Cluster
+	})
+
+	return clusters, true
+}
+
+// Dump returns a deep copy of the clusters of every service, keyed by "<namespace>/<name>" and sorted by cluster name,
+// eg for debugging stale entries.
+func (i *Interface) Dump() map[string][]ClusterDump {
+	i.mutex.RLock()
+	defer i.mutex.RUnlock()
+
+	dump := make(map[string][]ClusterDump, i.serviceCount())
+
+	i.forEachService(func(key string, serviceInfo *serviceInfo) {
+		clusters := make([]ClusterDump, 0, len(serviceInfo.clusters))
+
+		for name, info := range serviceInfo.clusters {
+			records := make([]DNSRecord, len(info.endpointRecords))
+			for j := range info.endpointRecords {
+				records[j] = info.endpointRecords[j]
+				records[j].IPs = append([]string(nil), info.endpointRecords[j].IPs...)
+				records[j].Ports = append([]mcsv1a1.ServicePort(nil), info.endpointRecords[j].Ports...)
+			}
+
+			clusters = append(clusters, ClusterDump{
+				Cluster:          name,
+				Weight:           info.weight,
+				EndpointsHealthy: info.endpointsHealthy,
+				Records:          records,
+			})
+		}
+
+		sort.Slice(clusters, func(x, y int) bool {
+			return clusters[x].Cluster < clusters[y].Cluster
+		})
+
+		dump[key] = clusters
+	})
+
+	return dump
+}
+
+// ForEach invokes the given function with the key, "<namespace>/<name>", the sorted cluster names and whether it's
+// headless of each service, in key order, until it returns false. The services are copied under the lock and the
+// function is invoked without it held, so it may take its time or call back into the resolver without blocking writers,
+// but it doesn't observe changes made meanwhile.
+func (i *Interface) ForEach(f func(key string, clusters []string, headless bool) bool) {
+	type serviceEntry struct {
+		key      string
+		clusters []string
+		headless bool
+	}
+
+	i.mutex.RLock()
+
+	entries := make([]serviceEntry, 0, i.serviceCount())
+
+	i.forEachService(func(key string, serviceInfo *serviceInfo) {
+		clusters := make([]string, 0, len(serviceInfo.clusters))
+		for name := range serviceInfo.clusters {
+			clusters = append(clusters, name)
+		}
+
+		sort.Strings(clusters)
+
+		entries = append(entries, serviceEntry{key: key, clusters: clusters, headless: serviceInfo.isHeadless})
+	})
+
+	i.mutex.RUnlock()
+
+	sort.Slice(entries, func(x, y int) bool {
+		return entries[x].key < entries[y].key
+	})
+
+	for _, entry := range entries {
+		if !f(entry.key, entry.clusters, entry.headless) {
+			return
+		}
+	}
+}
+
+// FirstSeen returns the time at which the given service was first put, which isn't reset by subsequent updates.
+func (i *Interface) FirstSeen(namespace, name string) (time.Time, bool) {
+	key, shard := i.rlockService(namespace, name)
+	defer i.runlockShard(shard)
+
+	serviceInfo, found := shard.services[key]
+	if !found {
+		return time.Time{}, false
+	}
+
+	return serviceInfo.firstSeen, true
+}
+
+// DroppedResolutions returns the number of resolution outcomes that weren't reported to the resolution sink because
+// its queue was full.
+func (i *Interface) DroppedResolutions() uint64 {
+	return atomic.LoadUint64(&i.droppedResolutions)
+}
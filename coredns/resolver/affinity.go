@@ -0,0 +1,117 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+
+Copyright Contributors to the Submariner project.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package resolver
+
+import (
+	"sync"
+	"time"
+)
+
+// defaultSessionAffinityTimeout is used for a ServiceImport with client IP session affinity that doesn't specify a
+// timeout, as for Kubernetes Services.
+const defaultSessionAffinityTimeout = 3 * time.Hour
+
+// sessionAffinity tracks the cluster selected for each client of a ClusterIP service with client IP session affinity.
+// Selections are made with only the read lock held so the clients are guarded by their own mutex.
+type sessionAffinity struct {
+	timeout time.Duration
+	mutex   sync.Mutex
+	clients map[string]clientAffinity
+	sweptAt time.Time
+}
+
+type clientAffinity struct {
+	cluster string
+	expires time.Time
+}
+
+// setTimeout sets the duration a client sticks to its selected cluster, forgetting the clients' clusters if it changed.
+// A zero timeout disables session affinity. The caller must hold the lock of the service's shard for writing.
+func (a *sessionAffinity) setTimeout(timeout time.Duration) {
+	if timeout == a.timeout {
+		return
+	}
+
+	a.timeout = timeout
+	a.clients = nil
+}
+
+// clusterFor returns the cluster selected for the given client, if any, unless its affinity has expired.
+func (a *sessionAffinity) clusterFor(client string, now time.Time) string {
+	if a.timeout <= 0 || client == "" {
+		return ""
+	}
+
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	affinity, found := a.clients[client]
+	if !found || !now.Before(affinity.expires) {
+		return ""
+	}
+
+	return affinity.cluster
+}
+
+// set records the cluster selected for the given client, which it sticks to until the timeout elapses. Expired
+// affinities are swept at most once per timeout so only the clients seen within twice the timeout are tracked.
+func (a *sessionAffinity) set(client, cluster string, now time.Time) {
+	if a.timeout <= 0 || client == "" {
+		return
+	}
+
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	if a.clients == nil {
+		a.clients = map[string]clientAffinity{}
+	}
+
+	if now.Sub(a.sweptAt) >= a.timeout {
+		for c, affinity := range a.clients {
+			if !now.Before(affinity.expires) {
+				delete(a.clients, c)
+			}
+		}
+
+		a.sweptAt = now
+	}
+
+	a.clients[client] = clientAffinity{cluster: cluster, expires: now.Add(a.timeout)}
+}
+
+// selectWithAffinity selects the cluster previously selected for the given client if its session affinity hasn't
+// expired and the cluster is still selectable, else selects a cluster via selectBalanced that the client then sticks to.
+func (i *Interface) selectWithAffinity(serviceInfo *serviceInfo, isSelectable func(string) bool, client string) *DNSRecord {
+	now := i.clock.Now()
+
+	if cluster := serviceInfo.affinity.clusterFor(client, now); cluster != "" {
+		info, found := serviceInfo.clusters[cluster]
+		if found && info.isServing() && isSelectable(cluster) && serviceInfo.acquire(cluster) {
+			return &info.endpointRecords[0]
+		}
+	}
+
+	record := i.selectBalanced(serviceInfo, isSelectable, client)
+	if record != nil {
+		serviceInfo.affinity.set(client, record.ClusterName, now)
+	}
+
+	return record
+}
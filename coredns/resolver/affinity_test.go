@@ -0,0 +1,160 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+
+Copyright Contributors to the Submariner project.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package resolver_test
+
+import (
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/submariner-io/lighthouse/coredns/constants"
+	"github.com/submariner-io/lighthouse/coredns/resolver"
+	corev1 "k8s.io/api/core/v1"
+	testingclock "k8s.io/utils/clock/testing"
+	"k8s.io/utils/ptr"
+	mcsv1a1 "sigs.k8s.io/mcs-api/pkg/apis/v1alpha1"
+)
+
+var _ = Describe("Session affinity", func() {
+	const (
+		client1 = "10.253.1.1"
+		client2 = "10.253.1.2"
+		timeout = 60
+	)
+
+	fakeClock := testingclock.NewFakeClock(time.Now())
+	t := newTestDriver(resolver.WithClock(fakeClock))
+
+	var serviceImport *mcsv1a1.ServiceImport
+
+	selectFor := func(client string) string {
+		records, _, found := t.resolver.GetDNSRecordsForClient(namespace1, service1, "", "", client)
+		Expect(found).To(BeTrue())
+		Expect(records).To(HaveLen(1))
+
+		return records[0].IP
+	}
+
+	BeforeEach(func() {
+		serviceImport = newAggregatedServiceImport(namespace1, service1)
+		serviceImport.Annotations = map[string]string{constants.LoadBalancerPolicyAnnotation: constants.LoadBalancerPolicyRoundRobin}
+		serviceImport.Spec.SessionAffinity = corev1.ServiceAffinityClientIP
+		serviceImport.Spec.SessionAffinityConfig = &corev1.SessionAffinityConfig{
+			ClientIP: &corev1.ClientIPConfig{TimeoutSeconds: ptr.To(int32(timeout))},
+		}
+	})
+
+	JustBeforeEach(func() {
+		t.resolver.PutServiceImport(serviceImport)
+
+		t.putEndpointSlice(newClusterIPEndpointSlice(namespace1, service1, clusterID1, serviceIP1, true, port1))
+		t.putEndpointSlice(newClusterIPEndpointSlice(namespace1, service1, clusterID2, serviceIP2, true, port1))
+		t.putEndpointSlice(newClusterIPEndpointSlice(namespace1, service1, clusterID3, serviceIP3, true, port1))
+	})
+
+	It("should select the same cluster for a client before the timeout", func() {
+		selected := selectFor(client1)
+
+		for j := 0; j < 5; j++ {
+			fakeClock.Step(10 * time.Second)
+			Expect(selectFor(client1)).To(Equal(selected))
+		}
+	})
+
+	It("should load balance across clients", func() {
+		Expect(selectFor(client1)).ToNot(Equal(selectFor(client2)))
+	})
+
+	It("should select anew for a client after the timeout", func() {
+		selected := selectFor(client1)
+
+		// Advance the round robin so the client's next selection differs.
+		t.resolver.GetDNSRecords(namespace1, service1, "", "")
+
+		fakeClock.Step(timeout * time.Second)
+		reselected := selectFor(client1)
+		Expect(reselected).ToNot(Equal(selected))
+
+		fakeClock.Step(10 * time.Second)
+		Expect(selectFor(client1)).To(Equal(reselected))
+	})
+
+	When("the client's cluster becomes unavailable", func() {
+		It("should select another cluster that the client then sticks to", func() {
+			selected := selectFor(client1)
+
+			for _, c := range []struct{ ip, cluster string }{
+				{serviceIP1, clusterID1}, {serviceIP2, clusterID2}, {serviceIP3, clusterID3},
+			} {
+				if c.ip == selected {
+					t.clusterStatus.DisconnectClusterID(c.cluster)
+				}
+			}
+
+			reselected := selectFor(client1)
+			Expect(reselected).ToNot(Equal(selected))
+			Expect(selectFor(client1)).To(Equal(reselected))
+		})
+	})
+
+	When("no timeout is specified", func() {
+		BeforeEach(func() {
+			serviceImport.Spec.SessionAffinityConfig = nil
+		})
+
+		It("should use the default timeout", func() {
+			selected := selectFor(client1)
+
+			fakeClock.Step(time.Hour)
+			Expect(selectFor(client1)).To(Equal(selected))
+
+			t.resolver.GetDNSRecords(namespace1, service1, "", "")
+
+			fakeClock.Step(2 * time.Hour)
+			Expect(selectFor(client1)).ToNot(Equal(selected))
+		})
+	})
+
+	When("session affinity isn't enabled", func() {
+		BeforeEach(func() {
+			serviceImport.Spec.SessionAffinity = corev1.ServiceAffinityNone
+		})
+
+		It("should not stick clients to a cluster", func() {
+			Expect(selectFor(client1)).ToNot(Equal(selectFor(client1)))
+		})
+	})
+
+	When("session affinity is subsequently disabled", func() {
+		It("should no longer stick clients to a cluster", func() {
+			selectFor(client1)
+
+			serviceImport.Spec.SessionAffinity = corev1.ServiceAffinityNone
+			t.resolver.PutServiceImport(serviceImport)
+
+			Expect(selectFor(client1)).ToNot(Equal(selectFor(client1)))
+		})
+	})
+
+	When("no client IP is supplied", func() {
+		It("should not stick to a cluster", func() {
+			Expect(selectFor("")).ToNot(Equal(selectFor("")))
+		})
+	})
+})
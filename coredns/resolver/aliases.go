@@ -0,0 +1,93 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+
+Copyright Contributors to the Submariner project.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package resolver
+
+import (
+	"fmt"
+	"strings"
+)
+
+// ReplaceAliases atomically replaces the table of service aliases. Each entry maps an alias "<namespace>/<name>" to
+// the "<namespace>/<name>" of the service, or of another alias, it resolves to. The whole set is rejected if it
+// contains a cycle, in which case the current table is retained.
+func (i *Interface) ReplaceAliases(aliases map[string]string) error {
+	if err := validateAliases(aliases); err != nil {
+		logger.Errorf(err, "Rejecting the new service aliases")
+		return err
+	}
+
+	newAliases := make(map[string]string, len(aliases))
+	for alias, target := range aliases {
+		newAliases[alias] = target
+	}
+
+	i.mutex.Lock()
+	defer i.mutex.Unlock()
+
+	i.aliases = newAliases
+	i.negativeCache.clear()
+
+	logger.Infof("Replaced the service aliases with %d entries", len(newAliases))
+
+	return nil
+}
+
+// serviceKey returns the key of the given service after following the alias table, so every per-service operation
+// honors the aliases the same way lookups do. The caller must hold the lock.
+func (i *Interface) serviceKey(namespace, name string) string {
+	return i.resolveAlias(keyFunc(namespace, name))
+}
+
+// resolveAlias returns the service key the given key resolves to by following the alias table. The table is
+// guaranteed to be acyclic. The caller must hold the lock.
+func (i *Interface) resolveAlias(key string) string {
+	for {
+		target, found := i.aliases[key]
+		if !found {
+			return key
+		}
+
+		key = target
+	}
+}
+
+func validateAliases(aliases map[string]string) error {
+	for alias := range aliases {
+		visited := map[string]bool{alias: true}
+		path := []string{alias}
+
+		for key := alias; ; {
+			target, found := aliases[key]
+			if !found {
+				break
+			}
+
+			path = append(path, target)
+
+			if visited[target] {
+				return fmt.Errorf("service aliases contain a cycle: %s", strings.Join(path, " -> "))
+			}
+
+			visited[target] = true
+			key = target
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,116 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+
+Copyright Contributors to the Submariner project.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package resolver_test
+
+import (
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("ReplaceAliases", func() {
+	const service2 = "service2"
+
+	t := newTestDriver()
+
+	BeforeEach(func() {
+		t.resolver.PutServiceImport(newAggregatedServiceImport(namespace1, service1))
+		t.putEndpointSlice(newClusterIPEndpointSlice(namespace1, service1, clusterID1, serviceIP1, true, port1))
+
+		t.resolver.PutServiceImport(newAggregatedServiceImport(namespace1, service2))
+		t.putEndpointSlice(newClusterIPEndpointSlice(namespace1, service2, clusterID2, serviceIP2, true, port1))
+	})
+
+	When("aliases are set", func() {
+		BeforeEach(func() {
+			Expect(t.resolver.ReplaceAliases(map[string]string{
+				namespace2 + "/alias":    namespace1 + "/" + service1,
+				namespace2 + "/indirect": namespace2 + "/alias",
+			})).To(Succeed())
+		})
+
+		It("should resolve an alias to its target service", func() {
+			Expect(t.getNonHeadlessDNSRecord(namespace2, "alias", "").IP).To(Equal(serviceIP1))
+		})
+
+		It("should resolve a chain of aliases", func() {
+			Expect(t.getNonHeadlessDNSRecord(namespace2, "indirect", "").IP).To(Equal(serviceIP1))
+		})
+
+		It("should still resolve services directly", func() {
+			Expect(t.getNonHeadlessDNSRecord(namespace1, service2, "").IP).To(Equal(serviceIP2))
+		})
+
+		It("should honor them in the per-service operations", func() {
+			t.getNonHeadlessDNSRecord(namespace2, "alias", "")
+
+			Expect(t.resolver.QueryCount(namespace2, "alias")).To(Equal(t.resolver.QueryCount(namespace1, service1)))
+			Expect(t.resolver.ClustersByWeight(namespace2, "alias")).To(Equal(t.resolver.ClustersByWeight(namespace1, service1)))
+
+			fingerprint, _ := t.resolver.Fingerprint(namespace1, service1)
+			aliasFingerprint, found := t.resolver.Fingerprint(namespace2, "indirect")
+			Expect(found).To(BeTrue())
+			Expect(aliasFingerprint).To(Equal(fingerprint))
+
+			t.putEndpointSlice(newClusterIPEndpointSlice(namespace1, service1, clusterID2, serviceIP3, true, port1))
+			t.resolver.Pin(namespace2, "alias", clusterID2)
+
+			for i := 0; i < 3; i++ {
+				Expect(t.getNonHeadlessDNSRecord(namespace1, service1, "").IP).To(Equal(serviceIP3))
+			}
+		})
+
+		Context("and then replaced", func() {
+			BeforeEach(func() {
+				Expect(t.resolver.ReplaceAliases(map[string]string{
+					namespace2 + "/alias": namespace1 + "/" + service2,
+				})).To(Succeed())
+			})
+
+			It("should immediately resolve using the new aliases", func() {
+				Expect(t.getNonHeadlessDNSRecord(namespace2, "alias", "").IP).To(Equal(serviceIP2))
+				t.assertDNSRecordsNotFound(namespace2, "indirect", "", "")
+			})
+		})
+
+		Context("and then replaced with a set containing a cycle", func() {
+			It("should reject the set and retain the current aliases", func() {
+				Expect(t.resolver.ReplaceAliases(map[string]string{
+					namespace2 + "/alias": namespace2 + "/a",
+					namespace2 + "/a":     namespace2 + "/b",
+					namespace2 + "/b":     namespace2 + "/a",
+				})).ToNot(Succeed())
+
+				Expect(t.getNonHeadlessDNSRecord(namespace2, "alias", "").IP).To(Equal(serviceIP1))
+				Expect(t.getNonHeadlessDNSRecord(namespace2, "indirect", "").IP).To(Equal(serviceIP1))
+			})
+		})
+	})
+
+	When("an alias refers to itself", func() {
+		It("should reject the set", func() {
+			Expect(t.resolver.ReplaceAliases(map[string]string{namespace2 + "/alias": namespace2 + "/alias"})).ToNot(Succeed())
+		})
+	})
+
+	When("an alias doesn't exist", func() {
+		It("should return not found", func() {
+			t.assertDNSRecordsNotFound(namespace2, "alias", "", "")
+		})
+	})
+})
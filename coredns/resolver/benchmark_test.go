@@ -0,0 +1,136 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+
+Copyright Contributors to the Submariner project.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package resolver_test
+
+import (
+	"fmt"
+	"runtime"
+	"sync/atomic"
+	"testing"
+
+	"github.com/submariner-io/lighthouse/coredns/resolver"
+	"github.com/submariner-io/lighthouse/coredns/resolver/fake"
+	discovery "k8s.io/api/discovery/v1"
+	fakeClient "k8s.io/client-go/dynamic/fake"
+	"k8s.io/client-go/kubernetes/scheme"
+	mcsv1a1 "sigs.k8s.io/mcs-api/pkg/apis/v1alpha1"
+)
+
+func BenchmarkGetDNSRecords(b *testing.B) {
+	r := resolver.New(fake.NewClusterStatus("", clusterID1, clusterID2, clusterID3), fakeClient.NewSimpleDynamicClient(scheme.Scheme))
+
+	r.PutServiceImport(newAggregatedServiceImport(namespace1, service1))
+	r.PutEndpointSlices(newClusterIPEndpointSlice(namespace1, service1, clusterID1, serviceIP1, true, port1, port2))
+	r.PutEndpointSlices(newClusterIPEndpointSlice(namespace1, service1, clusterID2, serviceIP2, true, port1, port2))
+	r.PutEndpointSlices(newClusterIPEndpointSlice(namespace1, service1, clusterID3, serviceIP3, true, port1, port2))
+
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		r.GetBestDNSRecord(namespace1, service1)
+		r.GetDNSRecords(namespace1, service1, "", "")
+	}
+}
+
+// BenchmarkGetDNSRecordsCheckingEndpoints measures lookups with an endpoint check, invoked per cluster and batched.
+func BenchmarkGetDNSRecordsCheckingEndpoints(b *testing.B) {
+	r := resolver.New(fake.NewClusterStatus("", clusterID1, clusterID2, clusterID3), fakeClient.NewSimpleDynamicClient(scheme.Scheme))
+
+	r.PutServiceImport(newAggregatedServiceImport(namespace1, service1))
+	r.PutEndpointSlices(newClusterIPEndpointSlice(namespace1, service1, clusterID1, serviceIP1, true, port1))
+	r.PutEndpointSlices(newClusterIPEndpointSlice(namespace1, service1, clusterID2, serviceIP2, true, port1))
+	r.PutEndpointSlices(newClusterIPEndpointSlice(namespace1, service1, clusterID3, serviceIP3, true, port1))
+
+	checkEndpoint := func(_, _, clusterID string) bool {
+		return clusterID != clusterID2
+	}
+
+	b.Run("per cluster", func(b *testing.B) {
+		b.ReportAllocs()
+
+		for i := 0; i < b.N; i++ {
+			r.GetAllRecords(namespace1, service1, checkEndpoint)
+		}
+	})
+
+	b.Run("batched", func(b *testing.B) {
+		results := map[string]bool{clusterID1: true, clusterID3: true}
+		checkEndpoints := func(_, _ string, _ []string) map[string]bool {
+			return results
+		}
+
+		b.ReportAllocs()
+
+		for i := 0; i < b.N; i++ {
+			r.GetDNSRecordsCheckingEndpoints(namespace1, service1, "", "", checkEndpoints)
+		}
+	})
+}
+
+// BenchmarkConcurrentDNSRecords measures lookups of many services interleaved with EndpointSlice updates from parallel
+// goroutines, with the services in a single shard and with the default sharding.
+func BenchmarkConcurrentDNSRecords(b *testing.B) {
+	for _, s := range []struct {
+		name string
+		opts []resolver.Option
+	}{
+		{name: "single shard", opts: []resolver.Option{resolver.WithServiceShards(1)}},
+		{name: "sharded"},
+	} {
+		b.Run(s.name, func(b *testing.B) {
+			const servicesPerWorker = 16
+
+			r := resolver.New(fake.NewClusterStatus("", clusterID1, clusterID2), fakeClient.NewSimpleDynamicClient(scheme.Scheme),
+				s.opts...)
+
+			// Each goroutine uses its own services as concurrent lookups of the same service contend on its load balancer.
+			slices := make([][]*discovery.EndpointSlice, runtime.GOMAXPROCS(0))
+
+			for w := range slices {
+				for j := 0; j < servicesPerWorker; j++ {
+					name := fmt.Sprintf("service-%d-%d", w, j)
+					slices[w] = append(slices[w], newClusterIPEndpointSlice(namespace1, name, clusterID2, serviceIP2, true, port1))
+
+					r.PutServiceImport(newAggregatedServiceImport(namespace1, name))
+					r.PutEndpointSlices(newClusterIPEndpointSlice(namespace1, name, clusterID1, serviceIP1, true, port1))
+					r.PutEndpointSlices(slices[w][j])
+				}
+			}
+
+			var workers int64
+
+			b.ResetTimer()
+
+			b.RunParallel(func(pb *testing.PB) {
+				own := slices[int(atomic.AddInt64(&workers, 1)-1)%len(slices)]
+
+				for n := 0; pb.Next(); n++ {
+					slice := own[n%servicesPerWorker]
+
+					if n%10 == 0 {
+						r.PutEndpointSlices(slice)
+					} else {
+						r.GetDNSRecords(namespace1, slice.Labels[mcsv1a1.LabelServiceName], "", "")
+					}
+				}
+			})
+		})
+	}
+}
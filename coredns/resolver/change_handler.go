@@ -0,0 +1,56 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+
+Copyright Contributors to the Submariner project.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package resolver
+
+import "sort"
+
+// SetChangeHandler sets the handler invoked after a service's clusters are added, updated or removed, with the
+// "<namespace>/<name>" key of the service and the names of its current clusters, sorted. The clusters are empty once
+// the service is removed. The handler is invoked without the resolver's lock held so it may call back into the
+// resolver. A nil handler disables the notifications.
+func (i *Interface) SetChangeHandler(handler func(key string, clusters []string)) {
+	i.mutex.Lock()
+	defer i.mutex.Unlock()
+
+	i.changeHandler = handler
+}
+
+// notifyClustersChanged invokes the change handler, if set, with the current clusters of the given service. The caller
+// must not hold the lock.
+func (i *Interface) notifyClustersChanged(key string) {
+	shard := i.rlockShard(key)
+
+	handler := i.changeHandler
+	clusters := []string{}
+
+	if serviceInfo, found := shard.services[key]; found {
+		for name := range serviceInfo.clusters {
+			clusters = append(clusters, name)
+		}
+	}
+
+	i.runlockShard(shard)
+
+	if handler == nil {
+		return
+	}
+
+	sort.Strings(clusters)
+	handler(key, clusters)
+}
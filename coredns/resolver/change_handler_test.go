@@ -0,0 +1,138 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+
+Copyright Contributors to the Submariner project.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package resolver_test
+
+import (
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/submariner-io/lighthouse/coredns/resolver"
+	discovery "k8s.io/api/discovery/v1"
+	mcsv1a1 "sigs.k8s.io/mcs-api/pkg/apis/v1alpha1"
+)
+
+type clusterChange struct {
+	key      string
+	clusters []string
+}
+
+var _ = Describe("SetChangeHandler", func() {
+	t := newTestDriver()
+
+	var changes []clusterChange
+
+	key := namespace1 + "/" + service1
+
+	BeforeEach(func() {
+		changes = nil
+
+		t.resolver.PutServiceImport(newAggregatedServiceImport(namespace1, service1))
+
+		t.resolver.SetChangeHandler(func(key string, clusters []string) {
+			changes = append(changes, clusterChange{key: key, clusters: clusters})
+		})
+	})
+
+	When("clusters are added", func() {
+		It("should report the current clusters after each", func() {
+			t.putEndpointSlice(newClusterIPEndpointSlice(namespace1, service1, clusterID2, serviceIP2, true, port1))
+			t.putEndpointSlice(newClusterIPEndpointSlice(namespace1, service1, clusterID1, serviceIP1, true, port1))
+
+			Expect(changes).To(Equal([]clusterChange{
+				{key: key, clusters: []string{clusterID2}},
+				{key: key, clusters: []string{clusterID1, clusterID2}},
+			}))
+		})
+	})
+
+	When("a cluster is updated", func() {
+		BeforeEach(func() {
+			t.putEndpointSlice(newClusterIPEndpointSlice(namespace1, service1, clusterID1, serviceIP1, true, port1))
+			changes = nil
+		})
+
+		It("should report the current clusters", func() {
+			t.putEndpointSlice(newClusterIPEndpointSlice(namespace1, service1, clusterID1, serviceIP1, false, port1))
+			Expect(changes).To(Equal([]clusterChange{{key: key, clusters: []string{clusterID1}}}))
+		})
+	})
+
+	When("clusters are removed", func() {
+		BeforeEach(func() {
+			t.putEndpointSlice(newClusterIPEndpointSlice(namespace1, service1, clusterID1, serviceIP1, true, port1))
+			t.putEndpointSlice(newClusterIPEndpointSlice(namespace1, service1, clusterID2, serviceIP2, true, port1))
+			changes = nil
+		})
+
+		It("should report the remaining clusters", func() {
+			t.resolver.RemoveEndpointSlice(newClusterIPEndpointSlice(namespace1, service1, clusterID1, serviceIP1, true, port1))
+			t.resolver.RemoveEndpointSlice(newClusterIPEndpointSlice(namespace1, service1, clusterID2, serviceIP2, true, port1))
+
+			Expect(changes).To(Equal([]clusterChange{
+				{key: key, clusters: []string{clusterID2}},
+				{key: key, clusters: []string{}},
+			}))
+		})
+
+		Context("along with the service", func() {
+			It("should report no clusters", func() {
+				t.resolver.RemoveServiceImport(newAggregatedServiceImport(namespace1, service1))
+				Expect(changes).To(Equal([]clusterChange{{key: key, clusters: []string{}}}))
+			})
+		})
+	})
+
+	When("the service is headless", func() {
+		It("should report the current clusters", func() {
+			t.resolver.PutServiceImport(newHeadlessAggregatedServiceImport(namespace2, service1))
+			t.putEndpointSlice(newEndpointSlice(namespace2, service1, clusterID3, []mcsv1a1.ServicePort{port1},
+				discovery.Endpoint{Addresses: []string{endpointIP1}}))
+
+			Expect(changes).To(Equal([]clusterChange{{key: namespace2 + "/" + service1, clusters: []string{clusterID3}}}))
+		})
+	})
+
+	When("the handler calls back into the resolver", func() {
+		It("should not deadlock", func() {
+			var records []resolver.DNSRecord
+
+			t.resolver.SetChangeHandler(func(_ string, _ []string) {
+				records, _, _ = t.resolver.GetDNSRecords(namespace1, service1, "", "")
+			})
+
+			t.putEndpointSlice(newClusterIPEndpointSlice(namespace1, service1, clusterID1, serviceIP1, true, port1))
+			Expect(records).To(HaveLen(1))
+		})
+	})
+
+	When("the handler is cleared", func() {
+		It("should no longer be invoked", func() {
+			t.resolver.SetChangeHandler(nil)
+			t.putEndpointSlice(newClusterIPEndpointSlice(namespace1, service1, clusterID1, serviceIP1, true, port1))
+			Expect(changes).To(BeEmpty())
+		})
+	})
+
+	When("the service doesn't exist", func() {
+		It("should not be invoked", func() {
+			Expect(t.resolver.PutEndpointSlices(newClusterIPEndpointSlice(namespace2, service1, clusterID1, serviceIP1, true,
+				port1))).To(BeTrue())
+			Expect(changes).To(BeEmpty())
+		})
+	})
+})
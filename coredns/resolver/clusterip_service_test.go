@@ -19,11 +19,20 @@ limitations under the License.
 package resolver_test
 
 import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
 	"github.com/submariner-io/admiral/pkg/syncer/test"
+	"github.com/submariner-io/lighthouse/coredns/constants"
 	"github.com/submariner-io/lighthouse/coredns/resolver"
+	discovery "k8s.io/api/discovery/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	testingclock "k8s.io/utils/clock/testing"
 	mcsv1a1 "sigs.k8s.io/mcs-api/pkg/apis/v1alpha1"
 )
 
@@ -32,6 +41,13 @@ var _ = Describe("GetDNSRecords", func() {
 		When("a service is present in one cluster", testClusterIPServiceInOneCluster)
 		When("a service is present in two clusters", testClusterIPServiceInTwoClusters)
 		When("a service is present in three clusters", testClusterIPServiceInThreeClusters)
+		When("requested cluster fallback is enabled", testClusterIPServiceWithRequestedClusterFallback)
+		When("empty endpoints eviction is enabled", testClusterIPServiceWithEmptyEndpointsEviction)
+		When("a service is dual-stack", testClusterIPServiceDualStack)
+		When("a legacy ServiceImport has service IPs", testClusterIPServiceLegacyIPs)
+		When("filtering by address type", testClusterIPServiceAddressTypeFilter)
+		When("resolved with a cache key", testClusterIPServiceCacheKey)
+		When("filtering by cluster labels", testClusterIPServiceLabelSelector)
 
 		testClusterIPServiceMisc()
 	})
@@ -42,7 +58,9 @@ func testClusterIPServiceInOneCluster() {
 
 	expDNSRecord := resolver.DNSRecord{
 		IP:          serviceIP1,
+		IPs:         []string{serviceIP1},
 		Ports:       []mcsv1a1.ServicePort{port1},
+		HostName:    clusterHostName(clusterID1, namespace1, service1),
 		ClusterName: clusterID1,
 	}
 
@@ -109,7 +127,9 @@ func testClusterIPServiceInOneCluster() {
 		It("should return the correct DNS record information", func() {
 			t.assertDNSRecordsFound(namespace1, service1, "", "", false, resolver.DNSRecord{
 				IP:          serviceIP2,
+				IPs:         []string{serviceIP2},
 				Ports:       []mcsv1a1.ServicePort{port2},
+				HostName:    clusterHostName(clusterID1, namespace1, service1),
 				ClusterName: clusterID1,
 			})
 		})
@@ -132,6 +152,18 @@ func testClusterIPServiceInTwoClusters() {
 		t.putEndpointSlice(newClusterIPEndpointSlice(namespace1, service1, clusterID2, serviceIP2, true, port1))
 	})
 
+	Context("and a specific cluster is requested", func() {
+		It("should return its IP and cluster-qualified host name", func() {
+			record := t.getNonHeadlessDNSRecord(namespace1, service1, clusterID2)
+			Expect(record.IP).To(Equal(serviceIP2))
+			Expect(record.HostName).To(Equal(clusterID2 + "." + service1 + "." + namespace1))
+
+			record = t.getNonHeadlessDNSRecord(namespace1, service1, clusterID1)
+			Expect(record.IP).To(Equal(serviceIP1))
+			Expect(record.HostName).To(Equal(clusterID1 + "." + service1 + "." + namespace1))
+		})
+	})
+
 	Context("and no specific cluster is requested", func() {
 		It("should consistently return the DNS records round-robin", func() {
 			t.testRoundRobin(namespace1, service1, serviceIP1, serviceIP2)
@@ -153,7 +185,9 @@ func testClusterIPServiceInTwoClusters() {
 	Context("and one becomes disconnected", func() {
 		expDNSRecord := resolver.DNSRecord{
 			IP:          serviceIP2,
+			IPs:         []string{serviceIP2},
 			Ports:       []mcsv1a1.ServicePort{port1},
+			HostName:    clusterHostName(clusterID2, namespace1, service1),
 			ClusterName: clusterID2,
 		}
 
@@ -173,7 +207,9 @@ func testClusterIPServiceInTwoClusters() {
 			It("should still return its DNS record", func() {
 				t.assertDNSRecordsFound(namespace1, service1, clusterID1, "", false, resolver.DNSRecord{
 					IP:          serviceIP1,
+					IPs:         []string{serviceIP1},
 					Ports:       []mcsv1a1.ServicePort{port1},
+					HostName:    clusterHostName(clusterID1, namespace1, service1),
 					ClusterName: clusterID1,
 				})
 			})
@@ -199,7 +235,9 @@ func testClusterIPServiceInTwoClusters() {
 	Context("and one becomes unhealthy", func() {
 		expDNSRecord := resolver.DNSRecord{
 			IP:          serviceIP1,
+			IPs:         []string{serviceIP1},
 			Ports:       []mcsv1a1.ServicePort{port1},
+			HostName:    clusterHostName(clusterID1, namespace1, service1),
 			ClusterName: clusterID1,
 		}
 
@@ -219,7 +257,9 @@ func testClusterIPServiceInTwoClusters() {
 			It("should still return its DNS record", func() {
 				t.assertDNSRecordsFound(namespace1, service1, clusterID2, "", false, resolver.DNSRecord{
 					IP:          serviceIP2,
+					IPs:         []string{serviceIP2},
 					Ports:       []mcsv1a1.ServicePort{port1},
+					HostName:    clusterHostName(clusterID2, namespace1, service1),
 					ClusterName: clusterID2,
 				})
 			})
@@ -235,7 +275,9 @@ func testClusterIPServiceInTwoClusters() {
 	Context("and one is subsequently removed", func() {
 		expDNSRecord := resolver.DNSRecord{
 			IP:          serviceIP1,
+			IPs:         []string{serviceIP1},
 			Ports:       []mcsv1a1.ServicePort{port1},
+			HostName:    clusterHostName(clusterID1, namespace1, service1),
 			ClusterName: clusterID1,
 		}
 
@@ -259,6 +301,45 @@ func testClusterIPServiceInTwoClusters() {
 			t.testRoundRobin(namespace1, service1, serviceIP1, serviceIP2)
 		})
 	})
+
+	Context("and the service IP of one is changed", func() {
+		JustBeforeEach(func() {
+			t.putEndpointSlice(newClusterIPEndpointSlice(namespace1, service1, clusterID2, serviceIP3, true, port1))
+		})
+
+		It("should return the new DNS record round-robin with the other cluster", func() {
+			t.testRoundRobin(namespace1, service1, serviceIP1, serviceIP3)
+		})
+
+		It("should return the new DNS record when the cluster is requested", func() {
+			t.assertDNSRecordsFound(namespace1, service1, clusterID2, "", false, resolver.DNSRecord{
+				IP:          serviceIP3,
+				IPs:         []string{serviceIP3},
+				Ports:       []mcsv1a1.ServicePort{port1},
+				HostName:    clusterHostName(clusterID2, namespace1, service1),
+				ClusterName: clusterID2,
+			})
+		})
+
+		It("should retain the cluster's weight", func() {
+			Expect(t.resolver.ClustersByWeight(namespace1, service1)).To(Equal([]resolver.ClusterWeight{
+				{Cluster: clusterID1, Weight: 1},
+				{Cluster: clusterID2, Weight: 1},
+			}))
+		})
+
+		Context("and it's the local cluster", func() {
+			BeforeEach(func() {
+				t.clusterStatus.SetLocalClusterID(clusterID2)
+			})
+
+			It("should consistently return its new DNS record", func() {
+				for i := 0; i < 10; i++ {
+					Expect(t.getNonHeadlessDNSRecord(namespace1, service1, "").IP).To(Equal(serviceIP3))
+				}
+			})
+		})
+	})
 }
 
 func testClusterIPServiceInThreeClusters() {
@@ -287,7 +368,9 @@ func testClusterIPServiceInThreeClusters() {
 	Context("and a specific cluster is requested", func() {
 		expDNSRecord := resolver.DNSRecord{
 			IP:          serviceIP2,
+			IPs:         []string{serviceIP2},
 			Ports:       []mcsv1a1.ServicePort{port1, port2},
+			HostName:    clusterHostName(clusterID2, namespace1, service1),
 			ClusterName: clusterID2,
 		}
 
@@ -342,102 +425,1259 @@ func testClusterIPServiceInThreeClusters() {
 	})
 }
 
-func testClusterIPServiceMisc() {
-	t := newTestDriver()
+func testClusterIPServiceWithRequestedClusterFallback() {
+	t := newTestDriver(resolver.WithRequestedClusterFallback())
 
-	When("a service exists in two namespaces", func() {
-		BeforeEach(func() {
-			t.resolver.PutServiceImport(newAggregatedServiceImport(namespace1, service1))
-			t.putEndpointSlice(newClusterIPEndpointSlice(namespace1, service1, clusterID1, serviceIP1, true))
+	BeforeEach(func() {
+		t.resolver.PutServiceImport(newAggregatedServiceImport(namespace1, service1))
 
-			t.resolver.PutServiceImport(newAggregatedServiceImport(namespace2, service1))
-			t.putEndpointSlice(newClusterIPEndpointSlice(namespace2, service1, clusterID1, serviceIP2, true))
+		t.putEndpointSlice(newClusterIPEndpointSlice(namespace1, service1, clusterID1, serviceIP1, true, port1))
+		t.putEndpointSlice(newClusterIPEndpointSlice(namespace1, service1, clusterID2, serviceIP2, true, port1))
+	})
+
+	Context("and the requested cluster exists", func() {
+		It("should return its DNS record", func() {
+			for i := 0; i < 5; i++ {
+				Expect(t.getNonHeadlessDNSRecord(namespace1, service1, clusterID2).IP).To(Equal(serviceIP2))
+			}
 		})
+	})
 
-		It("should return the correct DNS record for each namespace", func() {
-			t.assertDNSRecordsFound(namespace1, service1, clusterID1, "", false, resolver.DNSRecord{
-				IP:          serviceIP1,
-				Ports:       []mcsv1a1.ServicePort{},
-				ClusterName: clusterID1,
+	Context("and the requested cluster doesn't exist", func() {
+		It("should return the DNS records round-robin", func() {
+			t.testRoundRobin(namespace1, service1, serviceIP1, serviceIP2)
+
+			for i := 0; i < 4; i++ {
+				Expect(t.getNonHeadlessDNSRecord(namespace1, service1, "non-existent").IP).To(Or(Equal(serviceIP1), Equal(serviceIP2)))
+			}
+		})
+
+		Context("and there's a local cluster", func() {
+			BeforeEach(func() {
+				t.clusterStatus.SetLocalClusterID(clusterID2)
 			})
 
-			t.assertDNSRecordsFound(namespace2, service1, clusterID1, "", false, resolver.DNSRecord{
-				IP:          serviceIP2,
-				Ports:       []mcsv1a1.ServicePort{},
-				ClusterName: clusterID1,
+			It("should return the local cluster's DNS record", func() {
+				for i := 0; i < 5; i++ {
+					Expect(t.getNonHeadlessDNSRecord(namespace1, service1, "non-existent").IP).To(Equal(serviceIP2))
+				}
+			})
+		})
+
+		Context("and no cluster is available", func() {
+			BeforeEach(func() {
+				t.clusterStatus.DisconnectAll()
+			})
+
+			It("should return no DNS records", func() {
+				t.assertDNSRecordsFound(namespace1, service1, "non-existent", "", false)
 			})
 		})
 	})
+}
 
-	When("a cluster's EndpointSlice is initially created before the ServiceImport", func() {
-		It("should eventually process them and return its DNS record", func() {
-			es := newClusterIPEndpointSlice(namespace1, service1, clusterID1, serviceIP1, true)
-			Expect(t.resolver.PutEndpointSlices(es)).To(BeTrue())
+func testClusterIPServiceWithEmptyEndpointsEviction() {
+	const gracePeriod = time.Minute
 
-			t.awaitDNSRecords(namespace1, service1, clusterID1, "", false)
+	fakeClock := testingclock.NewFakeClock(time.Now())
+	t := newTestDriver(resolver.WithClock(fakeClock), resolver.WithEmptyEndpointsEviction(gracePeriod))
 
-			t.resolver.PutServiceImport(newAggregatedServiceImport(namespace1, service1))
-			t.putEndpointSlice(es)
+	BeforeEach(func() {
+		t.resolver.PutServiceImport(newAggregatedServiceImport(namespace1, service1))
 
-			t.assertDNSRecordsFound(namespace1, service1, "", "", false, resolver.DNSRecord{
-				IP:          serviceIP1,
-				Ports:       []mcsv1a1.ServicePort{},
+		t.putEndpointSlice(newClusterIPEndpointSlice(namespace1, service1, clusterID1, serviceIP1, true, port1))
+		t.putEndpointSlice(newClusterIPEndpointSlice(namespace1, service1, clusterID2, serviceIP2, false, port1))
+	})
+
+	Context("and a cluster's endpoints stay empty for less than the grace period", func() {
+		It("should not evict the cluster", func() {
+			fakeClock.Step(gracePeriod / 2)
+			Expect(t.getNonHeadlessDNSRecord(namespace1, service1, clusterID2).IP).To(Equal(serviceIP2))
+		})
+	})
+
+	Context("and a cluster's endpoints stay empty for longer than the grace period", func() {
+		It("should evict the cluster", func() {
+			fakeClock.Step(gracePeriod + time.Second)
+			t.assertDNSRecordsNotFound(namespace1, service1, clusterID2, "")
+			Expect(t.getNonHeadlessDNSRecord(namespace1, service1, clusterID1).IP).To(Equal(serviceIP1))
+		})
+	})
+
+	Context("and a cluster's endpoints return before the grace period expires", func() {
+		It("should not evict the cluster", func() {
+			fakeClock.Step(gracePeriod / 2)
+			t.putEndpointSlice(newClusterIPEndpointSlice(namespace1, service1, clusterID2, serviceIP2, true, port1))
+
+			fakeClock.Step(gracePeriod * 2)
+			Expect(t.getNonHeadlessDNSRecord(namespace1, service1, clusterID2).IP).To(Equal(serviceIP2))
+			t.testRoundRobin(namespace1, service1, serviceIP1, serviceIP2)
+		})
+	})
+
+	Context("and a cluster's endpoints become empty again after returning", func() {
+		It("should restart the grace period", func() {
+			fakeClock.Step(gracePeriod / 2)
+			t.putEndpointSlice(newClusterIPEndpointSlice(namespace1, service1, clusterID2, serviceIP2, true, port1))
+			t.putEndpointSlice(newClusterIPEndpointSlice(namespace1, service1, clusterID2, serviceIP2, false, port1))
+
+			fakeClock.Step(gracePeriod * 3 / 4)
+			Expect(t.getNonHeadlessDNSRecord(namespace1, service1, clusterID2).IP).To(Equal(serviceIP2))
+
+			fakeClock.Step(gracePeriod / 2)
+			t.assertDNSRecordsNotFound(namespace1, service1, clusterID2, "")
+		})
+	})
+
+	Context("and an evicted cluster's endpoints return", func() {
+		It("should restore the cluster", func() {
+			fakeClock.Step(gracePeriod + time.Second)
+			t.assertDNSRecordsNotFound(namespace1, service1, clusterID2, "")
+
+			t.putEndpointSlice(newClusterIPEndpointSlice(namespace1, service1, clusterID2, serviceIP2, true, port1))
+			Expect(t.getNonHeadlessDNSRecord(namespace1, service1, clusterID2).IP).To(Equal(serviceIP2))
+		})
+	})
+
+	Context("and an evicted cluster is from a legacy ServiceImport", func() {
+		It("should restore the cluster when its legacy EndpointSlice reports endpoints", func() {
+			t.resolver.PutServiceImport(newLegacyServiceImport(namespace2, service1, serviceIP3, clusterID3, port1))
+
+			endpointSlice := newClusterIPEndpointSlice(namespace2, service1, clusterID3, serviceIP3, false, port1)
+			delete(endpointSlice.Labels, constants.LabelIsHeadless)
+			endpointSlice.Endpoints = nil
+			t.putEndpointSlice(endpointSlice)
+
+			fakeClock.Step(gracePeriod + time.Second)
+			t.assertDNSRecordsNotFound(namespace2, service1, clusterID3, "")
+
+			endpointSlice.Endpoints = []discovery.Endpoint{{Addresses: []string{endpointIP1}}}
+			t.putEndpointSlice(endpointSlice)
+			Expect(t.getNonHeadlessDNSRecord(namespace2, service1, clusterID3).IP).To(Equal(serviceIP3))
+		})
+	})
+}
+
+func testClusterIPServiceDualStack() {
+	const (
+		serviceIPv6 = "fd00:10:96::a"
+		serviceIPv4 = "192.168.56.30"
+		serviceIP4  = "192.168.56.24"
+	)
+
+	t := newTestDriver()
+
+	BeforeEach(func() {
+		t.resolver.PutServiceImport(newAggregatedServiceImport(namespace1, service1))
+
+		eps := newClusterIPEndpointSlice(namespace1, service1, clusterID1, serviceIPv4, true, port1)
+		eps.Endpoints[0].Addresses = append(eps.Endpoints[0].Addresses, serviceIPv6)
+		t.putEndpointSlice(eps)
+
+		t.putEndpointSlice(newClusterIPEndpointSlice(namespace1, service1, clusterID2, serviceIP4, true, port1))
+	})
+
+	Context("and the requested cluster is dual-stack", func() {
+		It("should return both its IPv4 and IPv6 records", func() {
+			v4, v6, found := t.resolver.GetDualStackDNSRecords(namespace1, service1, clusterID1)
+			Expect(found).To(BeTrue())
+			Expect(v4).To(Equal(&resolver.DNSRecord{
+				IP:          serviceIPv4,
+				IPs:         []string{serviceIPv4, serviceIPv6},
+				Ports:       []mcsv1a1.ServicePort{port1},
+				HostName:    clusterHostName(clusterID1, namespace1, service1),
+				ClusterName: clusterID1,
+			}))
+			Expect(v6).To(Equal(&resolver.DNSRecord{
+				IP:          serviceIPv6,
+				IPs:         []string{serviceIPv4, serviceIPv6},
+				Ports:       []mcsv1a1.ServicePort{port1},
+				HostName:    clusterHostName(clusterID1, namespace1, service1),
+				ClusterName: clusterID1,
+			}))
+		})
+	})
+
+	Context("and the requested cluster is single-stack", func() {
+		It("should return only its IPv4 record", func() {
+			v4, v6, found := t.resolver.GetDualStackDNSRecords(namespace1, service1, clusterID2)
+			Expect(found).To(BeTrue())
+			Expect(v4).To(Equal(&resolver.DNSRecord{
+				IP:          serviceIP4,
+				IPs:         []string{serviceIP4},
+				Ports:       []mcsv1a1.ServicePort{port1},
+				HostName:    clusterHostName(clusterID2, namespace1, service1),
+				ClusterName: clusterID2,
+			}))
+			Expect(v6).To(BeNil())
+		})
+	})
+
+	Context("and no cluster is requested", func() {
+		It("should return the records of a single selected cluster", func() {
+			selected := map[string]int{}
+
+			for i := 0; i < 10; i++ {
+				v4, v6, found := t.resolver.GetDualStackDNSRecords(namespace1, service1, "")
+				Expect(found).To(BeTrue())
+				Expect(v4).ToNot(BeNil())
+
+				selected[v4.ClusterName]++
+
+				if v4.ClusterName == clusterID1 {
+					Expect(v6).ToNot(BeNil())
+					Expect(v6.ClusterName).To(Equal(clusterID1))
+				} else {
+					Expect(v6).To(BeNil())
+				}
+			}
+
+			Expect(selected).To(Equal(map[string]int{clusterID1: 5, clusterID2: 5}))
+		})
+	})
+
+	Context("and GetDNSRecords is called for the dual-stack cluster", func() {
+		It("should return the primary service IP", func() {
+			t.assertDNSRecordsFound(namespace1, service1, clusterID1, "", false, resolver.DNSRecord{
+				IP:          serviceIPv4,
+				IPs:         []string{serviceIPv4, serviceIPv6},
+				Ports:       []mcsv1a1.ServicePort{port1},
+				HostName:    clusterHostName(clusterID1, namespace1, service1),
 				ClusterName: clusterID1,
 			})
 		})
 	})
 
-	When("a local cluster ServiceImport is created", func() {
-		It("should ignore it", func() {
-			serviceImport := &mcsv1a1.ServiceImport{
-				ObjectMeta: metav1.ObjectMeta{
-					Name:      service1 + "-" + namespace1 + "-" + clusterID1,
-					Namespace: submarinerNamespace,
-					Labels: map[string]string{
-						mcsv1a1.LabelServiceName: service1,
-					},
-				},
-				Spec: mcsv1a1.ServiceImportSpec{
-					Type: mcsv1a1.ClusterSetIP,
-					IPs:  []string{serviceIP1},
-				},
-				Status: mcsv1a1.ServiceImportStatus{
-					Clusters: []mcsv1a1.ClusterStatus{
-						{
-							Cluster: clusterID1,
-						},
-					},
-				},
+	Context("and the service doesn't exist", func() {
+		It("should return not found", func() {
+			_, _, found := t.resolver.GetDualStackDNSRecords(namespace1, "unknown", "")
+			Expect(found).To(BeFalse())
+		})
+	})
+}
+
+func testClusterIPServiceLegacyIPs() {
+	const (
+		serviceIPv6 = "fd00:10:96::a"
+		serviceIPv4 = "192.168.56.30"
+	)
+
+	t := newTestDriver()
+
+	putWithIPs := func(ips ...string) *resolver.DNSRecord {
+		serviceImport := newLegacyServiceImport(namespace1, service1, "", clusterID1, port1)
+		serviceImport.Spec.IPs = ips
+		t.resolver.PutServiceImport(serviceImport)
+
+		endpointSlice := newClusterIPEndpointSlice(namespace1, service1, clusterID1, ips[0], true, port1)
+		delete(endpointSlice.Labels, constants.LabelIsHeadless)
+		t.putEndpointSlice(endpointSlice)
+
+		return t.getNonHeadlessDNSRecord(namespace1, service1, "")
+	}
+
+	Context("that are IPv4 only", func() {
+		It("should return a record with the IPv4 address", func() {
+			record := putWithIPs(serviceIPv4)
+			Expect(record.IP).To(Equal(serviceIPv4))
+			Expect(record.IPs).To(Equal([]string{serviceIPv4}))
+			Expect(record.IPv4()).To(Equal(serviceIPv4))
+		})
+	})
+
+	Context("that are IPv6 only", func() {
+		It("should return a record with the IPv6 address and no IPv4 address", func() {
+			record := putWithIPs(serviceIPv6)
+			Expect(record.IP).To(Equal(serviceIPv6))
+			Expect(record.IPs).To(Equal([]string{serviceIPv6}))
+			Expect(record.IPv4()).To(BeEmpty())
+		})
+	})
+
+	Context("that are dual-stack", func() {
+		It("should return a single record with both addresses", func() {
+			record := putWithIPs(serviceIPv6, serviceIPv4)
+			Expect(record.IP).To(Equal(serviceIPv6))
+			Expect(record.IPs).To(Equal([]string{serviceIPv6, serviceIPv4}))
+			Expect(record.IPv4()).To(Equal(serviceIPv4))
+		})
+	})
+
+	Context("that are empty", func() {
+		BeforeEach(func() {
+			putWithIPs(serviceIP2)
+		})
+
+		It("should ignore the ServiceImport and select the other cluster", func() {
+			Expect(func() {
+				t.resolver.PutServiceImport(newLegacyServiceImport(namespace1, service1, "", clusterID2, port1))
+			}).ToNot(Panic())
+
+			for i := 0; i < 5; i++ {
+				Expect(t.getNonHeadlessDNSRecord(namespace1, service1, "").IP).To(Equal(serviceIP2))
 			}
 
-			t.resolver.PutServiceImport(serviceImport)
+			records, _, _ := t.resolver.GetDNSRecords(namespace1, service1, clusterID2, "")
+			Expect(records).To(BeEmpty())
+		})
+	})
+}
 
-			Expect(t.resolver.PutEndpointSlices(newClusterIPEndpointSlice(namespace1, service1, clusterID1, serviceIP1, true))).To(BeTrue())
+func testClusterIPServiceAddressTypeFilter() {
+	const (
+		serviceIPv6  = "fd00:10:96::a"
+		serviceIP3v6 = "fd00:10:96::c"
+	)
 
-			t.resolver.RemoveServiceImport(serviceImport)
+	t := newTestDriver()
+
+	BeforeEach(func() {
+		t.resolver.PutServiceImport(newAggregatedServiceImport(namespace1, service1))
+
+		eps := newClusterIPEndpointSlice(namespace1, service1, clusterID1, serviceIP1, true, port1)
+		eps.Endpoints[0].Addresses = append(eps.Endpoints[0].Addresses, serviceIPv6)
+		t.putEndpointSlice(eps)
+
+		t.putEndpointSlice(newClusterIPEndpointSlice(namespace1, service1, clusterID2, serviceIP2, true, port1))
+		t.putEndpointSlice(newClusterIPEndpointSlice(namespace1, service1, clusterID3, serviceIP3v6, true, port1))
+	})
+
+	getRecord := func(cluster string, addressType discovery.AddressType) *resolver.DNSRecord {
+		records, isHeadless, found := t.resolver.GetDNSRecordsOfAddressType(namespace1, service1, cluster, "", addressType)
+		Expect(found).To(BeTrue())
+		Expect(isHeadless).To(BeFalse())
+
+		if len(records) == 0 {
+			return nil
+		}
+
+		Expect(records).To(HaveLen(1))
+
+		return &records[0]
+	}
+
+	Context("and IPv4 is requested", func() {
+		It("should only select the clusters with an IPv4 service IP", func() {
+			ips := map[string]int{}
+			for i := 0; i < 10; i++ {
+				ips[getRecord("", discovery.AddressTypeIPv4).IP]++
+			}
+
+			Expect(ips).To(Equal(map[string]int{serviceIP1: 5, serviceIP2: 5}))
 		})
 	})
 
-	When("an aggregated ServiceImport on the broker is created", func() {
-		It("should ignore it", func() {
-			serviceImport := &mcsv1a1.ServiceImport{
-				ObjectMeta: metav1.ObjectMeta{
-					Name:      service1 + "-" + namespace1,
-					Namespace: test.RemoteNamespace,
-					Annotations: map[string]string{
-						mcsv1a1.LabelServiceName: service1,
-					},
-				},
-				Spec: mcsv1a1.ServiceImportSpec{
-					Type: mcsv1a1.ClusterSetIP,
-				},
+	Context("and IPv6 is requested", func() {
+		It("should only select the clusters with an IPv6 service IP and return that IP", func() {
+			ips := map[string]int{}
+			for i := 0; i < 10; i++ {
+				ips[getRecord("", discovery.AddressTypeIPv6).IP]++
 			}
 
-			t.resolver.PutServiceImport(serviceImport)
+			Expect(ips).To(Equal(map[string]int{serviceIPv6: 5, serviceIP3v6: 5}))
+		})
 
-			Expect(t.resolver.PutEndpointSlices(newClusterIPEndpointSlice(namespace1, service1, clusterID1, serviceIP1, true))).To(BeTrue())
+		Context("for a cluster lacking an IPv6 service IP", func() {
+			It("should return no record", func() {
+				Expect(getRecord(clusterID2, discovery.AddressTypeIPv6)).To(BeNil())
+			})
+		})
 
-			t.resolver.RemoveServiceImport(serviceImport)
+		Context("and the local cluster lacks an IPv6 service IP", func() {
+			BeforeEach(func() {
+				t.clusterStatus.SetLocalClusterID(clusterID2)
+			})
+
+			It("should select a remote cluster", func() {
+				Expect(getRecord("", discovery.AddressTypeIPv6).IP).To(Or(Equal(serviceIPv6), Equal(serviceIP3v6)))
+			})
+		})
+	})
+
+	Context("and no cluster has a service IP of the requested type", func() {
+		BeforeEach(func() {
+			t.clusterStatus.DisconnectClusterID(clusterID1)
+			t.clusterStatus.DisconnectClusterID(clusterID3)
+		})
+
+		It("should return no record", func() {
+			Expect(getRecord("", discovery.AddressTypeIPv6)).To(BeNil())
 		})
 	})
 }
+
+func testClusterIPServiceCacheKey() {
+	t := newTestDriver()
+
+	BeforeEach(func() {
+		t.resolver.PutServiceImport(newAggregatedServiceImport(namespace1, service1))
+		t.putEndpointSlice(newClusterIPEndpointSlice(namespace1, service1, clusterID1, serviceIP1, true, port1))
+		t.putEndpointSlice(newClusterIPEndpointSlice(namespace1, service1, clusterID2, serviceIP2, true, port1))
+	})
+
+	resolve := func(cluster string) *resolver.Resolution {
+		resolution, found := t.resolver.Resolve(namespace1, service1, cluster)
+		Expect(found).To(BeTrue())
+
+		return resolution
+	}
+
+	It("should return the selected record and reason", func() {
+		resolution := resolve(clusterID2)
+		Expect(resolution.Record).ToNot(BeNil())
+		Expect(resolution.Record.IP).To(Equal(serviceIP2))
+		Expect(resolution.Reason).To(Equal(resolver.ResolvedClusterPinned))
+		Expect(resolution.CacheKey).ToNot(BeEmpty())
+	})
+
+	It("should return the selected cluster and reason for each way of selecting it", func() {
+		Expect(resolve(clusterID2)).To(And(
+			HaveField("Cluster", clusterID2), HaveField("Reason", resolver.ResolvedClusterPinned)))
+
+		Expect(resolve("")).To(And(
+			HaveField("Cluster", BeElementOf(clusterID1, clusterID2)), HaveField("Reason", resolver.ResolvedBalanced)))
+
+		t.clusterStatus.SetLocalClusterID(clusterID1)
+		Expect(resolve("")).To(And(HaveField("Cluster", clusterID1), HaveField("Reason", resolver.ResolvedLocal)))
+
+		t.clusterStatus.SetLocalClusterID("")
+		t.clusterStatus.DisconnectAll()
+		resolution := resolve("")
+		Expect(resolution.Record).To(BeNil())
+		Expect(resolution.Cluster).To(BeEmpty())
+		Expect(resolution.Reason).To(Equal(resolver.ResolvedNone))
+	})
+
+	It("should return a stable cache key while the state doesn't change", func() {
+		key := resolve(clusterID1).CacheKey
+		Expect(resolve(clusterID1).CacheKey).To(Equal(key))
+		Expect(resolve(clusterID1).CacheKey).To(Equal(key))
+	})
+
+	It("should return a different cache key for a different selected cluster", func() {
+		Expect(resolve(clusterID1).CacheKey).ToNot(Equal(resolve(clusterID2).CacheKey))
+	})
+
+	It("should return a different cache key for a different address family", func() {
+		key := resolve(clusterID1).CacheKey
+
+		t.putEndpointSlice(newClusterIPEndpointSlice(namespace1, service1, clusterID1, "fd00:10:96::a", true, port1))
+
+		resolution, found := t.resolver.Resolve(namespace1, service1, clusterID1)
+		Expect(found).To(BeTrue())
+		Expect(resolution.CacheKey).ToNot(Equal(key))
+		Expect(resolution.CacheKey).To(ContainSubstring("IPv6"))
+	})
+
+	It("should return a different cache key after an EndpointSlice is updated", func() {
+		key := resolve(clusterID1).CacheKey
+
+		t.putEndpointSlice(newClusterIPEndpointSlice(namespace1, service1, clusterID1, serviceIP3, true, port1))
+
+		resolution := resolve(clusterID1)
+		Expect(resolution.Record.IP).To(Equal(serviceIP3))
+		Expect(resolution.CacheKey).ToNot(Equal(key))
+	})
+
+	It("should return a different cache key after the ServiceImport is updated", func() {
+		key := resolve(clusterID1).CacheKey
+
+		serviceImport := newAggregatedServiceImport(namespace1, service1)
+		setClusterWeight(serviceImport, clusterID2, 2)
+		t.resolver.PutServiceImport(serviceImport)
+
+		Expect(resolve(clusterID1).CacheKey).ToNot(Equal(key))
+	})
+
+	It("should return a different cache key after the cluster health is updated", func() {
+		key := resolve(clusterID1).CacheKey
+
+		t.resolver.UpdateClusterHealth(clusterID2, false)
+
+		Expect(resolve(clusterID1).CacheKey).ToNot(Equal(key))
+	})
+
+	It("should return a different cache key after the traffic shift is updated", func() {
+		key := resolve(clusterID1).CacheKey
+
+		Expect(t.resolver.SetTrafficShift(clusterID2, 50)).To(Succeed())
+
+		Expect(resolve(clusterID1).CacheKey).ToNot(Equal(key))
+	})
+
+	It("should not resolve a non-existent service", func() {
+		_, found := t.resolver.Resolve(namespace1, "unknown", "")
+		Expect(found).To(BeFalse())
+	})
+}
+
+func testClusterIPServiceLabelSelector() {
+	t := newTestDriver()
+
+	gpuSelector := labels.SelectorFromSet(labels.Set{"gpu": "true"})
+
+	BeforeEach(func() {
+		serviceImport := newAggregatedServiceImport(namespace1, service1)
+		serviceImport.Annotations = map[string]string{
+			constants.ClusterLabelsAnnotationPrefix + "/" + clusterID1: "gpu=true,zone=east",
+			constants.ClusterLabelsAnnotationPrefix + "/" + clusterID2: "gpu=false,zone=east",
+			constants.ClusterLabelsAnnotationPrefix + "/" + clusterID3: "gpu=true,zone=west",
+		}
+		t.resolver.PutServiceImport(serviceImport)
+
+		t.putEndpointSlice(newClusterIPEndpointSlice(namespace1, service1, clusterID1, serviceIP1, true, port1))
+		t.putEndpointSlice(newClusterIPEndpointSlice(namespace1, service1, clusterID2, serviceIP2, true, port1))
+		t.putEndpointSlice(newClusterIPEndpointSlice(namespace1, service1, clusterID3, serviceIP3, true, port1))
+	})
+
+	getIPs := func(cluster string, selector labels.Selector, rounds int) map[string]int {
+		ips := map[string]int{}
+
+		for i := 0; i < rounds; i++ {
+			records, isHeadless, found := t.resolver.GetDNSRecordsMatching(namespace1, service1, cluster, "", selector)
+			Expect(found).To(BeTrue())
+			Expect(isHeadless).To(BeFalse())
+
+			for j := range records {
+				ips[records[j].IP]++
+			}
+		}
+
+		return ips
+	}
+
+	It("should only select the clusters matching the selector", func() {
+		Expect(getIPs("", gpuSelector, 10)).To(Equal(map[string]int{serviceIP1: 5, serviceIP3: 5}))
+
+		selector, err := labels.Parse("gpu=true,zone=west")
+		Expect(err).To(Succeed())
+		Expect(getIPs("", selector, 3)).To(Equal(map[string]int{serviceIP3: 3}))
+	})
+
+	It("should select all the clusters with an empty selector", func() {
+		Expect(getIPs("", labels.Everything(), 3)).To(HaveLen(3))
+	})
+
+	Context("and the local cluster doesn't match", func() {
+		BeforeEach(func() {
+			t.clusterStatus.SetLocalClusterID(clusterID2)
+		})
+
+		It("should select a matching remote cluster", func() {
+			Expect(getIPs("", gpuSelector, 2)).To(Equal(map[string]int{serviceIP1: 1, serviceIP3: 1}))
+		})
+	})
+
+	Context("and a specific cluster that doesn't match is requested", func() {
+		It("should return no record", func() {
+			Expect(getIPs(clusterID2, gpuSelector, 1)).To(BeEmpty())
+		})
+	})
+
+	Context("and no cluster matches", func() {
+		It("should return no record", func() {
+			Expect(getIPs("", labels.SelectorFromSet(labels.Set{"gpu": "maybe"}), 1)).To(BeEmpty())
+		})
+	})
+
+	Context("and the service is headless", func() {
+		BeforeEach(func() {
+			serviceImport := newHeadlessAggregatedServiceImport(namespace2, service1)
+			serviceImport.Annotations = map[string]string{
+				constants.ClusterLabelsAnnotationPrefix + "/" + clusterID1: "gpu=true",
+			}
+			t.resolver.PutServiceImport(serviceImport)
+
+			t.putEndpointSlice(newEndpointSlice(namespace2, service1, clusterID1, []mcsv1a1.ServicePort{port1},
+				discovery.Endpoint{Addresses: []string{endpointIP1}}))
+			t.putEndpointSlice(newEndpointSlice(namespace2, service1, clusterID2, []mcsv1a1.ServicePort{port1},
+				discovery.Endpoint{Addresses: []string{endpointIP2}}))
+		})
+
+		It("should only return the records of the matching clusters", func() {
+			records, isHeadless, found := t.resolver.GetDNSRecordsMatching(namespace2, service1, "", "", gpuSelector)
+			Expect(found).To(BeTrue())
+			Expect(isHeadless).To(BeTrue())
+			Expect(records).To(HaveLen(1))
+			Expect(records[0].IP).To(Equal(endpointIP1))
+		})
+	})
+}
+
+func testClusterIPServiceMisc() {
+	t := newTestDriver()
+
+	When("a service exists in two namespaces", func() {
+		BeforeEach(func() {
+			t.resolver.PutServiceImport(newAggregatedServiceImport(namespace1, service1))
+			t.putEndpointSlice(newClusterIPEndpointSlice(namespace1, service1, clusterID1, serviceIP1, true))
+
+			t.resolver.PutServiceImport(newAggregatedServiceImport(namespace2, service1))
+			t.putEndpointSlice(newClusterIPEndpointSlice(namespace2, service1, clusterID1, serviceIP2, true))
+		})
+
+		It("should return the correct DNS record for each namespace", func() {
+			t.assertDNSRecordsFound(namespace1, service1, clusterID1, "", false, resolver.DNSRecord{
+				IP:          serviceIP1,
+				IPs:         []string{serviceIP1},
+				Ports:       []mcsv1a1.ServicePort{},
+				HostName:    clusterHostName(clusterID1, namespace1, service1),
+				ClusterName: clusterID1,
+			})
+
+			t.assertDNSRecordsFound(namespace2, service1, clusterID1, "", false, resolver.DNSRecord{
+				IP:          serviceIP2,
+				IPs:         []string{serviceIP2},
+				Ports:       []mcsv1a1.ServicePort{},
+				HostName:    clusterHostName(clusterID1, namespace2, service1),
+				ClusterName: clusterID1,
+			})
+		})
+	})
+
+	When("a cluster's record has no service IP", func() {
+		BeforeEach(func() {
+			t.resolver.PutServiceImport(newAggregatedServiceImport(namespace1, service1))
+			t.putEndpointSlice(newClusterIPEndpointSlice(namespace1, service1, clusterID1, "", true, port1))
+			t.putEndpointSlice(newClusterIPEndpointSlice(namespace1, service1, clusterID2, serviceIP2, true, port1))
+		})
+
+		It("should not select it", func() {
+			for i := 0; i < 5; i++ {
+				Expect(t.getNonHeadlessDNSRecord(namespace1, service1, "").IP).To(Equal(serviceIP2))
+			}
+
+			record, found := t.resolver.GetBestDNSRecord(namespace1, service1)
+			Expect(found).To(BeTrue())
+			Expect(record.IP).To(Equal(serviceIP2))
+		})
+	})
+
+	When("a cluster's EndpointSlice is initially created before the ServiceImport", func() {
+		It("should eventually process them and return its DNS record", func() {
+			es := newClusterIPEndpointSlice(namespace1, service1, clusterID1, serviceIP1, true)
+			Expect(t.resolver.PutEndpointSlices(es)).To(BeTrue())
+
+			t.awaitDNSRecords(namespace1, service1, clusterID1, "", false)
+
+			t.resolver.PutServiceImport(newAggregatedServiceImport(namespace1, service1))
+			t.putEndpointSlice(es)
+
+			t.assertDNSRecordsFound(namespace1, service1, "", "", false, resolver.DNSRecord{
+				IP:          serviceIP1,
+				IPs:         []string{serviceIP1},
+				Ports:       []mcsv1a1.ServicePort{},
+				HostName:    clusterHostName(clusterID1, namespace1, service1),
+				ClusterName: clusterID1,
+			})
+		})
+	})
+
+	When("a ServiceImport with an unsupported type is created", func() {
+		It("should ignore it", func() {
+			serviceImport := newAggregatedServiceImport(namespace1, service1)
+			serviceImport.Spec.Type = "Unknown"
+
+			t.resolver.PutServiceImport(serviceImport)
+
+			t.assertDNSRecordsNotFound(namespace1, service1, "", "")
+			Expect(t.resolver.PutEndpointSlices(newClusterIPEndpointSlice(namespace1, service1, clusterID1, serviceIP1, true))).To(BeTrue())
+			t.assertDNSRecordsNotFound(namespace1, service1, "", "")
+		})
+	})
+
+	When("a local cluster ServiceImport is created", func() {
+		It("should ignore it", func() {
+			serviceImport := &mcsv1a1.ServiceImport{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      service1 + "-" + namespace1 + "-" + clusterID1,
+					Namespace: submarinerNamespace,
+					Labels: map[string]string{
+						mcsv1a1.LabelServiceName: service1,
+					},
+				},
+				Spec: mcsv1a1.ServiceImportSpec{
+					Type: mcsv1a1.ClusterSetIP,
+					IPs:  []string{serviceIP1},
+				},
+				Status: mcsv1a1.ServiceImportStatus{
+					Clusters: []mcsv1a1.ClusterStatus{
+						{
+							Cluster: clusterID1,
+						},
+					},
+				},
+			}
+
+			t.resolver.PutServiceImport(serviceImport)
+
+			Expect(t.resolver.PutEndpointSlices(newClusterIPEndpointSlice(namespace1, service1, clusterID1, serviceIP1, true))).To(BeTrue())
+
+			t.resolver.RemoveServiceImport(serviceImport)
+		})
+	})
+
+	When("an aggregated ServiceImport on the broker is created", func() {
+		It("should ignore it", func() {
+			serviceImport := &mcsv1a1.ServiceImport{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      service1 + "-" + namespace1,
+					Namespace: test.RemoteNamespace,
+					Annotations: map[string]string{
+						mcsv1a1.LabelServiceName: service1,
+					},
+				},
+				Spec: mcsv1a1.ServiceImportSpec{
+					Type: mcsv1a1.ClusterSetIP,
+				},
+			}
+
+			t.resolver.PutServiceImport(serviceImport)
+
+			Expect(t.resolver.PutEndpointSlices(newClusterIPEndpointSlice(namespace1, service1, clusterID1, serviceIP1, true))).To(BeTrue())
+
+			t.resolver.RemoveServiceImport(serviceImport)
+		})
+	})
+}
+
+var _ = Describe("LookupDNSRecords", func() {
+	t := newTestDriver()
+
+	lookup := func() ([]resolver.DNSRecord, resolver.LookupStatus) {
+		records, isHeadless, status := t.resolver.LookupDNSRecords(namespace1, service1, "", "", "")
+		Expect(isHeadless).To(BeFalse())
+
+		return records, status
+	}
+
+	When("the service doesn't exist", func() {
+		It("should report not found", func() {
+			records, status := lookup()
+			Expect(status).To(Equal(resolver.LookupNotFound))
+			Expect(records).To(BeEmpty())
+		})
+	})
+
+	When("the service exists", func() {
+		BeforeEach(func() {
+			t.resolver.PutServiceImport(newAggregatedServiceImport(namespace1, service1))
+		})
+
+		Context("and the endpoints of all its clusters are unhealthy", func() {
+			BeforeEach(func() {
+				t.putEndpointSlice(newClusterIPEndpointSlice(namespace1, service1, clusterID1, serviceIP1, false, port1))
+				t.putEndpointSlice(newClusterIPEndpointSlice(namespace1, service1, clusterID2, serviceIP2, false, port1))
+			})
+
+			It("should report unhealthy", func() {
+				records, status := lookup()
+				Expect(status).To(Equal(resolver.LookupUnhealthy))
+				Expect(records).To(BeEmpty())
+			})
+		})
+
+		Context("and one of its clusters is healthy", func() {
+			BeforeEach(func() {
+				t.putEndpointSlice(newClusterIPEndpointSlice(namespace1, service1, clusterID1, serviceIP1, false, port1))
+				t.putEndpointSlice(newClusterIPEndpointSlice(namespace1, service1, clusterID2, serviceIP2, true, port1))
+			})
+
+			It("should return its record", func() {
+				records, status := lookup()
+				Expect(status).To(Equal(resolver.LookupFound))
+				Expect(records).To(HaveLen(1))
+				Expect(records[0].IP).To(Equal(serviceIP2))
+			})
+		})
+
+		Context("and its clusters are disconnected", func() {
+			BeforeEach(func() {
+				t.putEndpointSlice(newClusterIPEndpointSlice(namespace1, service1, clusterID1, serviceIP1, false, port1))
+				t.clusterStatus.DisconnectAll()
+			})
+
+			It("should report empty", func() {
+				_, status := lookup()
+				Expect(status).To(Equal(resolver.LookupEmpty))
+			})
+		})
+
+		Context("and it has no clusters", func() {
+			It("should report empty", func() {
+				_, status := lookup()
+				Expect(status).To(Equal(resolver.LookupEmpty))
+			})
+		})
+	})
+})
+
+var _ = Describe("LookupDNSRecordsOfAddressType", func() {
+	const (
+		serviceIP2v6 = "fd00:10:96::b"
+		serviceIP3v6 = "fd00:10:96::c"
+	)
+
+	t := newTestDriver()
+
+	BeforeEach(func() {
+		t.resolver.PutServiceImport(newAggregatedServiceImport(namespace1, service1))
+		t.putEndpointSlice(newClusterIPEndpointSlice(namespace1, service1, clusterID1, serviceIP1, true, port1))
+		t.putEndpointSlice(newClusterIPEndpointSlice(namespace1, service1, clusterID2, serviceIP2v6, true, port1))
+
+		eps := newClusterIPEndpointSlice(namespace1, service1, clusterID3, serviceIP3, true, port1)
+		eps.Endpoints[0].Addresses = append(eps.Endpoints[0].Addresses, serviceIP3v6)
+		t.putEndpointSlice(eps)
+	})
+
+	lookupIPs := func(addressType discovery.AddressType) map[string]int {
+		ips := map[string]int{}
+
+		for i := 0; i < 10; i++ {
+			records, isHeadless, status := t.resolver.LookupDNSRecordsOfAddressType(namespace1, service1, "", "", "", addressType)
+			Expect(isHeadless).To(BeFalse())
+			Expect(status).To(Equal(resolver.LookupFound))
+			Expect(records).To(HaveLen(1))
+
+			ips[records[0].IP]++
+		}
+
+		return ips
+	}
+
+	Context("for an A query", func() {
+		It("should only select the IPv4-only and dual-stack clusters", func() {
+			Expect(lookupIPs(discovery.AddressTypeIPv4)).To(Equal(map[string]int{serviceIP1: 5, serviceIP3: 5}))
+		})
+	})
+
+	Context("for an AAAA query", func() {
+		It("should only select the IPv6-only and dual-stack clusters", func() {
+			Expect(lookupIPs(discovery.AddressTypeIPv6)).To(Equal(map[string]int{serviceIP2v6: 5, serviceIP3v6: 5}))
+		})
+
+		It("should return records whose IPv6 address is the selected IP", func() {
+			records, _, _ := t.resolver.LookupDNSRecordsOfAddressType(namespace1, service1, clusterID3, "", "",
+				discovery.AddressTypeIPv6)
+			Expect(records).To(HaveLen(1))
+			Expect(records[0].IPv6()).To(Equal(serviceIP3v6))
+			Expect(records[0].IPv4()).To(Equal(serviceIP3))
+		})
+	})
+
+	Context("and only the IPv4-only cluster is connected", func() {
+		BeforeEach(func() {
+			t.clusterStatus.DisconnectClusterID(clusterID2)
+			t.clusterStatus.DisconnectClusterID(clusterID3)
+		})
+
+		It("should report empty for an AAAA query", func() {
+			records, _, status := t.resolver.LookupDNSRecordsOfAddressType(namespace1, service1, "", "", "", discovery.AddressTypeIPv6)
+			Expect(status).To(Equal(resolver.LookupEmpty))
+			Expect(records).To(BeEmpty())
+		})
+
+		It("should still answer an A query", func() {
+			Expect(lookupIPs(discovery.AddressTypeIPv4)).To(Equal(map[string]int{serviceIP1: 10}))
+		})
+	})
+
+	Context("and no address type is given", func() {
+		It("should select any cluster", func() {
+			Expect(lookupIPs("")).To(HaveLen(3))
+		})
+	})
+})
+
+var _ = Describe("GetDNSRecordPreferred", func() {
+	t := newTestDriver()
+
+	var (
+		order     []string
+		unhealthy map[string]bool
+	)
+
+	checkEndpoint := func(_, _, clusterID string) bool {
+		return !unhealthy[clusterID]
+	}
+
+	getPreferred := func() string {
+		record, found := t.resolver.GetDNSRecordPreferred(namespace1, service1, order, checkEndpoint)
+		Expect(found).To(BeTrue())
+		Expect(record).ToNot(BeNil())
+
+		return record.IP
+	}
+
+	BeforeEach(func() {
+		order = []string{clusterID3, clusterID1, clusterID2}
+		unhealthy = map[string]bool{}
+
+		t.resolver.PutServiceImport(newAggregatedServiceImport(namespace1, service1))
+		t.putEndpointSlice(newClusterIPEndpointSlice(namespace1, service1, clusterID1, serviceIP1, true, port1))
+		t.putEndpointSlice(newClusterIPEndpointSlice(namespace1, service1, clusterID2, serviceIP2, true, port1))
+		t.putEndpointSlice(newClusterIPEndpointSlice(namespace1, service1, clusterID3, serviceIP3, true, port1))
+	})
+
+	It("should return the record of the primary cluster", func() {
+		for j := 0; j < 5; j++ {
+			Expect(getPreferred()).To(Equal(serviceIP3))
+		}
+	})
+
+	When("the primary cluster fails the endpoint check", func() {
+		BeforeEach(func() {
+			unhealthy[clusterID3] = true
+		})
+
+		It("should return the record of the secondary cluster", func() {
+			Expect(getPreferred()).To(Equal(serviceIP1))
+		})
+
+		Context("and the secondary cluster is disconnected", func() {
+			It("should return the record of the tertiary cluster", func() {
+				t.clusterStatus.DisconnectClusterID(clusterID1)
+				Expect(getPreferred()).To(Equal(serviceIP2))
+			})
+		})
+	})
+
+	When("the primary cluster's endpoints are unhealthy", func() {
+		It("should return the record of the secondary cluster", func() {
+			t.putEndpointSlice(newClusterIPEndpointSlice(namespace1, service1, clusterID3, serviceIP3, false, port1))
+			Expect(getPreferred()).To(Equal(serviceIP1))
+		})
+	})
+
+	When("no cluster in the order qualifies", func() {
+		BeforeEach(func() {
+			order = []string{clusterID3, "unknown"}
+			unhealthy[clusterID3] = true
+			unhealthy[clusterID1] = true
+		})
+
+		It("should fall back to a cluster that passes the endpoint check", func() {
+			Expect(getPreferred()).To(Equal(serviceIP2))
+		})
+	})
+
+	When("the service is headless", func() {
+		It("should return no record", func() {
+			t.resolver.PutServiceImport(newHeadlessAggregatedServiceImport(namespace2, service1))
+
+			record, found := t.resolver.GetDNSRecordPreferred(namespace2, service1, order, nil)
+			Expect(found).To(BeTrue())
+			Expect(record).To(BeNil())
+		})
+	})
+
+	When("the service doesn't exist", func() {
+		It("should return not found", func() {
+			_, found := t.resolver.GetDNSRecordPreferred(namespace2, service1, order, nil)
+			Expect(found).To(BeFalse())
+		})
+	})
+})
+
+var _ = Describe("GetDNSRecordsCheckingEndpoints", func() {
+	t := newTestDriver()
+
+	var (
+		calls   int
+		checked []string
+	)
+
+	failCluster2 := func(_, _ string, clusterIDs []string) map[string]bool {
+		calls++
+		checked = clusterIDs
+
+		results := map[string]bool{}
+		for _, clusterID := range clusterIDs {
+			results[clusterID] = clusterID != clusterID2
+		}
+
+		return results
+	}
+
+	BeforeEach(func() {
+		calls = 0
+		checked = nil
+	})
+
+	When("the service is ClusterIP", func() {
+		BeforeEach(func() {
+			t.resolver.PutServiceImport(newAggregatedServiceImport(namespace1, service1))
+			t.putEndpointSlice(newClusterIPEndpointSlice(namespace1, service1, clusterID1, serviceIP1, true, port1))
+			t.putEndpointSlice(newClusterIPEndpointSlice(namespace1, service1, clusterID2, serviceIP2, true, port1))
+			t.putEndpointSlice(newClusterIPEndpointSlice(namespace1, service1, clusterID3, serviceIP3, true, port1))
+		})
+
+		It("should invoke the check once per lookup with all the clusters", func() {
+			ips := map[string]int{}
+
+			for i := 1; i <= 10; i++ {
+				records, isHeadless, found := t.resolver.GetDNSRecordsCheckingEndpoints(namespace1, service1, "", "", failCluster2)
+				Expect(found).To(BeTrue())
+				Expect(isHeadless).To(BeFalse())
+				Expect(records).To(HaveLen(1))
+				Expect(calls).To(Equal(i))
+
+				ips[records[0].IP]++
+			}
+
+			Expect(checked).To(Equal([]string{clusterID1, clusterID2, clusterID3}))
+			Expect(ips).To(Equal(map[string]int{serviceIP1: 5, serviceIP3: 5}))
+		})
+
+		Context("and a cluster that fails the check is requested", func() {
+			It("should return no record", func() {
+				records, _, found := t.resolver.GetDNSRecordsCheckingEndpoints(namespace1, service1, clusterID2, "", failCluster2)
+				Expect(found).To(BeTrue())
+				Expect(records).To(BeEmpty())
+				Expect(calls).To(Equal(1))
+			})
+		})
+
+		Context("and the check is derived from a per-cluster check", func() {
+			It("should check each cluster once per lookup", func() {
+				calls := map[string]int{}
+
+				check := resolver.EndpointsCheckFrom(func(_, _, clusterID string) bool {
+					calls[clusterID]++
+					return clusterID == clusterID3
+				})
+
+				records, _, _ := t.resolver.GetDNSRecordsCheckingEndpoints(namespace1, service1, "", "", check)
+				Expect(records).To(HaveLen(1))
+				Expect(records[0].IP).To(Equal(serviceIP3))
+				Expect(calls).To(Equal(map[string]int{clusterID1: 1, clusterID2: 1, clusterID3: 1}))
+			})
+		})
+
+		Context("and no check is given", func() {
+			It("should select any cluster", func() {
+				records, _, found := t.resolver.GetDNSRecordsCheckingEndpoints(namespace1, service1, clusterID2, "", nil)
+				Expect(found).To(BeTrue())
+				Expect(records).To(HaveLen(1))
+				Expect(records[0].IP).To(Equal(serviceIP2))
+			})
+		})
+	})
+
+	When("the service is headless", func() {
+		BeforeEach(func() {
+			t.resolver.PutServiceImport(newHeadlessAggregatedServiceImport(namespace1, service1))
+
+			for i, clusterID := range []string{clusterID1, clusterID2} {
+				t.putEndpointSlice(newEndpointSlice(namespace1, service1, clusterID, []mcsv1a1.ServicePort{port1},
+					discovery.Endpoint{Addresses: []string{[]string{endpointIP1, endpointIP2}[i]}}))
+			}
+		})
+
+		It("should only return the records of the clusters that pass the check", func() {
+			records, isHeadless, found := t.resolver.GetDNSRecordsCheckingEndpoints(namespace1, service1, "", "", failCluster2)
+			Expect(found).To(BeTrue())
+			Expect(isHeadless).To(BeTrue())
+			Expect(records).To(Equal([]resolver.DNSRecord{{IP: endpointIP1, Ports: []mcsv1a1.ServicePort{port1}, ClusterName: clusterID1}}))
+			Expect(calls).To(Equal(1))
+		})
+	})
+
+	When("the service doesn't exist", func() {
+		It("should return not found without invoking the check", func() {
+			_, _, found := t.resolver.GetDNSRecordsCheckingEndpoints(namespace1, "unknown", "", "", failCluster2)
+			Expect(found).To(BeFalse())
+			Expect(calls).To(BeZero())
+		})
+	})
+})
+
+var _ = Describe("GetDNSRecordsContext", func() {
+	t := newTestDriver()
+
+	BeforeEach(func() {
+		t.resolver.PutServiceImport(newAggregatedServiceImport(namespace1, service1))
+		t.putEndpointSlice(newClusterIPEndpointSlice(namespace1, service1, clusterID1, serviceIP1, true, port1))
+		t.putEndpointSlice(newClusterIPEndpointSlice(namespace1, service1, clusterID2, serviceIP2, true, port1))
+	})
+
+	When("the check completes in time", func() {
+		It("should only consider the clusters that pass it", func() {
+			for i := 0; i < 5; i++ {
+				records, isHeadless, found, err := t.resolver.GetDNSRecordsContext(context.Background(), namespace1, service1, "", "",
+					func(_ context.Context, _, _, clusterID string) bool {
+						return clusterID == clusterID2
+					})
+				Expect(err).To(Succeed())
+				Expect(found).To(BeTrue())
+				Expect(isHeadless).To(BeFalse())
+				Expect(records).To(HaveLen(1))
+				Expect(records[0].IP).To(Equal(serviceIP2))
+			}
+		})
+	})
+
+	When("the check is slow and the context deadline passes", func() {
+		It("should return early with the deadline error and no record", func() {
+			release := make(chan struct{})
+			DeferCleanup(func() {
+				close(release)
+			})
+
+			ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+			DeferCleanup(cancel)
+
+			start := time.Now()
+
+			records, _, found, err := t.resolver.GetDNSRecordsContext(ctx, namespace1, service1, "", "",
+				func(_ context.Context, _, _, _ string) bool {
+					<-release
+					return true
+				})
+			Expect(time.Since(start)).To(BeNumerically("<", time.Second))
+			Expect(err).To(MatchError(context.DeadlineExceeded))
+			Expect(found).To(BeTrue())
+			Expect(records).To(BeEmpty())
+
+			// The lock mustn't be held by the blocked check.
+			t.putEndpointSlice(newClusterIPEndpointSlice(namespace1, service1, clusterID3, serviceIP3, true, port1))
+		})
+	})
+
+	When("the context is already cancelled", func() {
+		It("should not invoke the check", func() {
+			ctx, cancel := context.WithCancel(context.Background())
+			cancel()
+
+			_, _, _, err := t.resolver.GetDNSRecordsContext(ctx, namespace1, service1, "", "",
+				func(_ context.Context, _, _, _ string) bool {
+					Fail("the check was invoked")
+					return true
+				})
+			Expect(err).To(MatchError(context.Canceled))
+		})
+	})
+
+	When("the service doesn't exist", func() {
+		It("should return not found without an error", func() {
+			_, _, found, err := t.resolver.GetDNSRecordsContext(context.Background(), namespace2, service1, "", "",
+				func(_ context.Context, _, _, _ string) bool {
+					return true
+				})
+			Expect(err).To(Succeed())
+			Expect(found).To(BeFalse())
+		})
+	})
+
+	When("no check is given", func() {
+		It("should select any cluster", func() {
+			records, _, found, err := t.resolver.GetDNSRecordsContext(context.Background(), namespace1, service1, clusterID1, "", nil)
+			Expect(err).To(Succeed())
+			Expect(found).To(BeTrue())
+			Expect(records).To(HaveLen(1))
+			Expect(records[0].IP).To(Equal(serviceIP1))
+		})
+	})
+})
+
+var _ = Describe("ReplaceEndpointSlices", func() {
+	const updatedServiceIP = "192.168.56.24"
+
+	t := newTestDriver()
+
+	clusterNames := func() []string {
+		var names []string
+		for _, c := range t.resolver.ClustersByWeight(namespace1, service1) {
+			names = append(names, c.Cluster)
+		}
+
+		sort.Strings(names)
+
+		return names
+	}
+
+	sliceFor := func(clusterID, serviceIP string) *discovery.EndpointSlice {
+		return newClusterIPEndpointSlice(namespace1, service1, clusterID, serviceIP, true, port1)
+	}
+
+	BeforeEach(func() {
+		t.resolver.PutServiceImport(newAggregatedServiceImport(namespace1, service1))
+		t.putEndpointSlice(sliceFor(clusterID1, serviceIP1))
+		t.putEndpointSlice(sliceFor(clusterID2, serviceIP2))
+	})
+
+	It("should add, update and remove clusters to match the EndpointSlices", func() {
+		Expect(t.resolver.ReplaceEndpointSlices(namespace1, service1, sliceFor(clusterID2, updatedServiceIP),
+			sliceFor(clusterID3, serviceIP3))).To(Succeed())
+
+		Expect(clusterNames()).To(Equal([]string{clusterID2, clusterID3}))
+		t.assertDNSRecordsNotFound(namespace1, service1, clusterID1, "")
+		Expect(t.getNonHeadlessDNSRecord(namespace1, service1, clusterID2).IP).To(Equal(updatedServiceIP))
+		t.testRoundRobin(namespace1, service1, updatedServiceIP, serviceIP3)
+	})
+
+	When("an EndpointSlice is for another service", func() {
+		It("should return an error and change nothing", func() {
+			Expect(t.resolver.ReplaceEndpointSlices(namespace1, service1, sliceFor(clusterID3, serviceIP3),
+				newClusterIPEndpointSlice(namespace2, service1, clusterID1, serviceIP1, true, port1))).ToNot(Succeed())
+
+			Expect(clusterNames()).To(Equal([]string{clusterID1, clusterID2}))
+		})
+	})
+
+	When("the service is headless", func() {
+		It("should return an error", func() {
+			t.resolver.PutServiceImport(newHeadlessAggregatedServiceImport(namespace2, service1))
+
+			Expect(t.resolver.ReplaceEndpointSlices(namespace2, service1,
+				newClusterIPEndpointSlice(namespace2, service1, clusterID1, serviceIP1, true, port1))).ToNot(Succeed())
+		})
+	})
+
+	When("the service doesn't exist", func() {
+		It("should return an error", func() {
+			Expect(t.resolver.ReplaceEndpointSlices(namespace2, service1)).ToNot(Succeed())
+		})
+	})
+
+	When("reads run concurrently", func() {
+		It("should never observe a partially replaced set of clusters", func() {
+			setA := []string{clusterID1, clusterID2}
+			setB := []string{clusterID2, clusterID3}
+
+			stop := make(chan struct{})
+
+			var wg sync.WaitGroup
+
+			wg.Add(1)
+
+			go func() {
+				defer GinkgoRecover()
+				defer wg.Done()
+
+				for {
+					select {
+					case <-stop:
+						return
+					default:
+					}
+
+					Expect(clusterNames()).To(Or(Equal(setA), Equal(setB)))
+				}
+			}()
+
+			for n := 0; n < 200; n++ {
+				if n%2 == 0 {
+					Expect(t.resolver.ReplaceEndpointSlices(namespace1, service1, sliceFor(clusterID2, serviceIP2),
+						sliceFor(clusterID3, serviceIP3))).To(Succeed())
+				} else {
+					Expect(t.resolver.ReplaceEndpointSlices(namespace1, service1, sliceFor(clusterID1, serviceIP1),
+						sliceFor(clusterID2, serviceIP2))).To(Succeed())
+				}
+			}
+
+			close(stop)
+			wg.Wait()
+		})
+	})
+})
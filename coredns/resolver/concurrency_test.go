@@ -0,0 +1,142 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+
+Copyright Contributors to the Submariner project.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package resolver_test
+
+import (
+	"fmt"
+	"sync"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Concurrent access", func() {
+	const (
+		workers           = 8
+		servicesPerWorker = 16
+		iterations        = 50
+	)
+
+	t := newTestDriver()
+
+	serviceName := func(worker, j int) string {
+		return fmt.Sprintf("service-%d-%d", worker, j)
+	}
+
+	BeforeEach(func() {
+		for w := 0; w < workers; w++ {
+			for j := 0; j < servicesPerWorker; j++ {
+				t.resolver.PutServiceImport(newAggregatedServiceImport(namespace1, serviceName(w, j)))
+				t.putEndpointSlice(newClusterIPEndpointSlice(namespace1, serviceName(w, j), clusterID1, serviceIP1, true, port1))
+			}
+		}
+	})
+
+	It("should handle concurrent reads and writes across many services", func() {
+		var wg sync.WaitGroup
+
+		// Each worker uses its own services as concurrent lookups of the same service contend on its load balancer. The
+		// services of different workers share shards.
+		for w := 0; w < workers; w++ {
+			wg.Add(1)
+
+			go func(w int) {
+				defer GinkgoRecover()
+				defer wg.Done()
+
+				for n := 0; n < iterations; n++ {
+					name := serviceName(w, n%servicesPerWorker)
+					slice := newClusterIPEndpointSlice(namespace1, name, clusterID2, serviceIP2, true, port1)
+
+					Expect(t.resolver.PutEndpointSlices(slice)).To(BeFalse())
+
+					_, _, found := t.resolver.GetDNSRecords(namespace1, name, "", "")
+					Expect(found).To(BeTrue())
+
+					t.resolver.RemoveEndpointSlice(slice)
+				}
+			}(w)
+		}
+
+		wg.Add(1)
+
+		go func() {
+			defer GinkgoRecover()
+			defer wg.Done()
+
+			for n := 0; n < iterations; n++ {
+				Expect(t.resolver.ServiceClusterCounts()).To(HaveLen(workers * servicesPerWorker))
+			}
+		}()
+
+		wg.Wait()
+
+		for w := 0; w < workers; w++ {
+			for j := 0; j < servicesPerWorker; j++ {
+				Expect(t.getNonHeadlessDNSRecord(namespace1, serviceName(w, j), "").IP).To(Equal(serviceIP1))
+			}
+		}
+	})
+
+	It("should serialize concurrent selections of the same service", func() {
+		const (
+			lookups = 16
+			rounds  = 50
+		)
+
+		t.resolver.PutServiceImport(newAggregatedServiceImport(namespace1, service1))
+		t.putEndpointSlice(newClusterIPEndpointSlice(namespace1, service1, clusterID1, serviceIP1, true, port1))
+		t.putEndpointSlice(newClusterIPEndpointSlice(namespace1, service1, clusterID2, serviceIP2, false, port1))
+		t.putEndpointSlice(newClusterIPEndpointSlice(namespace1, service1, clusterID3, serviceIP3, true, port1))
+
+		var (
+			wg    sync.WaitGroup
+			mutex sync.Mutex
+		)
+
+		counts := map[string]int{}
+
+		for j := 0; j < lookups; j++ {
+			wg.Add(1)
+
+			go func() {
+				defer GinkgoRecover()
+				defer wg.Done()
+
+				for n := 0; n < rounds; n++ {
+					records, _, found := t.resolver.GetDNSRecords(namespace1, service1, "", "")
+					Expect(found).To(BeTrue())
+					Expect(records).To(HaveLen(1))
+
+					mutex.Lock()
+					counts[records[0].IP]++
+					mutex.Unlock()
+				}
+			}()
+		}
+
+		wg.Wait()
+
+		// The unhealthy cluster is skipped and the others alternate as if the selections were made sequentially.
+		Expect(counts).To(Equal(map[string]int{
+			serviceIP1: lookups * rounds / 2,
+			serviceIP3: lookups * rounds / 2,
+		}))
+	})
+})
@@ -39,7 +39,9 @@ var _ = Describe("Controller", func() {
 	When("a ClusterIP service EndpointSlice is created", func() {
 		expDNSRecord := resolver.DNSRecord{
 			IP:          serviceIP1,
+			IPs:         []string{serviceIP1},
 			Ports:       []mcsv1a1.ServicePort{port1},
+			HostName:    clusterHostName(clusterID1, namespace1, service1),
 			ClusterName: clusterID1,
 		}
 
@@ -48,9 +48,11 @@ func (i *Interface) PutEndpointSlices(endpointSlices ...*discovery.EndpointSlice
 		return false
 	}
 
+	clusterID = i.normalizeClusterName(clusterID)
+
 	logger.Infof("Put EndpointSlices for %q on cluster %q", key, clusterID)
 
-	localClusterID := i.clusterStatus.GetLocalClusterID()
+	localClusterID := i.normalizeClusterName(i.clusterStatus.GetLocalClusterID())
 
 	var (
 		localEndpointSliceErr error
@@ -64,10 +66,18 @@ func (i *Interface) PutEndpointSlices(endpointSlices ...*discovery.EndpointSlice
 		localEndpointSlices, localEndpointSliceErr = i.getLocalEndpointSlices(endpointSlices[0])
 	}
 
-	i.mutex.Lock()
-	defer i.mutex.Unlock()
+	changed := false
 
-	serviceInfo, found := i.serviceMap[key]
+	defer func() {
+		if changed {
+			i.notifyClustersChanged(key)
+		}
+	}()
+
+	shard := i.lockShard(key)
+	defer i.unlockShard(shard)
+
+	serviceInfo, found := shard.services[key]
 	if !found {
 		// This means we haven't observed a ServiceImport yet for the service. Return true for the controller to re-queue it.
 		logger.Infof("Service not found for EndpointSlice %q - requeuing", key)
@@ -75,7 +85,10 @@ func (i *Interface) PutEndpointSlices(endpointSlices ...*discovery.EndpointSlice
 		return true
 	}
 
+	serviceInfo.markChanged(i.clock.Now())
+
 	if !serviceInfo.isHeadless {
+		changed = true
 		return i.putClusterIPEndpointSlice(key, clusterID, endpointSlices[0], serviceInfo)
 	}
 
@@ -91,6 +104,8 @@ func (i *Interface) PutEndpointSlices(endpointSlices ...*discovery.EndpointSlice
 
 	i.putHeadlessEndpointSlices(key, clusterID, endpointSlices, serviceInfo)
 
+	changed = true
+
 	return false
 }
 
@@ -105,34 +120,124 @@ func (i *Interface) putClusterIPEndpointSlice(key, clusterID string, endpointSli
 		}
 
 		// For a ClusterIPService we really only care if there are any backing endpoints.
-		clusterInfo.endpointsHealthy = len(endpointSlice.Endpoints) > 0
+		clusterInfo.setEndpointsHealthy(len(endpointSlice.Endpoints) > 0, i.clock.Now())
 
 		return false
 	}
 
-	if len(endpointSlice.Endpoints) == 0 {
+	if len(endpointSlice.Endpoints) == 0 || len(endpointSlice.Endpoints[0].Addresses) == 0 {
 		// This shouldn't happen - we expect the service IP endpoint to always be present.
 		logger.Errorf(nil, "Missing service IP endpoint in EndpointSlice %q", key)
 
 		return false
 	}
 
-	clusterInfo := serviceInfo.ensureClusterInfo(clusterID)
-	clusterInfo.endpointRecords = []DNSRecord{{
-		IP:          endpointSlice.Endpoints[0].Addresses[0],
-		Ports:       mcsServicePortsFrom(endpointSlice.Ports),
-		ClusterName: clusterID,
-	}}
+	i.setClusterIPRecords(key, clusterID, endpointSlice, serviceInfo)
+	i.mergePorts(key, serviceInfo)
+	serviceInfo.updateLoadBalancing()
+
+	return false
+}
 
-	clusterInfo.endpointsHealthy = endpointSlice.Endpoints[0].Conditions.Ready == nil || *endpointSlice.Endpoints[0].Conditions.Ready
+// setClusterIPRecords sets the given cluster's records of the given ClusterIP service from its EndpointSlice, which must
+// have the service IP endpoint. The caller is responsible for merging the ports and updating the load balancing.
+func (i *Interface) setClusterIPRecords(key, clusterID string, endpointSlice *discovery.EndpointSlice, serviceInfo *serviceInfo) {
+	clusterInfo := serviceInfo.ensureClusterInfo(clusterID, i.clock.Now())
+	previous := clusterInfo.endpointRecords
+	mcsPorts := mcsServicePortsFrom(endpointSlice.Ports)
+	clusterInfo.ports = mcsPorts
+
+	// A dual-stack service has an address per IP family. The first is the primary service IP.
+	addresses := endpointSlice.Endpoints[0].Addresses
+	clusterInfo.endpointRecords = make([]DNSRecord, len(addresses))
+
+	for i, address := range addresses {
+		clusterInfo.endpointRecords[i] = DNSRecord{
+			IP:          address,
+			IPs:         append([]string{}, addresses...),
+			Ports:       mcsPorts,
+			HostName:    clusterHostName(clusterID, key),
+			ClusterName: clusterID,
+			TTL:         serviceInfo.recordTTL,
+		}
+	}
 
-	serviceInfo.mergePorts()
-	serviceInfo.resetLoadBalancing()
+	clusterInfo.setEndpointsHealthy(endpointSlice.Endpoints[0].Conditions.Ready == nil || *endpointSlice.Endpoints[0].Conditions.Ready,
+		i.clock.Now())
 
 	logger.Infof("Added DNSRecord with service IP %q for EndpointSlice %q on cluster %q, endpointsHealthy: %v, ports: %#v",
 		clusterInfo.endpointRecords[0].IP, key, clusterID, clusterInfo.endpointsHealthy, clusterInfo.endpointRecords[0].Ports)
 
-	return false
+	i.updateIPIndex(key, previous, clusterInfo.endpointRecords)
+}
+
+// ReplaceEndpointSlices replaces the clusters of the given ClusterIP service with those of the given EndpointSlices,
+// one per cluster, under a single lock: clusters with an EndpointSlice are added or updated and the others are removed.
+// Lookups thus observe either the previous or the new clusters, never a mix, and the ports are merged and the load
+// balancing updated once. Nothing is changed if an EndpointSlice isn't a post-0.15 ClusterIP EndpointSlice of the
+// service with its service IP endpoint, or the service isn't found or is headless.
+func (i *Interface) ReplaceEndpointSlices(namespace, name string, endpointSlices ...*discovery.EndpointSlice) error {
+	key := keyFunc(namespace, name)
+	byCluster := make(map[string]*discovery.EndpointSlice, len(endpointSlices))
+
+	for _, endpointSlice := range endpointSlices {
+		sliceKey, clusterID, ok := getKeyInfoFrom(endpointSlice)
+		if !ok || sliceKey != key {
+			return fmt.Errorf("EndpointSlice %q isn't for service %q", endpointSlice.Name, key)
+		}
+
+		if _, found := endpointSlice.Labels[constants.LabelIsHeadless]; !found {
+			return fmt.Errorf("EndpointSlice %q for service %q is a legacy EndpointSlice", endpointSlice.Name, key)
+		}
+
+		if len(endpointSlice.Endpoints) == 0 || len(endpointSlice.Endpoints[0].Addresses) == 0 {
+			return fmt.Errorf("EndpointSlice %q for service %q is missing the service IP endpoint", endpointSlice.Name, key)
+		}
+
+		byCluster[i.normalizeClusterName(clusterID)] = endpointSlice
+	}
+
+	logger.Infof("Replace EndpointSlices for %q with %d cluster(s)", key, len(byCluster))
+
+	changed := false
+
+	defer func() {
+		if changed {
+			i.notifyClustersChanged(key)
+		}
+	}()
+
+	shard := i.lockShard(key)
+	defer i.unlockShard(shard)
+
+	serviceInfo, found := shard.services[key]
+	if !found {
+		return fmt.Errorf("service %q not found", key)
+	}
+
+	if serviceInfo.isHeadless {
+		return fmt.Errorf("service %q is headless", key)
+	}
+
+	changed = true
+
+	serviceInfo.markChanged(i.clock.Now())
+
+	for clusterID, clusterInfo := range serviceInfo.clusters {
+		if _, found := byCluster[clusterID]; !found {
+			i.updateIPIndex(key, clusterInfo.endpointRecords, nil)
+			delete(serviceInfo.clusters, clusterID)
+		}
+	}
+
+	for clusterID, endpointSlice := range byCluster {
+		i.setClusterIPRecords(key, clusterID, endpointSlice, serviceInfo)
+	}
+
+	i.mergePorts(key, serviceInfo)
+	serviceInfo.updateLoadBalancing()
+
+	return nil
 }
 
 func (i *Interface) putHeadlessEndpointSlices(key, clusterID string, endpointSlices []*discovery.EndpointSlice, serviceInfo *serviceInfo) {
@@ -144,6 +249,7 @@ func (i *Interface) putHeadlessEndpointSlices(key, clusterID string, endpointSli
 
 	for _, endpointSlice := range endpointSlices {
 		mcsPorts := mcsServicePortsFrom(endpointSlice.Ports)
+		clusterInfo.ports = unionPorts(clusterInfo.ports, mcsPorts)
 		publishNotReadyAddresses := endpointSlice.Annotations[constants.PublishNotReadyAddresses] == strconv.FormatBool(true)
 
 		for i := range endpointSlice.Endpoints {
@@ -241,24 +347,143 @@ func (i *Interface) RemoveEndpointSlice(endpointSlice *discovery.EndpointSlice)
 		return
 	}
 
+	clusterID = i.normalizeClusterName(clusterID)
+
 	logger.Infof("Remove EndpointSlice %q on cluster %q", key, clusterID)
 
-	i.mutex.Lock()
-	defer i.mutex.Unlock()
+	changed := false
+
+	defer func() {
+		if changed {
+			i.notifyClustersChanged(key)
+		}
+	}()
+
+	shard := i.lockShard(key)
+	defer i.unlockShard(shard)
 
-	serviceInfo, found := i.serviceMap[key]
+	serviceInfo, found := shard.services[key]
 	if !found {
 		return
 	}
 
+	changed = true
+
+	if clusterInfo, found := serviceInfo.clusters[clusterID]; found && !serviceInfo.isHeadless {
+		i.updateIPIndex(key, clusterInfo.endpointRecords, nil)
+	}
+
 	delete(serviceInfo.clusters, clusterID)
 
+	serviceInfo.markChanged(i.clock.Now())
+
 	if !serviceInfo.isHeadless {
-		serviceInfo.mergePorts()
+		i.mergePorts(key, serviceInfo)
 		serviceInfo.resetLoadBalancing()
 	}
 }
 
+// UpdateClusterHealth sets whether the given cluster's endpoints are healthy across all ClusterIP services it backs,
+// resetting the load balancing of each affected service once. Returns the number of services whose health changed.
+func (i *Interface) UpdateClusterHealth(clusterID string, healthy bool) int {
+	i.mutex.Lock()
+	defer i.mutex.Unlock()
+
+	clusterID = i.normalizeClusterName(clusterID)
+	now := i.clock.Now()
+	updated := 0
+
+	i.forEachService(func(_ string, serviceInfo *serviceInfo) {
+		if serviceInfo.isHeadless {
+			return
+		}
+
+		clusterInfo, found := serviceInfo.clusters[clusterID]
+		if !found || clusterInfo.endpointsHealthy == healthy {
+			return
+		}
+
+		clusterInfo.setEndpointsHealthy(healthy, now)
+		serviceInfo.markChanged(now)
+		serviceInfo.updateLoadBalancing()
+
+		updated++
+	})
+
+	logger.Infof("Updated the endpoints health of cluster %q to %v for %d service(s)", clusterID, healthy, updated)
+
+	return updated
+}
+
+// ReportEndpointCheck records the outcome of an endpoint check of the given cluster of the given ClusterIP service. If
+// health decay is enabled, consecutive failures lower the cluster's load balancing weight and successes restore it.
+func (i *Interface) ReportEndpointCheck(namespace, name, clusterID string, passed bool) {
+	if !i.healthDecay.enabled() {
+		return
+	}
+
+	i.mutex.RLock()
+	defer i.mutex.RUnlock()
+
+	key := i.serviceKey(namespace, name)
+	shard := i.shardFor(key)
+
+	shard.mutex.Lock()
+	defer shard.mutex.Unlock()
+
+	serviceInfo, found := shard.services[key]
+	if !found || serviceInfo.isHeadless {
+		return
+	}
+
+	clusterInfo, found := serviceInfo.clusters[i.normalizeClusterName(clusterID)]
+	if !found {
+		return
+	}
+
+	if serviceInfo.recordEndpointCheck(clusterInfo, passed) {
+		serviceInfo.resetLoadBalancing()
+	}
+}
+
+// UpdateEndpointCount sets the number of ready endpoints backing the given cluster of the given ClusterIP service. A
+// cluster with no ready endpoints, eg whose pods are all draining, isn't selected even though it has a DNS record.
+// Clusters whose count was never set are assumed to be serving.
+func (i *Interface) UpdateEndpointCount(namespace, name, clusterID string, count int) {
+	i.mutex.RLock()
+	defer i.mutex.RUnlock()
+
+	key := i.serviceKey(namespace, name)
+	shard := i.shardFor(key)
+
+	shard.mutex.Lock()
+	defer shard.mutex.Unlock()
+
+	serviceInfo, found := shard.services[key]
+	if !found || serviceInfo.isHeadless {
+		return
+	}
+
+	clusterInfo, found := serviceInfo.clusters[i.normalizeClusterName(clusterID)]
+	if !found {
+		return
+	}
+
+	wasServing := clusterInfo.isServing()
+	previous := serviceInfo.capacityFor(clusterInfo)
+
+	clusterInfo.readyEndpoints = count
+	clusterInfo.readyEndpointsKnown = true
+
+	if clusterInfo.isServing() != wasServing {
+		serviceInfo.markChanged(i.clock.Now())
+	}
+
+	if serviceInfo.capacityFor(clusterInfo) != previous {
+		serviceInfo.updateLoadBalancing()
+	}
+}
+
 func getKeyInfoFrom(es *discovery.EndpointSlice) (string, string, bool) {
 	name, ok := es.Labels[mcsv1a1.LabelServiceName]
 	if !ok {
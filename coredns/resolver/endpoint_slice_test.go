@@ -20,6 +20,7 @@ package resolver_test
 
 import (
 	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
 	"github.com/submariner-io/admiral/pkg/syncer/test"
 	"github.com/submariner-io/lighthouse/coredns/constants"
 	discovery "k8s.io/api/discovery/v1"
@@ -115,3 +116,65 @@ var _ = Describe("RemoveEndpointSlice", func() {
 		})
 	})
 })
+
+var _ = Describe("UpdateClusterHealth", func() {
+	t := newTestDriver()
+
+	BeforeEach(func() {
+		for _, ns := range []string{namespace1, namespace2} {
+			t.resolver.PutServiceImport(newAggregatedServiceImport(ns, service1))
+
+			t.putEndpointSlice(newClusterIPEndpointSlice(ns, service1, clusterID1, serviceIP1, true, port1))
+			t.putEndpointSlice(newClusterIPEndpointSlice(ns, service1, clusterID2, serviceIP2, true, port1))
+		}
+
+		t.resolver.PutServiceImport(newAggregatedServiceImport(namespace1, "other"))
+		t.putEndpointSlice(newClusterIPEndpointSlice(namespace1, "other", clusterID2, serviceIP2, true, port1))
+	})
+
+	When("a cluster becomes unhealthy", func() {
+		It("should update all the services it backs", func() {
+			Expect(t.resolver.UpdateClusterHealth(clusterID1, false)).To(Equal(2))
+
+			for _, ns := range []string{namespace1, namespace2} {
+				for i := 0; i < 5; i++ {
+					Expect(t.getNonHeadlessDNSRecord(ns, service1, "").IP).To(Equal(serviceIP2))
+				}
+			}
+		})
+
+		Context("and it's updated again with the same health", func() {
+			It("should not update any service", func() {
+				t.resolver.UpdateClusterHealth(clusterID1, false)
+				Expect(t.resolver.UpdateClusterHealth(clusterID1, false)).To(Equal(0))
+			})
+		})
+
+		Context("and then healthy again", func() {
+			It("should restore round-robin selection for all the services", func() {
+				t.resolver.UpdateClusterHealth(clusterID1, false)
+				Expect(t.resolver.UpdateClusterHealth(clusterID1, true)).To(Equal(2))
+
+				t.testRoundRobin(namespace1, service1, serviceIP1, serviceIP2)
+				t.testRoundRobin(namespace2, service1, serviceIP1, serviceIP2)
+			})
+		})
+
+		Context("and then healthy again after a selection", func() {
+			It("should preserve the selection state of the load balancer", func() {
+				first := t.getNonHeadlessDNSRecord(namespace1, service1, "").IP
+
+				t.resolver.UpdateClusterHealth(clusterID1, false)
+				t.resolver.UpdateClusterHealth(clusterID1, true)
+
+				Expect(t.getNonHeadlessDNSRecord(namespace1, service1, "").IP).ToNot(Equal(first))
+			})
+		})
+	})
+
+	When("a cluster that backs no service is updated", func() {
+		It("should not update any service", func() {
+			Expect(t.resolver.UpdateClusterHealth(clusterID3, false)).To(Equal(0))
+		})
+	})
+})
@@ -0,0 +1,46 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+
+Copyright Contributors to the Submariner project.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package resolver
+
+import (
+	"github.com/go-logr/logr"
+	"github.com/submariner-io/admiral/pkg/log"
+)
+
+// SetLogger replaces the package logger for the duration of a test and returns a function that restores it.
+func SetLogger(l logr.Logger) func() {
+	previous := logger
+	logger = log.Logger{Logger: l}
+
+	return func() {
+		logger = previous
+	}
+}
+
+// WithServiceShards configures the number of shards the services are split into, eg to compare against a single shard.
+func WithServiceShards(count int) Option {
+	return func(i *Interface) {
+		i.shards = newServiceShards(count)
+	}
+}
+
+// IsRecentlyMissed returns whether a lookup of the given service would be answered from the negative cache.
+func (i *Interface) IsRecentlyMissed(namespace, name string) bool {
+	return i.isRecentlyMissed(namespace, name)
+}
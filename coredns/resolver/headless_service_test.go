@@ -440,3 +440,169 @@ func testHeadlessServiceInMultipleClusters() {
 		})
 	})
 }
+
+var _ = Describe("GetAllRecords", func() {
+	t := newTestDriver()
+
+	failCluster2 := func(_, _, clusterID string) bool {
+		return clusterID != clusterID2
+	}
+
+	When("the service is headless", func() {
+		BeforeEach(func() {
+			t.resolver.PutServiceImport(newHeadlessAggregatedServiceImport(namespace1, service1))
+
+			for i, clusterID := range []string{clusterID1, clusterID2, clusterID3} {
+				t.putEndpointSlice(newEndpointSlice(namespace1, service1, clusterID, []mcsv1a1.ServicePort{port1},
+					discovery.Endpoint{Addresses: []string{"100.96.157." + strconv.Itoa(i+1)}}))
+			}
+		})
+
+		It("should return the records of every cluster that passes the endpoint check", func() {
+			records, found := t.resolver.GetAllRecords(namespace1, service1, failCluster2)
+			Expect(found).To(BeTrue())
+			Expect(records).To(ConsistOf(
+				resolver.DNSRecord{IP: "100.96.157.1", Ports: []mcsv1a1.ServicePort{port1}, ClusterName: clusterID1},
+				resolver.DNSRecord{IP: "100.96.157.3", Ports: []mcsv1a1.ServicePort{port1}, ClusterName: clusterID3}))
+		})
+
+		It("should return the records of every cluster if no check is given", func() {
+			records, found := t.resolver.GetAllRecords(namespace1, service1, nil)
+			Expect(found).To(BeTrue())
+			Expect(records).To(HaveLen(3))
+		})
+
+		Context("and a cluster is disconnected", func() {
+			BeforeEach(func() {
+				t.clusterStatus.DisconnectClusterID(clusterID3)
+			})
+
+			It("should omit its records", func() {
+				records, found := t.resolver.GetAllRecords(namespace1, service1, failCluster2)
+				Expect(found).To(BeTrue())
+				Expect(records).To(ConsistOf(
+					resolver.DNSRecord{IP: "100.96.157.1", Ports: []mcsv1a1.ServicePort{port1}, ClusterName: clusterID1}))
+			})
+		})
+	})
+
+	When("the service is ClusterIP", func() {
+		BeforeEach(func() {
+			t.resolver.PutServiceImport(newAggregatedServiceImport(namespace1, service1))
+			t.putEndpointSlice(newClusterIPEndpointSlice(namespace1, service1, clusterID1, serviceIP1, true, port1))
+			t.putEndpointSlice(newClusterIPEndpointSlice(namespace1, service1, clusterID2, serviceIP2, true, port1))
+		})
+
+		It("should return the single selected record of a cluster that passes the endpoint check", func() {
+			for i := 0; i < 5; i++ {
+				records, found := t.resolver.GetAllRecords(namespace1, service1, failCluster2)
+				Expect(found).To(BeTrue())
+				Expect(records).To(HaveLen(1))
+				Expect(records[0].IP).To(Equal(serviceIP1))
+			}
+		})
+
+		Context("and every cluster fails the endpoint check", func() {
+			It("should return no records", func() {
+				records, found := t.resolver.GetAllRecords(namespace1, service1, func(_, _, _ string) bool {
+					return false
+				})
+				Expect(found).To(BeTrue())
+				Expect(records).To(BeEmpty())
+			})
+		})
+	})
+
+	When("the service doesn't exist", func() {
+		It("should return not found", func() {
+			_, found := t.resolver.GetAllRecords(namespace1, "unknown", nil)
+			Expect(found).To(BeFalse())
+		})
+	})
+})
+
+var _ = Describe("GetHostnameRecord", func() {
+	t := newTestDriver()
+
+	cluster1Record := resolver.DNSRecord{
+		IP:          endpointIP1,
+		Ports:       []mcsv1a1.ServicePort{port1},
+		ClusterName: clusterID1,
+		HostName:    hostName1,
+	}
+
+	cluster2Record := resolver.DNSRecord{
+		IP:          endpointIP2,
+		Ports:       []mcsv1a1.ServicePort{port1},
+		ClusterName: clusterID2,
+		HostName:    hostName2,
+	}
+
+	BeforeEach(func() {
+		t.resolver.PutServiceImport(newHeadlessAggregatedServiceImport(namespace1, service1))
+
+		t.putEndpointSlice(newEndpointSlice(namespace1, service1, clusterID1, []mcsv1a1.ServicePort{port1},
+			discovery.Endpoint{
+				Addresses: []string{endpointIP1},
+				Hostname:  &hostName1,
+			},
+			discovery.Endpoint{
+				Addresses: []string{endpointIP3},
+			}))
+
+		t.putEndpointSlice(newEndpointSlice(namespace1, service1, clusterID2, []mcsv1a1.ServicePort{port1},
+			discovery.Endpoint{
+				Addresses: []string{endpointIP2},
+				Hostname:  &hostName2,
+			}))
+	})
+
+	It("should return the record with the hostname from whichever cluster has it", func() {
+		record, found := t.resolver.GetHostnameRecord(namespace1, service1, hostName1, nil)
+		Expect(found).To(BeTrue())
+		Expect(*record).To(Equal(cluster1Record))
+
+		record, found = t.resolver.GetHostnameRecord(namespace1, service1, hostName2, nil)
+		Expect(found).To(BeTrue())
+		Expect(*record).To(Equal(cluster2Record))
+	})
+
+	When("no record has the hostname", func() {
+		It("should return false", func() {
+			_, found := t.resolver.GetHostnameRecord(namespace1, service1, "unknown", nil)
+			Expect(found).To(BeFalse())
+		})
+	})
+
+	When("the cluster with the hostname fails the endpoint check", func() {
+		It("should return false", func() {
+			_, found := t.resolver.GetHostnameRecord(namespace1, service1, hostName2, func(_, _, clusterID string) bool {
+				return clusterID != clusterID2
+			})
+			Expect(found).To(BeFalse())
+		})
+	})
+
+	When("the cluster with the hostname is disconnected", func() {
+		BeforeEach(func() {
+			t.clusterStatus.DisconnectClusterID(clusterID1)
+		})
+
+		It("should return false", func() {
+			_, found := t.resolver.GetHostnameRecord(namespace1, service1, hostName1, nil)
+			Expect(found).To(BeFalse())
+		})
+	})
+
+	When("the service is ClusterIP", func() {
+		BeforeEach(func() {
+			t.resolver.PutServiceImport(newAggregatedServiceImport(namespace2, service1))
+			t.putEndpointSlice(newClusterIPEndpointSlice(namespace2, service1, clusterID1, serviceIP1, true, port1))
+		})
+
+		It("should return false", func() {
+			_, found := t.resolver.GetHostnameRecord(namespace2, service1, clusterID1, nil)
+			Expect(found).To(BeFalse())
+		})
+	})
+})
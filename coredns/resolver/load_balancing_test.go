@@ -0,0 +1,2816 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+
+Copyright Contributors to the Submariner project.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package resolver_test
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/submariner-io/lighthouse/coredns/constants"
+	"github.com/submariner-io/lighthouse/coredns/loadbalancer"
+	"github.com/submariner-io/lighthouse/coredns/resolver"
+	"k8s.io/apimachinery/pkg/util/wait"
+	testingclock "k8s.io/utils/clock/testing"
+	mcsv1a1 "sigs.k8s.io/mcs-api/pkg/apis/v1alpha1"
+)
+
+var _ = Describe("ClustersByWeight", func() {
+	t := newTestDriver()
+
+	var serviceImport *mcsv1a1.ServiceImport
+
+	BeforeEach(func() {
+		serviceImport = newAggregatedServiceImport(namespace1, service1)
+	})
+
+	JustBeforeEach(func() {
+		t.resolver.PutServiceImport(serviceImport)
+
+		t.putEndpointSlice(newClusterIPEndpointSlice(namespace1, service1, clusterID3, serviceIP3, true, port1))
+		t.putEndpointSlice(newClusterIPEndpointSlice(namespace1, service1, clusterID1, serviceIP1, true, port1))
+		t.putEndpointSlice(newClusterIPEndpointSlice(namespace1, service1, clusterID2, serviceIP2, true, port1))
+	})
+
+	When("the clusters have distinct weights", func() {
+		BeforeEach(func() {
+			setClusterWeight(serviceImport, clusterID1, 2)
+			setClusterWeight(serviceImport, clusterID2, 5)
+			setClusterWeight(serviceImport, clusterID3, 1)
+		})
+
+		It("should return the clusters sorted by descending weight", func() {
+			Expect(t.resolver.ClustersByWeight(namespace1, service1)).To(Equal([]resolver.ClusterWeight{
+				{Cluster: clusterID2, Weight: 5},
+				{Cluster: clusterID1, Weight: 2},
+				{Cluster: clusterID3, Weight: 1},
+			}))
+		})
+	})
+
+	When("the clusters have equal weights", func() {
+		It("should return the clusters sorted by name", func() {
+			Expect(t.resolver.ClustersByWeight(namespace1, service1)).To(Equal([]resolver.ClusterWeight{
+				{Cluster: clusterID1, Weight: 1},
+				{Cluster: clusterID2, Weight: 1},
+				{Cluster: clusterID3, Weight: 1},
+			}))
+		})
+	})
+
+	When("some clusters have equal weights", func() {
+		BeforeEach(func() {
+			setClusterWeight(serviceImport, clusterID2, 3)
+			setClusterWeight(serviceImport, clusterID3, 3)
+		})
+
+		It("should break the ties by name", func() {
+			Expect(t.resolver.ClustersByWeight(namespace1, service1)).To(Equal([]resolver.ClusterWeight{
+				{Cluster: clusterID2, Weight: 3},
+				{Cluster: clusterID3, Weight: 3},
+				{Cluster: clusterID1, Weight: 1},
+			}))
+		})
+	})
+
+	When("the weights are updated", func() {
+		It("should return the new order", func() {
+			setClusterWeight(serviceImport, clusterID3, 10)
+			t.resolver.PutServiceImport(serviceImport)
+
+			Expect(t.resolver.ClustersByWeight(namespace1, service1)).To(Equal([]resolver.ClusterWeight{
+				{Cluster: clusterID3, Weight: 10},
+				{Cluster: clusterID1, Weight: 1},
+				{Cluster: clusterID2, Weight: 1},
+			}))
+		})
+	})
+
+	When("the service doesn't exist", func() {
+		It("should return nil", func() {
+			Expect(t.resolver.ClustersByWeight(namespace2, service1)).To(BeNil())
+		})
+	})
+})
+
+var _ = Describe("ListClusters", func() {
+	t := newTestDriver()
+
+	BeforeEach(func() {
+		serviceImport := newAggregatedServiceImport(namespace1, service1)
+		setClusterWeight(serviceImport, clusterID2, 5)
+		setClusterWeight(serviceImport, clusterID3, 0)
+		t.resolver.PutServiceImport(serviceImport)
+
+		t.putEndpointSlice(newClusterIPEndpointSlice(namespace1, service1, clusterID3, serviceIP3, true, port1))
+		t.putEndpointSlice(newClusterIPEndpointSlice(namespace1, service1, clusterID1, serviceIP1, true, port1))
+		t.putEndpointSlice(newClusterIPEndpointSlice(namespace1, service1, clusterID2, serviceIP2, false, port1))
+	})
+
+	It("should return every cluster sorted by name with its weight", func() {
+		clusters, found := t.resolver.ListClusters(namespace1, service1)
+		Expect(found).To(BeTrue())
+		Expect(clusters).To(Equal([]resolver.ClusterInfo{
+			{Cluster: clusterID1, Weight: 1},
+			{Cluster: clusterID2, Weight: 5},
+			{Cluster: clusterID3, Weight: 1},
+		}))
+	})
+
+	When("one is the local cluster", func() {
+		BeforeEach(func() {
+			t.clusterStatus.SetLocalClusterID(clusterID2)
+		})
+
+		It("should flag it", func() {
+			clusters, found := t.resolver.ListClusters(namespace1, service1)
+			Expect(found).To(BeTrue())
+			Expect(clusters).To(Equal([]resolver.ClusterInfo{
+				{Cluster: clusterID1, Weight: 1},
+				{Cluster: clusterID2, Weight: 5, IsLocal: true},
+				{Cluster: clusterID3, Weight: 1},
+			}))
+		})
+	})
+
+	When("the service doesn't exist", func() {
+		It("should return not found", func() {
+			_, found := t.resolver.ListClusters(namespace2, service1)
+			Expect(found).To(BeFalse())
+		})
+	})
+})
+
+var _ = Describe("Minimum share", func() {
+	t := newTestDriver()
+
+	var serviceImport *mcsv1a1.ServiceImport
+
+	BeforeEach(func() {
+		serviceImport = newAggregatedServiceImport(namespace1, service1)
+		serviceImport.Annotations = map[string]string{constants.LoadBalancerMinShareAnnotation: "10"}
+	})
+
+	JustBeforeEach(func() {
+		t.resolver.PutServiceImport(serviceImport)
+
+		t.putEndpointSlice(newClusterIPEndpointSlice(namespace1, service1, clusterID1, serviceIP1, true, port1))
+		t.putEndpointSlice(newClusterIPEndpointSlice(namespace1, service1, clusterID2, serviceIP2, true, port1))
+		t.putEndpointSlice(newClusterIPEndpointSlice(namespace1, service1, clusterID3, serviceIP3, true, port1))
+	})
+
+	When("a cluster's weight is below the minimum share", func() {
+		BeforeEach(func() {
+			setClusterWeight(serviceImport, clusterID1, 1)
+			setClusterWeight(serviceImport, clusterID2, 40)
+			setClusterWeight(serviceImport, clusterID3, 59)
+		})
+
+		It("should give it the minimum share and honor the relative weights of the others", func() {
+			t.assertSelectionShares(namespace1, service1, 1000, map[string]float64{
+				clusterID1: 0.1,
+				clusterID2: 0.9 * 40 / 99,
+				clusterID3: 0.9 * 59 / 99,
+			})
+		})
+	})
+
+	When("raising a cluster to the minimum share pushes another below it", func() {
+		BeforeEach(func() {
+			setClusterWeight(serviceImport, clusterID1, 1)
+			setClusterWeight(serviceImport, clusterID2, 10)
+			setClusterWeight(serviceImport, clusterID3, 89)
+		})
+
+		It("should give both the minimum share", func() {
+			t.assertSelectionShares(namespace1, service1, 1000, map[string]float64{
+				clusterID1: 0.1,
+				clusterID2: 0.1,
+				clusterID3: 0.8,
+			})
+		})
+	})
+
+	When("a cluster has zero weight", func() {
+		BeforeEach(func() {
+			setClusterWeight(serviceImport, clusterID1, 0)
+		})
+
+		It("should coerce its weight to 1", func() {
+			t.assertSelectionShares(namespace1, service1, 900, map[string]float64{
+				clusterID1: 1.0 / 3,
+				clusterID2: 1.0 / 3,
+				clusterID3: 1.0 / 3,
+			})
+		})
+	})
+
+	When("all clusters are above the minimum share", func() {
+		BeforeEach(func() {
+			setClusterWeight(serviceImport, clusterID1, 2)
+			setClusterWeight(serviceImport, clusterID2, 3)
+			setClusterWeight(serviceImport, clusterID3, 5)
+		})
+
+		It("should distribute by weight", func() {
+			t.assertSelectionShares(namespace1, service1, 1000, map[string]float64{
+				clusterID1: 0.2,
+				clusterID2: 0.3,
+				clusterID3: 0.5,
+			})
+		})
+	})
+})
+
+var _ = Describe("GetBestDNSRecord", func() {
+	t := newTestDriver()
+
+	BeforeEach(func() {
+		serviceImport := newAggregatedServiceImport(namespace1, service1)
+		setClusterWeight(serviceImport, clusterID1, 2)
+		setClusterWeight(serviceImport, clusterID2, 5)
+		setClusterWeight(serviceImport, clusterID3, 3)
+
+		t.resolver.PutServiceImport(serviceImport)
+
+		t.putEndpointSlice(newClusterIPEndpointSlice(namespace1, service1, clusterID1, serviceIP1, true, port1))
+		t.putEndpointSlice(newClusterIPEndpointSlice(namespace1, service1, clusterID2, serviceIP2, true, port1))
+		t.putEndpointSlice(newClusterIPEndpointSlice(namespace1, service1, clusterID3, serviceIP3, true, port1))
+	})
+
+	assertBest := func(expIP string) {
+		for i := 0; i < 5; i++ {
+			record, found := t.resolver.GetBestDNSRecord(namespace1, service1)
+			Expect(found).To(BeTrue())
+			Expect(record).ToNot(BeNil())
+			Expect(record.IP).To(Equal(expIP))
+		}
+	}
+
+	When("all clusters are healthy", func() {
+		It("should consistently return the highest weight cluster's record", func() {
+			assertBest(serviceIP2)
+		})
+	})
+
+	When("the highest weight cluster is unhealthy", func() {
+		BeforeEach(func() {
+			t.putEndpointSlice(newClusterIPEndpointSlice(namespace1, service1, clusterID2, serviceIP2, false, port1))
+		})
+
+		It("should return the next highest weight cluster's record", func() {
+			assertBest(serviceIP3)
+		})
+	})
+
+	When("the two highest weight clusters are disconnected", func() {
+		BeforeEach(func() {
+			t.clusterStatus.DisconnectClusterID(clusterID2)
+			t.clusterStatus.DisconnectClusterID(clusterID3)
+		})
+
+		It("should return the remaining cluster's record", func() {
+			assertBest(serviceIP1)
+		})
+	})
+
+	When("there's a healthy local cluster", func() {
+		BeforeEach(func() {
+			t.clusterStatus.SetLocalClusterID(clusterID1)
+		})
+
+		It("should return the local cluster's record", func() {
+			assertBest(serviceIP1)
+		})
+	})
+
+	When("no cluster is healthy", func() {
+		BeforeEach(func() {
+			t.clusterStatus.DisconnectAll()
+		})
+
+		It("should return no record", func() {
+			record, found := t.resolver.GetBestDNSRecord(namespace1, service1)
+			Expect(found).To(BeTrue())
+			Expect(record).To(BeNil())
+		})
+	})
+
+	When("the service doesn't exist", func() {
+		It("should return not found", func() {
+			_, found := t.resolver.GetBestDNSRecord(namespace2, service1)
+			Expect(found).To(BeFalse())
+		})
+	})
+})
+
+var _ = Describe("SelectionProbabilities", func() {
+	t := newTestDriver()
+
+	BeforeEach(func() {
+		serviceImport := newAggregatedServiceImport(namespace1, service1)
+		setClusterWeight(serviceImport, clusterID1, 1)
+		setClusterWeight(serviceImport, clusterID2, 3)
+		setClusterWeight(serviceImport, clusterID3, 4)
+
+		t.resolver.PutServiceImport(serviceImport)
+
+		t.putEndpointSlice(newClusterIPEndpointSlice(namespace1, service1, clusterID1, serviceIP1, true, port1))
+		t.putEndpointSlice(newClusterIPEndpointSlice(namespace1, service1, clusterID2, serviceIP2, true, port1))
+		t.putEndpointSlice(newClusterIPEndpointSlice(namespace1, service1, clusterID3, serviceIP3, true, port1))
+	})
+
+	When("all clusters are healthy", func() {
+		It("should return probabilities proportional to the weights", func() {
+			Expect(t.resolver.SelectionProbabilities(namespace1, service1, nil)).To(Equal(map[string]float64{
+				clusterID1: 0.125,
+				clusterID2: 0.375,
+				clusterID3: 0.5,
+			}))
+		})
+	})
+
+	When("a cluster is unhealthy", func() {
+		BeforeEach(func() {
+			t.putEndpointSlice(newClusterIPEndpointSlice(namespace1, service1, clusterID3, serviceIP3, false, port1))
+		})
+
+		It("should exclude it and redistribute among the healthy clusters", func() {
+			Expect(t.resolver.SelectionProbabilities(namespace1, service1, nil)).To(Equal(map[string]float64{
+				clusterID1: 0.25,
+				clusterID2: 0.75,
+			}))
+		})
+	})
+
+	When("a cluster is disconnected", func() {
+		BeforeEach(func() {
+			t.clusterStatus.DisconnectClusterID(clusterID1)
+		})
+
+		It("should exclude it", func() {
+			probabilities := t.resolver.SelectionProbabilities(namespace1, service1, nil)
+			Expect(probabilities).To(HaveLen(2))
+			Expect(probabilities[clusterID2]).To(BeNumerically("~", 3.0/7, 0.0001))
+			Expect(probabilities[clusterID3]).To(BeNumerically("~", 4.0/7, 0.0001))
+		})
+	})
+
+	When("the local cluster is healthy", func() {
+		BeforeEach(func() {
+			t.clusterStatus.SetLocalClusterID(clusterID1)
+		})
+
+		It("should return it with probability 1", func() {
+			Expect(t.resolver.SelectionProbabilities(namespace1, service1, nil)).To(Equal(map[string]float64{clusterID1: 1}))
+		})
+	})
+
+	When("a cluster fails the endpoint check", func() {
+		It("should exclude it", func() {
+			Expect(t.resolver.SelectionProbabilities(namespace1, service1, func(_, _, clusterID string) bool {
+				return clusterID != clusterID2
+			})).To(Equal(map[string]float64{
+				clusterID1: 0.2,
+				clusterID3: 0.8,
+			}))
+		})
+	})
+
+	When("every cluster fails the endpoint check", func() {
+		It("should return no probabilities", func() {
+			Expect(t.resolver.SelectionProbabilities(namespace1, service1, func(_, _, _ string) bool {
+				return false
+			})).To(BeEmpty())
+		})
+	})
+
+	When("the service doesn't exist", func() {
+		It("should return nil", func() {
+			Expect(t.resolver.SelectionProbabilities(namespace2, service1, nil)).To(BeNil())
+		})
+	})
+})
+
+var _ = Describe("SelectionProbabilities with cost-aware selection", func() {
+	t := newTestDriver(resolver.WithCostAwareSelection())
+
+	BeforeEach(func() {
+		serviceImport := newAggregatedServiceImport(namespace1, service1)
+		setClusterWeight(serviceImport, clusterID2, 1)
+		setClusterWeight(serviceImport, clusterID3, 3)
+		serviceImport.Annotations[constants.CostAnnotationPrefix+"/"+clusterID1] = "10"
+
+		t.resolver.PutServiceImport(serviceImport)
+
+		t.putEndpointSlice(newClusterIPEndpointSlice(namespace1, service1, clusterID1, serviceIP1, true, port1))
+		t.putEndpointSlice(newClusterIPEndpointSlice(namespace1, service1, clusterID2, serviceIP2, true, port1))
+		t.putEndpointSlice(newClusterIPEndpointSlice(namespace1, service1, clusterID3, serviceIP3, true, port1))
+	})
+
+	It("should only return the clusters of the cheapest tier", func() {
+		Expect(t.resolver.SelectionProbabilities(namespace1, service1, nil)).To(Equal(map[string]float64{
+			clusterID2: 0.25,
+			clusterID3: 0.75,
+		}))
+	})
+
+	When("the cheapest tier's clusters are unhealthy", func() {
+		BeforeEach(func() {
+			t.putEndpointSlice(newClusterIPEndpointSlice(namespace1, service1, clusterID2, serviceIP2, false, port1))
+			t.putEndpointSlice(newClusterIPEndpointSlice(namespace1, service1, clusterID3, serviceIP3, false, port1))
+		})
+
+		It("should return the next tier's clusters", func() {
+			Expect(t.resolver.SelectionProbabilities(namespace1, service1, nil)).To(Equal(map[string]float64{clusterID1: 1}))
+		})
+	})
+})
+
+var _ = Describe("In-flight limits", func() {
+	const lease = time.Minute
+
+	fakeClock := testingclock.NewFakeClock(time.Now())
+	t := newTestDriver(resolver.WithClock(fakeClock), resolver.WithInFlightLimits(lease))
+
+	BeforeEach(func() {
+		serviceImport := newAggregatedServiceImport(namespace1, service1)
+		serviceImport.Annotations = map[string]string{
+			constants.MaxInFlightAnnotationPrefix + "/" + clusterID1: "2",
+		}
+
+		t.resolver.PutServiceImport(serviceImport)
+
+		t.putEndpointSlice(newClusterIPEndpointSlice(namespace1, service1, clusterID1, serviceIP1, true, port1))
+		t.putEndpointSlice(newClusterIPEndpointSlice(namespace1, service1, clusterID2, serviceIP2, true, port1))
+	})
+
+	selectN := func(n int) map[string]int {
+		counts := map[string]int{}
+		for i := 0; i < n; i++ {
+			counts[t.getNonHeadlessDNSRecord(namespace1, service1, "").ClusterName]++
+		}
+
+		return counts
+	}
+
+	When("a cluster reaches its limit", func() {
+		It("should skip it until a selection is released", func() {
+			Expect(selectN(10)).To(Equal(map[string]int{clusterID1: 2, clusterID2: 8}))
+
+			t.resolver.ReleaseDNSRecord(namespace1, service1, clusterID1)
+
+			Expect(selectN(10)).To(Equal(map[string]int{clusterID1: 1, clusterID2: 9}))
+		})
+	})
+
+	When("a cluster's selection leases expire", func() {
+		It("should select it again", func() {
+			Expect(selectN(10)).To(Equal(map[string]int{clusterID1: 2, clusterID2: 8}))
+
+			fakeClock.Step(lease)
+
+			Expect(selectN(10)).To(Equal(map[string]int{clusterID1: 2, clusterID2: 8}))
+		})
+	})
+
+	When("the limited cluster is the local cluster", func() {
+		BeforeEach(func() {
+			t.clusterStatus.SetLocalClusterID(clusterID1)
+		})
+
+		It("should fall back to the other cluster once the limit is reached", func() {
+			Expect(selectN(5)).To(Equal(map[string]int{clusterID1: 2, clusterID2: 3}))
+
+			t.resolver.ReleaseDNSRecord(namespace1, service1, clusterID1)
+
+			Expect(t.getNonHeadlessDNSRecord(namespace1, service1, "").ClusterName).To(Equal(clusterID1))
+		})
+	})
+
+	When("all clusters reach their limits", func() {
+		BeforeEach(func() {
+			t.clusterStatus.DisconnectClusterID(clusterID2)
+		})
+
+		It("should return no DNS record", func() {
+			selectN(2)
+			t.assertDNSRecordsFound(namespace1, service1, "", "", false)
+		})
+	})
+
+	When("selections are acquired concurrently", func() {
+		It("should not exceed the limit", func() {
+			var (
+				mutex  sync.Mutex
+				wg     sync.WaitGroup
+				counts = map[string]int{}
+			)
+
+			for i := 0; i < 20; i++ {
+				wg.Add(1)
+
+				go func() {
+					defer GinkgoRecover()
+					defer wg.Done()
+
+					records, _, _ := t.resolver.GetDNSRecords(namespace1, service1, "", "")
+
+					mutex.Lock()
+					defer mutex.Unlock()
+
+					for i := range records {
+						counts[records[i].ClusterName]++
+					}
+				}()
+			}
+
+			wg.Wait()
+
+			Expect(counts[clusterID1]).To(BeNumerically("<=", 2))
+		})
+	})
+})
+
+var _ = Describe("In-flight limits not enabled", func() {
+	t := newTestDriver()
+
+	BeforeEach(func() {
+		serviceImport := newAggregatedServiceImport(namespace1, service1)
+		serviceImport.Annotations = map[string]string{
+			constants.MaxInFlightAnnotationPrefix + "/" + clusterID1: "1",
+		}
+
+		t.resolver.PutServiceImport(serviceImport)
+
+		t.putEndpointSlice(newClusterIPEndpointSlice(namespace1, service1, clusterID1, serviceIP1, true, port1))
+		t.putEndpointSlice(newClusterIPEndpointSlice(namespace1, service1, clusterID2, serviceIP2, true, port1))
+	})
+
+	It("should ignore the max-in-flight annotations", func() {
+		t.assertSelectionShares(namespace1, service1, 10, map[string]float64{clusterID1: 0.5, clusterID2: 0.5})
+	})
+})
+
+var _ = Describe("Recency penalty", func() {
+	const decay = time.Second
+
+	fakeClock := testingclock.NewFakeClock(time.Now())
+	t := newTestDriver(resolver.WithClock(fakeClock), resolver.WithRecencyPenalty(1, decay))
+
+	BeforeEach(func() {
+		serviceImport := newAggregatedServiceImport(namespace1, service1)
+		setClusterWeight(serviceImport, clusterID1, 3)
+		setClusterWeight(serviceImport, clusterID2, 1)
+
+		t.resolver.PutServiceImport(serviceImport)
+
+		t.putEndpointSlice(newClusterIPEndpointSlice(namespace1, service1, clusterID1, serviceIP1, true, port1))
+		t.putEndpointSlice(newClusterIPEndpointSlice(namespace1, service1, clusterID2, serviceIP2, true, port1))
+	})
+
+	When("selections are made in quick succession", func() {
+		It("should not select the same cluster back-to-back", func() {
+			previous := ""
+
+			for i := 0; i < 20; i++ {
+				cluster := t.getNonHeadlessDNSRecord(namespace1, service1, "").ClusterName
+				Expect(cluster).ToNot(Equal(previous))
+
+				previous = cluster
+			}
+		})
+	})
+
+	When("the penalty decays between selections", func() {
+		It("should distribute by weight", func() {
+			counts := map[string]int{}
+
+			for i := 0; i < 400; i++ {
+				counts[t.getNonHeadlessDNSRecord(namespace1, service1, "").ClusterName]++
+
+				fakeClock.Step(decay)
+			}
+
+			Expect(counts).To(Equal(map[string]int{clusterID1: 300, clusterID2: 100}))
+		})
+	})
+
+	When("only the recently selected cluster is available", func() {
+		BeforeEach(func() {
+			t.clusterStatus.DisconnectClusterID(clusterID2)
+		})
+
+		It("should still select it", func() {
+			for i := 0; i < 5; i++ {
+				Expect(t.getNonHeadlessDNSRecord(namespace1, service1, "").ClusterName).To(Equal(clusterID1))
+			}
+		})
+	})
+})
+
+var _ = Describe("Local cluster override", func() {
+	t := newTestDriver()
+
+	BeforeEach(func() {
+		t.clusterStatus.SetLocalClusterID(clusterID1)
+
+		t.resolver.PutServiceImport(newAggregatedServiceImport(namespace1, service1))
+
+		t.putEndpointSlice(newClusterIPEndpointSlice(namespace1, service1, clusterID1, serviceIP1, true, port1))
+		t.putEndpointSlice(newClusterIPEndpointSlice(namespace1, service1, clusterID2, serviceIP2, true, port1))
+	})
+
+	When("no override is set", func() {
+		It("should return and prefer the ClusterStatus local cluster", func() {
+			Expect(t.resolver.LocalClusterID()).To(Equal(clusterID1))
+
+			for i := 0; i < 5; i++ {
+				Expect(t.getNonHeadlessDNSRecord(namespace1, service1, "").IP).To(Equal(serviceIP1))
+			}
+		})
+	})
+
+	When("an override is set", func() {
+		BeforeEach(func() {
+			t.resolver.SetLocalClusterID(clusterID2)
+		})
+
+		It("should return and prefer the overridden local cluster", func() {
+			Expect(t.resolver.LocalClusterID()).To(Equal(clusterID2))
+
+			for i := 0; i < 5; i++ {
+				Expect(t.getNonHeadlessDNSRecord(namespace1, service1, "").IP).To(Equal(serviceIP2))
+			}
+		})
+
+		Context("and then cleared", func() {
+			BeforeEach(func() {
+				t.resolver.SetLocalClusterID("")
+			})
+
+			It("should revert to the ClusterStatus local cluster", func() {
+				Expect(t.resolver.LocalClusterID()).To(Equal(clusterID1))
+				Expect(t.getNonHeadlessDNSRecord(namespace1, service1, "").IP).To(Equal(serviceIP1))
+			})
+		})
+	})
+})
+
+var _ = Describe("Local cluster preference", func() {
+	t := newTestDriver()
+
+	var serviceImport *mcsv1a1.ServiceImport
+
+	BeforeEach(func() {
+		t.clusterStatus.SetLocalClusterID(clusterID1)
+
+		serviceImport = newAggregatedServiceImport(namespace1, service1)
+	})
+
+	JustBeforeEach(func() {
+		t.resolver.PutServiceImport(serviceImport)
+
+		t.putEndpointSlice(newClusterIPEndpointSlice(namespace1, service1, clusterID1, serviceIP1, true, port1))
+		t.putEndpointSlice(newClusterIPEndpointSlice(namespace1, service1, clusterID2, serviceIP2, true, port1))
+	})
+
+	When("the annotation isn't specified", func() {
+		It("should prefer the healthy local cluster", func() {
+			for i := 0; i < 5; i++ {
+				Expect(t.getNonHeadlessDNSRecord(namespace1, service1, "").IP).To(Equal(serviceIP1))
+			}
+		})
+	})
+
+	When("the annotation is true", func() {
+		BeforeEach(func() {
+			serviceImport.Annotations = map[string]string{constants.PreferLocalAnnotation: "true"}
+		})
+
+		It("should prefer the healthy local cluster", func() {
+			for i := 0; i < 5; i++ {
+				Expect(t.getNonHeadlessDNSRecord(namespace1, service1, "").IP).To(Equal(serviceIP1))
+			}
+
+			Expect(t.resolver.SelectionProbabilities(namespace1, service1, nil)).To(Equal(map[string]float64{clusterID1: 1}))
+		})
+	})
+
+	When("the annotation is false", func() {
+		BeforeEach(func() {
+			serviceImport.Annotations = map[string]string{constants.PreferLocalAnnotation: "false"}
+			setClusterWeight(serviceImport, clusterID2, 2)
+		})
+
+		It("should load balance across the local cluster as just another weighted cluster", func() {
+			t.assertSelectionShares(namespace1, service1, 300, map[string]float64{
+				clusterID1: 1.0 / 3,
+				clusterID2: 2.0 / 3,
+			})
+
+			Expect(t.resolver.SelectionProbabilities(namespace1, service1, nil)).To(Equal(map[string]float64{
+				clusterID1: 1.0 / 3,
+				clusterID2: 2.0 / 3,
+			}))
+
+			record, _ := t.resolver.GetBestDNSRecord(namespace1, service1)
+			Expect(record.IP).To(Equal(serviceIP2))
+		})
+
+		Context("and then removed", func() {
+			JustBeforeEach(func() {
+				t.resolver.PutServiceImport(newAggregatedServiceImport(namespace1, service1))
+			})
+
+			It("should prefer the local cluster again", func() {
+				Expect(t.getNonHeadlessDNSRecord(namespace1, service1, "").IP).To(Equal(serviceIP1))
+			})
+		})
+	})
+
+	When("the annotation is invalid", func() {
+		BeforeEach(func() {
+			serviceImport.Annotations = map[string]string{constants.PreferLocalAnnotation: "bogus"}
+		})
+
+		It("should prefer the local cluster", func() {
+			Expect(t.getNonHeadlessDNSRecord(namespace1, service1, "").IP).To(Equal(serviceIP1))
+		})
+	})
+})
+
+var _ = Describe("Local weight multiplier", func() {
+	testShares := func(t *testDriver, expected map[string]float64) {
+		BeforeEach(func() {
+			t.clusterStatus.SetLocalClusterID(clusterID1)
+
+			serviceImport := newAggregatedServiceImport(namespace1, service1)
+			serviceImport.Annotations = map[string]string{constants.PreferLocalAnnotation: "false"}
+			t.resolver.PutServiceImport(serviceImport)
+
+			t.putEndpointSlice(newClusterIPEndpointSlice(namespace1, service1, clusterID1, serviceIP1, true, port1))
+			t.putEndpointSlice(newClusterIPEndpointSlice(namespace1, service1, clusterID2, serviceIP2, true, port1))
+		})
+
+		It("should scale the local cluster's share accordingly", func() {
+			t.assertSelectionShares(namespace1, service1, 400, expected)
+		})
+	}
+
+	When("a multiplier is configured", func() {
+		testShares(newTestDriver(resolver.WithLocalWeightMultiplier(3)), map[string]float64{
+			clusterID1: 0.75,
+			clusterID2: 0.25,
+		})
+	})
+
+	When("a multiplier isn't configured", func() {
+		testShares(newTestDriver(), map[string]float64{
+			clusterID1: 0.5,
+			clusterID2: 0.5,
+		})
+	})
+})
+
+var _ = Describe("No healthy cluster policy", func() {
+	t := newTestDriver()
+
+	var serviceImport *mcsv1a1.ServiceImport
+
+	failAll := func(_, _ string, _ []string) map[string]bool {
+		return map[string]bool{}
+	}
+
+	BeforeEach(func() {
+		serviceImport = newAggregatedServiceImport(namespace1, service1)
+		setClusterWeight(serviceImport, clusterID2, 3)
+	})
+
+	JustBeforeEach(func() {
+		t.resolver.PutServiceImport(serviceImport)
+
+		t.putEndpointSlice(newClusterIPEndpointSlice(namespace1, service1, clusterID1, serviceIP1, true, port1))
+		t.putEndpointSlice(newClusterIPEndpointSlice(namespace1, service1, clusterID2, serviceIP2, true, port1))
+		t.putEndpointSlice(newClusterIPEndpointSlice(namespace1, service1, clusterID3, serviceIP3, true, port1))
+	})
+
+	When("the policy is serve-last", func() {
+		BeforeEach(func() {
+			serviceImport.Annotations[constants.OnNoHealthyAnnotation] = constants.OnNoHealthyServeLast
+		})
+
+		Context("and every cluster fails the endpoint check", func() {
+			It("should return the record of the highest weight cluster", func() {
+				for i := 0; i < 3; i++ {
+					records, _, found := t.resolver.GetDNSRecordsCheckingEndpoints(namespace1, service1, "", "", failAll)
+					Expect(found).To(BeTrue())
+					Expect(records).To(HaveLen(1))
+					Expect(records[0].IP).To(Equal(serviceIP2))
+				}
+			})
+		})
+
+		Context("and every cluster's endpoints are unhealthy", func() {
+			JustBeforeEach(func() {
+				t.putEndpointSlice(newClusterIPEndpointSlice(namespace1, service1, clusterID1, serviceIP1, false, port1))
+				t.putEndpointSlice(newClusterIPEndpointSlice(namespace1, service1, clusterID2, serviceIP2, false, port1))
+				t.putEndpointSlice(newClusterIPEndpointSlice(namespace1, service1, clusterID3, serviceIP3, false, port1))
+			})
+
+			It("should resolve the highest weight cluster as a last resort", func() {
+				resolution, found := t.resolver.Resolve(namespace1, service1, "")
+				Expect(found).To(BeTrue())
+				Expect(resolution.Cluster).To(Equal(clusterID2))
+				Expect(resolution.Reason).To(Equal(resolver.ResolvedLastResort))
+			})
+		})
+
+		Context("and the highest weight cluster is disconnected", func() {
+			JustBeforeEach(func() {
+				t.clusterStatus.DisconnectClusterID(clusterID2)
+			})
+
+			It("should return the record of the next highest weight cluster by name", func() {
+				records, _, _ := t.resolver.GetDNSRecordsCheckingEndpoints(namespace1, service1, "", "", failAll)
+				Expect(records).To(HaveLen(1))
+				Expect(records[0].IP).To(Equal(serviceIP1))
+			})
+		})
+	})
+
+	When("the policy is return-empty", func() {
+		BeforeEach(func() {
+			serviceImport.Annotations[constants.OnNoHealthyAnnotation] = constants.OnNoHealthyReturnEmpty
+		})
+
+		It("should return no record if every cluster fails the endpoint check", func() {
+			records, _, found := t.resolver.GetDNSRecordsCheckingEndpoints(namespace1, service1, "", "", failAll)
+			Expect(found).To(BeTrue())
+			Expect(records).To(BeEmpty())
+		})
+	})
+
+	When("the policy isn't specified", func() {
+		It("should return no record if every cluster fails the endpoint check", func() {
+			records, _, found := t.resolver.GetDNSRecordsCheckingEndpoints(namespace1, service1, "", "", failAll)
+			Expect(found).To(BeTrue())
+			Expect(records).To(BeEmpty())
+		})
+	})
+
+	When("the policy is invalid", func() {
+		BeforeEach(func() {
+			serviceImport.Annotations[constants.OnNoHealthyAnnotation] = "bogus"
+		})
+
+		It("should return no record if every cluster fails the endpoint check", func() {
+			records, _, _ := t.resolver.GetDNSRecordsCheckingEndpoints(namespace1, service1, "", "", failAll)
+			Expect(records).To(BeEmpty())
+		})
+	})
+})
+
+var _ = Describe("All drained policy", func() {
+	t := newTestDriver()
+
+	var serviceImport *mcsv1a1.ServiceImport
+
+	BeforeEach(func() {
+		serviceImport = newAggregatedServiceImport(namespace1, service1)
+		setClusterWeight(serviceImport, clusterID2, 3)
+	})
+
+	JustBeforeEach(func() {
+		t.resolver.PutServiceImport(serviceImport)
+
+		t.putEndpointSlice(newClusterIPEndpointSlice(namespace1, service1, clusterID1, serviceIP1, true, port1))
+		t.putEndpointSlice(newClusterIPEndpointSlice(namespace1, service1, clusterID2, serviceIP2, true, port1))
+		t.putEndpointSlice(newClusterIPEndpointSlice(namespace1, service1, clusterID3, serviceIP3, true, port1))
+
+		// Shifting all traffic to a cluster that's then disconnected drains the others.
+		Expect(t.resolver.SetTrafficShift(clusterID3, 100)).To(Succeed())
+		t.clusterStatus.DisconnectClusterID(clusterID3)
+	})
+
+	AfterEach(func() {
+		Expect(t.resolver.SetTrafficShift(clusterID3, 0)).To(Succeed())
+	})
+
+	assertDrainedSelected := func() {
+		for i := 0; i < 5; i++ {
+			Expect(t.getNonHeadlessDNSRecord(namespace1, service1, "").IP).To(BeElementOf(serviceIP1, serviceIP2))
+		}
+	}
+
+	When("the policy is serve-least-drained", func() {
+		BeforeEach(func() {
+			serviceImport.Annotations[constants.OnAllDrainedAnnotation] = constants.OnAllDrainedServeLeastDrained
+		})
+
+		It("should resolve the drained cluster with the highest weight", func() {
+			for i := 0; i < 3; i++ {
+				resolution, found := t.resolver.Resolve(namespace1, service1, "")
+				Expect(found).To(BeTrue())
+				Expect(resolution.Cluster).To(Equal(clusterID2))
+				Expect(resolution.Reason).To(Equal(resolver.ResolvedLeastDrained))
+			}
+		})
+
+		Context("and the highest weight drained cluster is unhealthy", func() {
+			JustBeforeEach(func() {
+				t.putEndpointSlice(newClusterIPEndpointSlice(namespace1, service1, clusterID2, serviceIP2, false, port1))
+			})
+
+			It("should return the record of the remaining drained cluster", func() {
+				Expect(t.getNonHeadlessDNSRecord(namespace1, service1, "").IP).To(Equal(serviceIP1))
+			})
+		})
+
+		Context("and a cluster isn't drained", func() {
+			JustBeforeEach(func() {
+				t.clusterStatus.ConnectClusterID(clusterID3)
+			})
+
+			It("should select it via the load balancer", func() {
+				resolution, _ := t.resolver.Resolve(namespace1, service1, "")
+				Expect(resolution.Cluster).To(Equal(clusterID3))
+				Expect(resolution.Reason).To(Equal(resolver.ResolvedBalanced))
+			})
+		})
+	})
+
+	When("the policy is return-empty", func() {
+		BeforeEach(func() {
+			serviceImport.Annotations[constants.OnAllDrainedAnnotation] = constants.OnAllDrainedReturnEmpty
+		})
+
+		It("should return no record", func() {
+			records, _, found := t.resolver.GetDNSRecords(namespace1, service1, "", "")
+			Expect(found).To(BeTrue())
+			Expect(records).To(BeEmpty())
+		})
+
+		It("should still return the record of a requested cluster", func() {
+			Expect(t.getNonHeadlessDNSRecord(namespace1, service1, clusterID1).IP).To(Equal(serviceIP1))
+		})
+	})
+
+	When("the policy is ignore-drain", func() {
+		BeforeEach(func() {
+			serviceImport.Annotations[constants.OnAllDrainedAnnotation] = constants.OnAllDrainedIgnoreDrain
+		})
+
+		It("should select among the drained clusters", func() {
+			assertDrainedSelected()
+		})
+	})
+
+	When("the policy isn't specified", func() {
+		It("should select among the drained clusters", func() {
+			assertDrainedSelected()
+		})
+	})
+
+	When("the policy is invalid", func() {
+		BeforeEach(func() {
+			serviceImport.Annotations[constants.OnAllDrainedAnnotation] = "bogus"
+		})
+
+		It("should select among the drained clusters", func() {
+			assertDrainedSelected()
+		})
+	})
+})
+
+var _ = Describe("Global traffic shift", func() {
+	const service2 = "service2"
+
+	t := newTestDriver()
+
+	BeforeEach(func() {
+		for _, name := range []string{service1, service2} {
+			t.resolver.PutServiceImport(newAggregatedServiceImport(namespace1, name))
+
+			t.putEndpointSlice(newClusterIPEndpointSlice(namespace1, name, clusterID1, serviceIP1, true, port1))
+			t.putEndpointSlice(newClusterIPEndpointSlice(namespace1, name, clusterID2, serviceIP2, true, port1))
+			t.putEndpointSlice(newClusterIPEndpointSlice(namespace1, name, clusterID3, serviceIP3, true, port1))
+		}
+	})
+
+	When("a shift is set", func() {
+		BeforeEach(func() {
+			Expect(t.resolver.SetTrafficShift(clusterID3, 50)).To(Succeed())
+		})
+
+		It("should bias the selection of all services toward the target cluster", func() {
+			for _, name := range []string{service1, service2} {
+				t.assertSelectionShares(namespace1, name, 1000, map[string]float64{
+					clusterID1: 0.25,
+					clusterID2: 0.25,
+					clusterID3: 0.5,
+				})
+			}
+		})
+
+		It("should apply on top of the per-service weights", func() {
+			serviceImport := newAggregatedServiceImport(namespace1, service1)
+			setClusterWeight(serviceImport, clusterID1, 3)
+			setClusterWeight(serviceImport, clusterID2, 1)
+			setClusterWeight(serviceImport, clusterID3, 1)
+			t.resolver.PutServiceImport(serviceImport)
+
+			t.assertSelectionShares(namespace1, service1, 1000, map[string]float64{
+				clusterID1: 0.375,
+				clusterID2: 0.125,
+				clusterID3: 0.5,
+			})
+		})
+
+		It("should apply to subsequently added services", func() {
+			t.resolver.PutServiceImport(newAggregatedServiceImport(namespace2, service1))
+
+			t.putEndpointSlice(newClusterIPEndpointSlice(namespace2, service1, clusterID1, serviceIP1, true, port1))
+			t.putEndpointSlice(newClusterIPEndpointSlice(namespace2, service1, clusterID3, serviceIP3, true, port1))
+
+			t.assertSelectionShares(namespace2, service1, 1000, map[string]float64{
+				clusterID1: 0.5,
+				clusterID3: 0.5,
+			})
+		})
+
+		Context("and then set to zero", func() {
+			BeforeEach(func() {
+				Expect(t.resolver.SetTrafficShift(clusterID3, 0)).To(Succeed())
+			})
+
+			It("should no longer bias the selection", func() {
+				t.testRoundRobin(namespace1, service1, serviceIP1, serviceIP2, serviceIP3)
+			})
+		})
+	})
+
+	When("the target cluster doesn't back a service", func() {
+		BeforeEach(func() {
+			Expect(t.resolver.SetTrafficShift("other", 80)).To(Succeed())
+		})
+
+		It("should not affect its selection", func() {
+			t.testRoundRobin(namespace1, service1, serviceIP1, serviceIP2, serviceIP3)
+		})
+	})
+
+	When("the percentage is out of range", func() {
+		It("should return an error", func() {
+			Expect(t.resolver.SetTrafficShift(clusterID3, 101)).ToNot(Succeed())
+			Expect(t.resolver.SetTrafficShift(clusterID3, -1)).ToNot(Succeed())
+		})
+	})
+})
+
+var _ = Describe("Recency boost", func() {
+	const idlePeriod = time.Minute
+
+	fakeClock := testingclock.NewFakeClock(time.Now())
+	t := newTestDriver(resolver.WithClock(fakeClock), resolver.WithRecencyBoost(idlePeriod))
+
+	BeforeEach(func() {
+		serviceImport := newAggregatedServiceImport(namespace1, service1)
+		setClusterWeight(serviceImport, clusterID1, 100)
+		setClusterWeight(serviceImport, clusterID2, 1)
+
+		t.resolver.PutServiceImport(serviceImport)
+
+		t.putEndpointSlice(newClusterIPEndpointSlice(namespace1, service1, clusterID1, serviceIP1, true, port1))
+		t.putEndpointSlice(newClusterIPEndpointSlice(namespace1, service1, clusterID2, serviceIP2, true, port1))
+	})
+
+	When("no cluster has been idle for the idle period", func() {
+		It("should select by weight", func() {
+			for i := 0; i < 10; i++ {
+				Expect(t.getNonHeadlessDNSRecord(namespace1, service1, "").ClusterName).To(Equal(clusterID1))
+			}
+		})
+	})
+
+	When("a cluster has been idle for longer than the idle period", func() {
+		BeforeEach(func() {
+			t.getNonHeadlessDNSRecord(namespace1, service1, "")
+			fakeClock.Step(idlePeriod / 2)
+			t.getNonHeadlessDNSRecord(namespace1, service1, "")
+			fakeClock.Step(idlePeriod)
+		})
+
+		It("should boost it once and then select by weight", func() {
+			Expect(t.getNonHeadlessDNSRecord(namespace1, service1, "").ClusterName).To(Equal(clusterID2))
+
+			for i := 0; i < 10; i++ {
+				Expect(t.getNonHeadlessDNSRecord(namespace1, service1, "").ClusterName).To(Equal(clusterID1))
+			}
+		})
+
+		Context("but is unhealthy", func() {
+			BeforeEach(func() {
+				t.putEndpointSlice(newClusterIPEndpointSlice(namespace1, service1, clusterID2, serviceIP2, false, port1))
+			})
+
+			It("should not boost it", func() {
+				Expect(t.getNonHeadlessDNSRecord(namespace1, service1, "").ClusterName).To(Equal(clusterID1))
+			})
+		})
+
+		Context("but is drained by a traffic shift", func() {
+			BeforeEach(func() {
+				Expect(t.resolver.SetTrafficShift(clusterID1, 100)).To(Succeed())
+			})
+
+			AfterEach(func() {
+				Expect(t.resolver.SetTrafficShift(clusterID1, 0)).To(Succeed())
+			})
+
+			It("should not boost it", func() {
+				Expect(t.getNonHeadlessDNSRecord(namespace1, service1, "").ClusterName).To(Equal(clusterID1))
+			})
+		})
+	})
+})
+
+var _ = Describe("Load balancing policy", func() {
+	t := newTestDriver()
+
+	var serviceImport *mcsv1a1.ServiceImport
+
+	BeforeEach(func() {
+		serviceImport = newAggregatedServiceImport(namespace1, service1)
+		serviceImport.Annotations = map[string]string{constants.LoadBalancerPolicyAnnotation: constants.LoadBalancerPolicyRoundRobin}
+		setClusterWeight(serviceImport, clusterID1, 5)
+		setClusterWeight(serviceImport, clusterID2, 1)
+		setClusterWeight(serviceImport, clusterID3, 3)
+	})
+
+	JustBeforeEach(func() {
+		t.resolver.PutServiceImport(serviceImport)
+
+		t.putEndpointSlice(newClusterIPEndpointSlice(namespace1, service1, clusterID1, serviceIP1, true, port1))
+		t.putEndpointSlice(newClusterIPEndpointSlice(namespace1, service1, clusterID2, serviceIP2, true, port1))
+		t.putEndpointSlice(newClusterIPEndpointSlice(namespace1, service1, clusterID3, serviceIP3, true, port1))
+	})
+
+	When("round robin is specified", func() {
+		It("should rotate through the clusters regardless of their weights", func() {
+			t.testRoundRobin(namespace1, service1, serviceIP1, serviceIP2, serviceIP3)
+		})
+
+		It("should be retained by a snapshot", func() {
+			data, err := t.resolver.MarshalBinary()
+			Expect(err).To(Succeed())
+
+			restored := resolver.New(t.clusterStatus, nil)
+			Expect(restored.UnmarshalBinary(data)).To(Succeed())
+
+			selected := map[string]int{}
+
+			for i := 0; i < 9; i++ {
+				records, _, _ := restored.GetDNSRecords(namespace1, service1, "", "")
+				Expect(records).To(HaveLen(1))
+				selected[records[0].ClusterName]++
+			}
+
+			Expect(selected).To(Equal(map[string]int{clusterID1: 3, clusterID2: 3, clusterID3: 3}))
+		})
+
+		Context("and then removed", func() {
+			JustBeforeEach(func() {
+				delete(serviceImport.Annotations, constants.LoadBalancerPolicyAnnotation)
+				t.resolver.PutServiceImport(serviceImport)
+			})
+
+			It("should load balance per the weights", func() {
+				t.assertSelectionShares(namespace1, service1, 900, map[string]float64{
+					clusterID1: 5.0 / 9,
+					clusterID2: 1.0 / 9,
+					clusterID3: 3.0 / 9,
+				})
+			})
+		})
+	})
+
+	When("random is specified", func() {
+		BeforeEach(func() {
+			serviceImport.Annotations[constants.LoadBalancerPolicyAnnotation] = constants.LoadBalancerPolicyRandom
+		})
+
+		It("should select the clusters at random per their weights", func() {
+			t.assertSelectionShares(namespace1, service1, 10000, map[string]float64{
+				clusterID1: 5.0 / 9,
+				clusterID2: 1.0 / 9,
+				clusterID3: 3.0 / 9,
+			})
+		})
+
+		Context("and a cluster isn't connected", func() {
+			JustBeforeEach(func() {
+				t.clusterStatus.DisconnectClusterID(clusterID1)
+			})
+
+			It("should select among the other clusters", func() {
+				t.assertSelectionShares(namespace1, service1, 10000, map[string]float64{
+					clusterID1: 0,
+					clusterID2: 0.25,
+					clusterID3: 0.75,
+				})
+			})
+		})
+	})
+
+	When("consistent hash is specified", func() {
+		BeforeEach(func() {
+			serviceImport.Annotations[constants.LoadBalancerPolicyAnnotation] = constants.LoadBalancerPolicyConsistentHash
+		})
+
+		clientIP := func(n int) string {
+			return fmt.Sprintf("192.168.0.%d", n)
+		}
+
+		selectFor := func(ip string) string {
+			records, _, found := t.resolver.GetDNSRecordsForClient(namespace1, service1, "", "", ip)
+			Expect(found).To(BeTrue())
+			Expect(records).To(HaveLen(1))
+
+			return records[0].ClusterName
+		}
+
+		selectForClients := func() map[string]string {
+			selected := map[string]string{}
+			for n := 1; n <= 50; n++ {
+				selected[clientIP(n)] = selectFor(clientIP(n))
+			}
+
+			return selected
+		}
+
+		It("should consistently select the same cluster for a client", func() {
+			expected := selectFor(clientIP(1))
+
+			for i := 0; i < 10; i++ {
+				Expect(selectFor(clientIP(1))).To(Equal(expected))
+			}
+		})
+
+		It("should spread the clients across the clusters", func() {
+			clusters := map[string]bool{}
+			for _, cluster := range selectForClients() {
+				clusters[cluster] = true
+			}
+
+			Expect(clusters).To(HaveLen(3))
+		})
+
+		It("should load balance per the weights without a client IP", func() {
+			t.assertSelectionShares(namespace1, service1, 900, map[string]float64{
+				clusterID1: 5.0 / 9,
+				clusterID2: 1.0 / 9,
+				clusterID3: 3.0 / 9,
+			})
+		})
+
+		Context("and a client's cluster isn't connected", func() {
+			It("should only move the clients of that cluster until it's reconnected", func() {
+				before := selectForClients()
+
+				t.clusterStatus.DisconnectClusterID(clusterID1)
+
+				for ip, cluster := range selectForClients() {
+					Expect(cluster).ToNot(Equal(clusterID1))
+
+					if before[ip] != clusterID1 {
+						Expect(cluster).To(Equal(before[ip]), "Client %q was moved", ip)
+					}
+				}
+
+				t.clusterStatus.ConnectClusterID(clusterID1)
+
+				Expect(selectForClients()).To(Equal(before))
+			})
+		})
+	})
+
+	When("Maglev is specified", func() {
+		BeforeEach(func() {
+			serviceImport.Annotations[constants.LoadBalancerPolicyAnnotation] = constants.LoadBalancerPolicyMaglev
+		})
+
+		selectFor := func(ip string) string {
+			records, _, found := t.resolver.GetDNSRecordsForClient(namespace1, service1, "", "", ip)
+			Expect(found).To(BeTrue())
+			Expect(records).To(HaveLen(1))
+
+			return records[0].ClusterName
+		}
+
+		It("should consistently select the same cluster for a client", func() {
+			expected := selectFor("192.168.0.1")
+
+			for i := 0; i < 10; i++ {
+				Expect(selectFor("192.168.0.1")).To(Equal(expected))
+			}
+		})
+
+		It("should spread the clients across the clusters per their weights", func() {
+			counts := map[string]int{}
+			for n := 0; n < 900; n++ {
+				counts[selectFor(fmt.Sprintf("192.168.%d.%d", n/256, n%256))]++
+			}
+
+			Expect(float64(counts[clusterID1]) / 900).To(BeNumerically("~", 5.0/9, 0.06))
+			Expect(float64(counts[clusterID2]) / 900).To(BeNumerically("~", 1.0/9, 0.06))
+			Expect(float64(counts[clusterID3]) / 900).To(BeNumerically("~", 3.0/9, 0.06))
+		})
+	})
+
+	When("a custom registered policy is specified", func() {
+		var created int
+
+		BeforeEach(func() {
+			created = 0
+
+			loadbalancer.Register("lightest", func() loadbalancer.Interface {
+				created++
+				return &lightestBalancer{
+					Interface: loadbalancer.NewRoundRobin(),
+					weights:   map[interface{}]int64{},
+				}
+			})
+
+			serviceImport.Annotations[constants.LoadBalancerPolicyAnnotation] = "lightest"
+		})
+
+		It("should use a load balancer instantiated from the registered factory", func() {
+			Expect(created).To(Equal(1))
+			Expect(t.getNonHeadlessDNSRecord(namespace1, service1, "").IP).To(Equal(serviceIP2))
+			Expect(t.getNonHeadlessDNSRecord(namespace1, service1, "").IP).To(Equal(serviceIP2))
+		})
+
+		Context("and the ServiceImport is updated with the same policy", func() {
+			It("should retain the load balancer", func() {
+				t.resolver.PutServiceImport(serviceImport)
+				Expect(created).To(Equal(1))
+			})
+		})
+	})
+
+	When("the specified policy is invalid", func() {
+		BeforeEach(func() {
+			serviceImport.Annotations[constants.LoadBalancerPolicyAnnotation] = "bogus"
+		})
+
+		It("should load balance per the weights", func() {
+			t.assertSelectionShares(namespace1, service1, 900, map[string]float64{
+				clusterID1: 5.0 / 9,
+				clusterID2: 1.0 / 9,
+				clusterID3: 3.0 / 9,
+			})
+		})
+	})
+})
+
+var _ = Describe("Cluster name normalization", func() {
+	When("enabled", func() {
+		t := newTestDriver(resolver.WithClusterNameNormalization())
+
+		BeforeEach(func() {
+			serviceImport := newAggregatedServiceImport(namespace1, service1)
+			setClusterWeight(serviceImport, "Cluster1", 3)
+			setClusterWeight(serviceImport, clusterID2, 1)
+			t.resolver.PutServiceImport(serviceImport)
+
+			t.putEndpointSlice(newClusterIPEndpointSlice(namespace1, service1, "CLUSTER1", serviceIP1, true, port1))
+			t.putEndpointSlice(newClusterIPEndpointSlice(namespace1, service1, " Cluster2 ", serviceIP2, true, port1))
+		})
+
+		It("should align the records with the weight annotations that differ by case", func() {
+			records, _, found := t.resolver.GetDNSRecords(namespace1, service1, "", "")
+			Expect(found).To(BeTrue())
+			Expect(records).To(HaveLen(1))
+			Expect(records[0].ClusterName).To(Or(Equal(clusterID1), Equal(clusterID2)))
+
+			t.assertSelectionShares(namespace1, service1, 1000, map[string]float64{
+				clusterID1: 0.75,
+				clusterID2: 0.25,
+			})
+		})
+
+		It("should resolve a requested cluster regardless of case", func() {
+			t.assertDNSRecordsFound(namespace1, service1, "Cluster2", "", false, resolver.DNSRecord{
+				IP:          serviceIP2,
+				IPs:         []string{serviceIP2},
+				Ports:       []mcsv1a1.ServicePort{port1},
+				HostName:    clusterHostName(clusterID2, namespace1, service1),
+				ClusterName: clusterID2,
+			})
+		})
+
+		It("should apply a traffic shift to a target cluster that differs by case", func() {
+			Expect(t.resolver.SetTrafficShift(" CLUSTER1 ", 50)).To(Succeed())
+
+			t.assertSelectionShares(namespace1, service1, 1000, map[string]float64{
+				clusterID1: 0.5,
+				clusterID2: 0.5,
+			})
+		})
+
+		It("should key the records of names that differ by case to the same cluster", func() {
+			t.putEndpointSlice(newClusterIPEndpointSlice(namespace1, service1, clusterID1, serviceIP3, true, port1))
+
+			Expect(t.resolver.ClustersByWeight(namespace1, service1)).To(HaveLen(2))
+			t.assertDNSRecordsFound(namespace1, service1, clusterID1, "", false, resolver.DNSRecord{
+				IP:          serviceIP3,
+				IPs:         []string{serviceIP3},
+				Ports:       []mcsv1a1.ServicePort{port1},
+				HostName:    clusterHostName(clusterID1, namespace1, service1),
+				ClusterName: clusterID1,
+			})
+		})
+	})
+
+	When("disabled", func() {
+		t := newTestDriver()
+
+		BeforeEach(func() {
+			t.resolver.PutServiceImport(newAggregatedServiceImport(namespace1, service1))
+			t.putEndpointSlice(newClusterIPEndpointSlice(namespace1, service1, "CLUSTER1", serviceIP1, true, port1))
+		})
+
+		It("should match cluster names exactly", func() {
+			t.assertDNSRecordsNotFound(namespace1, service1, clusterID1, "")
+		})
+	})
+})
+
+// lightestBalancer always selects the item with the lowest weight, while delegating the rest to the round robin load
+// balancer.
+type lightestBalancer struct {
+	loadbalancer.Interface
+	weights map[interface{}]int64
+}
+
+func (b *lightestBalancer) Add(item interface{}, weight int64) error {
+	b.weights[item] = weight
+	return b.Interface.Add(item, weight)
+}
+
+func (b *lightestBalancer) Update(item interface{}, weight int64) error {
+	b.weights[item] = weight
+	return b.Interface.(loadbalancer.Updater).Update(item, weight)
+}
+
+func (b *lightestBalancer) Next() interface{} {
+	var lightest interface{}
+
+	for item, weight := range b.weights {
+		if lightest == nil || weight < b.weights[lightest] {
+			lightest = item
+		}
+	}
+
+	return lightest
+}
+
+func (b *lightestBalancer) RemoveAll() {
+	b.weights = map[interface{}]int64{}
+	b.Interface.RemoveAll()
+}
+
+// flakyBalancer fails adding an item a given number of times, with the given error if set, before delegating to the
+// smooth weighted round robin load balancer.
+type flakyBalancer struct {
+	loadbalancer.Interface
+	failures map[interface{}]int
+	attempts map[interface{}]int
+	err      error
+}
+
+func (b *flakyBalancer) Add(item interface{}, weight int64) error {
+	b.attempts[item]++
+
+	if b.failures[item] > 0 {
+		b.failures[item]--
+
+		if b.err != nil {
+			return b.err
+		}
+
+		return errors.New("fake Add error")
+	}
+
+	return b.Interface.Add(item, weight)
+}
+
+var _ = Describe("Balancer add retry", func() {
+	var balancer *flakyBalancer
+
+	newBalancer := func() loadbalancer.Interface {
+		balancer = &flakyBalancer{
+			Interface: loadbalancer.NewSmoothWeightedRR(),
+			failures:  map[interface{}]int{},
+			attempts:  map[interface{}]int{},
+		}
+
+		return balancer
+	}
+
+	putService := func(t *testDriver) {
+		t.resolver.PutServiceImport(newAggregatedServiceImport(namespace1, service1))
+		t.putEndpointSlice(newClusterIPEndpointSlice(namespace1, service1, clusterID1, serviceIP1, true, port1))
+
+		balancer.failures[clusterID2] = 2
+		balancer.attempts = map[interface{}]int{}
+
+		t.putEndpointSlice(newClusterIPEndpointSlice(namespace1, service1, clusterID2, serviceIP2, true, port1))
+	}
+
+	When("retry is configured", func() {
+		t := newTestDriver(resolver.WithBalancer(newBalancer), resolver.WithBalancerAddRetry(wait.Backoff{
+			Steps:    3,
+			Duration: time.Millisecond,
+			Factor:   2,
+		}))
+
+		BeforeEach(func() {
+			putService(t)
+
+			Eventually(func() []string {
+				return t.resolver.UnbalancedClusters(namespace1, service1)
+			}).Should(BeEmpty())
+		})
+
+		It("should retry adding a cluster until it succeeds", func() {
+			Expect(balancer.attempts[clusterID2]).To(Equal(3))
+			Expect(balancer.attempts[clusterID1]).To(Equal(1))
+			Expect(t.resolver.UnbalancedClusters(namespace1, service1)).To(BeEmpty())
+
+			t.testRoundRobin(namespace1, service1, serviceIP1, serviceIP2)
+		})
+
+		Context("and adding keeps failing", func() {
+			BeforeEach(func() {
+				balancer.failures[clusterID2] = 5
+
+				// Re-adding the cluster resets the load balancer, unlike updating its EndpointSlice.
+				t.resolver.RemoveEndpointSlice(newClusterIPEndpointSlice(namespace1, service1, clusterID2, serviceIP2, true))
+				t.putEndpointSlice(newClusterIPEndpointSlice(namespace1, service1, clusterID2, serviceIP2, true, port1))
+			})
+
+			It("should give up and report the cluster", func() {
+				Consistently(func() []string {
+					return t.resolver.UnbalancedClusters(namespace1, service1)
+				}, 100*time.Millisecond).Should(Equal([]string{clusterID2}))
+
+				for i := 0; i < 5; i++ {
+					t.assertDNSRecordsFound(namespace1, service1, "", "", false, resolver.DNSRecord{
+						IP:          serviceIP1,
+						IPs:         []string{serviceIP1},
+						Ports:       []mcsv1a1.ServicePort{port1},
+						HostName:    clusterHostName(clusterID1, namespace1, service1),
+						ClusterName: clusterID1,
+					})
+				}
+			})
+		})
+
+		Context("and the error is permanent", func() {
+			BeforeEach(func() {
+				balancer.failures[clusterID2] = 1
+				balancer.err = loadbalancer.ErrDuplicateItem
+				t.resolver.RemoveEndpointSlice(newClusterIPEndpointSlice(namespace1, service1, clusterID2, serviceIP2, true))
+				t.putEndpointSlice(newClusterIPEndpointSlice(namespace1, service1, clusterID2, serviceIP2, true, port1))
+			})
+
+			It("should not retry adding the cluster", func() {
+				Consistently(func() []string {
+					return t.resolver.UnbalancedClusters(namespace1, service1)
+				}, 100*time.Millisecond).Should(Equal([]string{clusterID2}))
+			})
+		})
+	})
+
+	When("retry isn't configured", func() {
+		t := newTestDriver(resolver.WithBalancer(newBalancer))
+
+		BeforeEach(func() {
+			putService(t)
+		})
+
+		It("should try adding a cluster once", func() {
+			Expect(balancer.attempts[clusterID2]).To(Equal(1))
+			Expect(t.resolver.UnbalancedClusters(namespace1, service1)).To(Equal([]string{clusterID2}))
+		})
+	})
+})
+
+var _ = Describe("Selection subset", func() {
+	const rounds = 10000
+
+	putService := func(t *testDriver, weights map[string]int64) {
+		serviceImport := newAggregatedServiceImport(namespace1, service1)
+		for cluster, weight := range weights {
+			setClusterWeight(serviceImport, cluster, weight)
+		}
+
+		t.resolver.PutServiceImport(serviceImport)
+
+		t.putEndpointSlice(newClusterIPEndpointSlice(namespace1, service1, clusterID1, serviceIP1, true, port1))
+		t.putEndpointSlice(newClusterIPEndpointSlice(namespace1, service1, clusterID2, serviceIP2, true, port1))
+		t.putEndpointSlice(newClusterIPEndpointSlice(namespace1, service1, clusterID3, serviceIP3, true, port1))
+	}
+
+	When("k is 1", func() {
+		t := newTestDriver(resolver.WithSelectionSubset(1))
+
+		BeforeEach(func() {
+			putService(t, map[string]int64{clusterID1: 98, clusterID2: 1, clusterID3: 1})
+		})
+
+		It("should select the single sampled cluster regardless of the weights", func() {
+			t.assertSelectionShares(namespace1, service1, rounds, map[string]float64{
+				clusterID1: 1.0 / 3,
+				clusterID2: 1.0 / 3,
+				clusterID3: 1.0 / 3,
+			})
+		})
+	})
+
+	When("k is less than the number of clusters", func() {
+		t := newTestDriver(resolver.WithSelectionSubset(2))
+
+		BeforeEach(func() {
+			putService(t, map[string]int64{clusterID1: 98, clusterID2: 1, clusterID3: 1})
+		})
+
+		It("should select by weight within the sampled subset", func() {
+			// clusterID1 is sampled in two of the three possible subsets where it's selected 98 out of 99 times.
+			t.assertSelectionShares(namespace1, service1, rounds, map[string]float64{
+				clusterID1: 2.0 / 3 * 98 / 99,
+				clusterID2: 1.0/3*1/99 + 1.0/3*0.5,
+				clusterID3: 1.0/3*1/99 + 1.0/3*0.5,
+			})
+		})
+
+		Context("and a cluster isn't connected", func() {
+			BeforeEach(func() {
+				t.clusterStatus.DisconnectClusterID(clusterID1)
+			})
+
+			It("should sample only the connected clusters", func() {
+				t.assertSelectionShares(namespace1, service1, rounds, map[string]float64{
+					clusterID1: 0,
+					clusterID2: 0.5,
+					clusterID3: 0.5,
+				})
+			})
+		})
+	})
+
+	When("k is at least the number of clusters", func() {
+		t := newTestDriver(resolver.WithSelectionSubset(5))
+
+		BeforeEach(func() {
+			putService(t, map[string]int64{clusterID1: 2, clusterID2: 1, clusterID3: 1})
+		})
+
+		It("should select in proportion to the weights", func() {
+			t.assertSelectionShares(namespace1, service1, rounds, map[string]float64{
+				clusterID1: 0.5,
+				clusterID2: 0.25,
+				clusterID3: 0.25,
+			})
+		})
+	})
+
+	When("a cluster has zero weight", func() {
+		t := newTestDriver(resolver.WithSelectionSubset(1))
+
+		BeforeEach(func() {
+			putService(t, map[string]int64{clusterID1: 0, clusterID2: 1, clusterID3: 1})
+		})
+
+		It("should sample it with its weight coerced to 1", func() {
+			t.assertSelectionShares(namespace1, service1, rounds, map[string]float64{
+				clusterID1: 1.0 / 3,
+				clusterID2: 1.0 / 3,
+				clusterID3: 1.0 / 3,
+			})
+		})
+	})
+})
+
+var _ = Describe("Pin", func() {
+	t := newTestDriver()
+
+	BeforeEach(func() {
+		t.resolver.PutServiceImport(newAggregatedServiceImport(namespace1, service1))
+		t.putEndpointSlice(newClusterIPEndpointSlice(namespace1, service1, clusterID1, serviceIP1, true, port1))
+		t.putEndpointSlice(newClusterIPEndpointSlice(namespace1, service1, clusterID2, serviceIP2, true, port1))
+		t.putEndpointSlice(newClusterIPEndpointSlice(namespace1, service1, clusterID3, serviceIP3, true, port1))
+	})
+
+	pinnedRecord := resolver.DNSRecord{
+		IP:          serviceIP2,
+		IPs:         []string{serviceIP2},
+		Ports:       []mcsv1a1.ServicePort{port1},
+		HostName:    clusterHostName(clusterID2, namespace1, service1),
+		ClusterName: clusterID2,
+	}
+
+	When("a service is pinned to a cluster", func() {
+		BeforeEach(func() {
+			t.resolver.Pin(namespace1, service1, clusterID2)
+		})
+
+		It("should always return the pinned cluster's record", func() {
+			for i := 0; i < 5; i++ {
+				t.assertDNSRecordsFound(namespace1, service1, "", "", false, pinnedRecord)
+			}
+		})
+
+		It("should return the pinned cluster's record regardless of the weights", func() {
+			serviceImport := newAggregatedServiceImport(namespace1, service1)
+			setClusterWeight(serviceImport, clusterID2, 0)
+			t.resolver.PutServiceImport(serviceImport)
+
+			t.assertDNSRecordsFound(namespace1, service1, "", "", false, pinnedRecord)
+		})
+
+		It("should return the pinned cluster's record regardless of the health", func() {
+			t.clusterStatus.DisconnectClusterID(clusterID2)
+			t.putEndpointSlice(newClusterIPEndpointSlice(namespace1, service1, clusterID2, serviceIP2, false, port1))
+
+			t.assertDNSRecordsFound(namespace1, service1, "", "", false, pinnedRecord)
+		})
+
+		It("should return the pinned cluster's record over the local cluster's", func() {
+			t.clusterStatus.SetLocalClusterID(clusterID1)
+
+			t.assertDNSRecordsFound(namespace1, service1, "", "", false, pinnedRecord)
+		})
+
+		It("should still return a specifically requested cluster's record", func() {
+			t.assertDNSRecordsFound(namespace1, service1, clusterID3, "", false, resolver.DNSRecord{
+				IP:          serviceIP3,
+				IPs:         []string{serviceIP3},
+				Ports:       []mcsv1a1.ServicePort{port1},
+				HostName:    clusterHostName(clusterID3, namespace1, service1),
+				ClusterName: clusterID3,
+			})
+		})
+
+		Context("and then unpinned", func() {
+			BeforeEach(func() {
+				t.resolver.Unpin(namespace1, service1)
+			})
+
+			It("should restore the normal selection", func() {
+				t.testRoundRobin(namespace1, service1, serviceIP1, serviceIP2, serviceIP3)
+			})
+		})
+	})
+
+	When("a service is pinned to a cluster that isn't present", func() {
+		BeforeEach(func() {
+			t.resolver.Pin(namespace1, service1, "other")
+		})
+
+		It("should use the normal selection", func() {
+			t.testRoundRobin(namespace1, service1, serviceIP1, serviceIP2, serviceIP3)
+		})
+	})
+
+	When("the pinned cluster is removed", func() {
+		BeforeEach(func() {
+			t.resolver.Pin(namespace1, service1, clusterID2)
+			t.resolver.RemoveEndpointSlice(newClusterIPEndpointSlice(namespace1, service1, clusterID2, serviceIP2, true, port1))
+		})
+
+		It("should use the normal selection", func() {
+			t.testRoundRobin(namespace1, service1, serviceIP1, serviceIP3)
+		})
+	})
+})
+
+var _ = Describe("Cost-aware selection", func() {
+	t := newTestDriver(resolver.WithCostAwareSelection())
+
+	setClusterCost := func(si *mcsv1a1.ServiceImport, clusterID string, cost string) {
+		if si.Annotations == nil {
+			si.Annotations = map[string]string{}
+		}
+
+		si.Annotations[constants.CostAnnotationPrefix+"/"+clusterID] = cost
+	}
+
+	var serviceImport *mcsv1a1.ServiceImport
+
+	BeforeEach(func() {
+		serviceImport = newAggregatedServiceImport(namespace1, service1)
+		setClusterCost(serviceImport, clusterID1, "10")
+		setClusterCost(serviceImport, clusterID2, "1")
+		setClusterCost(serviceImport, clusterID3, "1")
+	})
+
+	JustBeforeEach(func() {
+		t.resolver.PutServiceImport(serviceImport)
+
+		t.putEndpointSlice(newClusterIPEndpointSlice(namespace1, service1, clusterID1, serviceIP1, true, port1))
+		t.putEndpointSlice(newClusterIPEndpointSlice(namespace1, service1, clusterID2, serviceIP2, true, port1))
+		t.putEndpointSlice(newClusterIPEndpointSlice(namespace1, service1, clusterID3, serviceIP3, true, port1))
+	})
+
+	It("should load balance across the cheapest clusters", func() {
+		t.testRoundRobin(namespace1, service1, serviceIP2, serviceIP3)
+	})
+
+	When("the cheapest clusters have different weights", func() {
+		BeforeEach(func() {
+			setClusterWeight(serviceImport, clusterID2, 3)
+			setClusterWeight(serviceImport, clusterID3, 1)
+		})
+
+		It("should load balance across them per their weights", func() {
+			t.assertSelectionShares(namespace1, service1, 1000, map[string]float64{
+				clusterID1: 0,
+				clusterID2: 0.75,
+				clusterID3: 0.25,
+			})
+		})
+	})
+
+	When("one of the cheapest clusters is unavailable", func() {
+		JustBeforeEach(func() {
+			t.clusterStatus.DisconnectClusterID(clusterID2)
+		})
+
+		It("should use the other cheapest cluster", func() {
+			t.testRoundRobin(namespace1, service1, serviceIP3)
+		})
+	})
+
+	When("all the cheapest clusters are unavailable", func() {
+		JustBeforeEach(func() {
+			t.clusterStatus.DisconnectClusterID(clusterID2)
+			t.putEndpointSlice(newClusterIPEndpointSlice(namespace1, service1, clusterID3, serviceIP3, false, port1))
+		})
+
+		It("should fall back to the expensive cluster", func() {
+			t.testRoundRobin(namespace1, service1, serviceIP1)
+		})
+	})
+
+	When("a cluster has no cost annotation", func() {
+		BeforeEach(func() {
+			delete(serviceImport.Annotations, constants.CostAnnotationPrefix+"/"+clusterID3)
+		})
+
+		It("should be considered the cheapest", func() {
+			t.testRoundRobin(namespace1, service1, serviceIP3)
+		})
+	})
+
+	When("a cost annotation is invalid", func() {
+		BeforeEach(func() {
+			setClusterCost(serviceImport, clusterID1, "bogus")
+		})
+
+		It("should treat the cluster as having zero cost", func() {
+			t.testRoundRobin(namespace1, service1, serviceIP1)
+		})
+	})
+})
+
+var _ = Describe("Cost-aware selection with in-flight limits", func() {
+	fakeClock := testingclock.NewFakeClock(time.Now())
+	t := newTestDriver(resolver.WithClock(fakeClock), resolver.WithCostAwareSelection(), resolver.WithInFlightLimits(time.Minute))
+
+	BeforeEach(func() {
+		serviceImport := newAggregatedServiceImport(namespace1, service1)
+		serviceImport.Annotations = map[string]string{
+			constants.CostAnnotationPrefix + "/" + clusterID1:        "1",
+			constants.CostAnnotationPrefix + "/" + clusterID2:        "10",
+			constants.MaxInFlightAnnotationPrefix + "/" + clusterID1: "2",
+		}
+
+		t.resolver.PutServiceImport(serviceImport)
+
+		t.putEndpointSlice(newClusterIPEndpointSlice(namespace1, service1, clusterID1, serviceIP1, true, port1))
+		t.putEndpointSlice(newClusterIPEndpointSlice(namespace1, service1, clusterID2, serviceIP2, true, port1))
+	})
+
+	When("the cheapest cluster reaches its limit", func() {
+		It("should fall back to the next cost tier", func() {
+			selected := func() string {
+				return t.getNonHeadlessDNSRecord(namespace1, service1, "").ClusterName
+			}
+
+			Expect(selected()).To(Equal(clusterID1))
+			Expect(selected()).To(Equal(clusterID1))
+			Expect(selected()).To(Equal(clusterID2))
+			Expect(selected()).To(Equal(clusterID2))
+
+			t.resolver.ReleaseDNSRecord(namespace1, service1, clusterID1)
+
+			Expect(selected()).To(Equal(clusterID1))
+			Expect(selected()).To(Equal(clusterID2))
+		})
+	})
+})
+
+var _ = Describe("Region-aware selection", func() {
+	t := newTestDriver(resolver.WithLocalRegion("east"))
+
+	var serviceImport *mcsv1a1.ServiceImport
+
+	BeforeEach(func() {
+		serviceImport = newAggregatedServiceImport(namespace1, service1)
+		serviceImport.Annotations = map[string]string{
+			constants.RegionAnnotationPrefix + "/" + clusterID1: "west",
+			constants.RegionAnnotationPrefix + "/" + clusterID2: "east",
+			constants.RegionAnnotationPrefix + "/" + clusterID3: "east",
+		}
+	})
+
+	JustBeforeEach(func() {
+		t.resolver.PutServiceImport(serviceImport)
+
+		t.putEndpointSlice(newClusterIPEndpointSlice(namespace1, service1, clusterID1, serviceIP1, true, port1))
+		t.putEndpointSlice(newClusterIPEndpointSlice(namespace1, service1, clusterID2, serviceIP2, true, port1))
+		t.putEndpointSlice(newClusterIPEndpointSlice(namespace1, service1, clusterID3, serviceIP3, true, port1))
+	})
+
+	It("should load balance across the clusters in the local region", func() {
+		t.testRoundRobin(namespace1, service1, serviceIP2, serviceIP3)
+	})
+
+	When("the clusters in the local region have different weights", func() {
+		BeforeEach(func() {
+			setClusterWeight(serviceImport, clusterID1, 10)
+			setClusterWeight(serviceImport, clusterID2, 3)
+		})
+
+		It("should load balance across them per their weights", func() {
+			t.assertSelectionShares(namespace1, service1, 1000, map[string]float64{
+				clusterID1: 0,
+				clusterID2: 0.75,
+				clusterID3: 0.25,
+			})
+		})
+	})
+
+	When("one of the clusters in the local region is unavailable", func() {
+		JustBeforeEach(func() {
+			t.clusterStatus.DisconnectClusterID(clusterID2)
+		})
+
+		It("should use the other cluster in the local region", func() {
+			t.testRoundRobin(namespace1, service1, serviceIP3)
+		})
+	})
+
+	When("the local region has no healthy endpoints", func() {
+		JustBeforeEach(func() {
+			t.putEndpointSlice(newClusterIPEndpointSlice(namespace1, service1, clusterID2, serviceIP2, false, port1))
+			t.putEndpointSlice(newClusterIPEndpointSlice(namespace1, service1, clusterID3, serviceIP3, false, port1))
+		})
+
+		It("should fall back to the clusters in the other regions", func() {
+			t.testRoundRobin(namespace1, service1, serviceIP1)
+		})
+	})
+
+	When("no cluster is in the local region", func() {
+		BeforeEach(func() {
+			serviceImport.Annotations = map[string]string{
+				constants.RegionAnnotationPrefix + "/" + clusterID1: "west",
+				constants.RegionAnnotationPrefix + "/" + clusterID2: "west",
+			}
+		})
+
+		It("should load balance across all the clusters", func() {
+			t.testRoundRobin(namespace1, service1, serviceIP1, serviceIP2, serviceIP3)
+		})
+	})
+})
+
+var _ = Describe("Ready endpoint counts", func() {
+	t := newTestDriver()
+
+	BeforeEach(func() {
+		t.resolver.PutServiceImport(newAggregatedServiceImport(namespace1, service1))
+
+		t.putEndpointSlice(newClusterIPEndpointSlice(namespace1, service1, clusterID1, serviceIP1, true, port1))
+		t.putEndpointSlice(newClusterIPEndpointSlice(namespace1, service1, clusterID2, serviceIP2, true, port1))
+		t.putEndpointSlice(newClusterIPEndpointSlice(namespace1, service1, clusterID3, serviceIP3, true, port1))
+	})
+
+	When("a cluster's count is set to zero", func() {
+		JustBeforeEach(func() {
+			t.resolver.UpdateEndpointCount(namespace1, service1, clusterID1, 2)
+			t.resolver.UpdateEndpointCount(namespace1, service1, clusterID2, 0)
+		})
+
+		It("should exclude it from selection", func() {
+			t.testRoundRobin(namespace1, service1, serviceIP1, serviceIP3)
+
+			record, found := t.resolver.GetBestDNSRecord(namespace1, service1)
+			Expect(found).To(BeTrue())
+			Expect(record.IP).ToNot(Equal(serviceIP2))
+		})
+
+		It("should still return its record when it's requested", func() {
+			t.assertDNSRecordsFound(namespace1, service1, clusterID2, "", false, resolver.DNSRecord{
+				IP:          serviceIP2,
+				IPs:         []string{serviceIP2},
+				Ports:       []mcsv1a1.ServicePort{port1},
+				HostName:    clusterHostName(clusterID2, namespace1, service1),
+				ClusterName: clusterID2,
+			})
+		})
+
+		Context("and then to non-zero", func() {
+			JustBeforeEach(func() {
+				t.resolver.UpdateEndpointCount(namespace1, service1, clusterID2, 1)
+			})
+
+			It("should include it in selection again", func() {
+				t.testRoundRobin(namespace1, service1, serviceIP1, serviceIP2, serviceIP3)
+			})
+		})
+	})
+
+	When("all the clusters' counts are set to zero", func() {
+		JustBeforeEach(func() {
+			for _, cluster := range []string{clusterID1, clusterID2, clusterID3} {
+				t.resolver.UpdateEndpointCount(namespace1, service1, cluster, 0)
+			}
+		})
+
+		It("should return no DNS records", func() {
+			t.assertDNSRecordsFound(namespace1, service1, "", "", false)
+		})
+	})
+})
+
+var _ = Describe("Capacity-aware weights", func() {
+	t := newTestDriver()
+
+	var serviceImport *mcsv1a1.ServiceImport
+
+	BeforeEach(func() {
+		serviceImport = newAggregatedServiceImport(namespace1, service1)
+		serviceImport.Annotations = map[string]string{constants.WeightByEndpointsAnnotation: "true"}
+	})
+
+	JustBeforeEach(func() {
+		t.resolver.PutServiceImport(serviceImport)
+
+		t.putEndpointSlice(newClusterIPEndpointSlice(namespace1, service1, clusterID1, serviceIP1, true, port1))
+		t.putEndpointSlice(newClusterIPEndpointSlice(namespace1, service1, clusterID2, serviceIP2, true, port1))
+
+		t.resolver.UpdateEndpointCount(namespace1, service1, clusterID1, 3)
+		t.resolver.UpdateEndpointCount(namespace1, service1, clusterID2, 1)
+	})
+
+	It("should distribute in proportion to the clusters' ready endpoints", func() {
+		t.assertSelectionShares(namespace1, service1, 400, map[string]float64{
+			clusterID1: 0.75,
+			clusterID2: 0.25,
+		})
+	})
+
+	When("a cluster's count changes", func() {
+		JustBeforeEach(func() {
+			t.resolver.UpdateEndpointCount(namespace1, service1, clusterID2, 3)
+		})
+
+		It("should recompute the distribution", func() {
+			t.assertSelectionShares(namespace1, service1, 400, map[string]float64{
+				clusterID1: 0.5,
+				clusterID2: 0.5,
+			})
+		})
+	})
+
+	When("a cluster's count isn't known", func() {
+		JustBeforeEach(func() {
+			t.putEndpointSlice(newClusterIPEndpointSlice(namespace1, service1, clusterID3, serviceIP3, true, port1))
+		})
+
+		It("should weight it as a single endpoint", func() {
+			t.assertSelectionShares(namespace1, service1, 500, map[string]float64{
+				clusterID1: 0.6,
+				clusterID2: 0.2,
+				clusterID3: 0.2,
+			})
+		})
+	})
+
+	When("a cluster also has a weight annotation", func() {
+		BeforeEach(func() {
+			setClusterWeight(serviceImport, clusterID2, 3)
+		})
+
+		It("should multiply the weight by the cluster's ready endpoints", func() {
+			t.assertSelectionShares(namespace1, service1, 400, map[string]float64{
+				clusterID1: 0.5,
+				clusterID2: 0.5,
+			})
+		})
+	})
+
+	When("the annotation isn't specified", func() {
+		BeforeEach(func() {
+			serviceImport.Annotations = nil
+		})
+
+		It("should ignore the clusters' ready endpoints", func() {
+			t.assertSelectionShares(namespace1, service1, 400, map[string]float64{
+				clusterID1: 0.5,
+				clusterID2: 0.5,
+			})
+		})
+	})
+})
+
+var _ = Describe("Health decay", func() {
+	t := newTestDriver(resolver.WithHealthDecay(0.5, 1))
+
+	BeforeEach(func() {
+		t.resolver.PutServiceImport(newAggregatedServiceImport(namespace1, service1))
+
+		t.putEndpointSlice(newClusterIPEndpointSlice(namespace1, service1, clusterID1, serviceIP1, true, port1))
+		t.putEndpointSlice(newClusterIPEndpointSlice(namespace1, service1, clusterID2, serviceIP2, true, port1))
+	})
+
+	failChecks := func(n int) {
+		for j := 0; j < n; j++ {
+			t.resolver.ReportEndpointCheck(namespace1, service1, clusterID1, false)
+		}
+	}
+
+	It("should load balance evenly while the checks pass", func() {
+		t.resolver.ReportEndpointCheck(namespace1, service1, clusterID1, true)
+		t.testRoundRobin(namespace1, service1, serviceIP1, serviceIP2)
+	})
+
+	When("a cluster fails consecutive checks", func() {
+		It("should decay its share per failure", func() {
+			failChecks(1)
+			t.assertSelectionShares(namespace1, service1, 300, map[string]float64{
+				clusterID1: 1.0 / 3,
+				clusterID2: 2.0 / 3,
+			})
+
+			failChecks(1)
+			t.assertSelectionShares(namespace1, service1, 500, map[string]float64{
+				clusterID1: 0.2,
+				clusterID2: 0.8,
+			})
+		})
+
+		It("should never drain it", func() {
+			failChecks(20)
+			Expect(t.resolver.SelectionProbabilities(namespace1, service1, nil)[clusterID1]).To(BeNumerically(">", 0))
+		})
+	})
+
+	When("a decayed cluster passes a check", func() {
+		It("should restore its share gradually", func() {
+			failChecks(2)
+
+			t.resolver.ReportEndpointCheck(namespace1, service1, clusterID1, true)
+			t.assertSelectionShares(namespace1, service1, 300, map[string]float64{
+				clusterID1: 1.0 / 3,
+				clusterID2: 2.0 / 3,
+			})
+
+			t.resolver.ReportEndpointCheck(namespace1, service1, clusterID1, true)
+			t.testRoundRobin(namespace1, service1, serviceIP1, serviceIP2)
+		})
+	})
+
+	When("a check fails for an unknown cluster or service", func() {
+		It("should ignore it", func() {
+			t.resolver.ReportEndpointCheck(namespace1, service1, clusterID3, false)
+			t.resolver.ReportEndpointCheck(namespace2, service1, clusterID1, false)
+			t.testRoundRobin(namespace1, service1, serviceIP1, serviceIP2)
+		})
+	})
+})
+
+var _ = Describe("Health decay with full recovery", func() {
+	t := newTestDriver(resolver.WithHealthDecay(0.5, 0))
+
+	BeforeEach(func() {
+		t.resolver.PutServiceImport(newAggregatedServiceImport(namespace1, service1))
+
+		t.putEndpointSlice(newClusterIPEndpointSlice(namespace1, service1, clusterID1, serviceIP1, true, port1))
+		t.putEndpointSlice(newClusterIPEndpointSlice(namespace1, service1, clusterID2, serviceIP2, true, port1))
+	})
+
+	It("should restore a decayed cluster's share on the first passing check", func() {
+		for j := 0; j < 3; j++ {
+			t.resolver.ReportEndpointCheck(namespace1, service1, clusterID1, false)
+		}
+
+		t.resolver.ReportEndpointCheck(namespace1, service1, clusterID1, true)
+		t.testRoundRobin(namespace1, service1, serviceIP1, serviceIP2)
+	})
+})
+
+var _ = Describe("Health decay not enabled", func() {
+	t := newTestDriver()
+
+	BeforeEach(func() {
+		t.resolver.PutServiceImport(newAggregatedServiceImport(namespace1, service1))
+
+		t.putEndpointSlice(newClusterIPEndpointSlice(namespace1, service1, clusterID1, serviceIP1, true, port1))
+		t.putEndpointSlice(newClusterIPEndpointSlice(namespace1, service1, clusterID2, serviceIP2, true, port1))
+	})
+
+	It("should not decay a failing cluster's share", func() {
+		t.resolver.ReportEndpointCheck(namespace1, service1, clusterID1, false)
+		t.testRoundRobin(namespace1, service1, serviceIP1, serviceIP2)
+	})
+})
+
+var _ = Describe("Replica tie-break", func() {
+	const (
+		replicaA = "coredns-a"
+		replicaB = "coredns-b"
+	)
+
+	selectionOrder := func(t *testDriver) []string {
+		order := []string{}
+		for i := 0; i < 3; i++ {
+			order = append(order, t.getNonHeadlessDNSRecord(namespace1, service1, "").ClusterName)
+		}
+
+		return order
+	}
+
+	putService := func(t *testDriver) {
+		t.resolver.PutServiceImport(newAggregatedServiceImport(namespace1, service1))
+		t.putEndpointSlice(newClusterIPEndpointSlice(namespace1, service1, clusterID1, serviceIP1, true, port1))
+		t.putEndpointSlice(newClusterIPEndpointSlice(namespace1, service1, clusterID2, serviceIP2, true, port1))
+		t.putEndpointSlice(newClusterIPEndpointSlice(namespace1, service1, clusterID3, serviceIP3, true, port1))
+	}
+
+	tA := newTestDriver(resolver.WithReplicaTieBreak(replicaA))
+	tB := newTestDriver(resolver.WithReplicaTieBreak(replicaB))
+
+	BeforeEach(func() {
+		putService(tA)
+		putService(tB)
+	})
+
+	It("should give each replica a stable order", func() {
+		for _, t := range []*testDriver{tA, tB} {
+			order := selectionOrder(t)
+			Expect(order).To(ConsistOf(clusterID1, clusterID2, clusterID3))
+
+			// Re-putting an EndpointSlice resets the load balancer.
+			for i := 0; i < 5; i++ {
+				t.putEndpointSlice(newClusterIPEndpointSlice(namespace1, service1, clusterID2, serviceIP2, true, port1))
+				Expect(selectionOrder(t)).To(Equal(order))
+			}
+		}
+	})
+
+	It("should give replicas with different IDs different orders", func() {
+		Expect(selectionOrder(tA)).ToNot(Equal(selectionOrder(tB)))
+	})
+
+	It("should order clusters of higher weight first", func() {
+		serviceImport := newAggregatedServiceImport(namespace1, service1)
+		setClusterWeight(serviceImport, clusterID3, 2)
+		tA.resolver.PutServiceImport(serviceImport)
+
+		Expect(tA.getNonHeadlessDNSRecord(namespace1, service1, "").ClusterName).To(Equal(clusterID3))
+	})
+})
+
+var _ = Describe("Weight annotations", func() {
+	t := newTestDriver()
+
+	var serviceImport *mcsv1a1.ServiceImport
+
+	BeforeEach(func() {
+		serviceImport = newAggregatedServiceImport(namespace1, service1)
+	})
+
+	JustBeforeEach(func() {
+		t.resolver.PutServiceImport(serviceImport)
+
+		t.putEndpointSlice(newClusterIPEndpointSlice(namespace1, service1, clusterID1, serviceIP1, true, port1))
+		t.putEndpointSlice(newClusterIPEndpointSlice(namespace1, service1, clusterID2, serviceIP2, true, port1))
+		t.putEndpointSlice(newClusterIPEndpointSlice(namespace1, service1, clusterID3, serviceIP3, true, port1))
+	})
+
+	When("a numeric weight is specified for a cluster", func() {
+		BeforeEach(func() {
+			setClusterWeight(serviceImport, clusterID1, 5)
+		})
+
+		It("should favor the cluster in proportion to its weight", func() {
+			t.assertSelectionShares(namespace1, service1, 700, map[string]float64{
+				clusterID1: 5.0 / 7,
+				clusterID2: 1.0 / 7,
+				clusterID3: 1.0 / 7,
+			})
+		})
+	})
+
+	When("a weight can't be parsed", func() {
+		BeforeEach(func() {
+			serviceImport.Annotations = map[string]string{constants.LoadBalancerWeightAnnotationPrefix + "/" + clusterID1: "five"}
+		})
+
+		It("should default the cluster's weight to 1", func() {
+			t.testRoundRobin(namespace1, service1, serviceIP1, serviceIP2, serviceIP3)
+		})
+	})
+
+	When("a weight is negative", func() {
+		BeforeEach(func() {
+			setClusterWeight(serviceImport, clusterID1, -5)
+		})
+
+		It("should default the cluster's weight to 1", func() {
+			Expect(t.resolver.UnbalancedClusters(namespace1, service1)).To(BeEmpty())
+			t.testRoundRobin(namespace1, service1, serviceIP1, serviceIP2, serviceIP3)
+		})
+	})
+
+	When("a weight is zero", func() {
+		BeforeEach(func() {
+			setClusterWeight(serviceImport, clusterID1, 0)
+		})
+
+		It("should coerce the cluster's weight to 1", func() {
+			t.testRoundRobin(namespace1, service1, serviceIP1, serviceIP2, serviceIP3)
+		})
+	})
+
+	When("a weight is above the maximum", func() {
+		BeforeEach(func() {
+			setClusterWeight(serviceImport, clusterID1, 5000)
+		})
+
+		It("should clamp the cluster's weight to the maximum", func() {
+			Expect(t.resolver.WeightShares(namespace1, service1)).To(Equal(map[string]float64{
+				clusterID1: 100 * 1000.0 / 1002,
+				clusterID2: 100 * 1.0 / 1002,
+				clusterID3: 100 * 1.0 / 1002,
+			}))
+		})
+	})
+})
+
+var _ = Describe("Maximum weight", func() {
+	t := newTestDriver(resolver.WithMaxWeight(10))
+
+	BeforeEach(func() {
+		serviceImport := newAggregatedServiceImport(namespace1, service1)
+		setClusterWeight(serviceImport, clusterID1, 1000)
+		setClusterWeight(serviceImport, clusterID2, -2)
+		setClusterWeight(serviceImport, clusterID3, 5)
+		t.resolver.PutServiceImport(serviceImport)
+
+		t.putEndpointSlice(newClusterIPEndpointSlice(namespace1, service1, clusterID1, serviceIP1, true, port1))
+		t.putEndpointSlice(newClusterIPEndpointSlice(namespace1, service1, clusterID2, serviceIP2, true, port1))
+		t.putEndpointSlice(newClusterIPEndpointSlice(namespace1, service1, clusterID3, serviceIP3, true, port1))
+	})
+
+	It("should distribute by the clamped weights", func() {
+		clusters, found := t.resolver.ListClusters(namespace1, service1)
+		Expect(found).To(BeTrue())
+		Expect(clusters).To(Equal([]resolver.ClusterInfo{
+			{Cluster: clusterID1, Weight: 10},
+			{Cluster: clusterID2, Weight: 1},
+			{Cluster: clusterID3, Weight: 5},
+		}))
+
+		t.assertSelectionShares(namespace1, service1, 1600, map[string]float64{
+			clusterID1: 10.0 / 16,
+			clusterID2: 1.0 / 16,
+			clusterID3: 5.0 / 16,
+		})
+	})
+
+	It("should return each cluster's percentage share of the total weight", func() {
+		Expect(t.resolver.WeightShares(namespace1, service1)).To(Equal(map[string]float64{
+			clusterID1: 100 * 10.0 / 16,
+			clusterID2: 100 * 1.0 / 16,
+			clusterID3: 100 * 5.0 / 16,
+		}))
+	})
+
+	When("the service doesn't exist", func() {
+		It("should return no shares", func() {
+			Expect(t.resolver.WeightShares(namespace2, service1)).To(BeNil())
+		})
+	})
+})
+
+var _ = Describe("Effective weights", func() {
+	t := newTestDriver(resolver.WithMaxWeight(4), resolver.WithLocalWeightMultiplier(2))
+
+	BeforeEach(func() {
+		t.clusterStatus.SetLocalClusterID(clusterID1)
+
+		serviceImport := newAggregatedServiceImport(namespace1, service1)
+		serviceImport.Annotations = map[string]string{
+			constants.PreferLocalAnnotation:       "false",
+			constants.WeightByEndpointsAnnotation: "true",
+		}
+
+		setClusterWeight(serviceImport, clusterID1, 3)
+		setClusterWeight(serviceImport, clusterID2, 5)
+		t.resolver.PutServiceImport(serviceImport)
+
+		t.putEndpointSlice(newClusterIPEndpointSlice(namespace1, service1, clusterID1, serviceIP1, true, port1))
+		t.putEndpointSlice(newClusterIPEndpointSlice(namespace1, service1, clusterID2, serviceIP2, true, port1))
+		t.putEndpointSlice(newClusterIPEndpointSlice(namespace1, service1, clusterID3, serviceIP3, true, port1))
+
+		t.resolver.UpdateEndpointCount(namespace1, service1, clusterID1, 2)
+		t.resolver.UpdateEndpointCount(namespace1, service1, clusterID2, 3)
+	})
+
+	It("should return the weights after clamping, the local bias and the endpoint scaling", func() {
+		weights, found := t.resolver.EffectiveWeights(namespace1, service1)
+		Expect(found).To(BeTrue())
+		Expect(weights).To(Equal(map[string]int64{
+			clusterID1: 3 * 2 * 2,
+			clusterID2: 4 * 3,
+			clusterID3: 1,
+		}))
+	})
+
+	When("a cluster's endpoint count changes", func() {
+		It("should return its updated weight", func() {
+			t.resolver.UpdateEndpointCount(namespace1, service1, clusterID2, 1)
+
+			weights, _ := t.resolver.EffectiveWeights(namespace1, service1)
+			Expect(weights).To(HaveKeyWithValue(clusterID2, int64(4)))
+		})
+	})
+
+	When("the service is headless", func() {
+		It("should return false", func() {
+			t.resolver.PutServiceImport(newHeadlessAggregatedServiceImport(namespace2, service1))
+
+			_, found := t.resolver.EffectiveWeights(namespace2, service1)
+			Expect(found).To(BeFalse())
+		})
+	})
+
+	When("the service doesn't exist", func() {
+		It("should return false", func() {
+			_, found := t.resolver.EffectiveWeights(namespace1, "unknown")
+			Expect(found).To(BeFalse())
+		})
+	})
+})
+
+var _ = Describe("Cluster exclusion", func() {
+	t := newTestDriver()
+
+	BeforeEach(func() {
+		serviceImport := newAggregatedServiceImport(namespace1, service1)
+		setClusterWeight(serviceImport, clusterID1, 5)
+		t.resolver.PutServiceImport(serviceImport)
+
+		t.putEndpointSlice(newClusterIPEndpointSlice(namespace1, service1, clusterID1, serviceIP1, true, port1))
+		t.putEndpointSlice(newClusterIPEndpointSlice(namespace1, service1, clusterID2, serviceIP2, true, port1))
+		t.putEndpointSlice(newClusterIPEndpointSlice(namespace1, service1, clusterID3, serviceIP3, true, port1))
+	})
+
+	selectExcluding := func(exclude map[string]bool) string {
+		records, _, found := t.resolver.GetDNSRecordsExcluding(namespace1, service1, "", "", exclude)
+		Expect(found).To(BeTrue())
+		Expect(records).To(HaveLen(1))
+
+		return records[0].ClusterName
+	}
+
+	When("the highest-weight cluster is excluded", func() {
+		It("should rotate only among the remaining clusters", func() {
+			counts := map[string]int{}
+			for i := 0; i < 100; i++ {
+				counts[selectExcluding(map[string]bool{clusterID1: true})]++
+			}
+
+			Expect(counts).To(HaveLen(2))
+			Expect(counts[clusterID2]).To(BeNumerically("~", 50, 5))
+			Expect(counts[clusterID3]).To(BeNumerically("~", 50, 5))
+		})
+	})
+
+	When("every cluster is excluded", func() {
+		It("should return no record", func() {
+			records, _, found := t.resolver.GetDNSRecordsExcluding(namespace1, service1, "", "",
+				map[string]bool{clusterID1: true, clusterID2: true, clusterID3: true})
+			Expect(found).To(BeTrue())
+			Expect(records).To(BeEmpty())
+		})
+	})
+
+	When("no cluster is excluded", func() {
+		It("should consider every cluster", func() {
+			counts := map[string]int{}
+			for i := 0; i < 70; i++ {
+				counts[selectExcluding(nil)]++
+			}
+
+			Expect(counts).To(Equal(map[string]int{clusterID1: 50, clusterID2: 10, clusterID3: 10}))
+		})
+	})
+})
+
+var _ = Describe("Random seed", func() {
+	const seed = 42
+
+	newSeededDriver := func(policy string) *testDriver {
+		t := newTestDriver(resolver.WithRandomSeed(seed))
+
+		BeforeEach(func() {
+			serviceImport := newAggregatedServiceImport(namespace1, service1)
+			if policy != "" {
+				serviceImport.Annotations = map[string]string{constants.LoadBalancerPolicyAnnotation: policy}
+			}
+
+			setClusterWeight(serviceImport, clusterID1, 5)
+			setClusterWeight(serviceImport, clusterID2, 1)
+			setClusterWeight(serviceImport, clusterID3, 5)
+			t.resolver.PutServiceImport(serviceImport)
+
+			t.putEndpointSlice(newClusterIPEndpointSlice(namespace1, service1, clusterID1, serviceIP1, true, port1))
+			t.putEndpointSlice(newClusterIPEndpointSlice(namespace1, service1, clusterID2, serviceIP2, true, port1))
+			t.putEndpointSlice(newClusterIPEndpointSlice(namespace1, service1, clusterID3, serviceIP3, true, port1))
+		})
+
+		return t
+	}
+
+	selectionsFrom := func(t *testDriver, n int) []string {
+		clusters := make([]string, n)
+		for j := range clusters {
+			clusters[j] = t.getNonHeadlessDNSRecord(namespace1, service1, "").ClusterName
+		}
+
+		return clusters
+	}
+
+	When("the default policy is used", func() {
+		t := newSeededDriver("")
+
+		It("should return the exact expected cluster sequence", func() {
+			Expect(selectionsFrom(t, 11)).To(Equal([]string{
+				clusterID1, clusterID3, clusterID1, clusterID3, clusterID2, clusterID1,
+				clusterID3, clusterID1, clusterID3, clusterID1, clusterID3,
+			}))
+		})
+	})
+
+	When("the random policy is used", func() {
+		t := newSeededDriver(constants.LoadBalancerPolicyRandom)
+
+		It("should return the exact expected cluster sequence", func() {
+			Expect(selectionsFrom(t, 11)).To(Equal([]string{
+				clusterID2, clusterID2, clusterID3, clusterID3, clusterID3, clusterID3,
+				clusterID1, clusterID3, clusterID3, clusterID1, clusterID3,
+			}))
+		})
+	})
+})
+
+// resetCountingBalancer counts the resets of the smooth weighted round robin load balancer. It doesn't implement
+// loadbalancer.Updater.
+type resetCountingBalancer struct {
+	loadbalancer.Interface
+	resets int
+}
+
+func (b *resetCountingBalancer) RemoveAll() {
+	b.resets++
+	b.Interface.RemoveAll()
+}
+
+// updateCountingBalancer counts the resets and updates of the smooth weighted round robin load balancer.
+type updateCountingBalancer struct {
+	resetCountingBalancer
+	updates int
+}
+
+func (b *updateCountingBalancer) Update(item interface{}, weight int64) error {
+	b.updates++
+	return b.Interface.(loadbalancer.Updater).Update(item, weight)
+}
+
+var _ = Describe("Incremental load balancer updates", func() {
+	var balancer *updateCountingBalancer
+
+	t := newTestDriver(resolver.WithBalancer(func() loadbalancer.Interface {
+		balancer = &updateCountingBalancer{resetCountingBalancer: resetCountingBalancer{Interface: loadbalancer.NewSmoothWeightedRR()}}
+		return balancer
+	}))
+
+	BeforeEach(func() {
+		t.resolver.PutServiceImport(newAggregatedServiceImport(namespace1, service1))
+		t.putEndpointSlice(newClusterIPEndpointSlice(namespace1, service1, clusterID1, serviceIP1, true, port1))
+		t.putEndpointSlice(newClusterIPEndpointSlice(namespace1, service1, clusterID2, serviceIP2, true, port1))
+
+		balancer.resets = 0
+	})
+
+	When("a cluster's EndpointSlice is updated", func() {
+		It("should not reset the selection state", func() {
+			previous := t.getNonHeadlessDNSRecord(namespace1, service1, "").IP
+
+			for i := 0; i < 6; i++ {
+				t.putEndpointSlice(newClusterIPEndpointSlice(namespace1, service1, clusterID1, serviceIP1, true, port1))
+
+				ip := t.getNonHeadlessDNSRecord(namespace1, service1, "").IP
+				Expect(ip).ToNot(Equal(previous))
+
+				previous = ip
+			}
+
+			Expect(balancer.resets).To(BeZero())
+			Expect(balancer.updates).To(BeZero())
+		})
+	})
+
+	When("a cluster's weight is changed", func() {
+		It("should update its weight in place", func() {
+			serviceImport := newAggregatedServiceImport(namespace1, service1)
+			setClusterWeight(serviceImport, clusterID1, 3)
+			t.resolver.PutServiceImport(serviceImport)
+
+			Expect(balancer.resets).To(BeZero())
+			Expect(balancer.updates).ToNot(BeZero())
+
+			t.assertSelectionShares(namespace1, service1, 100, map[string]float64{clusterID1: 0.75, clusterID2: 0.25})
+		})
+	})
+
+	When("a cluster is added", func() {
+		It("should reset the load balancer", func() {
+			t.putEndpointSlice(newClusterIPEndpointSlice(namespace1, service1, clusterID3, serviceIP3, true, port1))
+			Expect(balancer.resets).To(Equal(1))
+
+			t.testRoundRobin(namespace1, service1, serviceIP1, serviceIP2, serviceIP3)
+		})
+	})
+})
+
+var _ = Describe("Load balancer updates without in-place support", func() {
+	var balancer *resetCountingBalancer
+
+	t := newTestDriver(resolver.WithBalancer(func() loadbalancer.Interface {
+		balancer = &resetCountingBalancer{Interface: loadbalancer.NewSmoothWeightedRR()}
+		return balancer
+	}))
+
+	BeforeEach(func() {
+		t.resolver.PutServiceImport(newAggregatedServiceImport(namespace1, service1))
+		t.putEndpointSlice(newClusterIPEndpointSlice(namespace1, service1, clusterID1, serviceIP1, true, port1))
+		t.putEndpointSlice(newClusterIPEndpointSlice(namespace1, service1, clusterID2, serviceIP2, true, port1))
+
+		balancer.resets = 0
+	})
+
+	When("a cluster's EndpointSlice is updated", func() {
+		It("should not reset the load balancer", func() {
+			t.putEndpointSlice(newClusterIPEndpointSlice(namespace1, service1, clusterID1, serviceIP1, true, port1))
+			Expect(balancer.resets).To(BeZero())
+		})
+	})
+
+	When("a cluster's weight is changed", func() {
+		It("should reset the load balancer with the new weights", func() {
+			serviceImport := newAggregatedServiceImport(namespace1, service1)
+			setClusterWeight(serviceImport, clusterID1, 3)
+			t.resolver.PutServiceImport(serviceImport)
+
+			Expect(balancer.resets).To(Equal(1))
+
+			t.assertSelectionShares(namespace1, service1, 100, map[string]float64{clusterID1: 0.75, clusterID2: 0.25})
+		})
+	})
+})
+
+// nextCountingBalancer counts the items yielded by the round robin load balancer.
+type nextCountingBalancer struct {
+	loadbalancer.Interface
+	nexts int
+}
+
+func (b *nextCountingBalancer) Next() interface{} {
+	b.nexts++
+	return b.Interface.Next()
+}
+
+var _ = Describe("Max selection candidates", func() {
+	const (
+		numClusters   = 10
+		maxCandidates = 3
+	)
+
+	var balancer *nextCountingBalancer
+
+	// With a random seed, the clusters are added to the load balancer in name order.
+	t := newTestDriver(resolver.WithMaxSelectionCandidates(maxCandidates), resolver.WithRandomSeed(1),
+		resolver.WithBalancer(func() loadbalancer.Interface {
+			balancer = &nextCountingBalancer{Interface: loadbalancer.NewRoundRobin()}
+			return balancer
+		}))
+
+	clusterName := func(j int) string {
+		return fmt.Sprintf("cluster-%02d", j)
+	}
+
+	putClusters := func(healthy int) {
+		t.resolver.PutServiceImport(newAggregatedServiceImport(namespace1, service1))
+
+		for j := 0; j < numClusters; j++ {
+			t.clusterStatus.ConnectClusterID(clusterName(j))
+			t.putEndpointSlice(newClusterIPEndpointSlice(namespace1, service1, clusterName(j), fmt.Sprintf("10.1.0.%d", j+1),
+				j == healthy, port1))
+		}
+
+		balancer.nexts = 0
+	}
+
+	When("a healthy cluster is within the first candidates", func() {
+		BeforeEach(func() {
+			putClusters(1)
+		})
+
+		It("should select it after examining at most the maximum candidates", func() {
+			records, _, found := t.resolver.GetDNSRecords(namespace1, service1, "", "")
+			Expect(found).To(BeTrue())
+			Expect(records).To(HaveLen(1))
+			Expect(records[0].ClusterName).To(Equal(clusterName(1)))
+			Expect(balancer.nexts).To(BeNumerically("<=", maxCandidates))
+		})
+	})
+
+	When("no healthy cluster is within the first candidates", func() {
+		BeforeEach(func() {
+			putClusters(numClusters - 1)
+		})
+
+		It("should give up after examining the maximum candidates", func() {
+			records, _, found := t.resolver.GetDNSRecords(namespace1, service1, "", "")
+			Expect(found).To(BeTrue())
+			Expect(records).To(BeEmpty())
+			Expect(balancer.nexts).To(Equal(maxCandidates))
+		})
+	})
+})
+
+var _ = Describe("Max selection candidates with weights", func() {
+	t := newTestDriver(resolver.WithMaxSelectionCandidates(2), resolver.WithRandomSeed(1))
+
+	BeforeEach(func() {
+		serviceImport := newAggregatedServiceImport(namespace1, service1)
+		setClusterWeight(serviceImport, clusterID2, 3)
+		t.resolver.PutServiceImport(serviceImport)
+
+		t.putEndpointSlice(newClusterIPEndpointSlice(namespace1, service1, clusterID1, serviceIP1, true, port1))
+		t.putEndpointSlice(newClusterIPEndpointSlice(namespace1, service1, clusterID2, serviceIP2, true, port1))
+		t.putEndpointSlice(newClusterIPEndpointSlice(namespace1, service1, clusterID3, serviceIP3, true, port1))
+	})
+
+	It("should select the clusters in proportion to their weights", func() {
+		t.assertSelectionShares(namespace1, service1, 100, map[string]float64{clusterID1: 0.2, clusterID2: 0.6, clusterID3: 0.2})
+	})
+})
@@ -0,0 +1,112 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+
+Copyright Contributors to the Submariner project.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package metrics
+
+import (
+	"strings"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/submariner-io/lighthouse/coredns/resolver"
+)
+
+const (
+	namespaceLabel = "namespace"
+	serviceLabel   = "service"
+	clusterLabel   = "cluster"
+	reasonLabel    = "reason"
+
+	ClusterSelectionCounterName = "submariner_service_discovery_cluster_selections"
+	ServiceClustersGaugeName    = "submariner_service_discovery_service_clusters"
+)
+
+// ClusterCounter provides the number of clusters backing each service, keyed by "<namespace>/<name>". It's satisfied
+// by the resolver Interface.
+type ClusterCounter interface {
+	ServiceClusterCounts() map[string]int
+}
+
+// Selections is a prometheus Collector of the clusters selected by the resolver for ClusterIP services and of the
+// number of clusters backing each service. It receives the selections as a resolver.ResolutionSink, see
+// resolver.WithResolutionSink, and is only exposed once registered with a prometheus Registerer.
+type Selections struct {
+	selections     *prometheus.CounterVec
+	clusters       *prometheus.Desc
+	mutex          sync.Mutex
+	clusterCounter ClusterCounter
+}
+
+var _ resolver.ResolutionSink = &Selections{}
+
+func NewSelections() *Selections {
+	return &Selections{
+		selections: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: ClusterSelectionCounterName,
+			Help: "Count the clusters selected for ClusterIP service resolutions, by whether the local cluster was served " +
+				"or the selection fell back to the load balancer",
+		}, []string{namespaceLabel, serviceLabel, clusterLabel, reasonLabel}),
+		clusters: prometheus.NewDesc(ServiceClustersGaugeName, "The number of clusters backing each service",
+			[]string{namespaceLabel, serviceLabel}, nil),
+	}
+}
+
+// Track configures the source of the number of clusters backing each service, typically the resolver reporting the
+// selections. Until it's configured, no cluster counts are collected.
+func (s *Selections) Track(counter ClusterCounter) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.clusterCounter = counter
+}
+
+// Report counts the selected cluster, if any, of the given resolution.
+func (s *Selections) Report(outcome resolver.ResolutionOutcome) {
+	if outcome.Cluster == "" {
+		return
+	}
+
+	s.selections.With(prometheus.Labels{
+		namespaceLabel: outcome.Namespace,
+		serviceLabel:   outcome.Name,
+		clusterLabel:   outcome.Cluster,
+		reasonLabel:    string(outcome.Reason),
+	}).Inc()
+}
+
+func (s *Selections) Describe(ch chan<- *prometheus.Desc) {
+	s.selections.Describe(ch)
+	ch <- s.clusters
+}
+
+func (s *Selections) Collect(ch chan<- prometheus.Metric) {
+	s.selections.Collect(ch)
+
+	s.mutex.Lock()
+	counter := s.clusterCounter
+	s.mutex.Unlock()
+
+	if counter == nil {
+		return
+	}
+
+	for key, count := range counter.ServiceClusterCounts() {
+		namespace, name, _ := strings.Cut(key, "/")
+		ch <- prometheus.MustNewConstMetric(s.clusters, prometheus.GaugeValue, float64(count), namespace, name)
+	}
+}
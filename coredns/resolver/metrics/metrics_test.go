@@ -0,0 +1,188 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+
+Copyright Contributors to the Submariner project.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package metrics_test
+
+import (
+	"strconv"
+	"strings"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/submariner-io/lighthouse/coredns/constants"
+	"github.com/submariner-io/lighthouse/coredns/resolver"
+	"github.com/submariner-io/lighthouse/coredns/resolver/fake"
+	"github.com/submariner-io/lighthouse/coredns/resolver/metrics"
+	discovery "k8s.io/api/discovery/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/utils/ptr"
+	mcsv1a1 "sigs.k8s.io/mcs-api/pkg/apis/v1alpha1"
+)
+
+const (
+	namespace  = "namespace1"
+	service    = "service1"
+	clusterID1 = "cluster1"
+	clusterID2 = "cluster2"
+)
+
+var _ = Describe("Selections", func() {
+	var (
+		selections    *metrics.Selections
+		clusterStatus *fake.ClusterStatus
+		r             *resolver.Interface
+	)
+
+	BeforeEach(func() {
+		selections = metrics.NewSelections()
+		clusterStatus = fake.NewClusterStatus("", clusterID1, clusterID2)
+		r = resolver.New(clusterStatus, nil, resolver.WithResolutionSink(selections))
+
+		serviceImport := &mcsv1a1.ServiceImport{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      service,
+				Namespace: namespace,
+				Annotations: map[string]string{
+					constants.LoadBalancerWeightAnnotationPrefix + "/" + clusterID1: "3",
+					constants.LoadBalancerWeightAnnotationPrefix + "/" + clusterID2: "1",
+				},
+			},
+			Spec: mcsv1a1.ServiceImportSpec{Type: mcsv1a1.ClusterSetIP},
+		}
+
+		r.PutServiceImport(serviceImport)
+		Expect(r.PutEndpointSlices(newEndpointSlice(clusterID1, "10.1.1.1", true))).To(BeFalse())
+		Expect(r.PutEndpointSlices(newEndpointSlice(clusterID2, "10.1.1.2", true))).To(BeFalse())
+	})
+
+	resolve := func(n int) {
+		for i := 0; i < n; i++ {
+			_, _, found := r.GetDNSRecords(namespace, service, "", "")
+			Expect(found).To(BeTrue())
+		}
+	}
+
+	selectionCount := func(cluster string, reason resolver.ResolutionReason) func() float64 {
+		return func() float64 {
+			return gatherSelectionCount(selections, cluster, string(reason))
+		}
+	}
+
+	When("the clusters are selected by the load balancer", func() {
+		It("should count the selections per cluster", func() {
+			resolve(8)
+
+			Eventually(selectionCount(clusterID1, resolver.ResolvedBalanced)).Should(Equal(float64(6)))
+			Eventually(selectionCount(clusterID2, resolver.ResolvedBalanced)).Should(Equal(float64(2)))
+			Expect(selectionCount(clusterID1, resolver.ResolvedLocal)()).To(BeZero())
+		})
+	})
+
+	When("the local cluster is selected", func() {
+		BeforeEach(func() {
+			clusterStatus.SetLocalClusterID(clusterID2)
+		})
+
+		It("should count the selections as local", func() {
+			resolve(4)
+
+			Eventually(selectionCount(clusterID2, resolver.ResolvedLocal)).Should(Equal(float64(4)))
+			Expect(selectionCount(clusterID2, resolver.ResolvedBalanced)()).To(BeZero())
+		})
+
+		Context("and then has no healthy endpoints", func() {
+			It("should count the fallback selections as balanced", func() {
+				Expect(r.PutEndpointSlices(newEndpointSlice(clusterID2, "10.1.1.2", false))).To(BeFalse())
+				resolve(4)
+
+				Eventually(selectionCount(clusterID1, resolver.ResolvedBalanced)).Should(Equal(float64(4)))
+				Expect(selectionCount(clusterID2, resolver.ResolvedLocal)()).To(BeZero())
+			})
+		})
+	})
+
+	When("the clusters backing the services are tracked", func() {
+		It("should collect the number per service", func() {
+			Expect(testutil.CollectAndCount(selections, metrics.ServiceClustersGaugeName)).To(BeZero())
+
+			selections.Track(r)
+
+			Expect(testutil.CollectAndCompare(selections, strings.NewReader(`
+# HELP submariner_service_discovery_service_clusters The number of clusters backing each service
+# TYPE submariner_service_discovery_service_clusters gauge
+submariner_service_discovery_service_clusters{namespace="namespace1",service="service1"} 2
+`), metrics.ServiceClustersGaugeName)).To(Succeed())
+		})
+	})
+
+	It("should be registrable", func() {
+		Expect(prometheus.NewRegistry().Register(selections)).To(Succeed())
+	})
+})
+
+func newEndpointSlice(clusterID, ip string, isHealthy bool) *discovery.EndpointSlice {
+	return &discovery.EndpointSlice{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      service + "-" + clusterID,
+			Namespace: namespace,
+			Labels: map[string]string{
+				discovery.LabelManagedBy:        constants.LabelValueManagedBy,
+				constants.LabelSourceNamespace:  namespace,
+				constants.MCSLabelSourceCluster: clusterID,
+				mcsv1a1.LabelServiceName:        service,
+				constants.LabelIsHeadless:       strconv.FormatBool(false),
+			},
+		},
+		AddressType: discovery.AddressTypeIPv4,
+		Endpoints: []discovery.Endpoint{{
+			Addresses:  []string{ip},
+			Conditions: discovery.EndpointConditions{Ready: ptr.To(isHealthy)},
+		}},
+	}
+}
+
+// gatherSelectionCount returns the value of the selection counter with the given cluster and reason labels, or zero if
+// it doesn't exist.
+func gatherSelectionCount(selections *metrics.Selections, cluster, reason string) float64 {
+	registry := prometheus.NewPedanticRegistry()
+	Expect(registry.Register(selections)).To(Succeed())
+
+	families, err := registry.Gather()
+	Expect(err).To(Succeed())
+
+	for _, family := range families {
+		if family.GetName() != metrics.ClusterSelectionCounterName {
+			continue
+		}
+
+		for _, metric := range family.GetMetric() {
+			labels := map[string]string{}
+			for _, label := range metric.GetLabel() {
+				labels[label.GetName()] = label.GetValue()
+			}
+
+			if labels["cluster"] == cluster && labels["reason"] == reason {
+				return metric.GetCounter().GetValue()
+			}
+		}
+	}
+
+	return 0
+}
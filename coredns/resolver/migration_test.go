@@ -22,8 +22,10 @@ import (
 	"context"
 	"fmt"
 
+	"github.com/go-logr/logr"
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
+	"github.com/submariner-io/admiral/pkg/log"
 	"github.com/submariner-io/lighthouse/coredns/constants"
 	"github.com/submariner-io/lighthouse/coredns/resolver"
 	discovery "k8s.io/api/discovery/v1"
@@ -47,13 +49,17 @@ func testClusterIPServiceMigration() {
 
 	cluster1DNSRecord := resolver.DNSRecord{
 		IP:          serviceIP1,
+		IPs:         []string{serviceIP1},
 		Ports:       []mcsv1a1.ServicePort{port1},
+		HostName:    clusterHostName(clusterID1, namespace1, service1),
 		ClusterName: clusterID1,
 	}
 
 	cluster2DNSRecord := resolver.DNSRecord{
 		IP:          serviceIP2,
+		IPs:         []string{serviceIP2},
 		Ports:       []mcsv1a1.ServicePort{port1},
+		HostName:    clusterHostName(clusterID2, namespace1, service1),
 		ClusterName: clusterID2,
 	}
 
@@ -77,6 +83,124 @@ func testClusterIPServiceMigration() {
 			t.awaitDNSRecordsFound(namespace1, service1, clusterID1, "", false, cluster1DNSRecord)
 		})
 
+		Context("and the ServiceImport's source labels conflict with its origin annotations", func() {
+			Specify("should use the annotations and log a warning", func() {
+				sink := &structuredLogSink{}
+				DeferCleanup(resolver.SetLogger(logr.New(sink)))
+
+				serviceImport := newLegacyServiceImport(namespace2, service1, serviceIP2, clusterID2, port1)
+				serviceImport.Labels["lighthouse.submariner.io/sourceName"] = "other-name"
+				serviceImport.Labels[constants.LabelSourceNamespace] = "other-namespace"
+				t.resolver.PutServiceImport(serviceImport)
+
+				var warnings []string
+
+				for _, entry := range sink.get() {
+					if entry[log.WarningKey] == "true" {
+						warnings = append(warnings, entry["msg"].(string))
+					}
+				}
+
+				Expect(warnings).To(ConsistOf(
+					ContainSubstring(`label "lighthouse.submariner.io/sourceName" with value "other-name"`),
+					ContainSubstring(`label "`+constants.LabelSourceNamespace+`" with value "other-namespace"`)))
+
+				t.assertDNSRecordsFound(namespace2, service1, clusterID2, "", false, resolver.DNSRecord{
+					IP:          serviceIP2,
+					IPs:         []string{serviceIP2},
+					Ports:       []mcsv1a1.ServicePort{port1},
+					HostName:    clusterHostName(clusterID2, namespace2, service1),
+					ClusterName: clusterID2,
+				})
+
+				t.assertDNSRecordsNotFound("other-namespace", "other-name", clusterID2, "")
+			})
+		})
+
+		Context("and the ServiceImport's source cluster label conflicts with its origin annotation", func() {
+			Specify("should use the annotation and log a warning", func() {
+				sink := &structuredLogSink{}
+				DeferCleanup(resolver.SetLogger(logr.New(sink)))
+
+				serviceImport := newLegacyServiceImport(namespace2, service1, serviceIP2, clusterID2, port1)
+				serviceImport.Annotations["origin-cluster"] = clusterID2
+				serviceImport.Labels["lighthouse.submariner.io/sourceCluster"] = clusterID3
+				t.resolver.PutServiceImport(serviceImport)
+
+				Expect(sink.get()).To(ContainElement(And(
+					HaveKeyWithValue(log.WarningKey, "true"),
+					HaveKeyWithValue("msg", ContainSubstring(`label "lighthouse.submariner.io/sourceCluster" with value %q`, clusterID3)))))
+
+				t.assertDNSRecordsFound(namespace2, service1, clusterID2, "", false, resolver.DNSRecord{
+					IP:          serviceIP2,
+					IPs:         []string{serviceIP2},
+					Ports:       []mcsv1a1.ServicePort{port1},
+					HostName:    clusterHostName(clusterID2, namespace2, service1),
+					ClusterName: clusterID2,
+				})
+
+				t.assertDNSRecordsNotFound(namespace2, service1, clusterID3, "")
+			})
+		})
+
+		Context("and a ServiceImport has neither a source namespace annotation nor label", func() {
+			Specify("should ignore it and log a warning", func() {
+				sink := &structuredLogSink{}
+				DeferCleanup(resolver.SetLogger(logr.New(sink)))
+
+				serviceImport := newLegacyServiceImport(namespace2, service1, serviceIP2, clusterID2, port1)
+				delete(serviceImport.Annotations, "origin-namespace")
+				delete(serviceImport.Labels, constants.LabelSourceNamespace)
+				t.resolver.PutServiceImport(serviceImport)
+
+				Expect(sink.get()).To(ContainElement(And(
+					HaveKeyWithValue(log.WarningKey, "true"),
+					HaveKeyWithValue("msg", ContainSubstring(serviceImport.Name)))))
+
+				t.assertDNSRecordsNotFound("", service1, clusterID2, "")
+				t.assertDNSRecordsNotFound(namespace2, service1, clusterID2, "")
+
+				t.resolver.RemoveServiceImport(serviceImport)
+				t.awaitDNSRecordsFound(namespace1, service1, clusterID1, "", false, cluster1DNSRecord)
+			})
+		})
+
+		Context("and a ServiceImport has a source namespace label but no annotation", func() {
+			Specify("should use the label", func() {
+				serviceImport := newLegacyServiceImport(namespace2, service1, serviceIP2, clusterID2, port1)
+				delete(serviceImport.Annotations, "origin-namespace")
+				t.resolver.PutServiceImport(serviceImport)
+
+				t.assertDNSRecordsFound(namespace2, service1, clusterID2, "", false, resolver.DNSRecord{
+					IP:          serviceIP2,
+					IPs:         []string{serviceIP2},
+					Ports:       []mcsv1a1.ServicePort{port1},
+					HostName:    clusterHostName(clusterID2, namespace2, service1),
+					ClusterName: clusterID2,
+				})
+			})
+		})
+
+		Context("and the ServiceImport's service IP is changed", func() {
+			Specify("should replace its DNS record", func() {
+				t.awaitDNSRecordsFound(namespace1, service1, clusterID1, "", false, cluster1DNSRecord)
+
+				t.resolver.PutServiceImport(newLegacyServiceImport(namespace1, service1, serviceIP3, clusterID1, port1))
+
+				t.assertDNSRecordsFound(namespace1, service1, clusterID1, "", false, resolver.DNSRecord{
+					IP:          serviceIP3,
+					IPs:         []string{serviceIP3},
+					Ports:       []mcsv1a1.ServicePort{port1},
+					HostName:    clusterHostName(clusterID1, namespace1, service1),
+					ClusterName: clusterID1,
+				})
+
+				Expect(t.resolver.ClustersByWeight(namespace1, service1)).To(Equal([]resolver.ClusterWeight{
+					{Cluster: clusterID1, Weight: 1},
+				}))
+			})
+		})
+
 		Context("that are subsequently deleted", func() {
 			Specify("should remove the DNS records", func() {
 				t.awaitDNSRecords(namespace1, service1, clusterID1, "", true)
@@ -0,0 +1,144 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+
+Copyright Contributors to the Submariner project.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package resolver
+
+import (
+	"sync"
+	"time"
+)
+
+// negativeCache remembers the keys of recently missed services so repeated lookups for them, eg due to a
+// misconfigured client, return without taking the resolver's lock. It's guarded by its own mutex. A nil cache, the
+// default, doesn't remember any keys, see WithNegativeCache.
+type negativeCache struct {
+	mutex    sync.Mutex
+	ttl      time.Duration
+	size     int
+	expiries map[string]time.Time
+}
+
+func newNegativeCache(ttl time.Duration, size int) *negativeCache {
+	return &negativeCache{ttl: ttl, size: size, expiries: make(map[string]time.Time, size)}
+}
+
+// contains returns whether the given key was missed within the TTL.
+func (c *negativeCache) contains(key string, now time.Time) bool {
+	if c == nil {
+		return false
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	expiry, found := c.expiries[key]
+	if found && !now.Before(expiry) {
+		delete(c.expiries, key)
+		return false
+	}
+
+	return found
+}
+
+// add remembers that the given key was missed. If the cache is full, the expired keys are evicted or else the key that
+// expires soonest.
+func (c *negativeCache) add(key string, now time.Time) {
+	if c == nil {
+		return
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if _, found := c.expiries[key]; !found && len(c.expiries) >= c.size {
+		c.evict(now)
+	}
+
+	c.expiries[key] = now.Add(c.ttl)
+}
+
+func (c *negativeCache) evict(now time.Time) {
+	soonest := ""
+
+	for key, expiry := range c.expiries {
+		if !now.Before(expiry) {
+			delete(c.expiries, key)
+		} else if soonest == "" || expiry.Before(c.expiries[soonest]) {
+			soonest = key
+		}
+	}
+
+	if len(c.expiries) >= c.size {
+		delete(c.expiries, soonest)
+	}
+}
+
+// remove forgets the given keys, eg when a service is added with one of them.
+func (c *negativeCache) remove(keys ...string) {
+	if c == nil {
+		return
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	for _, key := range keys {
+		delete(c.expiries, key)
+	}
+}
+
+// clear forgets all keys, eg when the services or aliases are replaced.
+func (c *negativeCache) clear() {
+	if c == nil {
+		return
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.expiries = make(map[string]time.Time, c.size)
+}
+
+// forgetMissed removes the service with the given key, and the aliases resolving to it, from the negative cache. The
+// caller must hold the lock of the service's shard for writing so a concurrent lookup can't cache it as missed again.
+func (i *Interface) forgetMissed(key string) {
+	if i.negativeCache == nil {
+		return
+	}
+
+	keys := []string{key}
+
+	for alias := range i.aliases {
+		if i.resolveAlias(alias) == key {
+			keys = append(keys, alias)
+		}
+	}
+
+	i.negativeCache.remove(keys...)
+}
+
+// isRecentlyMissed returns whether the given service was looked up but didn't exist within the negative cache TTL.
+func (i *Interface) isRecentlyMissed(namespace, name string) bool {
+	return i.negativeCache.contains(keyFunc(namespace, name), i.clock.Now())
+}
+
+// cacheMiss remembers that the given service was looked up but didn't exist. The caller must hold the lock of the
+// service's shard for reading, see forgetMissed.
+func (i *Interface) cacheMiss(namespace, name string) {
+	i.negativeCache.add(keyFunc(namespace, name), i.clock.Now())
+}
@@ -0,0 +1,144 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+
+Copyright Contributors to the Submariner project.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package resolver_test
+
+import (
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/submariner-io/lighthouse/coredns/resolver"
+	testingclock "k8s.io/utils/clock/testing"
+)
+
+var _ = Describe("Negative cache", func() {
+	const (
+		ttl  = 10 * time.Second
+		size = 2
+	)
+
+	fakeClock := testingclock.NewFakeClock(time.Now())
+	t := newTestDriver(resolver.WithClock(fakeClock), resolver.WithNegativeCache(ttl, size))
+
+	lookup := func(namespace, name string) bool {
+		_, _, found := t.resolver.GetDNSRecords(namespace, name, "", "")
+		return found
+	}
+
+	When("a service that doesn't exist is looked up", func() {
+		BeforeEach(func() {
+			Expect(lookup(namespace1, service1)).To(BeFalse())
+		})
+
+		It("should answer subsequent lookups from the cache", func() {
+			Expect(t.resolver.IsRecentlyMissed(namespace1, service1)).To(BeTrue())
+			Expect(lookup(namespace1, service1)).To(BeFalse())
+
+			_, _, status := t.resolver.LookupDNSRecords(namespace1, service1, "", "", "")
+			Expect(status).To(Equal(resolver.LookupNotFound))
+		})
+
+		Context("and the TTL expires", func() {
+			It("should no longer answer from the cache", func() {
+				fakeClock.Step(ttl)
+				Expect(t.resolver.IsRecentlyMissed(namespace1, service1)).To(BeFalse())
+			})
+		})
+
+		Context("and the service is subsequently put", func() {
+			It("should immediately return it", func() {
+				t.resolver.PutServiceImport(newAggregatedServiceImport(namespace1, service1))
+				t.putEndpointSlice(newClusterIPEndpointSlice(namespace1, service1, clusterID1, serviceIP1, true, port1))
+
+				Expect(t.resolver.IsRecentlyMissed(namespace1, service1)).To(BeFalse())
+				Expect(t.getNonHeadlessDNSRecord(namespace1, service1, "").IP).To(Equal(serviceIP1))
+			})
+		})
+
+		Context("and an alias resolving to it is looked up before the service is put", func() {
+			It("should immediately return the service for the alias", func() {
+				Expect(t.resolver.ReplaceAliases(map[string]string{namespace2 + "/alias": namespace1 + "/" + service1})).To(Succeed())
+				Expect(lookup(namespace2, "alias")).To(BeFalse())
+				Expect(t.resolver.IsRecentlyMissed(namespace2, "alias")).To(BeTrue())
+
+				t.resolver.PutServiceImport(newAggregatedServiceImport(namespace1, service1))
+
+				Expect(t.resolver.IsRecentlyMissed(namespace2, "alias")).To(BeFalse())
+				Expect(lookup(namespace2, "alias")).To(BeTrue())
+			})
+		})
+
+		Context("and the aliases are replaced", func() {
+			It("should no longer answer from the cache", func() {
+				Expect(t.resolver.ReplaceAliases(map[string]string{namespace1 + "/" + service1: namespace2 + "/" + service1})).To(Succeed())
+				Expect(t.resolver.IsRecentlyMissed(namespace1, service1)).To(BeFalse())
+			})
+		})
+	})
+
+	When("a service that doesn't exist is looked up via the lookup variants", func() {
+		It("should cache it for all of them", func() {
+			_, _, found := t.resolver.GetDNSRecordsExcluding(namespace1, service1, "", "", nil)
+			Expect(found).To(BeFalse())
+			Expect(t.resolver.IsRecentlyMissed(namespace1, service1)).To(BeTrue())
+
+			_, found = t.resolver.GetAllRecords(namespace1, "other", nil)
+			Expect(found).To(BeFalse())
+			Expect(t.resolver.IsRecentlyMissed(namespace1, "other")).To(BeTrue())
+
+			_, _, found = t.resolver.GetDNSRecordsForPort(namespace1, service1, "", "", port1.Name, port1.Protocol)
+			Expect(found).To(BeFalse())
+
+			_, found = t.resolver.GetDNSRecordPreferred(namespace1, service1, []string{clusterID1}, nil)
+			Expect(found).To(BeFalse())
+		})
+	})
+
+	When("more services than the cache size are missed", func() {
+		It("should evict the service missed the longest ago", func() {
+			lookup(namespace1, "a")
+			fakeClock.Step(time.Second)
+			lookup(namespace1, "b")
+			fakeClock.Step(time.Second)
+			lookup(namespace1, "c")
+
+			Expect(t.resolver.IsRecentlyMissed(namespace1, "a")).To(BeFalse())
+			Expect(t.resolver.IsRecentlyMissed(namespace1, "b")).To(BeTrue())
+			Expect(t.resolver.IsRecentlyMissed(namespace1, "c")).To(BeTrue())
+		})
+	})
+
+	When("a service exists", func() {
+		It("should not cache it", func() {
+			t.resolver.PutServiceImport(newAggregatedServiceImport(namespace1, service1))
+			Expect(lookup(namespace1, service1)).To(BeTrue())
+			Expect(t.resolver.IsRecentlyMissed(namespace1, service1)).To(BeFalse())
+		})
+	})
+})
+
+var _ = Describe("Negative cache when not enabled", func() {
+	t := newTestDriver()
+
+	It("should not cache missed services", func() {
+		_, _, found := t.resolver.GetDNSRecords(namespace1, service1, "", "")
+		Expect(found).To(BeFalse())
+		Expect(t.resolver.IsRecentlyMissed(namespace1, service1)).To(BeFalse())
+	})
+})
@@ -0,0 +1,933 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+
+Copyright Contributors to the Submariner project.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package resolver_test
+
+import (
+	"sync"
+	"time"
+
+	"github.com/go-logr/logr"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/submariner-io/lighthouse/coredns/resolver"
+	discovery "k8s.io/api/discovery/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	testingclock "k8s.io/utils/clock/testing"
+	mcsv1a1 "sigs.k8s.io/mcs-api/pkg/apis/v1alpha1"
+)
+
+type recordingSink struct {
+	mutex    sync.Mutex
+	outcomes []resolver.ResolutionOutcome
+}
+
+func (s *recordingSink) Report(outcome resolver.ResolutionOutcome) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.outcomes = append(s.outcomes, outcome)
+}
+
+func (s *recordingSink) reset() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.outcomes = nil
+}
+
+func (s *recordingSink) get() []resolver.ResolutionOutcome {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return append([]resolver.ResolutionOutcome(nil), s.outcomes...)
+}
+
+var _ = Describe("ResolutionSink", func() {
+	sink := &recordingSink{}
+	t := newTestDriver(resolver.WithResolutionSink(sink))
+
+	BeforeEach(func() {
+		sink.reset()
+
+		t.resolver.PutServiceImport(newAggregatedServiceImport(namespace1, service1))
+		t.putEndpointSlice(newClusterIPEndpointSlice(namespace1, service1, clusterID1, serviceIP1, true, port1))
+		t.putEndpointSlice(newClusterIPEndpointSlice(namespace1, service1, clusterID2, serviceIP2, true, port1))
+	})
+
+	awaitOutcome := func(expCluster string, expReason resolver.ResolutionReason) {
+		Eventually(sink.get).Should(HaveLen(1))
+
+		outcome := sink.get()[0]
+		Expect(outcome.Namespace).To(Equal(namespace1))
+		Expect(outcome.Name).To(Equal(service1))
+		Expect(outcome.Cluster).To(Equal(expCluster))
+		Expect(outcome.Reason).To(Equal(expReason))
+		Expect(outcome.Latency).To(BeNumerically(">", 0))
+	}
+
+	When("a specific cluster is requested", func() {
+		It("should report the requested cluster", func() {
+			t.getNonHeadlessDNSRecord(namespace1, service1, clusterID2)
+			awaitOutcome(clusterID2, resolver.ResolvedClusterPinned)
+		})
+	})
+
+	When("the local cluster is selected", func() {
+		BeforeEach(func() {
+			t.clusterStatus.SetLocalClusterID(clusterID1)
+		})
+
+		It("should report the local cluster", func() {
+			t.getNonHeadlessDNSRecord(namespace1, service1, "")
+			awaitOutcome(clusterID1, resolver.ResolvedLocal)
+		})
+	})
+
+	When("a cluster is selected by the load balancer", func() {
+		It("should report the selected cluster", func() {
+			record := t.getNonHeadlessDNSRecord(namespace1, service1, "")
+			awaitOutcome(record.ClusterName, resolver.ResolvedBalanced)
+		})
+	})
+
+	When("no cluster is available", func() {
+		BeforeEach(func() {
+			t.clusterStatus.DisconnectAll()
+		})
+
+		It("should report no cluster", func() {
+			t.assertDNSRecordsFound(namespace1, service1, "", "", false)
+			awaitOutcome("", resolver.ResolvedNone)
+		})
+	})
+
+	When("multiple resolutions are performed", func() {
+		It("should report each one", func() {
+			for i := 0; i < 5; i++ {
+				t.getNonHeadlessDNSRecord(namespace1, service1, "")
+			}
+
+			Eventually(sink.get).Should(HaveLen(5))
+		})
+	})
+
+	When("resolutions are performed via the other entry points", func() {
+		It("should report each one", func() {
+			t.resolver.GetDualStackDNSRecords(namespace1, service1, "")
+			t.resolver.GetDNSRecordsOfAddressType(namespace1, service1, "", "", discovery.AddressTypeIPv4)
+			t.resolver.GetDNSRecordsMatching(namespace1, service1, "", "", labels.Everything())
+			t.resolver.Resolve(namespace1, service1, "")
+
+			Eventually(sink.get).Should(HaveLen(4))
+		})
+	})
+})
+
+// blockingSink blocks reporting until released.
+type blockingSink struct {
+	recordingSink
+	release chan struct{}
+}
+
+func (s *blockingSink) Report(outcome resolver.ResolutionOutcome) {
+	<-s.release
+	s.recordingSink.Report(outcome)
+}
+
+var _ = Describe("ResolutionSink queue", func() {
+	const resolutions = 3000
+
+	sink := &blockingSink{}
+	t := newTestDriver(resolver.WithResolutionSink(sink))
+
+	BeforeEach(func() {
+		sink.reset()
+		sink.release = make(chan struct{})
+
+		t.resolver.PutServiceImport(newAggregatedServiceImport(namespace1, service1))
+		t.putEndpointSlice(newClusterIPEndpointSlice(namespace1, service1, clusterID1, serviceIP1, true, port1))
+	})
+
+	When("the sink can't keep up", func() {
+		It("should drop and count the outcomes that don't fit in the queue", func() {
+			for i := 0; i < resolutions; i++ {
+				t.getNonHeadlessDNSRecord(namespace1, service1, "")
+			}
+
+			dropped := t.resolver.DroppedResolutions()
+			Expect(dropped).To(BeNumerically(">", 0))
+
+			close(sink.release)
+
+			Eventually(func() int {
+				return len(sink.get())
+			}).Should(Equal(resolutions - int(dropped)))
+		})
+	})
+})
+
+var _ = Describe("Remote-only detection", func() {
+	t := newTestDriver()
+
+	BeforeEach(func() {
+		t.clusterStatus.SetLocalClusterID(clusterID1)
+
+		t.resolver.PutServiceImport(newAggregatedServiceImport(namespace1, service1))
+		t.putEndpointSlice(newClusterIPEndpointSlice(namespace1, service1, clusterID2, serviceIP2, true, port1))
+	})
+
+	When("the service is absent from the local cluster but present in a remote cluster", func() {
+		It("should report it as remote-only and count the remote resolutions", func() {
+			Expect(t.resolver.IsRemoteOnly(namespace1, service1)).To(BeTrue())
+
+			for i := 0; i < 3; i++ {
+				Expect(t.getNonHeadlessDNSRecord(namespace1, service1, "").IP).To(Equal(serviceIP2))
+			}
+
+			Expect(t.resolver.RemoteOnlyResolutions(namespace1, service1)).To(Equal(int64(3)))
+		})
+	})
+
+	When("the service returns to the local cluster", func() {
+		BeforeEach(func() {
+			t.getNonHeadlessDNSRecord(namespace1, service1, "")
+			t.putEndpointSlice(newClusterIPEndpointSlice(namespace1, service1, clusterID1, serviceIP1, true, port1))
+		})
+
+		It("should no longer report it as remote-only nor count further resolutions", func() {
+			Expect(t.resolver.IsRemoteOnly(namespace1, service1)).To(BeFalse())
+			Expect(t.getNonHeadlessDNSRecord(namespace1, service1, "").IP).To(Equal(serviceIP1))
+			Expect(t.resolver.RemoteOnlyResolutions(namespace1, service1)).To(Equal(int64(1)))
+		})
+	})
+
+	When("the local cluster is unknown", func() {
+		BeforeEach(func() {
+			t.clusterStatus.SetLocalClusterID("")
+		})
+
+		It("should not report it as remote-only", func() {
+			Expect(t.resolver.IsRemoteOnly(namespace1, service1)).To(BeFalse())
+			t.getNonHeadlessDNSRecord(namespace1, service1, "")
+			Expect(t.resolver.RemoteOnlyResolutions(namespace1, service1)).To(BeZero())
+		})
+	})
+
+	When("the service doesn't exist", func() {
+		It("should not report it as remote-only", func() {
+			Expect(t.resolver.IsRemoteOnly(namespace2, service1)).To(BeFalse())
+			Expect(t.resolver.RemoteOnlyResolutions(namespace2, service1)).To(BeZero())
+		})
+	})
+})
+
+var _ = Describe("QueryCount", func() {
+	t := newTestDriver()
+
+	BeforeEach(func() {
+		t.resolver.PutServiceImport(newAggregatedServiceImport(namespace1, service1))
+		t.putEndpointSlice(newClusterIPEndpointSlice(namespace1, service1, clusterID1, serviceIP1, true, port1))
+	})
+
+	It("should count every lookup regardless of the outcome", func() {
+		Expect(t.resolver.QueryCount(namespace1, service1)).To(BeZero())
+
+		t.getNonHeadlessDNSRecord(namespace1, service1, "")
+		t.getNonHeadlessDNSRecord(namespace1, service1, clusterID1)
+		t.assertDNSRecordsNotFound(namespace1, service1, clusterID2, "")
+
+		t.clusterStatus.DisconnectAll()
+		t.assertDNSRecordsFound(namespace1, service1, "", "", false)
+
+		Expect(t.resolver.QueryCount(namespace1, service1)).To(Equal(uint64(4)))
+	})
+
+	It("should count lookups via every entry point", func() {
+		t.resolver.GetDualStackDNSRecords(namespace1, service1, "")
+		t.resolver.GetDNSRecordsOfAddressType(namespace1, service1, "", "", discovery.AddressTypeIPv4)
+		t.resolver.GetDNSRecordsMatching(namespace1, service1, "", "", labels.Everything())
+		t.resolver.Resolve(namespace1, service1, "")
+
+		Expect(t.resolver.QueryCount(namespace1, service1)).To(Equal(uint64(4)))
+	})
+
+	When("the service doesn't exist", func() {
+		It("should return zero", func() {
+			t.assertDNSRecordsNotFound(namespace2, service1, "", "")
+			Expect(t.resolver.QueryCount(namespace2, service1)).To(BeZero())
+		})
+	})
+})
+
+var _ = Describe("LastSelectedTimes", func() {
+	fakeClock := testingclock.NewFakeClock(time.Unix(1700000000, 0))
+	t := newTestDriver(resolver.WithClock(fakeClock))
+
+	BeforeEach(func() {
+		t.resolver.PutServiceImport(newAggregatedServiceImport(namespace1, service1))
+		t.putEndpointSlice(newClusterIPEndpointSlice(namespace1, service1, clusterID1, serviceIP1, true, port1))
+		t.putEndpointSlice(newClusterIPEndpointSlice(namespace1, service1, clusterID2, serviceIP2, true, port1))
+	})
+
+	It("should return the time each cluster was last selected", func() {
+		Expect(t.resolver.LastSelectedTimes(namespace1, service1)).To(BeEmpty())
+
+		first := t.getNonHeadlessDNSRecord(namespace1, service1, "").ClusterName
+		firstTime := fakeClock.Now()
+
+		Expect(t.resolver.LastSelectedTimes(namespace1, service1)).To(Equal(map[string]time.Time{first: firstTime}))
+
+		fakeClock.Step(time.Minute)
+
+		second := t.getNonHeadlessDNSRecord(namespace1, service1, "").ClusterName
+		Expect(second).ToNot(Equal(first))
+
+		Expect(t.resolver.LastSelectedTimes(namespace1, service1)).To(Equal(map[string]time.Time{
+			first:  firstTime,
+			second: fakeClock.Now(),
+		}))
+
+		fakeClock.Step(time.Minute)
+
+		Expect(t.getNonHeadlessDNSRecord(namespace1, service1, "").ClusterName).To(Equal(first))
+		Expect(t.resolver.LastSelectedTimes(namespace1, service1)).To(HaveKeyWithValue(first, fakeClock.Now()))
+	})
+
+	When("the service doesn't exist", func() {
+		It("should return nil", func() {
+			Expect(t.resolver.LastSelectedTimes(namespace2, service1)).To(BeNil())
+		})
+	})
+})
+
+type structuredLogSink struct {
+	mutex   sync.Mutex
+	entries []map[string]interface{}
+}
+
+func (s *structuredLogSink) Init(_ logr.RuntimeInfo) {}
+
+func (s *structuredLogSink) Enabled(_ int) bool {
+	return true
+}
+
+func (s *structuredLogSink) Info(_ int, msg string, keysAndValues ...interface{}) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	entry := map[string]interface{}{"msg": msg}
+	for j := 0; j+1 < len(keysAndValues); j += 2 {
+		entry[keysAndValues[j].(string)] = keysAndValues[j+1]
+	}
+
+	s.entries = append(s.entries, entry)
+}
+
+func (s *structuredLogSink) Error(_ error, _ string, _ ...interface{}) {}
+
+func (s *structuredLogSink) WithValues(_ ...interface{}) logr.LogSink {
+	return s
+}
+
+func (s *structuredLogSink) WithName(_ string) logr.LogSink {
+	return s
+}
+
+func (s *structuredLogSink) get() []map[string]interface{} {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return append([]map[string]interface{}(nil), s.entries...)
+}
+
+func (s *structuredLogSink) reset() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.entries = nil
+}
+
+var _ = Describe("SetTrace", func() {
+	sink := &structuredLogSink{}
+	t := newTestDriver(resolver.WithTraceLogger(logr.New(sink)))
+
+	BeforeEach(func() {
+		sink.reset()
+
+		t.resolver.PutServiceImport(newAggregatedServiceImport(namespace1, service1))
+		t.putEndpointSlice(newClusterIPEndpointSlice(namespace1, service1, clusterID1, serviceIP1, true, port1))
+		t.putEndpointSlice(newClusterIPEndpointSlice(namespace1, service1, clusterID2, serviceIP2, true, port1))
+		t.putEndpointSlice(newClusterIPEndpointSlice(namespace1, service1, clusterID3, serviceIP3, true, port1))
+
+		t.clusterStatus.DisconnectClusterID(clusterID3)
+		t.resolver.SetTrace(namespace1, service1, true)
+	})
+
+	assertEntry := func(expChosen string, expReason resolver.ResolutionReason) {
+		entries := sink.get()
+		Expect(entries).To(HaveLen(1))
+
+		entry := entries[0]
+		Expect(entry["service"]).To(Equal(namespace1 + "/" + service1))
+		Expect(entry["candidates"]).To(Equal([]string{clusterID1, clusterID2, clusterID3}))
+		Expect(entry["skipped"]).To(Equal([]string{clusterID3}))
+		Expect(entry["chosen"]).To(Equal(expChosen))
+		Expect(entry["reason"]).To(Equal(string(expReason)))
+		Expect(entry["duration"]).To(BeAssignableToTypeOf(time.Duration(0)))
+	}
+
+	When("a cluster is drained by a traffic shift", func() {
+		BeforeEach(func() {
+			Expect(t.resolver.SetTrafficShift(clusterID1, 100)).To(Succeed())
+		})
+
+		AfterEach(func() {
+			Expect(t.resolver.SetTrafficShift(clusterID1, 0)).To(Succeed())
+		})
+
+		It("should log it as skipped", func() {
+			t.resolver.GetDNSRecords(namespace1, service1, "", "")
+
+			entries := sink.get()
+			Expect(entries).To(HaveLen(1))
+			Expect(entries[0]["skipped"]).To(Equal([]string{clusterID2, clusterID3}))
+		})
+	})
+
+	When("a specific cluster is requested", func() {
+		It("should log the pinned decision", func() {
+			t.resolver.GetDNSRecords(namespace1, service1, clusterID2, "")
+			assertEntry(clusterID2, resolver.ResolvedClusterPinned)
+		})
+	})
+
+	When("the local cluster is selected", func() {
+		BeforeEach(func() {
+			t.clusterStatus.SetLocalClusterID(clusterID1)
+		})
+
+		It("should log the local decision", func() {
+			t.resolver.GetDNSRecords(namespace1, service1, "", "")
+			assertEntry(clusterID1, resolver.ResolvedLocal)
+		})
+	})
+
+	When("a cluster is selected by the load balancer", func() {
+		It("should log the balanced decision", func() {
+			t.resolver.GetDNSRecords(namespace1, service1, "", "")
+
+			entries := sink.get()
+			Expect(entries).To(HaveLen(1))
+			assertEntry(entries[0]["chosen"].(string), resolver.ResolvedBalanced)
+			Expect(entries[0]["chosen"]).To(Or(Equal(clusterID1), Equal(clusterID2)))
+		})
+	})
+
+	When("tracing is enabled via an alias", func() {
+		BeforeEach(func() {
+			t.resolver.SetTrace(namespace1, service1, false)
+			Expect(t.resolver.ReplaceAliases(map[string]string{namespace2 + "/alias": namespace1 + "/" + service1})).To(Succeed())
+			t.resolver.SetTrace(namespace2, "alias", true)
+		})
+
+		It("should trace the target service", func() {
+			t.resolver.GetDNSRecords(namespace1, service1, clusterID2, "")
+			assertEntry(clusterID2, resolver.ResolvedClusterPinned)
+		})
+	})
+
+	When("no cluster is available", func() {
+		BeforeEach(func() {
+			t.clusterStatus.DisconnectAll()
+		})
+
+		It("should log that nothing was chosen", func() {
+			t.resolver.GetDNSRecords(namespace1, service1, "", "")
+
+			entries := sink.get()
+			Expect(entries).To(HaveLen(1))
+			Expect(entries[0]["chosen"]).To(Equal(""))
+			Expect(entries[0]["reason"]).To(Equal(string(resolver.ResolvedNone)))
+			Expect(entries[0]["skipped"]).To(Equal([]string{clusterID1, clusterID2, clusterID3}))
+		})
+	})
+
+	When("tracing is disabled", func() {
+		BeforeEach(func() {
+			t.resolver.SetTrace(namespace1, service1, false)
+		})
+
+		It("should not log the decision", func() {
+			t.resolver.GetDNSRecords(namespace1, service1, "", "")
+			Expect(sink.get()).To(BeEmpty())
+		})
+	})
+
+	When("another service is resolved", func() {
+		BeforeEach(func() {
+			t.resolver.PutServiceImport(newAggregatedServiceImport(namespace2, service1))
+			t.putEndpointSlice(newClusterIPEndpointSlice(namespace2, service1, clusterID1, serviceIP1, true, port1))
+		})
+
+		It("should not log the decision", func() {
+			t.resolver.GetDNSRecords(namespace2, service1, "", "")
+			Expect(sink.get()).To(BeEmpty())
+		})
+	})
+})
+
+var _ = Describe("DownServices", func() {
+	t := newTestDriver()
+
+	BeforeEach(func() {
+		t.resolver.PutServiceImport(newAggregatedServiceImport(namespace1, service1))
+		t.putEndpointSlice(newClusterIPEndpointSlice(namespace1, service1, clusterID1, serviceIP1, true, port1))
+		t.putEndpointSlice(newClusterIPEndpointSlice(namespace1, service1, clusterID2, serviceIP2, true, port1))
+
+		t.resolver.PutServiceImport(newAggregatedServiceImport(namespace2, service1))
+		t.putEndpointSlice(newClusterIPEndpointSlice(namespace2, service1, clusterID2, serviceIP2, true, port1))
+		t.putEndpointSlice(newClusterIPEndpointSlice(namespace2, service1, clusterID3, serviceIP3, true, port1))
+	})
+
+	isConnected := func(_, _, clusterID string) bool {
+		return t.clusterStatus.IsConnected(clusterID)
+	}
+
+	When("every service has a healthy cluster", func() {
+		It("should return none", func() {
+			Expect(t.resolver.DownServices(isConnected)).To(BeEmpty())
+		})
+	})
+
+	When("every cluster of a service fails the health check", func() {
+		BeforeEach(func() {
+			t.clusterStatus.DisconnectClusterID(clusterID2)
+			t.clusterStatus.DisconnectClusterID(clusterID3)
+		})
+
+		It("should return only that service", func() {
+			Expect(t.resolver.DownServices(isConnected)).To(Equal([]string{namespace2 + "/" + service1}))
+		})
+	})
+
+	When("every cluster fails the health check", func() {
+		It("should return all services", func() {
+			Expect(t.resolver.DownServices(func(_, _, _ string) bool {
+				return false
+			})).To(Equal([]string{namespace1 + "/" + service1, namespace2 + "/" + service1}))
+		})
+	})
+
+	When("the health check depends on the service", func() {
+		It("should pass the service's namespace and name", func() {
+			Expect(t.resolver.DownServices(func(namespace, name, _ string) bool {
+				return namespace == namespace1 && name == service1
+			})).To(Equal([]string{namespace2 + "/" + service1}))
+		})
+	})
+
+	When("a service has no clusters", func() {
+		BeforeEach(func() {
+			t.resolver.PutServiceImport(newAggregatedServiceImport(namespace2, "service2"))
+		})
+
+		It("should return it", func() {
+			Expect(t.resolver.DownServices(isConnected)).To(Equal([]string{namespace2 + "/service2"}))
+		})
+	})
+})
+
+var _ = Describe("GlobalSummary", func() {
+	t := newTestDriver()
+
+	BeforeEach(func() {
+		t.resolver.PutServiceImport(newAggregatedServiceImport(namespace1, service1))
+		t.putEndpointSlice(newClusterIPEndpointSlice(namespace1, service1, clusterID1, serviceIP1, true, port1))
+		t.putEndpointSlice(newClusterIPEndpointSlice(namespace1, service1, clusterID2, serviceIP2, true, port1))
+
+		t.resolver.PutServiceImport(newAggregatedServiceImport(namespace2, service1))
+		t.putEndpointSlice(newClusterIPEndpointSlice(namespace2, service1, clusterID3, serviceIP3, true, port1))
+
+		t.resolver.PutServiceImport(newHeadlessAggregatedServiceImport(namespace1, "service2"))
+		t.putEndpointSlice(newEndpointSlice(namespace1, "service2", clusterID1, []mcsv1a1.ServicePort{port1}, discovery.Endpoint{
+			Addresses: []string{endpointIP1},
+		}))
+		t.putEndpointSlice(newEndpointSlice(namespace1, "service2", clusterID3, []mcsv1a1.ServicePort{port1}, discovery.Endpoint{
+			Addresses: []string{endpointIP2},
+		}))
+
+		t.clusterStatus.DisconnectClusterID(clusterID3)
+	})
+
+	It("should return the counts across all services", func() {
+		Expect(t.resolver.GlobalSummary(func(_, _, clusterID string) bool {
+			return t.clusterStatus.IsConnected(clusterID)
+		})).To(Equal(resolver.GlobalStats{
+			Services:             3,
+			HeadlessServices:     1,
+			ClusterSetIPServices: 2,
+			Clusters:             5,
+			HealthyClusters:      3,
+			UnhealthyClusters:    2,
+		}))
+	})
+
+	It("should pass each service's namespace and name to the health check", func() {
+		stats := t.resolver.GlobalSummary(func(namespace, name, _ string) bool {
+			return namespace == namespace1 && name == service1
+		})
+
+		Expect(stats.HealthyClusters).To(Equal(2))
+		Expect(stats.UnhealthyClusters).To(Equal(3))
+	})
+
+	When("there are no services", func() {
+		BeforeEach(func() {
+			t.resolver.RemoveServiceImport(newAggregatedServiceImport(namespace1, service1))
+			t.resolver.RemoveServiceImport(newAggregatedServiceImport(namespace2, service1))
+			t.resolver.RemoveServiceImport(newHeadlessAggregatedServiceImport(namespace1, "service2"))
+		})
+
+		It("should return zero counts", func() {
+			Expect(t.resolver.GlobalSummary(func(_, _, _ string) bool {
+				return true
+			})).To(Equal(resolver.GlobalStats{}))
+		})
+	})
+})
+
+var _ = Describe("Stats", func() {
+	t := newTestDriver()
+
+	BeforeEach(func() {
+		t.resolver.PutServiceImport(newAggregatedServiceImport(namespace1, service1))
+		t.putEndpointSlice(newClusterIPEndpointSlice(namespace1, service1, clusterID1, serviceIP1, true, port1))
+		t.putEndpointSlice(newClusterIPEndpointSlice(namespace1, service1, clusterID2, serviceIP2, true, port1))
+
+		t.resolver.PutServiceImport(newAggregatedServiceImport(namespace2, service1))
+		t.putEndpointSlice(newClusterIPEndpointSlice(namespace2, service1, clusterID3, serviceIP3, true, port1))
+
+		t.resolver.PutServiceImport(newHeadlessAggregatedServiceImport(namespace1, "service2"))
+		t.putEndpointSlice(newEndpointSlice(namespace1, "service2", clusterID1, []mcsv1a1.ServicePort{port1},
+			discovery.Endpoint{Addresses: []string{endpointIP1}},
+			discovery.Endpoint{Addresses: []string{endpointIP2}}))
+		t.putEndpointSlice(newEndpointSlice(namespace1, "service2", clusterID3, []mcsv1a1.ServicePort{port1},
+			discovery.Endpoint{Addresses: []string{endpointIP3}}))
+	})
+
+	It("should return the counts across all services", func() {
+		Expect(t.resolver.ServiceCount()).To(Equal(3))
+		Expect(t.resolver.ClusterRecordCount()).To(Equal(6))
+		Expect(t.resolver.Stats()).To(Equal(resolver.ServiceStats{
+			Services:             3,
+			HeadlessServices:     1,
+			ClusterSetIPServices: 2,
+			ClusterRecords:       6,
+		}))
+	})
+
+	When("services are removed", func() {
+		BeforeEach(func() {
+			t.resolver.RemoveServiceImport(newAggregatedServiceImport(namespace1, service1))
+			t.resolver.RemoveServiceImport(newHeadlessAggregatedServiceImport(namespace1, "service2"))
+		})
+
+		It("should return the counts of the remaining services", func() {
+			Expect(t.resolver.ServiceCount()).To(Equal(1))
+			Expect(t.resolver.ClusterRecordCount()).To(Equal(1))
+			Expect(t.resolver.Stats()).To(Equal(resolver.ServiceStats{
+				Services:             1,
+				ClusterSetIPServices: 1,
+				ClusterRecords:       1,
+			}))
+		})
+	})
+
+	When("a cluster's EndpointSlice is removed", func() {
+		BeforeEach(func() {
+			t.resolver.RemoveEndpointSlice(newClusterIPEndpointSlice(namespace1, service1, clusterID2, serviceIP2, true))
+		})
+
+		It("should no longer count its records", func() {
+			Expect(t.resolver.ServiceCount()).To(Equal(3))
+			Expect(t.resolver.ClusterRecordCount()).To(Equal(5))
+		})
+	})
+})
+
+var _ = Describe("Dump", func() {
+	t := newTestDriver()
+
+	BeforeEach(func() {
+		serviceImport := newAggregatedServiceImport(namespace1, service1)
+		setClusterWeight(serviceImport, clusterID2, 3)
+		t.resolver.PutServiceImport(serviceImport)
+
+		t.putEndpointSlice(newClusterIPEndpointSlice(namespace1, service1, clusterID2, serviceIP2, true, port1))
+		t.putEndpointSlice(newClusterIPEndpointSlice(namespace1, service1, clusterID1, serviceIP1, false, port1))
+
+		t.resolver.PutServiceImport(newHeadlessAggregatedServiceImport(namespace2, service1))
+		t.putEndpointSlice(newEndpointSlice(namespace2, service1, clusterID3, []mcsv1a1.ServicePort{port2},
+			discovery.Endpoint{Addresses: []string{endpointIP1, endpointIP2}}))
+	})
+
+	It("should return the clusters of every service", func() {
+		dump := t.resolver.Dump()
+		Expect(dump).To(HaveLen(2))
+
+		clusters := dump[namespace1+"/"+service1]
+		Expect(clusters).To(HaveLen(2))
+
+		Expect(clusters[0].Cluster).To(Equal(clusterID1))
+		Expect(clusters[0].Weight).To(Equal(int64(1)))
+		Expect(clusters[0].EndpointsHealthy).To(BeFalse())
+		Expect(clusters[0].Records).To(HaveLen(1))
+		Expect(clusters[0].Records[0].IP).To(Equal(serviceIP1))
+
+		Expect(clusters[1].Cluster).To(Equal(clusterID2))
+		Expect(clusters[1].Weight).To(Equal(int64(3)))
+		Expect(clusters[1].EndpointsHealthy).To(BeTrue())
+		Expect(clusters[1].Records).To(HaveLen(1))
+		Expect(clusters[1].Records[0].IP).To(Equal(serviceIP2))
+		Expect(clusters[1].Records[0].Ports).To(Equal([]mcsv1a1.ServicePort{port1}))
+
+		clusters = dump[namespace2+"/"+service1]
+		Expect(clusters).To(HaveLen(1))
+		Expect(clusters[0].Cluster).To(Equal(clusterID3))
+		Expect(clusters[0].Records).To(ConsistOf(
+			resolver.DNSRecord{IP: endpointIP1, Ports: []mcsv1a1.ServicePort{port2}, ClusterName: clusterID3},
+			resolver.DNSRecord{IP: endpointIP2, Ports: []mcsv1a1.ServicePort{port2}, ClusterName: clusterID3}))
+	})
+
+	It("should return a copy that can't be used to mutate the resolver's state", func() {
+		dump := t.resolver.Dump()
+		clusters := dump[namespace1+"/"+service1]
+		clusters[1].Records[0].IP = "1.2.3.4"
+		clusters[1].Records[0].Ports[0].Port = 1
+		clusters[1].Records = nil
+		delete(dump, namespace2+"/"+service1)
+
+		dump = t.resolver.Dump()
+		Expect(dump).To(HaveLen(2))
+
+		clusters = dump[namespace1+"/"+service1]
+		Expect(clusters[1].Records[0].IP).To(Equal(serviceIP2))
+		Expect(clusters[1].Records[0].Ports).To(Equal([]mcsv1a1.ServicePort{port1}))
+		Expect(t.getNonHeadlessDNSRecord(namespace1, service1, clusterID2).IP).To(Equal(serviceIP2))
+	})
+})
+
+var _ = Describe("ForEach", func() {
+	const service2 = "service2"
+
+	t := newTestDriver()
+
+	type serviceEntry struct {
+		key      string
+		clusters []string
+		headless bool
+	}
+
+	BeforeEach(func() {
+		t.resolver.PutServiceImport(newAggregatedServiceImport(namespace1, service1))
+		t.putEndpointSlice(newClusterIPEndpointSlice(namespace1, service1, clusterID2, serviceIP2, true, port1))
+		t.putEndpointSlice(newClusterIPEndpointSlice(namespace1, service1, clusterID1, serviceIP1, true, port1))
+
+		t.resolver.PutServiceImport(newHeadlessAggregatedServiceImport(namespace2, service1))
+		t.putEndpointSlice(newEndpointSlice(namespace2, service1, clusterID3, []mcsv1a1.ServicePort{port2},
+			discovery.Endpoint{Addresses: []string{endpointIP1}}))
+
+		t.resolver.PutServiceImport(newAggregatedServiceImport(namespace1, service2))
+	})
+
+	It("should invoke the function with every service in key order", func() {
+		var entries []serviceEntry
+
+		t.resolver.ForEach(func(key string, clusters []string, headless bool) bool {
+			entries = append(entries, serviceEntry{key: key, clusters: clusters, headless: headless})
+			return true
+		})
+
+		Expect(entries).To(Equal([]serviceEntry{
+			{key: namespace1 + "/" + service1, clusters: []string{clusterID1, clusterID2}},
+			{key: namespace1 + "/" + service2, clusters: []string{}},
+			{key: namespace2 + "/" + service1, clusters: []string{clusterID3}, headless: true},
+		}))
+	})
+
+	It("should stop once the function returns false", func() {
+		var keys []string
+
+		t.resolver.ForEach(func(key string, _ []string, _ bool) bool {
+			keys = append(keys, key)
+			return len(keys) < 2
+		})
+
+		Expect(keys).To(Equal([]string{namespace1 + "/" + service1, namespace1 + "/" + service2}))
+	})
+
+	It("should allow the function to call back into the resolver", func() {
+		t.resolver.ForEach(func(_ string, _ []string, _ bool) bool {
+			t.resolver.PutServiceImport(newAggregatedServiceImport(namespace2, service2))
+			return true
+		})
+
+		Expect(t.resolver.ServiceCount()).To(Equal(4))
+	})
+})
+
+var _ = Describe("FirstSeen", func() {
+	fakeClock := testingclock.NewFakeClock(time.Unix(1700000000, 0))
+	t := newTestDriver(resolver.WithClock(fakeClock))
+
+	var putTime time.Time
+
+	BeforeEach(func() {
+		putTime = fakeClock.Now()
+
+		t.resolver.PutServiceImport(newAggregatedServiceImport(namespace1, service1))
+		t.putEndpointSlice(newClusterIPEndpointSlice(namespace1, service1, clusterID1, serviceIP1, true, port1))
+	})
+
+	It("should return the time the service was first put", func() {
+		firstSeen, found := t.resolver.FirstSeen(namespace1, service1)
+		Expect(found).To(BeTrue())
+		Expect(firstSeen).To(Equal(putTime))
+	})
+
+	When("the service is subsequently updated", func() {
+		BeforeEach(func() {
+			fakeClock.Step(time.Hour)
+
+			serviceImport := newAggregatedServiceImport(namespace1, service1)
+			setClusterWeight(serviceImport, clusterID1, 2)
+			t.resolver.PutServiceImport(serviceImport)
+			t.putEndpointSlice(newClusterIPEndpointSlice(namespace1, service1, clusterID2, serviceIP2, true, port1))
+		})
+
+		It("should not reset the time", func() {
+			firstSeen, found := t.resolver.FirstSeen(namespace1, service1)
+			Expect(found).To(BeTrue())
+			Expect(firstSeen).To(Equal(putTime))
+		})
+	})
+
+	When("the service is removed and put again", func() {
+		BeforeEach(func() {
+			t.resolver.RemoveServiceImport(newAggregatedServiceImport(namespace1, service1))
+
+			fakeClock.Step(time.Hour)
+			t.resolver.PutServiceImport(newAggregatedServiceImport(namespace1, service1))
+		})
+
+		It("should return the time it was put again", func() {
+			firstSeen, found := t.resolver.FirstSeen(namespace1, service1)
+			Expect(found).To(BeTrue())
+			Expect(firstSeen).To(Equal(putTime.Add(time.Hour)))
+		})
+	})
+
+	When("the service doesn't exist", func() {
+		It("should return not found", func() {
+			_, found := t.resolver.FirstSeen(namespace2, service1)
+			Expect(found).To(BeFalse())
+		})
+	})
+})
+
+var _ = Describe("RemoteFallbackRatio", func() {
+	t := newTestDriver()
+
+	BeforeEach(func() {
+		t.resolver.PutServiceImport(newAggregatedServiceImport(namespace1, service1))
+		t.putEndpointSlice(newClusterIPEndpointSlice(namespace1, service1, clusterID1, serviceIP1, true, port1))
+		t.putEndpointSlice(newClusterIPEndpointSlice(namespace1, service1, clusterID2, serviceIP2, true, port1))
+
+		t.clusterStatus.SetLocalClusterID(clusterID1)
+	})
+
+	resolve := func(n int) {
+		for i := 0; i < n; i++ {
+			t.getNonHeadlessDNSRecord(namespace1, service1, "")
+		}
+	}
+
+	When("there were no selections", func() {
+		It("should return zero", func() {
+			Expect(t.resolver.RemoteFallbackRatio(namespace1, service1)).To(BeZero())
+		})
+	})
+
+	When("the local cluster is healthy", func() {
+		It("should return zero", func() {
+			resolve(4)
+
+			local, remote := t.resolver.SelectionCounts(namespace1, service1)
+			Expect(local).To(Equal(int64(4)))
+			Expect(remote).To(BeZero())
+			Expect(t.resolver.RemoteFallbackRatio(namespace1, service1)).To(BeZero())
+		})
+	})
+
+	When("the local cluster is unhealthy for part of the selections", func() {
+		It("should return the fraction of remote fallback selections", func() {
+			resolve(3)
+
+			t.putEndpointSlice(newClusterIPEndpointSlice(namespace1, service1, clusterID1, serviceIP1, false, port1))
+			resolve(1)
+
+			local, remote := t.resolver.SelectionCounts(namespace1, service1)
+			Expect(local).To(Equal(int64(3)))
+			Expect(remote).To(Equal(int64(1)))
+			Expect(t.resolver.RemoteFallbackRatio(namespace1, service1)).To(Equal(0.25))
+
+			t.putEndpointSlice(newClusterIPEndpointSlice(namespace1, service1, clusterID1, serviceIP1, true, port1))
+			resolve(4)
+
+			Expect(t.resolver.RemoteFallbackRatio(namespace1, service1)).To(Equal(0.125))
+		})
+	})
+
+	When("the local cluster is unhealthy for all the selections", func() {
+		BeforeEach(func() {
+			t.putEndpointSlice(newClusterIPEndpointSlice(namespace1, service1, clusterID1, serviceIP1, false, port1))
+		})
+
+		It("should return one", func() {
+			resolve(3)
+			Expect(t.resolver.RemoteFallbackRatio(namespace1, service1)).To(Equal(1.0))
+		})
+	})
+
+	When("a specific cluster is requested", func() {
+		It("should not count the selection", func() {
+			t.getNonHeadlessDNSRecord(namespace1, service1, clusterID2)
+
+			local, remote := t.resolver.SelectionCounts(namespace1, service1)
+			Expect(local).To(BeZero())
+			Expect(remote).To(BeZero())
+		})
+	})
+
+	When("the service doesn't exist", func() {
+		It("should return zero", func() {
+			Expect(t.resolver.RemoteFallbackRatio(namespace2, service1)).To(BeZero())
+		})
+	})
+})
@@ -0,0 +1,246 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+
+Copyright Contributors to the Submariner project.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package resolver
+
+import (
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/submariner-io/lighthouse/coredns/loadbalancer"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/utils/clock"
+)
+
+// Option configures optional behavior of the resolver Interface.
+type Option func(*Interface)
+
+// WithResolutionSink configures a sink to which the outcome of every ClusterIP service resolution is reported.
+// Outcomes are queued and reported in order by a single goroutine so the sink doesn't block DNS query handling. If the
+// queue is full, the outcome is dropped and counted, as reported by DroppedResolutions.
+func WithResolutionSink(sink ResolutionSink) Option {
+	return func(i *Interface) {
+		i.resolutionSink = sink
+	}
+}
+
+// WithRequestedClusterFallback enables falling back to the local cluster and then load balancing across the other
+// clusters when the specifically requested cluster of a ClusterIP service doesn't exist.
+func WithRequestedClusterFallback() Option {
+	return func(i *Interface) {
+		i.requestedClusterFallback = true
+	}
+}
+
+// WithEmptyEndpointsEviction enables evicting a cluster from a ClusterIP service once it has reported no healthy
+// endpoints for longer than the given grace period. An evicted cluster is treated as absent, even when specifically
+// requested, until it reports healthy endpoints again.
+func WithEmptyEndpointsEviction(gracePeriod time.Duration) Option {
+	return func(i *Interface) {
+		i.emptyEndpointsEviction = gracePeriod
+	}
+}
+
+// WithInFlightLimits enables the per-cluster limits on in-flight selections configured via the
+// "lighthouse.submariner.io/serviceimport.max-in-flight/<cluster>" annotations. A selection counts against its
+// cluster's limit until it's released via ReleaseDNSRecord or the given lease, typically the records' TTL, expires.
+func WithInFlightLimits(lease time.Duration) Option {
+	return func(i *Interface) {
+		i.inFlightLease = lease
+	}
+}
+
+// WithNegativeCache configures remembering, for the given TTL, up to the given number of services that were looked up
+// via GetDNSRecords, GetDNSRecordsForClient or LookupDNSRecords but didn't exist, so repeated lookups for them
+// return without taking the resolver's lock. A service is forgotten as soon as it's put. By default, misses aren't
+// remembered.
+func WithNegativeCache(ttl time.Duration, size int) Option {
+	return func(i *Interface) {
+		if ttl > 0 && size > 0 {
+			i.negativeCache = newNegativeCache(ttl, size)
+		}
+	}
+}
+
+// WithRandomSeed configures seeding the random numbers used to select clusters, including those of the random load
+// balancing policy, so selections are reproducible, eg in tests. By default, the numbers aren't seeded.
+func WithRandomSeed(seed int64) Option {
+	return func(i *Interface) {
+		i.random = newRandomSource(seed)
+	}
+}
+
+// WithClock configures the clock used to track time-based state. It defaults to the real clock.
+func WithClock(c clock.PassiveClock) Option {
+	return func(i *Interface) {
+		i.clock = c
+	}
+}
+
+// WithRecencyPenalty biases load balancing away from recently selected clusters. A selected cluster is passed over
+// with the given probability, in the range 0 to 1, which decays linearly to zero over the decay period.
+func WithRecencyPenalty(penalty float64, decay time.Duration) Option {
+	return func(i *Interface) {
+		i.recencyPenalty = penalty
+		i.recencyPenaltyDecay = decay
+	}
+}
+
+// WithDynamicTTL enables deriving a service's record TTL, as returned by RecordTTL, from the time since the
+// service last changed, bounded by the given minimum and maximum. Records of an unstable service thus expire sooner.
+func WithDynamicTTL(minTTL, maxTTL time.Duration) Option {
+	return func(i *Interface) {
+		i.minTTL = minTTL
+		i.maxTTL = maxTTL
+	}
+}
+
+// WithRecencyBoost keeps clusters warm by selecting a ClusterIP service's cluster that hasn't been selected for longer
+// than the given idle period ahead of the load balancer. The boost is bounded to a single selection per idle period so
+// a cold cluster isn't overwhelmed, after which it's load balanced per its weight again. Clusters drained by a zero load
+// balancer weight, eg due to a traffic shift, aren't boosted.
+func WithRecencyBoost(idlePeriod time.Duration) Option {
+	return func(i *Interface) {
+		i.recencyBoostIdlePeriod = idlePeriod
+	}
+}
+
+// WithTraceLogger configures the structured logger to which resolution decisions are logged for the services enabled
+// via SetTrace. It defaults to the resolver's logger.
+func WithTraceLogger(l logr.Logger) Option {
+	return func(i *Interface) {
+		i.traceLogger = l
+	}
+}
+
+// WithClusterNameNormalization enables lowercasing and trimming the cluster names derived from EndpointSlices,
+// ServiceImport annotations and requests so names that differ only by case or surrounding whitespace refer to the same
+// cluster. Note that the names are passed to the ClusterStatus in the normalized form.
+func WithClusterNameNormalization() Option {
+	return func(i *Interface) {
+		i.normalizeClusterNames = true
+	}
+}
+
+// WithBalancer configures the constructor of the load balancer of each ClusterIP service that doesn't specify a load
+// balancing policy annotation. It defaults to the smooth weighted round robin load balancer. A load balancer that
+// doesn't implement loadbalancer.Updater is reset whenever a cluster's weight changes.
+func WithBalancer(newBalancer func() loadbalancer.Interface) Option {
+	return func(i *Interface) {
+		i.newBalancer = newBalancer
+	}
+}
+
+// WithBalancerAddRetry configures retrying, per the given backoff, adding a cluster to a service's load balancer when
+// it fails with a transient error. Retries are made in the background. A cluster that hasn't been added isn't selected
+// by the load balancer and is reported by UnbalancedClusters. By default, adding isn't retried.
+func WithBalancerAddRetry(backoff wait.Backoff) Option {
+	return func(i *Interface) {
+		i.balancerRetry.backoff = backoff
+	}
+}
+
+// WithSelectionSubset enables load balancing each ClusterIP service resolution over a random subset of k of the
+// available clusters, picking among them in proportion to their weights, rather than over all the clusters, ie
+// power-of-k choices. Zero, the default, considers all the clusters.
+func WithSelectionSubset(k int) Option {
+	return func(i *Interface) {
+		i.selectionSubset = k
+	}
+}
+
+// WithMaxSelectionCandidates limits the clusters the load balancer yields to examine per ClusterIP service resolution
+// to k, in the load balancer's order, which honors their weights. If none of them can be selected, no record is
+// returned rather than examining every cluster, bounding the latency of resolutions of services with many clusters.
+// Zero, the default, examines all the clusters.
+func WithMaxSelectionCandidates(k int) Option {
+	return func(i *Interface) {
+		i.maxCandidates = k
+	}
+}
+
+// WithPortsEmptyCallback configures a callback invoked when the merged ports of a service become empty, eg because its
+// clusters advertise disjoint ports or all its clusters were removed, with empty set to true, and when they subsequently
+// become non-empty again, with empty set to false. The callback is invoked synchronously with the resolver's lock held
+// so it must not block or call back into the resolver. Callbacks for different services may be invoked concurrently.
+func WithPortsEmptyCallback(callback func(namespace, name string, empty bool)) Option {
+	return func(i *Interface) {
+		i.portsEmptyCallback = callback
+	}
+}
+
+// WithCostAwareSelection enables preferring the cheapest clusters of a ClusterIP service, per the
+// "lighthouse.submariner.io/serviceimport.cost/<cluster>" annotations, when load balancing. The healthy clusters with
+// the lowest cost are load balanced per their weights and more expensive clusters are only used as a fallback.
+func WithCostAwareSelection() Option {
+	return func(i *Interface) {
+		i.costAwareSelection = true
+	}
+}
+
+// WithLocalRegion enables preferring the clusters of a ClusterIP service in the given region, per the
+// "lighthouse.submariner.io/serviceimport.region/<cluster>" annotations, when the local cluster can't be selected. The
+// clusters in other regions are only used as a fallback once none of the same-region clusters can be selected.
+func WithLocalRegion(region string) Option {
+	return func(i *Interface) {
+		i.localRegion = region
+	}
+}
+
+// WithHealthDecay enables lowering the load balancing weight of a ClusterIP service's cluster after consecutive failed
+// endpoint checks, as reported via ReportEndpointCheck, so a flapping cluster receives proportionally less traffic
+// rather than all or nothing. Each consecutive failure multiplies the cluster's weight by the given factor, in the
+// range 0 to 1, and each successful check forgives the given number of failures, or all of them if zero, until the
+// weight is restored.
+func WithHealthDecay(factor float64, recovery int) Option {
+	return func(i *Interface) {
+		i.healthDecay = healthDecay{factor: factor, recovery: recovery}
+	}
+}
+
+// WithMaxWeight configures the maximum load balancing weight of a cluster. Weights specified via the
+// "lighthouse.submariner.io/serviceimport.weight/<cluster>" annotations above it are clamped to it, so huge weights
+// can't skew the load balancer. The maximum must be at least 1, otherwise it's ignored.
+func WithMaxWeight(maxWeight int64) Option {
+	return func(i *Interface) {
+		if maxWeight >= 1 {
+			i.maxWeight = maxWeight
+		}
+	}
+}
+
+// WithLocalWeightMultiplier scales the load balancing weight of the local cluster by the given multiplier, biasing
+// selection toward it without always preferring it, eg for services whose ServiceImport disables preferring the local
+// cluster. A change of the local cluster applies once a service's load balancer is next updated. The multiplier must
+// be at least 1, the default, which doesn't bias selection, otherwise it's ignored.
+func WithLocalWeightMultiplier(multiplier int64) Option {
+	return func(i *Interface) {
+		if multiplier >= 1 {
+			i.localWeight.multiplier = multiplier
+		}
+	}
+}
+
+// WithReplicaTieBreak orders the ClusterIP service clusters of equal weight in the load balancer by a hash of the
+// cluster name and the given replica ID, eg the CoreDNS pod name. Each replica thus has a stable selection order that
+// differs from that of the other replicas, spreading the load across the clusters without per-instance seeding.
+func WithReplicaTieBreak(replicaID string) Option {
+	return func(i *Interface) {
+		i.replicaID = replicaID
+	}
+}
@@ -0,0 +1,782 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+
+Copyright Contributors to the Submariner project.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package resolver_test
+
+import (
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/submariner-io/lighthouse/coredns/constants"
+	"github.com/submariner-io/lighthouse/coredns/resolver"
+	corev1 "k8s.io/api/core/v1"
+	discovery "k8s.io/api/discovery/v1"
+	testingclock "k8s.io/utils/clock/testing"
+	mcsv1a1 "sigs.k8s.io/mcs-api/pkg/apis/v1alpha1"
+)
+
+var _ = Describe("PortContributors", func() {
+	t := newTestDriver()
+
+	BeforeEach(func() {
+		t.resolver.PutServiceImport(newAggregatedServiceImport(namespace1, service1))
+
+		t.putEndpointSlice(newClusterIPEndpointSlice(namespace1, service1, clusterID1, serviceIP1, true, port1, port2))
+		t.putEndpointSlice(newClusterIPEndpointSlice(namespace1, service1, clusterID2, serviceIP2, true, port1))
+		t.putEndpointSlice(newClusterIPEndpointSlice(namespace1, service1, clusterID3, serviceIP3, true, port1, port3))
+	})
+
+	When("the clusters advertise divergent ports", func() {
+		It("should return the clusters that advertise each port", func() {
+			contributors, found := t.resolver.PortContributors(namespace1, service1)
+			Expect(found).To(BeTrue())
+			Expect(contributors).To(Equal(map[string][]string{
+				"http/TCP/8080": {clusterID1, clusterID2, clusterID3},
+				"POP3/UDP/110":  {clusterID1},
+				"https/TCP/443": {clusterID3},
+			}))
+		})
+	})
+
+	When("a cluster is removed", func() {
+		BeforeEach(func() {
+			t.resolver.RemoveEndpointSlice(newClusterIPEndpointSlice(namespace1, service1, clusterID3, serviceIP3, true))
+		})
+
+		It("should no longer return it as a contributor", func() {
+			contributors, found := t.resolver.PortContributors(namespace1, service1)
+			Expect(found).To(BeTrue())
+			Expect(contributors).To(Equal(map[string][]string{
+				"http/TCP/8080": {clusterID1, clusterID2},
+				"POP3/UDP/110":  {clusterID1},
+			}))
+		})
+	})
+
+	When("the service doesn't exist", func() {
+		It("should return not found", func() {
+			_, found := t.resolver.PortContributors(namespace2, service1)
+			Expect(found).To(BeFalse())
+		})
+	})
+})
+
+var _ = Describe("ClusterUniquePorts", func() {
+	t := newTestDriver()
+
+	BeforeEach(func() {
+		t.resolver.PutServiceImport(newAggregatedServiceImport(namespace1, service1))
+
+		t.putEndpointSlice(newClusterIPEndpointSlice(namespace1, service1, clusterID1, serviceIP1, true, port1, port2, port4))
+		t.putEndpointSlice(newClusterIPEndpointSlice(namespace1, service1, clusterID2, serviceIP2, true, port1))
+		t.putEndpointSlice(newClusterIPEndpointSlice(namespace1, service1, clusterID3, serviceIP3, true, port1, port3))
+	})
+
+	When("the clusters advertise divergent ports", func() {
+		It("should return the ports unique to each cluster", func() {
+			unique, found := t.resolver.ClusterUniquePorts(namespace1, service1)
+			Expect(found).To(BeTrue())
+			Expect(unique).To(Equal(map[string][]mcsv1a1.ServicePort{
+				clusterID1: {port2, port4},
+				clusterID3: {port3},
+			}))
+		})
+	})
+
+	When("the lagging clusters catch up", func() {
+		BeforeEach(func() {
+			t.putEndpointSlice(newClusterIPEndpointSlice(namespace1, service1, clusterID2, serviceIP2, true, port1, port2, port4))
+			t.putEndpointSlice(newClusterIPEndpointSlice(namespace1, service1, clusterID3, serviceIP3, true, port1, port2, port3, port4))
+		})
+
+		It("should only report the ports not yet advertised by all clusters", func() {
+			unique, found := t.resolver.ClusterUniquePorts(namespace1, service1)
+			Expect(found).To(BeTrue())
+			Expect(unique).To(Equal(map[string][]mcsv1a1.ServicePort{
+				clusterID3: {port3},
+			}))
+		})
+	})
+
+	When("the service doesn't exist", func() {
+		It("should return not found", func() {
+			_, found := t.resolver.ClusterUniquePorts(namespace2, service1)
+			Expect(found).To(BeFalse())
+		})
+	})
+})
+
+var _ = Describe("PortConflicts", func() {
+	t := newTestDriver()
+
+	BeforeEach(func() {
+		t.resolver.PutServiceImport(newAggregatedServiceImport(namespace1, service1))
+		t.putEndpointSlice(newClusterIPEndpointSlice(namespace1, service1, clusterID1, serviceIP1, true, port1, port2))
+	})
+
+	When("the clusters advertise identical ports", func() {
+		BeforeEach(func() {
+			t.putEndpointSlice(newClusterIPEndpointSlice(namespace1, service1, clusterID2, serviceIP2, true, port2, port1))
+		})
+
+		It("should not report a conflict", func() {
+			Expect(t.resolver.PortConflicts(namespace1, service1)).To(BeFalse())
+		})
+	})
+
+	When("the clusters advertise disjoint ports", func() {
+		BeforeEach(func() {
+			t.putEndpointSlice(newClusterIPEndpointSlice(namespace1, service1, clusterID2, serviceIP2, true, port3))
+		})
+
+		It("should report a conflict", func() {
+			Expect(t.resolver.PortConflicts(namespace1, service1)).To(BeTrue())
+
+			ports, found := t.resolver.GetPorts(namespace1, service1)
+			Expect(found).To(BeTrue())
+			Expect(ports).To(BeEmpty())
+		})
+
+		Context("and the conflicting cluster is subsequently fixed", func() {
+			It("should no longer report a conflict", func() {
+				t.putEndpointSlice(newClusterIPEndpointSlice(namespace1, service1, clusterID2, serviceIP2, true, port1, port2))
+				Expect(t.resolver.PortConflicts(namespace1, service1)).To(BeFalse())
+			})
+		})
+
+		Context("and the conflicting cluster is removed", func() {
+			It("should no longer report a conflict", func() {
+				t.resolver.RemoveEndpointSlice(newClusterIPEndpointSlice(namespace1, service1, clusterID2, serviceIP2, true))
+				Expect(t.resolver.PortConflicts(namespace1, service1)).To(BeFalse())
+			})
+		})
+	})
+
+	When("the clusters advertise overlapping ports with the union strategy", func() {
+		BeforeEach(func() {
+			serviceImport := newAggregatedServiceImport(namespace1, service1)
+			serviceImport.Annotations = map[string]string{constants.PortMergeAnnotation: constants.PortMergeUnion}
+			t.resolver.PutServiceImport(serviceImport)
+
+			t.putEndpointSlice(newClusterIPEndpointSlice(namespace1, service1, clusterID2, serviceIP2, true, port1))
+		})
+
+		It("should report a conflict", func() {
+			Expect(t.resolver.PortConflicts(namespace1, service1)).To(BeTrue())
+		})
+	})
+
+	When("the service is headless", func() {
+		BeforeEach(func() {
+			t.resolver.PutServiceImport(newHeadlessAggregatedServiceImport(namespace2, service1))
+			t.putEndpointSlice(newEndpointSlice(namespace2, service1, clusterID1, []mcsv1a1.ServicePort{port1},
+				discovery.Endpoint{Addresses: []string{endpointIP1}}))
+			t.putEndpointSlice(newEndpointSlice(namespace2, service1, clusterID2, []mcsv1a1.ServicePort{port2},
+				discovery.Endpoint{Addresses: []string{endpointIP2}}))
+		})
+
+		It("should not report a conflict", func() {
+			Expect(t.resolver.PortConflicts(namespace2, service1)).To(BeFalse())
+		})
+	})
+
+	When("the service doesn't exist", func() {
+		It("should not report a conflict", func() {
+			Expect(t.resolver.PortConflicts(namespace2, "unknown")).To(BeFalse())
+		})
+	})
+})
+
+var _ = Describe("ClusterPorts", func() {
+	t := newTestDriver()
+
+	BeforeEach(func() {
+		t.resolver.PutServiceImport(newAggregatedServiceImport(namespace1, service1))
+
+		t.putEndpointSlice(newClusterIPEndpointSlice(namespace1, service1, clusterID1, serviceIP1, true, port1, port2))
+		t.putEndpointSlice(newClusterIPEndpointSlice(namespace1, service1, clusterID2, serviceIP2, true, port1, port3))
+	})
+
+	It("should return each cluster's own ports unaffected by the merge", func() {
+		ports, found := t.resolver.ClusterPorts(namespace1, service1, clusterID1)
+		Expect(found).To(BeTrue())
+		Expect(ports).To(Equal([]mcsv1a1.ServicePort{port1, port2}))
+
+		ports, found = t.resolver.ClusterPorts(namespace1, service1, clusterID2)
+		Expect(found).To(BeTrue())
+		Expect(ports).To(Equal([]mcsv1a1.ServicePort{port1, port3}))
+
+		Expect(t.getNonHeadlessDNSRecord(namespace1, service1, "").Ports).To(Equal([]mcsv1a1.ServicePort{port1}))
+	})
+
+	When("a cluster's ports are updated", func() {
+		BeforeEach(func() {
+			t.putEndpointSlice(newClusterIPEndpointSlice(namespace1, service1, clusterID2, serviceIP2, true, port4))
+		})
+
+		It("should return the updated ports", func() {
+			ports, found := t.resolver.ClusterPorts(namespace1, service1, clusterID2)
+			Expect(found).To(BeTrue())
+			Expect(ports).To(Equal([]mcsv1a1.ServicePort{port4}))
+		})
+	})
+
+	When("the service is headless", func() {
+		BeforeEach(func() {
+			t.resolver.PutServiceImport(newHeadlessAggregatedServiceImport(namespace2, service1))
+
+			Expect(t.resolver.PutEndpointSlices(
+				newEndpointSlice(namespace2, service1, clusterID1, []mcsv1a1.ServicePort{port1}, discovery.Endpoint{
+					Addresses: []string{endpointIP1},
+				}),
+				newEndpointSlice(namespace2, service1, clusterID1, []mcsv1a1.ServicePort{port1, port2}, discovery.Endpoint{
+					Addresses: []string{endpointIP2},
+				}))).To(BeFalse())
+		})
+
+		It("should return the union of the cluster's EndpointSlice ports", func() {
+			ports, found := t.resolver.ClusterPorts(namespace2, service1, clusterID1)
+			Expect(found).To(BeTrue())
+			Expect(ports).To(Equal([]mcsv1a1.ServicePort{port1, port2}))
+		})
+	})
+
+	When("the cluster doesn't exist", func() {
+		It("should return not found", func() {
+			_, found := t.resolver.ClusterPorts(namespace1, service1, clusterID3)
+			Expect(found).To(BeFalse())
+		})
+	})
+
+	When("the service doesn't exist", func() {
+		It("should return not found", func() {
+			_, found := t.resolver.ClusterPorts(namespace2, service1, clusterID1)
+			Expect(found).To(BeFalse())
+		})
+	})
+})
+
+var _ = Describe("GetPorts", func() {
+	t := newTestDriver()
+
+	BeforeEach(func() {
+		t.resolver.PutServiceImport(newAggregatedServiceImport(namespace1, service1))
+
+		t.putEndpointSlice(newClusterIPEndpointSlice(namespace1, service1, clusterID1, serviceIP1, true, port1, port2, port3))
+		t.putEndpointSlice(newClusterIPEndpointSlice(namespace1, service1, clusterID2, serviceIP2, true, port2, port3, port4))
+	})
+
+	It("should return exactly the intersected ports", func() {
+		ports, found := t.resolver.GetPorts(namespace1, service1)
+		Expect(found).To(BeTrue())
+		Expect(ports).To(ConsistOf(port2, port3))
+	})
+
+	It("should return a copy of the ports", func() {
+		ports, _ := t.resolver.GetPorts(namespace1, service1)
+		ports[0].Port = 1
+
+		ports, _ = t.resolver.GetPorts(namespace1, service1)
+		Expect(ports).To(ConsistOf(port2, port3))
+	})
+
+	When("the service is headless", func() {
+		BeforeEach(func() {
+			t.resolver.PutServiceImport(newHeadlessAggregatedServiceImport(namespace2, service1))
+		})
+
+		It("should return not found", func() {
+			_, found := t.resolver.GetPorts(namespace2, service1)
+			Expect(found).To(BeFalse())
+		})
+	})
+
+	When("the service doesn't exist", func() {
+		It("should return not found", func() {
+			_, found := t.resolver.GetPorts(namespace2, service1)
+			Expect(found).To(BeFalse())
+		})
+	})
+})
+
+var _ = Describe("SRVRecords", func() {
+	t := newTestDriver()
+
+	When("the service is ClusterIP", func() {
+		BeforeEach(func() {
+			t.resolver.PutServiceImport(newAggregatedServiceImport(namespace1, service1))
+
+			t.putEndpointSlice(newClusterIPEndpointSlice(namespace1, service1, clusterID2, serviceIP2, true, port1, port2))
+			t.putEndpointSlice(newClusterIPEndpointSlice(namespace1, service1, clusterID1, serviceIP1, true, port1))
+			t.putEndpointSlice(newClusterIPEndpointSlice(namespace1, service1, clusterID3, serviceIP3, false, port1))
+		})
+
+		It("should return a record per healthy cluster with the merged ports", func() {
+			records, found := t.resolver.SRVRecords(namespace1, service1)
+			Expect(found).To(BeTrue())
+			Expect(records).To(HaveLen(2))
+
+			Expect(records[0].ClusterName).To(Equal(clusterID1))
+			Expect(records[0].IP).To(Equal(serviceIP1))
+			Expect(records[0].Ports).To(Equal([]mcsv1a1.ServicePort{port1}))
+
+			Expect(records[1].ClusterName).To(Equal(clusterID2))
+			Expect(records[1].IP).To(Equal(serviceIP2))
+			Expect(records[1].Ports).To(Equal([]mcsv1a1.ServicePort{port1}))
+		})
+	})
+
+	When("the service is headless", func() {
+		BeforeEach(func() {
+			t.resolver.PutServiceImport(newHeadlessAggregatedServiceImport(namespace1, service1))
+
+			t.putEndpointSlice(newEndpointSlice(namespace1, service1, clusterID1, []mcsv1a1.ServicePort{port1},
+				discovery.Endpoint{Addresses: []string{endpointIP1}, Hostname: &hostName1},
+				discovery.Endpoint{Addresses: []string{endpointIP2}, Hostname: &hostName2}))
+			t.putEndpointSlice(newEndpointSlice(namespace1, service1, clusterID2, []mcsv1a1.ServicePort{port2},
+				discovery.Endpoint{Addresses: []string{endpointIP3}, Hostname: &hostName1}))
+		})
+
+		It("should return a record per endpoint address with its host name and ports", func() {
+			records, found := t.resolver.SRVRecords(namespace1, service1)
+			Expect(found).To(BeTrue())
+			Expect(records).To(ConsistOf(
+				resolver.DNSRecord{IP: endpointIP1, Ports: []mcsv1a1.ServicePort{port1}, HostName: hostName1, ClusterName: clusterID1},
+				resolver.DNSRecord{IP: endpointIP2, Ports: []mcsv1a1.ServicePort{port1}, HostName: hostName2, ClusterName: clusterID1},
+				resolver.DNSRecord{IP: endpointIP3, Ports: []mcsv1a1.ServicePort{port2}, HostName: hostName1, ClusterName: clusterID2}))
+		})
+	})
+
+	When("the service doesn't exist", func() {
+		It("should return not found", func() {
+			_, found := t.resolver.SRVRecords(namespace2, service1)
+			Expect(found).To(BeFalse())
+		})
+	})
+})
+
+var _ = Describe("Port-scoped selection", func() {
+	t := newTestDriver()
+
+	When("the service is ClusterIP", func() {
+		BeforeEach(func() {
+			t.resolver.PutServiceImport(newAggregatedServiceImport(namespace1, service1))
+
+			t.putEndpointSlice(newClusterIPEndpointSlice(namespace1, service1, clusterID1, serviceIP1, true, port1, port2))
+			t.putEndpointSlice(newClusterIPEndpointSlice(namespace1, service1, clusterID2, serviceIP2, true, port1))
+			t.putEndpointSlice(newClusterIPEndpointSlice(namespace1, service1, clusterID3, serviceIP3, true, port1, port3))
+		})
+
+		getIPs := func(portName string, protocol corev1.Protocol) []string {
+			var ips []string
+
+			for j := 0; j < 6; j++ {
+				records, isHeadless, found := t.resolver.GetDNSRecordsForPort(namespace1, service1, "", "", portName, protocol)
+				Expect(found).To(BeTrue())
+				Expect(isHeadless).To(BeFalse())
+
+				for k := range records {
+					ips = append(ips, records[k].IP)
+				}
+			}
+
+			return ips
+		}
+
+		It("should only select the clusters exposing the requested port", func() {
+			ips := getIPs(port3.Name, port3.Protocol)
+			Expect(ips).To(HaveLen(6))
+			Expect(ips).To(HaveEach(serviceIP3))
+
+			ips = getIPs("pop3", corev1.ProtocolUDP)
+			Expect(ips).To(HaveLen(6))
+			Expect(ips).To(HaveEach(serviceIP1))
+		})
+
+		It("should select any cluster for a port they all expose", func() {
+			Expect(getIPs(port1.Name, "")).To(ContainElements(serviceIP1, serviceIP2, serviceIP3))
+		})
+
+		It("should return no record if no cluster exposes the requested port", func() {
+			Expect(getIPs(port4.Name, port4.Protocol)).To(BeEmpty())
+			Expect(getIPs(port3.Name, corev1.ProtocolUDP)).To(BeEmpty())
+		})
+
+		It("should return no record if the requested cluster doesn't expose the requested port", func() {
+			records, _, found := t.resolver.GetDNSRecordsForPort(namespace1, service1, clusterID2, "", port3.Name, port3.Protocol)
+			Expect(found).To(BeTrue())
+			Expect(records).To(BeEmpty())
+		})
+	})
+
+	When("the service is headless", func() {
+		BeforeEach(func() {
+			t.resolver.PutServiceImport(newHeadlessAggregatedServiceImport(namespace1, service1))
+
+			t.putEndpointSlice(newEndpointSlice(namespace1, service1, clusterID1, []mcsv1a1.ServicePort{port1},
+				discovery.Endpoint{Addresses: []string{endpointIP1}}))
+			t.putEndpointSlice(newEndpointSlice(namespace1, service1, clusterID2, []mcsv1a1.ServicePort{port1, port2},
+				discovery.Endpoint{Addresses: []string{endpointIP2}}))
+		})
+
+		It("should only return the records exposing the requested port", func() {
+			records, isHeadless, found := t.resolver.GetDNSRecordsForPort(namespace1, service1, "", "", port2.Name, port2.Protocol)
+			Expect(found).To(BeTrue())
+			Expect(isHeadless).To(BeTrue())
+			Expect(records).To(HaveLen(1))
+			Expect(records[0].IP).To(Equal(endpointIP2))
+		})
+	})
+
+	When("the service doesn't exist", func() {
+		It("should return not found", func() {
+			_, _, found := t.resolver.GetDNSRecordsForPort(namespace2, service1, "", "", port1.Name, port1.Protocol)
+			Expect(found).To(BeFalse())
+		})
+	})
+})
+
+var _ = Describe("Prepared records", func() {
+	t := newTestDriver()
+
+	BeforeEach(func() {
+		t.resolver.PutServiceImport(newAggregatedServiceImport(namespace1, service1))
+		t.putEndpointSlice(newClusterIPEndpointSlice(namespace1, service1, clusterID1, serviceIP1, true, port1, port2))
+	})
+
+	It("should return copies of the prepared record", func() {
+		record, _ := t.resolver.GetBestDNSRecord(namespace1, service1)
+		record.IP = serviceIP2
+		record.Ports[0] = port3
+
+		again, _ := t.resolver.GetBestDNSRecord(namespace1, service1)
+		Expect(again).ToNot(BeIdenticalTo(record))
+		Expect(again.IP).To(Equal(serviceIP1))
+		Expect(again.Ports).To(ConsistOf(port1, port2))
+
+		resolution, _ := t.resolver.Resolve(namespace1, service1, "")
+		resolution.Record.Ports[0] = port3
+		Expect(t.getNonHeadlessDNSRecord(namespace1, service1, "").Ports).To(ConsistOf(port1, port2))
+	})
+
+	When("the merged ports change after a second put", func() {
+		It("should return a record with the updated ports", func() {
+			previous, _ := t.resolver.GetBestDNSRecord(namespace1, service1)
+			Expect(previous.Ports).To(ConsistOf(port1, port2))
+
+			t.putEndpointSlice(newClusterIPEndpointSlice(namespace1, service1, clusterID2, serviceIP2, false, port1))
+
+			record, _ := t.resolver.GetBestDNSRecord(namespace1, service1)
+			Expect(record.IP).To(Equal(serviceIP1))
+			Expect(record.Ports).To(Equal([]mcsv1a1.ServicePort{port1}))
+			Expect(t.getNonHeadlessDNSRecord(namespace1, service1, "").Ports).To(Equal([]mcsv1a1.ServicePort{port1}))
+
+			Expect(previous.Ports).To(ConsistOf(port1, port2))
+		})
+	})
+
+	When("the port merge strategy changes", func() {
+		It("should return a record with the updated ports", func() {
+			t.putEndpointSlice(newClusterIPEndpointSlice(namespace1, service1, clusterID2, serviceIP2, false, port1))
+
+			serviceImport := newAggregatedServiceImport(namespace1, service1)
+			serviceImport.Annotations = map[string]string{constants.PortMergeAnnotation: constants.PortMergeUnion}
+			t.resolver.PutServiceImport(serviceImport)
+
+			record, _ := t.resolver.GetBestDNSRecord(namespace1, service1)
+			Expect(record.Ports).To(ConsistOf(port1, port2))
+		})
+	})
+})
+
+var _ = Describe("PortsVersion", func() {
+	t := newTestDriver()
+
+	var version uint64
+
+	BeforeEach(func() {
+		t.resolver.PutServiceImport(newAggregatedServiceImport(namespace1, service1))
+
+		t.putEndpointSlice(newClusterIPEndpointSlice(namespace1, service1, clusterID1, serviceIP1, true, port1, port2))
+		t.putEndpointSlice(newClusterIPEndpointSlice(namespace1, service1, clusterID2, serviceIP2, true, port1, port2))
+
+		version = t.getPortsVersion()
+	})
+
+	When("the merged ports change", func() {
+		It("should bump the version", func() {
+			t.putEndpointSlice(newClusterIPEndpointSlice(namespace1, service1, clusterID2, serviceIP2, true, port1))
+			Expect(t.getPortsVersion()).To(BeNumerically(">", version))
+		})
+	})
+
+	When("a cluster is updated without changing the merged ports", func() {
+		It("should not bump the version", func() {
+			t.putEndpointSlice(newClusterIPEndpointSlice(namespace1, service1, clusterID2, serviceIP3, false, port2, port1))
+			Expect(t.getPortsVersion()).To(Equal(version))
+		})
+	})
+
+	When("a cluster with a superset of the merged ports is added", func() {
+		It("should not bump the version", func() {
+			t.putEndpointSlice(newClusterIPEndpointSlice(namespace1, service1, clusterID3, serviceIP3, true, port1, port2, port3))
+			Expect(t.getPortsVersion()).To(Equal(version))
+		})
+	})
+
+	When("a cluster is removed causing the merged ports to change", func() {
+		BeforeEach(func() {
+			t.putEndpointSlice(newClusterIPEndpointSlice(namespace1, service1, clusterID3, serviceIP3, true, port1))
+			version = t.getPortsVersion()
+		})
+
+		It("should bump the version", func() {
+			t.resolver.RemoveEndpointSlice(newClusterIPEndpointSlice(namespace1, service1, clusterID3, serviceIP3, true))
+			Expect(t.getPortsVersion()).To(BeNumerically(">", version))
+		})
+	})
+
+	When("the service doesn't exist", func() {
+		It("should return not found", func() {
+			_, found := t.resolver.PortsVersion(namespace2, service1)
+			Expect(found).To(BeFalse())
+		})
+	})
+})
+
+var _ = Describe("Merged port order", func() {
+	t := newTestDriver()
+
+	sortedPorts := []mcsv1a1.ServicePort{port2, port4, port1, port3}
+
+	BeforeEach(func() {
+		t.resolver.PutServiceImport(newAggregatedServiceImport(namespace1, service1))
+	})
+
+	It("should return the merged ports sorted by name, protocol and number", func() {
+		for i := 0; i < 10; i++ {
+			t.putEndpointSlice(newClusterIPEndpointSlice(namespace1, service1, clusterID1, serviceIP1, true, port3, port1, port4, port2))
+			t.putEndpointSlice(newClusterIPEndpointSlice(namespace1, service1, clusterID2, serviceIP2, true, port2, port4, port1, port3))
+
+			for j := 0; j < 2; j++ {
+				Expect(t.getNonHeadlessDNSRecord(namespace1, service1, "").Ports).To(Equal(sortedPorts))
+			}
+		}
+	})
+
+	It("should not reorder a cluster's own ports", func() {
+		t.putEndpointSlice(newClusterIPEndpointSlice(namespace1, service1, clusterID1, serviceIP1, true, port3, port1, port4, port2))
+
+		Expect(t.getNonHeadlessDNSRecord(namespace1, service1, "").Ports).To(Equal(sortedPorts))
+		Expect(t.getNonHeadlessDNSRecord(namespace1, service1, clusterID1).Ports).To(Equal(
+			[]mcsv1a1.ServicePort{port3, port1, port4, port2}))
+	})
+})
+
+var _ = Describe("Port merge strategy", func() {
+	fakeClock := testingclock.NewFakeClock(time.Now())
+	t := newTestDriver(resolver.WithClock(fakeClock))
+
+	var serviceImport *mcsv1a1.ServiceImport
+
+	BeforeEach(func() {
+		serviceImport = newAggregatedServiceImport(namespace1, service1)
+	})
+
+	JustBeforeEach(func() {
+		t.resolver.PutServiceImport(serviceImport)
+
+		t.putEndpointSlice(newClusterIPEndpointSlice(namespace1, service1, clusterID2, serviceIP2, true, port1, port3))
+		fakeClock.Step(time.Second)
+		t.putEndpointSlice(newClusterIPEndpointSlice(namespace1, service1, clusterID1, serviceIP1, true, port1, port2))
+	})
+
+	setPortMerge := func(strategy string) {
+		serviceImport.Annotations = map[string]string{constants.PortMergeAnnotation: strategy}
+	}
+
+	mergedPorts := func() []mcsv1a1.ServicePort {
+		return t.getNonHeadlessDNSRecord(namespace1, service1, "").Ports
+	}
+
+	addDisjointCluster := func() {
+		t.putEndpointSlice(newClusterIPEndpointSlice(namespace1, service1, clusterID3, serviceIP3, true, port4))
+	}
+
+	When("not specified", func() {
+		It("should intersect the ports", func() {
+			Expect(mergedPorts()).To(Equal([]mcsv1a1.ServicePort{port1}))
+
+			addDisjointCluster()
+			Expect(mergedPorts()).To(BeEmpty())
+		})
+	})
+
+	When("intersection is specified", func() {
+		BeforeEach(func() {
+			setPortMerge(constants.PortMergeIntersection)
+		})
+
+		It("should intersect the ports", func() {
+			Expect(mergedPorts()).To(Equal([]mcsv1a1.ServicePort{port1}))
+		})
+	})
+
+	When("union is specified", func() {
+		BeforeEach(func() {
+			setPortMerge(constants.PortMergeUnion)
+		})
+
+		It("should include the ports of every cluster once", func() {
+			Expect(mergedPorts()).To(Equal([]mcsv1a1.ServicePort{port2, port1, port3}))
+
+			addDisjointCluster()
+			Expect(mergedPorts()).To(Equal([]mcsv1a1.ServicePort{port2, port4, port1, port3}))
+		})
+	})
+
+	When("first is specified", func() {
+		BeforeEach(func() {
+			setPortMerge(constants.PortMergeFirst)
+		})
+
+		It("should use the ports of the first cluster to join", func() {
+			Expect(mergedPorts()).To(Equal([]mcsv1a1.ServicePort{port1, port3}))
+
+			addDisjointCluster()
+			Expect(mergedPorts()).To(Equal([]mcsv1a1.ServicePort{port1, port3}))
+		})
+
+		Context("and the first cluster is removed", func() {
+			It("should use the ports of the next cluster to join", func() {
+				t.resolver.RemoveEndpointSlice(newClusterIPEndpointSlice(namespace1, service1, clusterID2, serviceIP2, true))
+				Expect(mergedPorts()).To(Equal([]mcsv1a1.ServicePort{port2, port1}))
+			})
+		})
+	})
+
+	When("the strategy is changed", func() {
+		It("should merge the ports again", func() {
+			Expect(mergedPorts()).To(Equal([]mcsv1a1.ServicePort{port1}))
+
+			setPortMerge(constants.PortMergeUnion)
+			t.resolver.PutServiceImport(serviceImport)
+
+			Expect(mergedPorts()).To(Equal([]mcsv1a1.ServicePort{port2, port1, port3}))
+		})
+	})
+
+	When("the strategy is invalid", func() {
+		BeforeEach(func() {
+			setPortMerge("bogus")
+		})
+
+		It("should intersect the ports", func() {
+			Expect(mergedPorts()).To(Equal([]mcsv1a1.ServicePort{port1}))
+		})
+	})
+})
+
+func (t *testDriver) getPortsVersion() uint64 {
+	version, found := t.resolver.PortsVersion(namespace1, service1)
+	Expect(found).To(BeTrue())
+
+	return version
+}
+
+type portsEmptyEvent struct {
+	namespace string
+	name      string
+	empty     bool
+}
+
+var _ = Describe("Ports empty callback", func() {
+	var events []portsEmptyEvent
+
+	t := newTestDriver(resolver.WithPortsEmptyCallback(func(namespace, name string, empty bool) {
+		events = append(events, portsEmptyEvent{namespace: namespace, name: name, empty: empty})
+	}))
+
+	BeforeEach(func() {
+		events = nil
+
+		t.resolver.PutServiceImport(newAggregatedServiceImport(namespace1, service1))
+		t.putEndpointSlice(newClusterIPEndpointSlice(namespace1, service1, clusterID1, serviceIP1, true, port1, port2))
+		t.putEndpointSlice(newClusterIPEndpointSlice(namespace1, service1, clusterID2, serviceIP2, true, port1))
+	})
+
+	It("should not be invoked while the merged ports are non-empty", func() {
+		Expect(events).To(BeEmpty())
+	})
+
+	When("the merged ports become empty", func() {
+		BeforeEach(func() {
+			t.putEndpointSlice(newClusterIPEndpointSlice(namespace1, service1, clusterID2, serviceIP2, true, port3))
+		})
+
+		It("should be invoked with the service identity", func() {
+			Expect(events).To(Equal([]portsEmptyEvent{{namespace: namespace1, name: service1, empty: true}}))
+		})
+
+		Context("and remain empty", func() {
+			BeforeEach(func() {
+				t.putEndpointSlice(newClusterIPEndpointSlice(namespace1, service1, clusterID2, serviceIP2, true, port4))
+			})
+
+			It("should not be invoked again", func() {
+				Expect(events).To(HaveLen(1))
+			})
+		})
+
+		Context("and then recover", func() {
+			BeforeEach(func() {
+				t.resolver.RemoveEndpointSlice(newClusterIPEndpointSlice(namespace1, service1, clusterID2, serviceIP2, true, port3))
+			})
+
+			It("should be invoked for the recovery", func() {
+				Expect(events).To(Equal([]portsEmptyEvent{
+					{namespace: namespace1, name: service1, empty: true},
+					{namespace: namespace1, name: service1, empty: false},
+				}))
+			})
+		})
+	})
+
+	When("all the clusters are removed", func() {
+		BeforeEach(func() {
+			t.resolver.RemoveEndpointSlice(newClusterIPEndpointSlice(namespace1, service1, clusterID1, serviceIP1, true))
+			t.resolver.RemoveEndpointSlice(newClusterIPEndpointSlice(namespace1, service1, clusterID2, serviceIP2, true))
+		})
+
+		It("should be invoked", func() {
+			Expect(events).To(Equal([]portsEmptyEvent{{namespace: namespace1, name: service1, empty: true}}))
+		})
+	})
+
+	When("a new service is added", func() {
+		BeforeEach(func() {
+			t.resolver.PutServiceImport(newAggregatedServiceImport(namespace2, service1))
+			t.putEndpointSlice(newClusterIPEndpointSlice(namespace2, service1, clusterID1, serviceIP1, true, port1))
+		})
+
+		It("should not be invoked", func() {
+			Expect(events).To(BeEmpty())
+		})
+	})
+})
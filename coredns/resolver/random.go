@@ -0,0 +1,80 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+
+Copyright Contributors to the Submariner project.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package resolver
+
+import (
+	"math/rand"
+	"sync"
+)
+
+// randomSource draws the random numbers used for load balancing. Selections are made with only the read lock held so
+// the seeded source, which isn't safe for concurrent use, is guarded by a mutex. A nil source draws from the global
+// source, which is the default, see WithRandomSeed.
+type randomSource struct {
+	mutex sync.Mutex
+	rand  *rand.Rand
+}
+
+func newRandomSource(seed int64) *randomSource {
+	return &randomSource{rand: rand.New(rand.NewSource(seed))} //nolint:gosec // Cryptographically secure randomness isn't needed here
+}
+
+func (r *randomSource) Int63() int64 {
+	if r == nil {
+		return rand.Int63() //nolint:gosec // Cryptographically secure randomness isn't needed here
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	return r.rand.Int63()
+}
+
+func (r *randomSource) Int63n(n int64) int64 {
+	if r == nil {
+		return rand.Int63n(n) //nolint:gosec // Cryptographically secure randomness isn't needed here
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	return r.rand.Int63n(n)
+}
+
+func (r *randomSource) Intn(n int) int {
+	if r == nil {
+		return rand.Intn(n) //nolint:gosec // Cryptographically secure randomness isn't needed here
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	return r.rand.Intn(n)
+}
+
+func (r *randomSource) Float64() float64 {
+	if r == nil {
+		return rand.Float64() //nolint:gosec // Cryptographically secure randomness isn't needed here
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	return r.rand.Float64()
+}
@@ -19,70 +19,876 @@ limitations under the License.
 package resolver
 
 import (
+	"context"
+	"fmt"
+	"math/rand"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/submariner-io/lighthouse/coredns/constants"
+	"github.com/submariner-io/lighthouse/coredns/loadbalancer"
+	corev1 "k8s.io/api/core/v1"
+	discovery "k8s.io/api/discovery/v1"
+	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/client-go/dynamic"
+	"k8s.io/utils/clock"
 )
 
-func New(clusterStatus ClusterStatus, client dynamic.Interface) *Interface {
-	return &Interface{
+// resolutionQueueSize is the maximum number of resolution outcomes queued for the resolution sink.
+const resolutionQueueSize = 1024
+
+// defaultMaxWeight is the maximum load balancing weight of a cluster unless configured via WithMaxWeight.
+const defaultMaxWeight = 1000
+
+func New(clusterStatus ClusterStatus, client dynamic.Interface, opts ...Option) *Interface {
+	i := &Interface{
 		clusterStatus: clusterStatus,
-		serviceMap:    make(map[string]*serviceInfo),
+		shards:        newServiceShards(serviceShardCount),
+		servicesByIP:  make(map[string]map[string]int),
 		client:        client,
+		clock:         clock.RealClock{},
+		traceLogger:   logger.Logger,
+		newBalancer:   loadbalancer.NewSmoothWeightedRR,
+		maxWeight:     defaultMaxWeight,
+	}
+
+	for _, opt := range opts {
+		opt(i)
 	}
+
+	i.balancerRetry.mutex = &i.mutex
+	i.localWeight.cluster = i.getLocalClusterID
+
+	if i.resolutionSink != nil {
+		i.resolutions = make(chan ResolutionOutcome, resolutionQueueSize)
+		go i.drainResolutions()
+	}
+
+	return i
 }
 
 func (i *Interface) GetDNSRecords(namespace, name, clusterID, hostname string) (records []DNSRecord, isHeadless bool, found bool) {
-	i.mutex.RLock()
-	defer i.mutex.RUnlock()
+	records, isHeadless, status := i.lookup(namespace, name, clusterID, hostname, nil)
+	return records, isHeadless, status != LookupNotFound
+}
 
-	serviceInfo, found := i.serviceMap[keyFunc(namespace, name)]
+// GetDNSRecordsForClient behaves like GetDNSRecords but, for a ClusterIP service with the consistent hash load balancing
+// policy, consistently selects the same cluster for the given client IP while that cluster remains selectable. For a
+// ClusterIP service with client IP session affinity, the client sticks to its selected cluster until the affinity
+// timeout elapses.
+func (i *Interface) GetDNSRecordsForClient(namespace, name, clusterID, hostname, clientIP string,
+) (records []DNSRecord, isHeadless bool, found bool) {
+	records, isHeadless, status := i.LookupDNSRecords(namespace, name, clusterID, hostname, clientIP)
+	return records, isHeadless, status != LookupNotFound
+}
+
+// LookupDNSRecords behaves like GetDNSRecordsForClient but reports why no records were returned, so callers can
+// distinguish a non-existent service from one whose clusters are all unhealthy, eg to answer NXDOMAIN or SERVFAIL
+// respectively.
+func (i *Interface) LookupDNSRecords(namespace, name, clusterID, hostname, clientIP string,
+) (records []DNSRecord, isHeadless bool, status LookupStatus) {
+	return i.LookupDNSRecordsOfAddressType(namespace, name, clusterID, hostname, clientIP, "")
+}
+
+// LookupDNSRecordsOfAddressType behaves like LookupDNSRecords but only considers records of the given address type, eg
+// IPv6 to answer an AAAA query. For a ClusterIP service, only clusters with a service IP of the type are selected, so
+// if there are none, LookupEmpty is reported rather than returning a record of the other type. An empty address type
+// considers any record.
+func (i *Interface) LookupDNSRecordsOfAddressType(namespace, name, clusterID, hostname, clientIP string,
+	addressType discovery.AddressType,
+) (records []DNSRecord, isHeadless bool, status LookupStatus) {
+	return i.lookup(namespace, name, clusterID, hostname, func(_ *serviceInfo) *selectionFilter {
+		return &selectionFilter{clientIP: clientIP, addressType: addressType}
+	})
+}
+
+// lookup looks up the given service's records per GetDNSRecords, restricting the selection of a ClusterIP service's
+// cluster and a headless service's records by the filter that newFilter, if any, returns for the service. All of the
+// lookup variants go through here so they consult the negative cache and report a missing service alike.
+func (i *Interface) lookup(namespace, name, clusterID, hostname string, newFilter func(*serviceInfo) *selectionFilter,
+) (records []DNSRecord, isHeadless bool, status LookupStatus) {
+	if i.isRecentlyMissed(namespace, name) {
+		return nil, false, LookupNotFound
+	}
+
+	key, shard := i.rlockService(namespace, name)
+	defer i.runlockShard(shard)
+
+	serviceInfo, found := i.findService(shard, key)
 	if !found {
-		return nil, false, false
+		i.cacheMiss(namespace, name)
+		return nil, false, LookupNotFound
 	}
 
-	if !serviceInfo.isHeadless {
-		record, found := i.getClusterIPRecord(serviceInfo, clusterID)
+	var filter *selectionFilter
+	if newFilter != nil {
+		filter = newFilter(serviceInfo)
+	}
+
+	if serviceInfo.isHeadless {
+		records, found = i.getHeadlessRecords(serviceInfo, clusterID, hostname)
+		records = filter.recordsAllowed(records)
+	} else {
+		var record *DNSRecord
+
+		record, found, _ = i.selectClusterIPRecord(namespace, name, key, serviceInfo, clusterID, filter)
 		if record != nil {
-			return []DNSRecord{*record}, false, true
+			records = []DNSRecord{*record}
 		}
+	}
 
-		return nil, false, found
+	switch {
+	case !found:
+		status = LookupNotFound
+	case len(records) > 0:
+		status = LookupFound
+	case !serviceInfo.isHeadless && i.isUnhealthy(serviceInfo):
+		status = LookupUnhealthy
+	default:
+		status = LookupEmpty
 	}
 
-	records, found = i.getHeadlessRecords(serviceInfo, clusterID, hostname)
-	return records, true, found
+	return records, serviceInfo.isHeadless, status
 }
 
-func (i *Interface) getClusterIPRecord(serviceInfo *serviceInfo, clusterID string) (*DNSRecord, bool) {
-	// If a clusterID is specified, we supply it even if the service is not healthy.
-	if clusterID != "" {
-		clusterInfo, found := serviceInfo.clusters[clusterID]
-		if !found {
-			return nil, false
+// isUnhealthy returns whether the given service has connected clusters and none of them are serving.
+func (i *Interface) isUnhealthy(serviceInfo *serviceInfo) bool {
+	connected := false
+
+	for name, info := range serviceInfo.clusters {
+		if !i.clusterStatus.IsConnected(name) {
+			continue
+		}
+
+		if info.isServing() {
+			return false
 		}
 
-		return &clusterInfo.endpointRecords[0], true
+		connected = true
+	}
+
+	return connected
+}
+
+// GetDualStackDNSRecords selects a single cluster for a ClusterIP service, in the same manner as GetDNSRecords, and
+// returns its IPv4 and IPv6 records. Either may be nil if the selected cluster is single-stack.
+func (i *Interface) GetDualStackDNSRecords(namespace, name, clusterID string) (v4, v6 *DNSRecord, found bool) {
+	key, shard := i.rlockService(namespace, name)
+	defer i.runlockShard(shard)
+
+	serviceInfo, found := i.findService(shard, key)
+	if !found || serviceInfo.isHeadless {
+		return nil, nil, false
+	}
+
+	record, found, _ := i.selectClusterIPRecord(namespace, name, key, serviceInfo, clusterID, nil)
+	if record == nil {
+		return nil, nil, found
+	}
+
+	clusterInfo := serviceInfo.clusters[record.ClusterName]
+
+	if r := clusterInfo.recordOfAddressType(discovery.AddressTypeIPv4); r != nil {
+		v4 = serviceInfo.newRecordFrom(r).clone()
+	}
+
+	if r := clusterInfo.recordOfAddressType(discovery.AddressTypeIPv6); r != nil {
+		v6 = serviceInfo.newRecordFrom(r).clone()
+	}
+
+	return v4, v6, true
+}
+
+// GetDNSRecordsOfAddressType behaves like GetDNSRecords but only returns records whose IP is of the given address type.
+// For a ClusterIP service, clusters lacking a service IP of that type are skipped when selecting a cluster.
+func (i *Interface) GetDNSRecordsOfAddressType(namespace, name, clusterID, hostname string, addressType discovery.AddressType,
+) (records []DNSRecord, isHeadless bool, found bool) {
+	records, isHeadless, status := i.lookup(namespace, name, clusterID, hostname, func(_ *serviceInfo) *selectionFilter {
+		return &selectionFilter{addressType: addressType}
+	})
+
+	return records, isHeadless, status != LookupNotFound
+}
+
+// Resolve selects a cluster for a ClusterIP service, in the same manner as GetDNSRecords, and returns the structured
+// result, including which cluster was selected and why, eg for tracing. The cache key is derived from the service's
+// version, which is incremented on every change to its state, and the selected cluster and address family.
+func (i *Interface) Resolve(namespace, name, clusterID string) (*Resolution, bool) {
+	key, shard := i.rlockService(namespace, name)
+	defer i.runlockShard(shard)
+
+	serviceInfo, found := i.findService(shard, key)
+	if !found || serviceInfo.isHeadless {
+		return nil, false
+	}
+
+	record, found, reason := i.selectClusterIPRecord(namespace, name, key, serviceInfo, clusterID, nil)
+	if !found {
+		return nil, false
+	}
+
+	resolution := &Resolution{Reason: reason}
+
+	if record != nil {
+		resolution.Record = record.clone()
+		resolution.Cluster = record.ClusterName
+		resolution.CacheKey = fmt.Sprintf("%s/%d/%s/%s", key, serviceInfo.version, record.ClusterName, addressTypeOf(record.IP))
+	} else {
+		resolution.CacheKey = fmt.Sprintf("%s/%d", key, serviceInfo.version)
+	}
+
+	return resolution, true
+}
+
+// GetDNSRecordsMatching behaves like GetDNSRecords but only considers the clusters whose labels, per the
+// "lighthouse.submariner.io/serviceimport.cluster-labels/<cluster>" annotations, match the given selector.
+func (i *Interface) GetDNSRecordsMatching(namespace, name, clusterID, hostname string, selector labels.Selector,
+) (records []DNSRecord, isHeadless bool, found bool) {
+	records, isHeadless, status := i.lookup(namespace, name, clusterID, hostname, func(serviceInfo *serviceInfo) *selectionFilter {
+		return &selectionFilter{selector: selector, clusterLabels: serviceInfo.clusterLabels}
+	})
+
+	return records, isHeadless, status != LookupNotFound
+}
+
+// GetDNSRecordsExcluding behaves like GetDNSRecords but never considers the clusters in the given exclusion set, eg
+// those under maintenance.
+func (i *Interface) GetDNSRecordsExcluding(namespace, name, clusterID, hostname string, exclude map[string]bool,
+) (records []DNSRecord, isHeadless bool, found bool) {
+	records, isHeadless, status := i.lookup(namespace, name, clusterID, hostname, func(_ *serviceInfo) *selectionFilter {
+		return &selectionFilter{exclude: exclude}
+	})
+
+	return records, isHeadless, status != LookupNotFound
+}
+
+// GetDNSRecordsForPort behaves like GetDNSRecords but only considers the clusters, or for a headless service the
+// records, that expose a port with the given name and protocol, eg for an SRV query scoped to a named port. An empty
+// name or protocol matches any. If the service exists but no cluster exposes the port, found is true with no records.
+func (i *Interface) GetDNSRecordsForPort(namespace, name, clusterID, hostname, portName string, protocol corev1.Protocol,
+) (records []DNSRecord, isHeadless bool, found bool) {
+	records, isHeadless, status := i.lookup(namespace, name, clusterID, hostname, func(_ *serviceInfo) *selectionFilter {
+		return &selectionFilter{portName: portName, protocol: protocol}
+	})
+
+	return records, isHeadless, status != LookupNotFound
+}
+
+// GetDNSRecordPreferred returns the record of the first cluster of the given ClusterIP service, in the given preference
+// order, that's connected and healthy and passes the given endpoint check, if any, eg to fail over across clusters in a
+// fixed order regardless of their weights. If none qualifies, a cluster that passes the endpoint check is selected as
+// per GetDNSRecords. A cluster the service is pinned to via Pin takes precedence. The record is nil if none is available
+// or the service is headless.
+func (i *Interface) GetDNSRecordPreferred(namespace, name string, order []string,
+	checkEndpoint func(namespace, name, clusterID string) bool,
+) (*DNSRecord, bool) {
+	records, isHeadless, status := i.lookup(namespace, name, "", "", func(serviceInfo *serviceInfo) *selectionFilter {
+		if serviceInfo.isHeadless {
+			return nil
+		}
+
+		return &selectionFilter{
+			preferred:    order,
+			checkCluster: checkClusters(namespace, name, serviceInfo, EndpointsCheckFrom(checkEndpoint)),
+		}
+	})
+
+	if isHeadless || len(records) == 0 {
+		return nil, status != LookupNotFound
+	}
+
+	return records[0].clone(), true
+}
+
+// GetDNSRecordsCheckingEndpoints behaves like GetDNSRecords but only considers the clusters that pass the given
+// endpoint check, if any. The check is invoked once per lookup with all of the service's clusters, rather than once per
+// candidate cluster, so an expensive check, eg one backed by a cache or API, can be batched.
+func (i *Interface) GetDNSRecordsCheckingEndpoints(namespace, name, clusterID, hostname string, checkEndpoints EndpointsCheck,
+) (records []DNSRecord, isHeadless bool, found bool) {
+	records, isHeadless, status := i.lookup(namespace, name, clusterID, hostname, func(serviceInfo *serviceInfo) *selectionFilter {
+		return &selectionFilter{checkCluster: checkClusters(namespace, name, serviceInfo, checkEndpoints)}
+	})
+
+	return records, isHeadless, status != LookupNotFound
+}
+
+// GetDNSRecordsContext behaves like GetDNSRecordsCheckingEndpoints but invokes the given per-cluster endpoint check, if
+// any, with the given context. The checks are made without holding any lock and the lookup is aborted once the context
+// is done, returning its error, eg context.DeadlineExceeded, so a slow check is distinguishable from the service not
+// being found.
+func (i *Interface) GetDNSRecordsContext(ctx context.Context, namespace, name, clusterID, hostname string,
+	checkEndpoint ContextEndpointCheck,
+) (records []DNSRecord, isHeadless bool, found bool, err error) {
+	if checkEndpoint == nil {
+		records, isHeadless, found = i.GetDNSRecordsCheckingEndpoints(namespace, name, clusterID, hostname, nil)
+		return records, isHeadless, found, nil
+	}
+
+	clusterIDs, found := i.clusterNamesOf(namespace, name)
+	if !found {
+		return nil, false, false, nil
+	}
+
+	results, err := checkEndpointsContext(ctx, namespace, name, clusterIDs, checkEndpoint)
+	if err != nil {
+		return nil, false, true, err
+	}
+
+	records, isHeadless, found = i.GetDNSRecordsCheckingEndpoints(namespace, name, clusterID, hostname,
+		func(_, _ string, _ []string) map[string]bool {
+			return results
+		})
+
+	return records, isHeadless, found, nil
+}
+
+// clusterNamesOf returns the names of the given service's clusters, in name order.
+func (i *Interface) clusterNamesOf(namespace, name string) ([]string, bool) {
+	if i.isRecentlyMissed(namespace, name) {
+		return nil, false
+	}
+
+	key, shard := i.rlockService(namespace, name)
+	defer i.runlockShard(shard)
+
+	serviceInfo, found := shard.services[key]
+	if !found {
+		i.cacheMiss(namespace, name)
+		return nil, false
+	}
+
+	return serviceInfo.clusterNames(), true
+}
+
+// checkEndpointsContext invokes the given check for each of the given clusters in turn and returns the results, or the
+// context's error as soon as it's done, even if a check is still in progress.
+func checkEndpointsContext(ctx context.Context, namespace, name string, clusterIDs []string, checkEndpoint ContextEndpointCheck,
+) (map[string]bool, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	done := make(chan map[string]bool, 1)
+
+	go func() {
+		results := make(map[string]bool, len(clusterIDs))
+
+		for _, clusterID := range clusterIDs {
+			if ctx.Err() != nil {
+				break
+			}
+
+			results[clusterID] = checkEndpoint(ctx, namespace, name, clusterID)
+		}
+
+		done <- results
+	}()
+
+	select {
+	case results := <-done:
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		return results, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// EndpointsCheckFrom returns an EndpointsCheck that invokes the given per-cluster check for each cluster, or nil if the
+// check is nil.
+func EndpointsCheckFrom(checkEndpoint func(namespace, name, clusterID string) bool) EndpointsCheck {
+	if checkEndpoint == nil {
+		return nil
+	}
+
+	return func(namespace, name string, clusterIDs []string) map[string]bool {
+		results := make(map[string]bool, len(clusterIDs))
+
+		for _, clusterID := range clusterIDs {
+			results[clusterID] = checkEndpoint(namespace, name, clusterID)
+		}
+
+		return results
+	}
+}
+
+// checkClusters invokes the given check once for all of the given service's clusters, in name order, and returns a
+// predicate over the results for selection to use. A nil check returns a nil predicate.
+func checkClusters(namespace, name string, serviceInfo *serviceInfo, checkEndpoints EndpointsCheck) func(string) bool {
+	if checkEndpoints == nil {
+		return nil
+	}
+
+	results := checkEndpoints(namespace, name, serviceInfo.clusterNames())
+
+	return func(clusterID string) bool {
+		return results[clusterID]
+	}
+}
+
+// GetAllRecords returns the records of the given service regardless of its type, ie the records of every connected
+// cluster of a headless service or the single selected record of a ClusterIP service. Either way, only the clusters
+// that pass the given endpoint check, if any, are considered.
+func (i *Interface) GetAllRecords(namespace, name string, checkEndpoint func(namespace, name, clusterID string) bool,
+) ([]DNSRecord, bool) {
+	records, _, status := i.lookup(namespace, name, "", "", func(serviceInfo *serviceInfo) *selectionFilter {
+		return &selectionFilter{checkCluster: checkClusters(namespace, name, serviceInfo, EndpointsCheckFrom(checkEndpoint))}
+	})
+
+	return records, status != LookupNotFound
+}
+
+// GetHostnameRecord returns the record of the endpoint with the given hostname of the given headless service, eg a
+// StatefulSet pod, searching the connected clusters that pass the given endpoint check, if any, in name order. Returns
+// false if no such record is found or the service isn't headless.
+func (i *Interface) GetHostnameRecord(namespace, name, hostname string, checkEndpoint func(namespace, name, clusterID string) bool,
+) (*DNSRecord, bool) {
+	key, shard := i.rlockService(namespace, name)
+	defer i.runlockShard(shard)
+
+	serviceInfo, found := i.findService(shard, key)
+	if !found || !serviceInfo.isHeadless || hostname == "" {
+		return nil, false
+	}
+
+	for _, clusterID := range serviceInfo.clusterNames() {
+		records := serviceInfo.clusters[clusterID].endpointRecordsByHost[hostname]
+		if len(records) == 0 || !i.clusterStatus.IsConnected(clusterID) {
+			continue
+		}
+
+		if checkEndpoint == nil || checkEndpoint(namespace, name, clusterID) {
+			record := records[0]
+			return &record, true
+		}
+	}
+
+	return nil, false
+}
+
+// findService returns the info of the service with the given key in the given shard and counts the lookup if it
+// exists. The caller must hold the shard's lock for reading, see rlockService.
+func (i *Interface) findService(shard *serviceShard, key string) (*serviceInfo, bool) {
+	serviceInfo, found := shard.services[key]
+	if found {
+		atomic.AddUint64(&serviceInfo.queryCount, 1)
+	}
+
+	return serviceInfo, found
+}
+
+// selectClusterIPRecord selects the record of a ClusterIP service found by findService and reports and traces the
+// resolution. The caller must hold the read lock.
+func (i *Interface) selectClusterIPRecord(namespace, name, key string, serviceInfo *serviceInfo, clusterID string,
+	filter *selectionFilter,
+) (*DNSRecord, bool, ResolutionReason) {
+	start := time.Now()
+
+	record, found, reason := i.getClusterIPRecord(serviceInfo, clusterID, filter)
+	latency := time.Since(start)
+
+	i.reportResolution(namespace, name, record, reason, latency)
+	i.traceResolution(key, serviceInfo, record, reason, latency)
+
+	return record, found, reason
+}
+
+func (i *Interface) getClusterIPRecord(serviceInfo *serviceInfo, clusterID string, filter *selectionFilter,
+) (*DNSRecord, bool, ResolutionReason) {
+	record, found, reason, handled := i.getRequestedClusterRecord(serviceInfo, i.normalizeClusterName(clusterID), filter)
+	if handled {
+		return record, found, reason
+	}
+
+	if record = i.selectPreferred(serviceInfo, filter); record != nil {
+		return serviceInfo.newRecordFrom(record), true, ResolvedPreferred
 	}
 
 	// If we are aware of the local cluster and we found some accessible IP, we shall return it.
-	localClusterID := i.clusterStatus.GetLocalClusterID()
+	localClusterID := i.getLocalClusterID()
+	localFound := false
+
 	if localClusterID != "" {
-		clusterInfo, found := serviceInfo.clusters[localClusterID]
-		if found && clusterInfo.endpointsHealthy {
-			return serviceInfo.newRecordFrom(&clusterInfo.endpointRecords[0]), true
+		var clusterInfo *clusterInfo
+
+		clusterInfo, localFound = serviceInfo.clusters[localClusterID]
+		if localFound && !serviceInfo.ignoreLocal && clusterInfo.isServing() && filter.allows(clusterInfo) &&
+			serviceInfo.acquire(localClusterID) {
+			atomic.AddInt64(&serviceInfo.localSelections, 1)
+
+			return serviceInfo.newRecordFrom(filter.recordFrom(clusterInfo)), true, ResolvedLocal
+		}
+	}
+
+	isSelectable := func(name string) bool {
+		return i.clusterStatus.IsConnected(name) && filter.allows(serviceInfo.clusters[name])
+	}
+
+	switch serviceInfo.onAllDrained {
+	case constants.OnAllDrainedReturnEmpty, constants.OnAllDrainedServeLeastDrained:
+		if drained := serviceInfo.drainedClusters(isSelectable); drained != nil {
+			return i.selectFromDrained(serviceInfo, drained, localClusterID, localFound)
 		}
 	}
 
 	// Fall back to selected load balancer (weighted/RR/etc) if service is not present in the local cluster
-	record := serviceInfo.selectIP(i.clusterStatus.IsConnected)
+	record = i.selectWithAffinity(serviceInfo, isSelectable, filter.hashKey())
+	if record != nil {
+		serviceInfo.markSelected(record.ClusterName, i.clock.Now())
+
+		serviceInfo.countSelection(localClusterID, localFound, record.ClusterName)
+
+		return serviceInfo.newRecordFrom(filter.recordFrom(serviceInfo.clusters[record.ClusterName])), true, ResolvedBalanced
+	}
+
+	if serviceInfo.serveLastResort {
+		if record = i.selectLastResort(serviceInfo, filter); record != nil {
+			return serviceInfo.newRecordFrom(record), true, ResolvedLastResort
+		}
+	}
+
+	return nil, true, ResolvedNone
+}
+
+// selectFromDrained applies the service's "lighthouse.submariner.io/on-all-drained" policy to the given drained
+// clusters, ie returns no record or that of the available drained cluster with the highest configured weight, with ties
+// broken by name.
+func (i *Interface) selectFromDrained(serviceInfo *serviceInfo, drained []string, localClusterID string, localFound bool,
+) (*DNSRecord, bool, ResolutionReason) {
+	if serviceInfo.onAllDrained != constants.OnAllDrainedServeLeastDrained {
+		return nil, true, ResolvedNone
+	}
+
+	sort.SliceStable(drained, func(j, k int) bool {
+		return serviceInfo.clusters[drained[j]].weight > serviceInfo.clusters[drained[k]].weight
+	})
+
+	for _, name := range drained {
+		if serviceInfo.acquire(name) {
+			serviceInfo.markSelected(name, i.clock.Now())
+			serviceInfo.countSelection(localClusterID, localFound, name)
+
+			return serviceInfo.newRecordFrom(&serviceInfo.clusters[name].endpointRecords[0]), true, ResolvedLeastDrained
+		}
+	}
+
+	return nil, true, ResolvedNone
+}
+
+// selectLastResort returns the record of the connected cluster with the highest weight, with ties broken by name, that
+// the filter allows regardless of the health of its endpoints and the filter's endpoint check, or nil if none.
+func (i *Interface) selectLastResort(serviceInfo *serviceInfo, filter *selectionFilter) *DNSRecord {
+	var unchecked *selectionFilter
+
+	if filter != nil {
+		f := *filter
+		f.checkCluster = nil
+		unchecked = &f
+	}
+
+	var best *clusterInfo
+
+	for _, name := range serviceInfo.clusterNames() {
+		info := serviceInfo.clusters[name]
+		if !i.clusterStatus.IsConnected(name) || !unchecked.allows(info) || unchecked.recordFrom(info) == nil {
+			continue
+		}
+
+		if best == nil || info.weight > best.weight {
+			best = info
+		}
+	}
+
+	if best == nil {
+		return nil
+	}
+
+	return unchecked.recordFrom(best)
+}
+
+// getRequestedClusterRecord returns the record of the requested cluster, if specified, else of the cluster the service
+// is pinned to, if any. Either is supplied even if the cluster is not healthy. A requested cluster's record carries the
+// cluster's own ports rather than the merged ports, as the client addressed that cluster specifically. The returned
+// handled flag indicates whether the resolution is complete.
+func (i *Interface) getRequestedClusterRecord(serviceInfo *serviceInfo, clusterID string, filter *selectionFilter,
+) (record *DNSRecord, found bool, reason ResolutionReason, handled bool) {
+	if clusterID == "" {
+		clusterInfo, isPinned := serviceInfo.clusters[serviceInfo.pinnedCluster]
+		if isPinned && serviceInfo.pinnedCluster != "" && filter.allows(clusterInfo) {
+			return serviceInfo.newRecordFrom(filter.recordFrom(clusterInfo)), true, ResolvedPinned, true
+		}
+
+		return nil, false, ResolvedNone, false
+	}
+
+	clusterInfo, exists := serviceInfo.clusters[clusterID]
+	if exists && !i.isEvicted(clusterInfo) {
+		if !filter.allows(clusterInfo) {
+			return nil, true, ResolvedNone, true
+		}
+
+		return filter.recordFrom(clusterInfo), true, ResolvedClusterPinned, true
+	}
+
+	return nil, false, ResolvedNone, !i.requestedClusterFallback
+}
+
+// selectPreferred returns the record of the first cluster in the filter's preference order, if any, that's connected,
+// healthy and allowed by the filter.
+func (i *Interface) selectPreferred(serviceInfo *serviceInfo, filter *selectionFilter) *DNSRecord {
+	if filter == nil {
+		return nil
+	}
+
+	for _, name := range filter.preferred {
+		name = i.normalizeClusterName(name)
+
+		info, found := serviceInfo.clusters[name]
+		if found && info.isServing() && i.clusterStatus.IsConnected(name) && filter.allows(info) &&
+			serviceInfo.acquire(name) {
+			serviceInfo.markSelected(name, i.clock.Now())
+
+			return filter.recordFrom(info)
+		}
+	}
+
+	return nil
+}
+
+// selectBalanced selects a cluster other than via the local cluster preference. If a local region is configured, the
+// clusters in that region are tried first and the clusters in any region only once none of them can be selected.
+func (i *Interface) selectBalanced(serviceInfo *serviceInfo, isSelectable func(string) bool, hashKey string) *DNSRecord {
+	if i.localRegion != "" {
+		record := i.selectByCost(serviceInfo, serviceInfo.clustersInRegion(i.localRegion, isSelectable), hashKey)
+		if record != nil {
+			return record
+		}
+	}
+
+	return i.selectByCost(serviceInfo, isSelectable, hashKey)
+}
+
+// selectByCost selects one of the selectable clusters. If cost-aware selection is enabled, the clusters are tried in
+// ascending cost tiers so more expensive clusters are only selected once none of the cheaper ones can be.
+func (i *Interface) selectByCost(serviceInfo *serviceInfo, isSelectable func(string) bool, hashKey string) *DNSRecord {
+	if !i.costAwareSelection {
+		return i.selectFrom(serviceInfo, isSelectable, hashKey)
+	}
+
+	for _, cost := range serviceInfo.costTiers(isSelectable) {
+		if record := i.selectFrom(serviceInfo, serviceInfo.clustersCosting(cost, isSelectable), hashKey); record != nil {
+			return record
+		}
+	}
+
+	return nil
+}
+
+// selectFrom selects one of the selectable clusters, ie the cluster the hash key maps to if given and the load balancer
+// supports it, else an idle cluster if the recency boost is enabled, else a cluster from a random subset if enabled,
+// else the load balancer's cluster. The load balancer is also the fallback if no cluster in the subset could be
+// acquired.
+func (i *Interface) selectFrom(serviceInfo *serviceInfo, isSelectable func(string) bool, hashKey string) *DNSRecord {
+	if balancer, ok := serviceInfo.balancer.(loadbalancer.HashInterface); ok && hashKey != "" {
+		return serviceInfo.selectIPFor(balancer, hashKey, isSelectable)
+	}
+
+	record := i.selectIdleCluster(serviceInfo, isSelectable)
+
+	if record == nil && i.selectionSubset > 0 {
+		record = serviceInfo.selectFromSubset(i.selectionSubset, isSelectable)
+	}
+
+	if record == nil {
+		record = serviceInfo.selectIP(isSelectable, i.isRecentlySelected, i.maxCandidates)
+	}
+
+	return record
+}
+
+// isEvicted returns whether the given cluster's endpoints have been empty for longer than the configured eviction grace
+// period, in which case it's treated as absent by lookups until its endpoints return.
+func (i *Interface) isEvicted(info *clusterInfo) bool {
+	return i.emptyEndpointsEviction > 0 && !info.emptySince.IsZero() &&
+		!info.emptySince.After(i.clock.Now().Add(-i.emptyEndpointsEviction))
+}
+
+// mergePorts merges the ports of the given service's clusters and invokes the ports empty callback, if configured, when
+// the merged ports transition from non-empty to empty and when they subsequently recover. A warning is logged when the
+// clusters start advertising conflicting ports, see PortConflicts.
+func (i *Interface) mergePorts(key string, serviceInfo *serviceInfo) {
+	hadPorts := len(serviceInfo.ports) > 0
+	hadConflict := serviceInfo.portConflict
+
+	serviceInfo.mergePorts()
+
+	if serviceInfo.portConflict && !hadConflict {
+		logger.Warningf("The clusters backing service %q advertise conflicting ports - the merged ports are %v", key,
+			serviceInfo.ports)
+	}
+
+	isEmpty := len(serviceInfo.ports) == 0
+
+	switch {
+	case hadPorts && isEmpty:
+		serviceInfo.portsEmpty = true
+	case serviceInfo.portsEmpty && !isEmpty:
+		serviceInfo.portsEmpty = false
+	default:
+		return
+	}
+
+	if i.portsEmptyCallback != nil {
+		namespace, name, _ := strings.Cut(key, "/")
+		i.portsEmptyCallback(namespace, name, isEmpty)
+	}
+}
+
+// selectIdleCluster returns the record of the available cluster that has been idle the longest, if longer than the
+// recency boost idle period. A cluster that has never been selected is considered idle since it was added.
+func (i *Interface) selectIdleCluster(serviceInfo *serviceInfo, isSelectable func(string) bool) *DNSRecord {
+	if i.recencyBoostIdlePeriod <= 0 {
+		return nil
+	}
+
+	idleBefore := i.clock.Now().Add(-i.recencyBoostIdlePeriod)
+	candidates := []string{}
+	idleSince := map[string]time.Time{}
+
+	for name, info := range serviceInfo.clusters {
+		if serviceInfo.balancedWeights[name] <= 0 || !info.isServing() || !isSelectable(name) {
+			continue
+		}
+
+		since := info.addedAt
+		if lastSelected := atomic.LoadInt64(&info.lastSelected); lastSelected != 0 {
+			since = time.Unix(0, lastSelected)
+		}
+
+		if since.Before(idleBefore) {
+			candidates = append(candidates, name)
+			idleSince[name] = since
+		}
+	}
+
+	sort.Slice(candidates, func(x, y int) bool {
+		if !idleSince[candidates[x]].Equal(idleSince[candidates[y]]) {
+			return idleSince[candidates[x]].Before(idleSince[candidates[y]])
+		}
+
+		return candidates[x] < candidates[y]
+	})
+
+	for _, name := range candidates {
+		if serviceInfo.acquire(name) {
+			return &serviceInfo.clusters[name].endpointRecords[0]
+		}
+	}
+
+	return nil
+}
+
+// isRecentlySelected returns whether the cluster should be passed over due to the recency penalty.
+func (i *Interface) isRecentlySelected(info *clusterInfo) bool {
+	if i.recencyPenalty <= 0 || i.recencyPenaltyDecay <= 0 {
+		return false
+	}
+
+	lastSelected := atomic.LoadInt64(&info.lastSelected)
+	if lastSelected == 0 {
+		return false
+	}
+
+	elapsed := i.clock.Since(time.Unix(0, lastSelected))
+	if elapsed >= i.recencyPenaltyDecay {
+		return false
+	}
+
+	penalty := i.recencyPenalty * (1 - float64(elapsed)/float64(i.recencyPenaltyDecay))
+
+	return i.random.Float64() < penalty
+}
+
+func (i *Interface) getLocalClusterID() string {
+	if i.localClusterID != "" {
+		return i.normalizeClusterName(i.localClusterID)
+	}
+
+	return i.normalizeClusterName(i.clusterStatus.GetLocalClusterID())
+}
+
+// newBalancerFor returns a new load balancer for the given policy, as returned by getBalancerPolicyFrom, from the
+// loadbalancer registry. The default policy uses the configured balancer, see WithBalancer, as does a policy that isn't
+// registered. If a random seed is configured, the random policy draws from a source seeded from it.
+func (i *Interface) newBalancerFor(policy string) loadbalancer.Interface {
+	if policy == "" {
+		return i.newBalancer()
+	}
+
+	if policy == constants.LoadBalancerPolicyRandom && i.random != nil {
+		source := rand.NewSource(i.random.Int63()) //nolint:gosec // Cryptographically secure randomness isn't needed here
+		return loadbalancer.NewRandomFrom(source)
+	}
+
+	balancer, err := loadbalancer.New(policy)
+	if err != nil {
+		logger.Errorf(err, "Invalid %q annotation value - using the default policy", constants.LoadBalancerPolicyAnnotation)
+
+		return i.newBalancer()
+	}
+
+	return balancer
+}
+
+// normalizeClusterName returns the lowercased and trimmed cluster name if normalization is enabled.
+func (i *Interface) normalizeClusterName(name string) string {
+	if !i.normalizeClusterNames {
+		return name
+	}
+
+	return strings.ToLower(strings.TrimSpace(name))
+}
+
+func (i *Interface) isClusterHealthy(name string, info *clusterInfo) bool {
+	return i.clusterStatus.IsConnected(name) && info.isServing()
+}
+
+func (i *Interface) reportResolution(namespace, name string, record *DNSRecord, reason ResolutionReason, latency time.Duration) {
+	if i.resolutionSink == nil {
+		return
+	}
+
+	outcome := ResolutionOutcome{
+		Namespace: namespace,
+		Name:      name,
+		Reason:    reason,
+		Latency:   latency,
+	}
 
 	if record != nil {
-		return serviceInfo.newRecordFrom(record), true
+		outcome.Cluster = record.ClusterName
 	}
 
-	return nil, true
+	select {
+	case i.resolutions <- outcome:
+	default:
+		atomic.AddUint64(&i.droppedResolutions, 1)
+	}
+}
+
+// drainResolutions reports the queued resolution outcomes to the sink, in order.
+func (i *Interface) drainResolutions() {
+	for outcome := range i.resolutions {
+		i.resolutionSink.Report(outcome)
+	}
 }
 
 func (i *Interface) getHeadlessRecords(serviceInfo *serviceInfo, clusterID, hostname string) ([]DNSRecord, bool) {
+	clusterID = i.normalizeClusterName(clusterID)
 	clusterInfo, clusterFound := serviceInfo.clusters[clusterID]
 
 	switch {
@@ -109,3 +915,10 @@ func (i *Interface) getHeadlessRecords(serviceInfo *serviceInfo, clusterID, host
 func keyFunc(namespace, name string) string {
 	return namespace + "/" + name
 }
+
+// clusterHostName returns the host name of the given cluster's record of the ClusterIP service with the given key, in
+// the "<cluster>.<service>.<namespace>" form of the service's cluster-qualified DNS name.
+func clusterHostName(clusterID, key string) string {
+	namespace, name, _ := strings.Cut(key, "/")
+	return clusterID + "." + name + "." + namespace
+}
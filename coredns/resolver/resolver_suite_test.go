@@ -128,7 +128,7 @@ type testDriver struct {
 	serviceImports dynamic.NamespaceableResourceInterface
 }
 
-func newTestDriver() *testDriver {
+func newTestDriver(opts ...resolver.Option) *testDriver {
 	t := &testDriver{}
 
 	BeforeEach(func() {
@@ -141,7 +141,7 @@ func newTestDriver() *testDriver {
 		t.endpointSlices = client.Resource(*test.GetGroupVersionResourceFor(restMapper, &discovery.EndpointSlice{}))
 		t.serviceImports = client.Resource(*test.GetGroupVersionResourceFor(restMapper, &mcsv1a1.ServiceImport{}))
 
-		t.resolver = resolver.New(t.clusterStatus, client)
+		t.resolver = resolver.New(t.clusterStatus, client, opts...)
 		controller := resolver.NewController(t.resolver)
 
 		Expect(controller.Start(watcher.Config{
@@ -273,6 +273,19 @@ func (t *testDriver) testRoundRobin(ns, service string, serviceIPs ...string) {
 	}
 }
 
+func (t *testDriver) assertSelectionShares(ns, service string, rounds int, expShares map[string]float64) {
+	counts := map[string]int{}
+
+	for i := 0; i < rounds; i++ {
+		counts[t.getNonHeadlessDNSRecord(ns, service, "").ClusterName]++
+	}
+
+	for cluster, share := range expShares {
+		Expect(float64(counts[cluster])/float64(rounds)).To(BeNumerically("~", share, 0.02),
+			"Unexpected share for cluster %q - counts: %v", cluster, counts)
+	}
+}
+
 func (t *testDriver) putEndpointSlice(es *discovery.EndpointSlice) {
 	Expect(t.resolver.PutEndpointSlices(es)).To(BeFalse())
 }
@@ -289,6 +302,14 @@ func newAggregatedServiceImport(namespace, name string) *mcsv1a1.ServiceImport {
 	}
 }
 
+func setClusterWeight(si *mcsv1a1.ServiceImport, clusterID string, weight int64) {
+	if si.Annotations == nil {
+		si.Annotations = map[string]string{}
+	}
+
+	si.Annotations[constants.LoadBalancerWeightAnnotationPrefix+"/"+clusterID] = strconv.FormatInt(weight, 10)
+}
+
 func newHeadlessAggregatedServiceImport(namespace, name string) *mcsv1a1.ServiceImport {
 	si := newAggregatedServiceImport(namespace, name)
 	si.Spec.Type = mcsv1a1.Headless
@@ -342,3 +363,7 @@ func newEndpointSlice(namespace, name, clusterID string, ports []mcsv1a1.Service
 		Endpoints:   endpoints,
 	}
 }
+
+func clusterHostName(clusterID, namespace, name string) string {
+	return clusterID + "." + name + "." + namespace
+}
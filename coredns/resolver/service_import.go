@@ -19,7 +19,14 @@ limitations under the License.
 package resolver
 
 import (
-	"github.com/submariner-io/lighthouse/coredns/loadbalancer"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/submariner-io/lighthouse/coredns/constants"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
 	mcsv1a1 "sigs.k8s.io/mcs-api/pkg/apis/v1alpha1"
 )
 
@@ -28,43 +35,118 @@ func (i *Interface) PutServiceImport(serviceImport *mcsv1a1.ServiceImport) {
 		return
 	}
 
-	key, isLegacy := getServiceImportKey(serviceImport)
+	key, isLegacy, ok := getServiceImportKey(serviceImport)
+	if !ok {
+		logger.Warningf("Ignoring legacy ServiceImport %q whose source name or namespace can't be determined", serviceImport.Name)
+		return
+	}
+
+	if !isSupportedServiceImportType(serviceImport.Spec.Type) {
+		logger.Warningf("Ignoring ServiceImport %q with unsupported type %q", key, serviceImport.Spec.Type)
+		return
+	}
 
 	logger.Infof("Put ServiceImport %q", key)
 
-	i.mutex.Lock()
-	defer i.mutex.Unlock()
+	changed := false
+
+	defer func() {
+		if changed {
+			i.notifyClustersChanged(key)
+		}
+	}()
+
+	shard := i.lockShard(key)
+	defer i.unlockShard(shard)
+
+	i.forgetMissed(key)
 
-	svcInfo, found := i.serviceMap[key]
+	var policy string
+	if !isLegacy {
+		policy = getBalancerPolicyFrom(serviceImport)
+	}
+
+	svcInfo, found := shard.services[key]
 
 	if !found {
 		svcInfo = &serviceInfo{
-			clusters:   make(map[string]*clusterInfo),
-			balancer:   loadbalancer.NewSmoothWeightedRR(),
-			isHeadless: serviceImport.Spec.Type == mcsv1a1.Headless,
+			clusters:       make(map[string]*clusterInfo),
+			balancer:       i.newBalancerFor(policy),
+			balancerPolicy: policy,
+			isHeadless:     serviceImport.Spec.Type == mcsv1a1.Headless,
+			trafficShift:   &i.trafficShift,
+			balancerRetry:  &i.balancerRetry,
+			replicaID:      i.replicaID,
+			inFlightLease:  i.inFlightLease,
+			healthDecay:    i.healthDecay,
+			maxWeight:      i.maxWeight,
+			localWeight:    &i.localWeight,
+			random:         i.random,
+			clock:          i.clock,
+			firstSeen:      i.clock.Now(),
+		}
+
+		shard.services[key] = svcInfo
+	}
+
+	svcInfo.markChanged(i.clock.Now())
+
+	if !isLegacy {
+		svcInfo.recordTTLs = getRecordTTLsFrom(serviceImport)
+		svcInfo.clusterLabels = getClusterLabelsFrom(serviceImport, i.normalizeClusterName)
+
+		if portMerge := getPortMergeFrom(serviceImport); portMerge != svcInfo.portMerge {
+			svcInfo.portMerge = portMerge
+			i.mergePorts(key, svcInfo)
 		}
+	}
 
-		i.serviceMap[key] = svcInfo
+	if svcInfo.isHeadless {
+		return
 	}
 
-	if svcInfo.isHeadless || !isLegacy {
+	if !isLegacy {
+		if policy != svcInfo.balancerPolicy {
+			svcInfo.balancerPolicy = policy
+			svcInfo.balancer = i.newBalancerFor(policy)
+			svcInfo.resetLoadBalancing()
+		}
+
+		svcInfo.updateLoadBalancingFrom(serviceImport, i.normalizeClusterName)
+
 		return
 	}
 
 	// This is a legacy pre-0.15 remote cluster ServiceImport - initialize the cluster info to maintain backwards compatibility
 	// while roling upgrade is in progress.
 
-	clusterName := serviceImport.Labels["lighthouse.submariner.io/sourceCluster"]
+	warnOnSourceConflicts(serviceImport)
+
+	clusterName := i.normalizeClusterName(getSourceCluster(serviceImport))
+
+	if len(serviceImport.Spec.IPs) == 0 || serviceImport.Spec.IPs[0] == "" {
+		logger.Errorf(nil, "Legacy ServiceImport %q from cluster %q has no service IPs - ignoring it", key, clusterName)
+		return
+	}
 
-	clusterInfo := svcInfo.ensureClusterInfo(clusterName)
+	clusterInfo := svcInfo.ensureClusterInfo(clusterName, i.clock.Now())
+	previous := clusterInfo.endpointRecords
+	clusterInfo.ports = serviceImport.Spec.Ports
 	clusterInfo.endpointRecords = []DNSRecord{{
 		IP:          serviceImport.Spec.IPs[0],
+		IPs:         append([]string{}, serviceImport.Spec.IPs...),
 		Ports:       serviceImport.Spec.Ports,
+		HostName:    clusterHostName(clusterName, key),
 		ClusterName: clusterName,
+		TTL:         svcInfo.recordTTL,
 	}}
 
-	svcInfo.mergePorts()
-	svcInfo.resetLoadBalancing()
+	i.mergePorts(key, svcInfo)
+	svcInfo.updateLoadBalancing()
+
+	i.updateIPIndex(key, previous, clusterInfo.endpointRecords)
+
+	changed = true
 }
 
 func (i *Interface) RemoveServiceImport(serviceImport *mcsv1a1.ServiceImport) {
@@ -72,26 +154,455 @@ func (i *Interface) RemoveServiceImport(serviceImport *mcsv1a1.ServiceImport) {
 		return
 	}
 
-	key, isLegacy := getServiceImportKey(serviceImport)
+	key, isLegacy, ok := getServiceImportKey(serviceImport)
+	if !ok {
+		logger.Warningf("Ignoring removed legacy ServiceImport %q whose source name or namespace can't be determined",
+			serviceImport.Name)
+		return
+	}
+
 	if isLegacy {
 		return
 	}
 
 	logger.Infof("Remove ServiceImport %q", key)
 
+	changed := false
+
+	defer func() {
+		if changed {
+			i.notifyClustersChanged(key)
+		}
+	}()
+
+	shard := i.lockShard(key)
+	defer i.unlockShard(shard)
+
+	if serviceInfo, found := shard.services[key]; found {
+		i.removeFromIPIndex(key, serviceInfo)
+
+		changed = true
+	}
+
+	delete(shard.services, key)
+}
+
+// Clear removes all services, eg when the ServiceImport informer's cache is reset.
+func (i *Interface) Clear() {
+	i.RetainOnly(nil)
+}
+
+// RetainOnly removes the services whose "<namespace>/<name>" keys aren't in the given set, eg to prune services whose
+// removal was missed when reconciling with a resynced ServiceImport informer. The services are removed atomically.
+func (i *Interface) RetainOnly(keys map[string]bool) {
+	for _, key := range i.removeServicesExcept(keys) {
+		logger.Infof("Removed ServiceImport %q", key)
+		i.notifyClustersChanged(key)
+	}
+}
+
+func (i *Interface) removeServicesExcept(keys map[string]bool) []string {
 	i.mutex.Lock()
 	defer i.mutex.Unlock()
 
-	delete(i.serviceMap, key)
+	var removed []string
+
+	for _, shard := range i.shards {
+		for key, serviceInfo := range shard.services {
+			if keys[key] {
+				continue
+			}
+
+			i.removeFromIPIndex(key, serviceInfo)
+			delete(shard.services, key)
+
+			removed = append(removed, key)
+		}
+	}
+
+	sort.Strings(removed)
+
+	return removed
+}
+
+// warnOnSourceConflicts logs a warning if a legacy ServiceImport's source name, namespace or cluster labels disagree
+// with the corresponding origin annotations. The annotations take precedence as they're used to build the service key
+// and the cluster info.
+func warnOnSourceConflicts(serviceImport *mcsv1a1.ServiceImport) {
+	conflicts := []struct{ label, annotation string }{
+		{label: "lighthouse.submariner.io/sourceName", annotation: "origin-name"},
+		{label: constants.LabelSourceNamespace, annotation: "origin-namespace"},
+		{label: "lighthouse.submariner.io/sourceCluster", annotation: "origin-cluster"},
+	}
+
+	for _, c := range conflicts {
+		labelValue, hasLabel := serviceImport.Labels[c.label]
+		annotationValue, hasAnnotation := serviceImport.Annotations[c.annotation]
+
+		if hasLabel && hasAnnotation && labelValue != annotationValue {
+			logger.Warningf("ServiceImport %q has label %q with value %q that conflicts with annotation %q with value %q"+
+				" - using the annotation", serviceImport.Name, c.label, labelValue, c.annotation, annotationValue)
+		}
+	}
+}
+
+// getServiceImportKey returns the key of the service for the given ServiceImport and whether it's a legacy per-cluster
+// ServiceImport. The last return value is false if it's a legacy ServiceImport whose source name or namespace can't be
+// determined, in which case it should be ignored rather than mis-keyed.
+func getServiceImportKey(from *mcsv1a1.ServiceImport) (string, bool, bool) {
+	name, isLegacy := from.Annotations["origin-name"]
+	if !isLegacy {
+		return keyFunc(from.Namespace, from.Name), false, true
+	}
+
+	namespace, found := getSourceNamespace(from)
+	if name == "" || !found {
+		return "", true, false
+	}
+
+	return keyFunc(namespace, name), true, true
+}
+
+// getSourceNamespace returns the source namespace of the given legacy ServiceImport from its origin annotation, falling
+// back to its source namespace label, and whether it was found.
+func getSourceNamespace(from *mcsv1a1.ServiceImport) (string, bool) {
+	namespace, ok := from.Annotations["origin-namespace"]
+	if !ok {
+		namespace, ok = from.Labels[constants.LabelSourceNamespace]
+	}
+
+	return namespace, ok && namespace != ""
 }
 
-func getServiceImportKey(from *mcsv1a1.ServiceImport) (string, bool) {
-	name, ok := from.Annotations["origin-name"]
-	if ok {
-		return keyFunc(from.Annotations["origin-namespace"], name), true
+// getSourceCluster returns the source cluster of the given legacy ServiceImport from its origin annotation, falling back
+// to its source cluster label.
+func getSourceCluster(from *mcsv1a1.ServiceImport) string {
+	cluster, ok := from.Annotations["origin-cluster"]
+	if !ok {
+		cluster = from.Labels["lighthouse.submariner.io/sourceCluster"]
 	}
 
-	return keyFunc(from.Namespace, from.Name), false
+	return cluster
+}
+
+// getServiceWeightsFrom returns the per-cluster load balancing weights specified via the
+// "lighthouse.submariner.io/serviceimport.weight/<cluster>" annotations on the aggregated ServiceImport.
+func getServiceWeightsFrom(serviceImport *mcsv1a1.ServiceImport, maxWeight int64) map[string]int64 {
+	weights := map[string]int64{}
+	prefix := constants.LoadBalancerWeightAnnotationPrefix + "/"
+
+	for key := range serviceImport.Annotations {
+		if strings.HasPrefix(key, prefix) {
+			clusterName := strings.TrimPrefix(key, prefix)
+			weights[clusterName] = getServiceWeightFrom(serviceImport, clusterName, maxWeight)
+		}
+	}
+
+	return weights
+}
+
+// getServiceWeightFrom returns the given cluster's weight clamped to the range [1, maxWeight]. Invalid weights default
+// to 1 as zero would cause no selection.
+func getServiceWeightFrom(serviceImport *mcsv1a1.ServiceImport, forClusterName string, maxWeight int64) int64 {
+	weightKey := constants.LoadBalancerWeightAnnotationPrefix + "/" + forClusterName
+
+	val, ok := serviceImport.Annotations[weightKey]
+	if !ok {
+		return 1
+	}
+
+	f, err := strconv.ParseInt(val, 0, 64)
+
+	switch {
+	case err != nil:
+		logger.Errorf(err, "Invalid %q annotation value %q from ServiceImport %q", weightKey, val, serviceImport.Name)
+		return 1
+	case f < 1:
+		logger.Warningf("%q annotation value %d from ServiceImport %q is below the minimum - using 1", weightKey, f,
+			serviceImport.Name)
+
+		return 1
+	case f > maxWeight:
+		logger.Warningf("%q annotation value %d from ServiceImport %q is above the maximum - using %d", weightKey, f,
+			serviceImport.Name, maxWeight)
+
+		return maxWeight
+	}
+
+	return f
+}
+
+// getMaxInFlightFrom returns the per-cluster limits on concurrently acquired selections specified via the
+// "lighthouse.submariner.io/serviceimport.max-in-flight/<cluster>" annotations on the aggregated ServiceImport.
+func getMaxInFlightFrom(serviceImport *mcsv1a1.ServiceImport) map[string]int64 {
+	limits := map[string]int64{}
+	prefix := constants.MaxInFlightAnnotationPrefix + "/"
+
+	for key, val := range serviceImport.Annotations {
+		if !strings.HasPrefix(key, prefix) {
+			continue
+		}
+
+		limit, err := strconv.ParseInt(val, 0, 64)
+		if err != nil || limit < 0 {
+			logger.Errorf(err, "Invalid %q annotation value %q from ServiceImport %q", key, val, serviceImport.Name)
+			continue
+		}
+
+		limits[strings.TrimPrefix(key, prefix)] = limit
+	}
+
+	return limits
+}
+
+// getCostsFrom returns the per-cluster costs specified via the "lighthouse.submariner.io/serviceimport.cost/<cluster>"
+// annotations on the aggregated ServiceImport. Clusters without a cost annotation have zero cost.
+func getCostsFrom(serviceImport *mcsv1a1.ServiceImport) map[string]int64 {
+	costs := map[string]int64{}
+	prefix := constants.CostAnnotationPrefix + "/"
+
+	for key, val := range serviceImport.Annotations {
+		if !strings.HasPrefix(key, prefix) {
+			continue
+		}
+
+		cost, err := strconv.ParseInt(val, 0, 64)
+		if err != nil || cost < 0 {
+			logger.Errorf(err, "Invalid %q annotation value %q from ServiceImport %q", key, val, serviceImport.Name)
+			continue
+		}
+
+		costs[strings.TrimPrefix(key, prefix)] = cost
+	}
+
+	return costs
+}
+
+// getRegionsFrom returns the per-cluster regions specified via the "lighthouse.submariner.io/serviceimport.region/<cluster>"
+// annotations on the aggregated ServiceImport, keyed by the normalized cluster names.
+func getRegionsFrom(serviceImport *mcsv1a1.ServiceImport, normalize func(string) string) map[string]string {
+	regions := map[string]string{}
+	prefix := constants.RegionAnnotationPrefix + "/"
+
+	for key, val := range serviceImport.Annotations {
+		if strings.HasPrefix(key, prefix) && val != "" {
+			regions[normalize(strings.TrimPrefix(key, prefix))] = val
+		}
+	}
+
+	return regions
+}
+
+// getClusterLabelsFrom returns the per-cluster labels specified via the
+// "lighthouse.submariner.io/serviceimport.cluster-labels/<cluster>" annotations on the aggregated ServiceImport, in the
+// form "key1=value1,key2=value2".
+func getClusterLabelsFrom(serviceImport *mcsv1a1.ServiceImport, normalize func(string) string) map[string]labels.Set {
+	clusterLabels := map[string]labels.Set{}
+	prefix := constants.ClusterLabelsAnnotationPrefix + "/"
+
+	for key, val := range serviceImport.Annotations {
+		if !strings.HasPrefix(key, prefix) {
+			continue
+		}
+
+		set, err := labels.ConvertSelectorToLabelsMap(val)
+		if err != nil {
+			logger.Errorf(err, "Invalid %q annotation value %q from ServiceImport %q", key, val, serviceImport.Name)
+			continue
+		}
+
+		clusterLabels[normalize(strings.TrimPrefix(key, prefix))] = set
+	}
+
+	return clusterLabels
+}
+
+// getRecordTTLsFrom returns the per-record-type TTLs, in seconds, specified via the
+// "lighthouse.submariner.io/serviceimport.ttl/<record type>" annotations, keyed by upper-case record type.
+func getRecordTTLsFrom(serviceImport *mcsv1a1.ServiceImport) map[string]uint32 {
+	ttls := map[string]uint32{}
+	prefix := constants.RecordTTLAnnotationPrefix + "/"
+
+	for key, val := range serviceImport.Annotations {
+		if !strings.HasPrefix(key, prefix) {
+			continue
+		}
+
+		ttl, err := strconv.ParseUint(val, 10, 32)
+		if err != nil {
+			logger.Errorf(err, "Invalid %q annotation value %q from ServiceImport %q", key, val, serviceImport.Name)
+			continue
+		}
+
+		ttls[strings.ToUpper(strings.TrimPrefix(key, prefix))] = uint32(ttl)
+	}
+
+	return ttls
+}
+
+// getRecordTTLFrom returns the TTL, in seconds, of the service's records as specified via the
+// "lighthouse.submariner.io/ttl" annotation, or zero if absent or invalid.
+func getRecordTTLFrom(serviceImport *mcsv1a1.ServiceImport) uint32 {
+	val, found := serviceImport.Annotations[constants.RecordTTLAnnotation]
+	if !found {
+		return 0
+	}
+
+	ttl, err := strconv.ParseUint(val, 10, 32)
+	if err != nil {
+		logger.Errorf(err, "Invalid %q annotation value %q from ServiceImport %q - using the default TTL",
+			constants.RecordTTLAnnotation, val, serviceImport.Name)
+
+		return 0
+	}
+
+	return uint32(ttl)
+}
+
+// getSessionAffinityTimeoutFrom returns the duration a client sticks to its selected cluster as specified via the
+// ServiceImport's client IP session affinity config, or zero if client IP session affinity isn't enabled.
+func getSessionAffinityTimeoutFrom(serviceImport *mcsv1a1.ServiceImport) time.Duration {
+	if serviceImport.Spec.SessionAffinity != corev1.ServiceAffinityClientIP {
+		return 0
+	}
+
+	config := serviceImport.Spec.SessionAffinityConfig
+	if config == nil || config.ClientIP == nil || config.ClientIP.TimeoutSeconds == nil {
+		return defaultSessionAffinityTimeout
+	}
+
+	timeout := *config.ClientIP.TimeoutSeconds
+	if timeout <= 0 {
+		logger.Errorf(nil, "Invalid session affinity timeout %d from ServiceImport %q - using the default timeout",
+			timeout, serviceImport.Name)
+
+		return defaultSessionAffinityTimeout
+	}
+
+	return time.Duration(timeout) * time.Second
+}
+
+// getMinShareFrom returns the minimum percentage of selections each cluster should receive, as specified via the
+// "lighthouse.submariner.io/serviceimport.min-share" annotation, as a fraction.
+func getMinShareFrom(serviceImport *mcsv1a1.ServiceImport) float64 {
+	val, ok := serviceImport.Annotations[constants.LoadBalancerMinShareAnnotation]
+	if !ok {
+		return 0
+	}
+
+	f, err := strconv.ParseFloat(val, 64)
+	if err != nil || f < 0 || f > 100 {
+		logger.Errorf(err, "Invalid %q annotation value %q from ServiceImport %q",
+			constants.LoadBalancerMinShareAnnotation, val, serviceImport.Name)
+
+		return 0
+	}
+
+	return f / 100
+}
+
+// getBalancerPolicyFrom returns the name of the load balancing policy specified via the
+// "lighthouse.submariner.io/serviceimport.loadbalancer-policy" annotation, or empty for the default policy. The name is
+// resolved via the loadbalancer registry when the service's load balancer is created.
+func getBalancerPolicyFrom(serviceImport *mcsv1a1.ServiceImport) string {
+	return serviceImport.Annotations[constants.LoadBalancerPolicyAnnotation]
+}
+
+// getPortMergeFrom returns the strategy for merging the clusters' ports specified via the
+// "lighthouse.submariner.io/serviceimport.port-merge" annotation, or empty for the default intersection strategy.
+func getPortMergeFrom(serviceImport *mcsv1a1.ServiceImport) string {
+	portMerge := serviceImport.Annotations[constants.PortMergeAnnotation]
+
+	switch portMerge {
+	case "", constants.PortMergeIntersection:
+		return ""
+	case constants.PortMergeUnion, constants.PortMergeFirst:
+		return portMerge
+	default:
+		logger.Errorf(nil, "Invalid %q annotation value %q from ServiceImport %q - using the intersection strategy",
+			constants.PortMergeAnnotation, portMerge, serviceImport.Name)
+
+		return ""
+	}
+}
+
+// getOnNoHealthyFrom returns the behavior if no cluster is healthy per the "lighthouse.submariner.io/on-no-healthy"
+// annotation, which defaults to returning no record.
+func getOnNoHealthyFrom(serviceImport *mcsv1a1.ServiceImport) string {
+	onNoHealthy, found := serviceImport.Annotations[constants.OnNoHealthyAnnotation]
+
+	switch {
+	case !found:
+		return constants.OnNoHealthyReturnEmpty
+	case onNoHealthy == constants.OnNoHealthyReturnEmpty, onNoHealthy == constants.OnNoHealthyServeLast:
+		return onNoHealthy
+	default:
+		logger.Errorf(nil, "Invalid %q annotation value %q from ServiceImport %q - returning no record if no cluster is healthy",
+			constants.OnNoHealthyAnnotation, onNoHealthy, serviceImport.Name)
+
+		return constants.OnNoHealthyReturnEmpty
+	}
+}
+
+// getOnAllDrainedFrom returns the behavior if every healthy cluster is drained per the
+// "lighthouse.submariner.io/on-all-drained" annotation, which defaults to ignoring the drain.
+func getOnAllDrainedFrom(serviceImport *mcsv1a1.ServiceImport) string {
+	onAllDrained, found := serviceImport.Annotations[constants.OnAllDrainedAnnotation]
+
+	switch {
+	case !found:
+		return constants.OnAllDrainedIgnoreDrain
+	case onAllDrained == constants.OnAllDrainedIgnoreDrain, onAllDrained == constants.OnAllDrainedReturnEmpty,
+		onAllDrained == constants.OnAllDrainedServeLeastDrained:
+		return onAllDrained
+	default:
+		logger.Errorf(nil, "Invalid %q annotation value %q from ServiceImport %q - ignoring the drain if all clusters are drained",
+			constants.OnAllDrainedAnnotation, onAllDrained, serviceImport.Name)
+
+		return constants.OnAllDrainedIgnoreDrain
+	}
+}
+
+// getWeightByEndpointsFrom returns whether the clusters' load balancing weights are multiplied by their ready endpoint
+// counts per the "lighthouse.submariner.io/weight-by-endpoints" annotation, which defaults to false.
+func getWeightByEndpointsFrom(serviceImport *mcsv1a1.ServiceImport) bool {
+	val, found := serviceImport.Annotations[constants.WeightByEndpointsAnnotation]
+	if !found {
+		return false
+	}
+
+	weightByEndpoints, err := strconv.ParseBool(val)
+	if err != nil {
+		logger.Errorf(err, "Invalid %q annotation value %q from ServiceImport %q - not weighting by endpoints",
+			constants.WeightByEndpointsAnnotation, val, serviceImport.Name)
+
+		return false
+	}
+
+	return weightByEndpoints
+}
+
+// getPreferLocalFrom returns whether the local cluster is preferred per the
+// "lighthouse.submariner.io/serviceimport.prefer-local" annotation, which defaults to true.
+func getPreferLocalFrom(serviceImport *mcsv1a1.ServiceImport) bool {
+	val, found := serviceImport.Annotations[constants.PreferLocalAnnotation]
+	if !found {
+		return true
+	}
+
+	preferLocal, err := strconv.ParseBool(val)
+	if err != nil {
+		logger.Errorf(err, "Invalid %q annotation value %q from ServiceImport %q - preferring the local cluster",
+			constants.PreferLocalAnnotation, val, serviceImport.Name)
+
+		return true
+	}
+
+	return preferLocal
+}
+
+func isSupportedServiceImportType(t mcsv1a1.ServiceImportType) bool {
+	return t == mcsv1a1.ClusterSetIP || t == mcsv1a1.Headless
 }
 
 func ignoreServiceImport(serviceImport *mcsv1a1.ServiceImport) bool {
@@ -0,0 +1,155 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+
+Copyright Contributors to the Submariner project.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package resolver_test
+
+import (
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/submariner-io/lighthouse/coredns/resolver"
+	discovery "k8s.io/api/discovery/v1"
+	mcsv1a1 "sigs.k8s.io/mcs-api/pkg/apis/v1alpha1"
+)
+
+var _ = Describe("Service pruning", func() {
+	t := newTestDriver()
+
+	var changes []clusterChange
+
+	key1 := namespace1 + "/" + service1
+	key2 := namespace2 + "/" + service1
+	key3 := namespace1 + "/service2"
+
+	BeforeEach(func() {
+		changes = nil
+
+		t.resolver.PutServiceImport(newAggregatedServiceImport(namespace1, service1))
+		t.putEndpointSlice(newClusterIPEndpointSlice(namespace1, service1, clusterID1, serviceIP1, true, port1))
+
+		t.resolver.PutServiceImport(newAggregatedServiceImport(namespace2, service1))
+		t.putEndpointSlice(newClusterIPEndpointSlice(namespace2, service1, clusterID2, serviceIP2, true, port1))
+
+		t.resolver.PutServiceImport(newHeadlessAggregatedServiceImport(namespace1, "service2"))
+		t.putEndpointSlice(newEndpointSlice(namespace1, "service2", clusterID1, []mcsv1a1.ServicePort{port1},
+			discovery.Endpoint{Addresses: []string{endpointIP1}}))
+
+		t.resolver.SetChangeHandler(func(key string, clusters []string) {
+			changes = append(changes, clusterChange{key: key, clusters: clusters})
+		})
+	})
+
+	Describe("Clear", func() {
+		It("should remove all services", func() {
+			t.resolver.Clear()
+
+			Expect(t.resolver.ServiceCount()).To(Equal(0))
+			t.assertDNSRecordsNotFound(namespace1, service1, "", "")
+			t.assertDNSRecordsNotFound(namespace2, service1, "", "")
+			t.assertDNSRecordsNotFound(namespace1, "service2", "", "")
+
+			Expect(changes).To(Equal([]clusterChange{
+				{key: key1, clusters: []string{}},
+				{key: key3, clusters: []string{}},
+				{key: key2, clusters: []string{}},
+			}))
+		})
+
+		Context("and services are subsequently put again", func() {
+			It("should return their DNS records without retaining the removed services' IPs", func() {
+				t.resolver.Clear()
+
+				t.resolver.PutServiceImport(newAggregatedServiceImport(namespace2, "service2"))
+				t.putEndpointSlice(newClusterIPEndpointSlice(namespace2, "service2", clusterID1, serviceIP1, true, port1))
+
+				Expect(t.getNonHeadlessDNSRecord(namespace2, "service2", "").IP).To(Equal(serviceIP1))
+				Expect(t.resolver.Verify()).To(Succeed())
+			})
+		})
+	})
+
+	Describe("RetainOnly", func() {
+		It("should remove exactly the services not in the given set", func() {
+			t.resolver.RetainOnly(map[string]bool{key2: true, key3: true, namespace2 + "/unknown": true})
+
+			Expect(t.resolver.ServiceCount()).To(Equal(2))
+			t.assertDNSRecordsNotFound(namespace1, service1, "", "")
+			Expect(t.getNonHeadlessDNSRecord(namespace2, service1, "").IP).To(Equal(serviceIP2))
+
+			records, isHeadless, found := t.resolver.GetDNSRecords(namespace1, "service2", "", "")
+			Expect(found).To(BeTrue())
+			Expect(isHeadless).To(BeTrue())
+			Expect(records).To(HaveLen(1))
+
+			Expect(changes).To(Equal([]clusterChange{{key: key1, clusters: []string{}}}))
+		})
+
+		Context("with all the services", func() {
+			It("should not remove any", func() {
+				t.resolver.RetainOnly(map[string]bool{key1: true, key2: true, key3: true})
+
+				Expect(t.resolver.ServiceCount()).To(Equal(3))
+				Expect(changes).To(BeEmpty())
+			})
+		})
+	})
+})
+
+var _ = Describe("Aggregated ServiceImport with multiple clusters", func() {
+	t := newTestDriver()
+
+	BeforeEach(func() {
+		serviceImport := newAggregatedServiceImport(namespace1, service1)
+		serviceImport.Status.Clusters = []mcsv1a1.ClusterStatus{{Cluster: clusterID1}, {Cluster: clusterID2}, {Cluster: clusterID3}}
+		setClusterWeight(serviceImport, clusterID1, 1)
+		setClusterWeight(serviceImport, clusterID2, 2)
+		setClusterWeight(serviceImport, clusterID3, 3)
+		t.resolver.PutServiceImport(serviceImport)
+	})
+
+	When("each cluster's EndpointSlice is put", func() {
+		BeforeEach(func() {
+			t.putEndpointSlice(newClusterIPEndpointSlice(namespace1, service1, clusterID1, serviceIP1, true, port1))
+			t.putEndpointSlice(newClusterIPEndpointSlice(namespace1, service1, clusterID2, serviceIP2, true, port1))
+			t.putEndpointSlice(newClusterIPEndpointSlice(namespace1, service1, clusterID3, serviceIP3, true, port1))
+		})
+
+		It("should return the record of each cluster with its weight", func() {
+			Expect(t.getNonHeadlessDNSRecord(namespace1, service1, clusterID1).IP).To(Equal(serviceIP1))
+			Expect(t.getNonHeadlessDNSRecord(namespace1, service1, clusterID2).IP).To(Equal(serviceIP2))
+			Expect(t.getNonHeadlessDNSRecord(namespace1, service1, clusterID3).IP).To(Equal(serviceIP3))
+
+			Expect(t.resolver.ClustersByWeight(namespace1, service1)).To(Equal([]resolver.ClusterWeight{
+				{Cluster: clusterID3, Weight: 3},
+				{Cluster: clusterID2, Weight: 2},
+				{Cluster: clusterID1, Weight: 1},
+			}))
+		})
+	})
+
+	When("a listed cluster's EndpointSlice hasn't been put", func() {
+		BeforeEach(func() {
+			t.putEndpointSlice(newClusterIPEndpointSlice(namespace1, service1, clusterID1, serviceIP1, true, port1))
+		})
+
+		It("should only return the records of the clusters whose EndpointSlices were put", func() {
+			Expect(t.resolver.ClusterRecordCount()).To(Equal(1))
+			Expect(t.getNonHeadlessDNSRecord(namespace1, service1, "").IP).To(Equal(serviceIP1))
+			Expect(t.getNonHeadlessDNSRecord(namespace1, service1, "").IP).To(Equal(serviceIP1))
+		})
+	})
+})
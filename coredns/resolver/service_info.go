@@ -19,44 +19,443 @@ limitations under the License.
 package resolver
 
 import (
+	"errors"
 	"fmt"
+	"hash/fnv"
+	"math"
+	"net"
+	"reflect"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
 
 	"github.com/submariner-io/admiral/pkg/slices"
+	"github.com/submariner-io/lighthouse/coredns/constants"
+	"github.com/submariner-io/lighthouse/coredns/loadbalancer"
+	corev1 "k8s.io/api/core/v1"
+	discovery "k8s.io/api/discovery/v1"
 	mcsv1a1 "sigs.k8s.io/mcs-api/pkg/apis/v1alpha1"
 )
 
+const minShareWeightScale = 1000
+
+// markChanged records that the service's state, and thus possibly its answers, changed at the given time.
+func (si *serviceInfo) markChanged(now time.Time) {
+	si.lastChanged = now
+	si.version++
+}
+
 func (si *serviceInfo) resetLoadBalancing() {
+	si.prepareRecords()
+
 	si.balancer.RemoveAll()
+	si.unbalancedClusters = nil
+	si.balancedWeights = map[string]int64{}
+	si.balancerGeneration++
+
+	weights := si.balancerWeights()
+
+	var pending []string
+
+	for _, name := range si.balancerOrder(weights) {
+		added, retryable := si.addToBalancer(name, weights[name])
+		if added {
+			continue
+		}
+
+		si.unbalancedClusters = append(si.unbalancedClusters, name)
+
+		if retryable {
+			pending = append(pending, name)
+		}
+	}
+
+	if len(pending) > 0 && si.balancerRetry != nil && si.balancerRetry.backoff.Steps > 1 {
+		go si.retryLoadBalancing(si.balancerGeneration, pending, weights)
+	}
+}
+
+// updateLoadBalancing updates the weights of the clusters in the load balancer in place if its clusters are unchanged,
+// so its selection state is preserved, eg when a cluster's EndpointSlice is updated. Otherwise, if the load balancer
+// can't update weights in place or if an update fails, the load balancer is reset.
+func (si *serviceInfo) updateLoadBalancing() {
+	weights := si.balancerWeights()
+
+	if len(si.unbalancedClusters) > 0 || len(weights) != len(si.balancedWeights) {
+		si.resetLoadBalancing()
+		return
+	}
+
+	for name := range weights {
+		if _, found := si.balancedWeights[name]; !found {
+			si.resetLoadBalancing()
+			return
+		}
+	}
+
+	si.prepareRecords()
+
+	updater, canUpdate := si.balancer.(loadbalancer.Updater)
+
+	for name, weight := range weights {
+		if weight == si.balancedWeights[name] {
+			continue
+		}
+
+		if !canUpdate {
+			si.resetLoadBalancing()
+			return
+		}
+
+		if err := updater.Update(name, weight); err != nil {
+			logger.Error(err, "Error updating load balancer info")
+			si.resetLoadBalancing()
+
+			return
+		}
+
+		si.balancedWeights[name] = weight
+	}
+}
+
+// clusterNames returns the names of the service's clusters, in name order.
+func (si *serviceInfo) clusterNames() []string {
+	names := make([]string, 0, len(si.clusters))
+	for name := range si.clusters {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	return names
+}
+
+// balancerOrder returns the order in which to add the given clusters to the load balancer, which determines the
+// selection order of clusters with equal weights. If a replica ID is configured, the clusters are sorted by descending
+// weight with ties ordered by a hash of the replica ID and cluster name, otherwise the order is arbitrary unless a
+// random seed is configured, in which case they're sorted by name.
+func (si *serviceInfo) balancerOrder(weights map[string]int64) []string {
+	names := make([]string, 0, len(weights))
+	for name := range weights {
+		names = append(names, name)
+	}
+
+	if si.replicaID == "" {
+		// With a seeded random source, selections should be reproducible so the order mustn't depend on map iteration.
+		if si.random != nil {
+			sort.Strings(names)
+		}
+
+		return names
+	}
+
+	hashes := make(map[string]uint64, len(names))
+
+	for _, name := range names {
+		h := fnv.New64a()
+		_, _ = h.Write([]byte(si.replicaID + "/" + name))
+		hashes[name] = h.Sum64()
+	}
+
+	sort.Slice(names, func(i, j int) bool {
+		if weights[names[i]] != weights[names[j]] {
+			return weights[names[i]] > weights[names[j]]
+		}
+
+		if hashes[names[i]] != hashes[names[j]] {
+			return hashes[names[i]] < hashes[names[j]]
+		}
 
+		return names[i] < names[j]
+	})
+
+	return names
+}
+
+// addToBalancer adds the given cluster to the load balancer. If it fails, it returns whether the error is transient so
+// adding should be retried.
+func (si *serviceInfo) addToBalancer(name string, weight int64) (added, retryable bool) {
+	err := si.balancer.Add(name, weight)
+	if err == nil {
+		si.balancedWeights[name] = weight
+		return true, false
+	}
+
+	logger.Error(err, "Error adding load balancer info")
+
+	return false, !errors.Is(err, loadbalancer.ErrNilItem) && !errors.Is(err, loadbalancer.ErrNegativeWeight) &&
+		!errors.Is(err, loadbalancer.ErrDuplicateItem)
+}
+
+// retryLoadBalancing retries adding the given pending clusters to the load balancer per the configured backoff. The
+// backoff is slept without holding the resolver's lock, which is only taken to add the clusters. Retrying stops once the
+// load balancer is reset again as the reset retries on its own.
+func (si *serviceInfo) retryLoadBalancing(generation uint64, pending []string, weights map[string]int64) {
+	backoff := si.balancerRetry.backoff
+	backoff.Steps-- // The first attempt was already made by the reset.
+
+	for backoff.Steps > 0 && len(pending) > 0 {
+		time.Sleep(backoff.Step())
+
+		pending = si.addPendingToBalancer(generation, pending, weights)
+	}
+}
+
+func (si *serviceInfo) addPendingToBalancer(generation uint64, pending []string, weights map[string]int64) []string {
+	si.balancerRetry.mutex.Lock()
+	defer si.balancerRetry.mutex.Unlock()
+
+	if si.balancerGeneration != generation {
+		return nil
+	}
+
+	var stillPending []string
+
+	for _, name := range pending {
+		added, retryable := si.addToBalancer(name, weights[name])
+		if added {
+			si.unbalancedClusters, _ = slices.Remove(si.unbalancedClusters, name, func(s string) string { return s })
+		} else if retryable {
+			stillPending = append(stillPending, name)
+		}
+	}
+
+	return stillPending
+}
+
+// balancerWeights returns the weights to add to the load balancer, ie the minimum share weights with the global traffic
+// shift, if any, applied. The shift gives its target cluster the configured percentage of the total weight and scales
+// the other clusters' weights down proportionally.
+func (si *serviceInfo) balancerWeights() map[string]int64 {
+	weights := si.minShareWeights()
+
+	if si.trafficShift == nil || si.trafficShift.percent <= 0 {
+		return weights
+	}
+
+	if _, found := weights[si.trafficShift.cluster]; !found || len(weights) < 2 {
+		return weights
+	}
+
+	var othersWeight int64
+
+	for name, weight := range weights {
+		if name != si.trafficShift.cluster {
+			othersWeight += weight
+		}
+	}
+
+	if othersWeight == 0 {
+		return weights
+	}
+
+	for name, weight := range weights {
+		if name == si.trafficShift.cluster {
+			weights[name] = othersWeight * si.trafficShift.percent
+		} else {
+			weights[name] = weight * (100 - si.trafficShift.percent)
+		}
+	}
+
+	return weights
+}
+
+const (
+	// healthDecayScale is the factor by which the clusters' weights are scaled when health decay is enabled.
+	healthDecayScale = 100
+	// maxCheckFailures bounds the counted consecutive endpoint check failures so a cluster that has been failing for a
+	// long time recovers in a bounded number of successful checks.
+	maxCheckFailures = 10
+)
+
+func (d healthDecay) enabled() bool {
+	return d.factor > 0 && d.factor < 1
+}
+
+// minShareWeights returns the clusters' weights adjusted for the minimum share. If a minimum share is configured, each
+// cluster whose weight would give it less than that share is raised to it and the remainder is divided among the other
+// clusters in proportion to their weights.
+func (si *serviceInfo) minShareWeights() map[string]int64 {
+	weights := make(map[string]int64, len(si.clusters))
 	for name, info := range si.clusters {
-		err := si.balancer.Add(name, info.weight)
-		if err != nil {
-			logger.Error(err, "Error adding load balancer info")
+		weights[name] = si.decayedWeight(info) * si.localWeightMultiplierFor(name) * si.capacityFor(info)
+	}
+
+	// Clusters drained by a zero weight don't participate so they aren't raised to the minimum share.
+	weighted := map[string]int64{}
+
+	for name, weight := range weights {
+		if weight > 0 {
+			weighted[name] = weight
 		}
 	}
+
+	if si.minShare <= 0 || len(weighted) < 2 {
+		return weights
+	}
+
+	if si.minShare*float64(len(weighted)) >= 1 {
+		for name := range weighted {
+			weights[name] = 1
+		}
+
+		return weights
+	}
+
+	floored := map[string]bool{}
+
+	for {
+		var remainingWeight int64
+
+		for name, weight := range weighted {
+			if !floored[name] {
+				remainingWeight += weight
+			}
+		}
+
+		remainingShare := 1 - si.minShare*float64(len(floored))
+		changed := false
+
+		for name, weight := range weighted {
+			if !floored[name] && (remainingWeight == 0 || float64(weight)/float64(remainingWeight)*remainingShare < si.minShare) {
+				floored[name] = true
+				changed = true
+			}
+		}
+
+		if changed {
+			continue
+		}
+
+		for name, weight := range weighted {
+			share := si.minShare
+			if !floored[name] {
+				share = float64(weight) / float64(remainingWeight) * remainingShare
+			}
+
+			weights[name] = int64(math.Round(share * minShareWeightScale))
+			if weights[name] == 0 {
+				weights[name] = 1
+			}
+		}
+
+		return weights
+	}
 }
 
+// mergePorts merges the clusters' ports per the service's strategy, ie the ports advertised by all clusters by
+// default, the ports advertised by any cluster for the union strategy, or the ports of the first cluster to join for the
+// first strategy.
 func (si *serviceInfo) mergePorts() {
-	si.ports = nil
+	var ports []mcsv1a1.ServicePort
+
+	switch si.portMerge {
+	case constants.PortMergeUnion:
+		for _, info := range si.clusters {
+			ports = unionPorts(ports, info.ports)
+		}
+	case constants.PortMergeFirst:
+		if first := si.firstJoinedCluster(); first != nil {
+			ports = first.ports
+		}
+	default:
+		first := true
+
+		for _, info := range si.clusters {
+			if first {
+				ports, first = info.ports, false
+			} else {
+				ports = slices.Intersect(ports, info.ports, servicePortKey)
+			}
+		}
+	}
+
+	// Sort a copy so the merged ports, and thus the SRV answers, have a stable order that doesn't depend on map
+	// iteration and so the cluster's own record isn't reordered.
+	if ports != nil {
+		ports = append([]mcsv1a1.ServicePort{}, ports...)
+		sort.SliceStable(ports, func(i, j int) bool {
+			if ports[i].Name != ports[j].Name {
+				return ports[i].Name < ports[j].Name
+			}
+
+			if ports[i].Protocol != ports[j].Protocol {
+				return ports[i].Protocol < ports[j].Protocol
+			}
+
+			return ports[i].Port < ports[j].Port
+		})
+	}
+
+	if !servicePortsEquivalent(si.ports, ports) {
+		si.portsVersion++
+	}
+
+	si.ports = ports
+	si.portConflict = si.hasPortConflict()
+
+	si.prepareRecords()
+}
+
+// hasPortConflict returns whether the clusters advertise different ports, so the merged ports differ from those of at
+// least one cluster. This typically indicates the service is misconfigured in some clusters.
+func (si *serviceInfo) hasPortConflict() bool {
+	if si.isHeadless {
+		return false
+	}
+
+	var first []mcsv1a1.ServicePort
+
+	seen := false
 
 	for _, info := range si.clusters {
-		if si.ports == nil {
-			si.ports = info.endpointRecords[0].Ports
-		} else {
-			si.ports = slices.Intersect(si.ports, info.endpointRecords[0].Ports, func(p mcsv1a1.ServicePort) string {
-				return fmt.Sprintf("%s%s%d", p.Name, p.Protocol, p.Port)
-			})
+		if !seen {
+			first, seen = info.ports, true
+		} else if !servicePortsEquivalent(first, info.ports) {
+			return true
 		}
 	}
+
+	return false
+}
+
+// prepareRecords recomputes the clusters' records with the merged ports.
+func (si *serviceInfo) prepareRecords() {
+	for _, info := range si.clusters {
+		info.mergedRecords = make([]DNSRecord, len(info.endpointRecords))
+
+		for j := range info.endpointRecords {
+			info.mergedRecords[j] = info.endpointRecords[j]
+			info.mergedRecords[j].Ports = si.ports
+		}
+	}
+}
+
+// firstJoinedCluster returns the cluster that was added first, with ties broken by name, or nil if there are none.
+func (si *serviceInfo) firstJoinedCluster() *clusterInfo {
+	var (
+		firstName string
+		first     *clusterInfo
+	)
+
+	for name, info := range si.clusters {
+		if first == nil || info.addedAt.Before(first.addedAt) || (info.addedAt.Equal(first.addedAt) && name < firstName) {
+			firstName, first = name, info
+		}
+	}
+
+	return first
 }
 
-func (si *serviceInfo) ensureClusterInfo(name string) *clusterInfo {
+func (si *serviceInfo) ensureClusterInfo(name string, now time.Time) *clusterInfo {
 	info, ok := si.clusters[name]
 
 	if !ok {
 		info = &clusterInfo{
 			endpointRecordsByHost: make(map[string][]DNSRecord),
-			weight:                1,
+			weight:                si.weightFor(name),
+			addedAt:               now,
 		}
 
 		si.clusters[name] = info
@@ -65,26 +464,632 @@ func (si *serviceInfo) ensureClusterInfo(name string) *clusterInfo {
 	return info
 }
 
+func (si *serviceInfo) updateLoadBalancingFrom(serviceImport *mcsv1a1.ServiceImport, normalize func(string) string) {
+	si.maxInFlight = normalizeClusterKeys(getMaxInFlightFrom(serviceImport), normalize)
+	si.costs = normalizeClusterKeys(getCostsFrom(serviceImport), normalize)
+	si.regions = getRegionsFrom(serviceImport, normalize)
+	si.ignoreLocal = !getPreferLocalFrom(serviceImport)
+	si.serveLastResort = getOnNoHealthyFrom(serviceImport) == constants.OnNoHealthyServeLast
+	si.onAllDrained = getOnAllDrainedFrom(serviceImport)
+	si.setRecordTTL(getRecordTTLFrom(serviceImport))
+	si.affinity.setTimeout(getSessionAffinityTimeoutFrom(serviceImport))
+
+	weights := normalizeClusterKeys(getServiceWeightsFrom(serviceImport, si.maxWeight), normalize)
+	minShare := getMinShareFrom(serviceImport)
+	weightByEndpoints := getWeightByEndpointsFrom(serviceImport)
+
+	if reflect.DeepEqual(si.weights, weights) && si.minShare == minShare && si.weightByEndpoints == weightByEndpoints {
+		return
+	}
+
+	si.weights = weights
+	si.minShare = minShare
+	si.weightByEndpoints = weightByEndpoints
+
+	for name, info := range si.clusters {
+		info.weight = si.weightFor(name)
+	}
+
+	si.updateLoadBalancing()
+}
+
+// setRecordTTL sets the TTL of the service's records. The records are replaced rather than modified so those already
+// returned remain unchanged.
+func (si *serviceInfo) setRecordTTL(ttl uint32) {
+	if ttl == si.recordTTL {
+		return
+	}
+
+	si.recordTTL = ttl
+
+	for _, info := range si.clusters {
+		records := make([]DNSRecord, len(info.endpointRecords))
+
+		for j := range info.endpointRecords {
+			records[j] = info.endpointRecords[j]
+			records[j].TTL = ttl
+		}
+
+		info.endpointRecords = records
+	}
+
+	si.prepareRecords()
+}
+
+// normalizeClusterKeys returns the given per-cluster values keyed by the normalized cluster names.
+func normalizeClusterKeys(values map[string]int64, normalize func(string) string) map[string]int64 {
+	normalized := make(map[string]int64, len(values))
+	for name, v := range values {
+		normalized[normalize(name)] = v
+	}
+
+	return normalized
+}
+
+// decayedWeight returns the cluster's weight lowered per its consecutive endpoint check failures, if health decay is
+// enabled. The weights are then scaled so a decayed weight can be lower than that of a cluster with the default weight
+// without reaching zero, which would drain the cluster.
+func (si *serviceInfo) decayedWeight(info *clusterInfo) int64 {
+	if !si.healthDecay.enabled() {
+		return info.weight
+	}
+
+	weight := int64(float64(info.weight*healthDecayScale) * math.Pow(si.healthDecay.factor, float64(info.checkFailures)))
+	if weight < 1 {
+		return 1
+	}
+
+	return weight
+}
+
+// recordEndpointCheck updates the given cluster's consecutive endpoint check failures and returns whether its decayed
+// weight changed as a result.
+func (si *serviceInfo) recordEndpointCheck(info *clusterInfo, passed bool) bool {
+	previous := si.decayedWeight(info)
+
+	switch {
+	case !passed && info.checkFailures < maxCheckFailures:
+		info.checkFailures++
+	case passed:
+		info.checkFailures -= si.healthDecay.recovery
+		if info.checkFailures < 0 || si.healthDecay.recovery <= 0 {
+			info.checkFailures = 0
+		}
+	}
+
+	return si.decayedWeight(info) != previous
+}
+
+// capacityFor returns the factor by which the given cluster's load balancing weight is multiplied to make its traffic
+// proportional to its ready endpoints, if enabled via the "lighthouse.submariner.io/weight-by-endpoints" annotation.
+// Clusters whose ready endpoint count isn't known have a factor of 1.
+func (si *serviceInfo) capacityFor(info *clusterInfo) int64 {
+	if !si.weightByEndpoints || !info.readyEndpointsKnown {
+		return 1
+	}
+
+	return int64(info.readyEndpoints)
+}
+
+// localWeightMultiplierFor returns the multiplier of the given cluster's load balancing weight, see
+// WithLocalWeightMultiplier, ie 1 unless it's the local cluster.
+func (si *serviceInfo) localWeightMultiplierFor(clusterName string) int64 {
+	if si.localWeight == nil || si.localWeight.multiplier <= 1 || clusterName != si.localWeight.cluster() {
+		return 1
+	}
+
+	return si.localWeight.multiplier
+}
+
+func (si *serviceInfo) weightFor(clusterName string) int64 {
+	weight, ok := si.weights[clusterName]
+	if !ok {
+		return 1
+	}
+
+	return weight
+}
+
+// newRecordFrom returns the given record of one of the service's clusters with the merged ports. The prepared record is
+// returned, if any, so it must not be modified and APIs that return a pointer to it must return a clone instead.
 func (si *serviceInfo) newRecordFrom(from *DNSRecord) *DNSRecord {
+	if info, found := si.clusters[from.ClusterName]; found {
+		for j := range info.endpointRecords {
+			if &info.endpointRecords[j] == from && j < len(info.mergedRecords) {
+				return &info.mergedRecords[j]
+			}
+		}
+	}
+
 	r := *from
 	r.Ports = si.ports
 
 	return &r
 }
 
-func (si *serviceInfo) selectIP(checkCluster func(string) bool) *DNSRecord {
+// drainedClusters returns the names, in name order, of the selectable healthy clusters in the load balancer if every one
+// of them has a zero weight, or nil if any has a positive weight or there are none.
+func (si *serviceInfo) drainedClusters(checkCluster func(string) bool) []string {
+	var drained []string
+
+	for _, name := range si.clusterNames() {
+		weight, found := si.balancedWeights[name]
+		info := si.clusters[name]
+
+		if !found || !info.hasRecord() || !info.isServing() || !checkCluster(name) {
+			continue
+		}
+
+		if weight > 0 {
+			return nil
+		}
+
+		drained = append(drained, name)
+	}
+
+	return drained
+}
+
+// selectIP returns the record of the next available cluster from the load balancer. Clusters for which passOver
+// returns true are only selected if no other cluster is available, in which case the least recently selected one is chosen.
+// If maxCandidates is positive, at most that many clusters are examined. Concurrent selections are serialized as they
+// advance the load balancer.
+func (si *serviceInfo) selectIP(checkCluster func(string) bool, passOver func(*clusterInfo) bool, maxCandidates int) *DNSRecord {
+	si.balancerMutex.Lock()
+	defer si.balancerMutex.Unlock()
+
+	var passedOver []string
+
 	queueLength := si.balancer.ItemCount()
+	if maxCandidates > 0 && maxCandidates < queueLength {
+		queueLength = maxCandidates
+	}
+
 	for i := 0; i < queueLength; i++ {
 		clusterID := si.balancer.Next().(string)
 		clusterInfo := si.clusters[clusterID]
 
-		if checkCluster(clusterID) && clusterInfo.endpointsHealthy {
-			return &clusterInfo.endpointRecords[0]
+		if clusterInfo.hasRecord() && checkCluster(clusterID) && clusterInfo.isServing() {
+			if passOver(clusterInfo) {
+				passedOver = append(passedOver, clusterID)
+				continue
+			}
+
+			if si.acquire(clusterID) {
+				return &clusterInfo.endpointRecords[0]
+			}
 		}
 
 		// Will Skip the cluster until a full "round" of the items is done
 		si.balancer.Skip(clusterID)
 	}
 
+	sort.SliceStable(passedOver, func(i, j int) bool {
+		return atomic.LoadInt64(&si.clusters[passedOver[i]].selectionSeq) < atomic.LoadInt64(&si.clusters[passedOver[j]].selectionSeq)
+	})
+
+	for _, clusterID := range passedOver {
+		if si.acquire(clusterID) {
+			return &si.clusters[clusterID].endpointRecords[0]
+		}
+	}
+
 	return nil
 }
+
+// selectIPFor selects the first of the selectable clusters in the given load balancer's order of preference for the
+// given key.
+func (si *serviceInfo) selectIPFor(balancer loadbalancer.HashInterface, key string, checkCluster func(string) bool) *DNSRecord {
+	si.balancerMutex.Lock()
+	items := balancer.ItemsFor(key)
+	si.balancerMutex.Unlock()
+
+	for _, item := range items {
+		clusterID := item.(string)
+		clusterInfo := si.clusters[clusterID]
+
+		if checkCluster(clusterID) && clusterInfo.isServing() && si.acquire(clusterID) {
+			return &clusterInfo.endpointRecords[0]
+		}
+	}
+
+	return nil
+}
+
+// selectFromSubset samples k of the selectable clusters with a positive load balancer weight uniformly at random and
+// then picks one of the sampled clusters at random in proportion to its weight. As k approaches the number of
+// clusters, the distribution thus approaches that of the weights.
+func (si *serviceInfo) selectFromSubset(k int, checkCluster func(string) bool) *DNSRecord {
+	candidates := make([]string, 0, len(si.balancedWeights))
+
+	for name, weight := range si.balancedWeights {
+		if weight > 0 && si.clusters[name].isServing() && checkCluster(name) {
+			candidates = append(candidates, name)
+		}
+	}
+
+	if k < len(candidates) {
+		for j := 0; j < k; j++ {
+			r := j + si.random.Intn(len(candidates)-j)
+			candidates[j], candidates[r] = candidates[r], candidates[j]
+		}
+
+		candidates = candidates[:k]
+	}
+
+	for len(candidates) > 0 {
+		var total int64
+		for _, name := range candidates {
+			total += si.balancedWeights[name]
+		}
+
+		r := si.random.Int63n(total)
+
+		j := 0
+		for ; r >= si.balancedWeights[candidates[j]]; j++ {
+			r -= si.balancedWeights[candidates[j]]
+		}
+
+		if si.acquire(candidates[j]) {
+			return &si.clusters[candidates[j]].endpointRecords[0]
+		}
+
+		candidates = append(candidates[:j], candidates[j+1:]...)
+	}
+
+	return nil
+}
+
+// costTiers returns the distinct costs, in ascending order, of the selectable clusters that are healthy and have a
+// positive load balancer weight.
+func (si *serviceInfo) costTiers(checkCluster func(string) bool) []int64 {
+	var costs []int64
+
+	present := map[int64]bool{}
+
+	for name, weight := range si.balancedWeights {
+		cost := si.costs[name]
+		if weight > 0 && !present[cost] && si.clusters[name].isServing() && checkCluster(name) {
+			present[cost] = true
+			costs = append(costs, cost)
+		}
+	}
+
+	sort.Slice(costs, func(i, j int) bool {
+		return costs[i] < costs[j]
+	})
+
+	return costs
+}
+
+// clustersCosting returns a check that restricts the given one to the clusters with the given cost.
+func (si *serviceInfo) clustersCosting(cost int64, checkCluster func(string) bool) func(string) bool {
+	return func(name string) bool {
+		return si.costs[name] == cost && checkCluster(name)
+	}
+}
+
+// clustersInRegion returns a check that restricts the given one to the clusters in the given region.
+func (si *serviceInfo) clustersInRegion(region string, checkCluster func(string) bool) func(string) bool {
+	return func(name string) bool {
+		return si.regions[name] == region && checkCluster(name)
+	}
+}
+
+// countSelection updates the selection counters for a load balanced selection of the given cluster.
+func (si *serviceInfo) countSelection(localClusterID string, localFound bool, selected string) {
+	if localClusterID == "" {
+		return
+	}
+
+	if !localFound {
+		atomic.AddInt64(&si.remoteOnlyResolutions, 1)
+	}
+
+	if selected == localClusterID {
+		atomic.AddInt64(&si.localSelections, 1)
+	} else {
+		atomic.AddInt64(&si.remoteSelections, 1)
+	}
+}
+
+// markSelected records the time and sequence of the cluster's latest selection.
+func (si *serviceInfo) markSelected(name string, now time.Time) {
+	info := si.clusters[name]
+	atomic.StoreInt64(&info.lastSelected, now.UnixNano())
+	atomic.StoreInt64(&info.selectionSeq, atomic.AddInt64(&si.selectionSeq, 1))
+}
+
+func (si *serviceInfo) clustersByWeight() []ClusterWeight {
+	clusters := make([]ClusterWeight, 0, len(si.clusters))
+	for name, info := range si.clusters {
+		clusters = append(clusters, ClusterWeight{Cluster: name, Weight: info.weight})
+	}
+
+	sort.Slice(clusters, func(i, j int) bool {
+		if clusters[i].Weight != clusters[j].Weight {
+			return clusters[i].Weight > clusters[j].Weight
+		}
+
+		return clusters[i].Cluster < clusters[j].Cluster
+	})
+
+	return clusters
+}
+
+func (si *serviceInfo) portContributors() map[string][]string {
+	contributors := map[string][]string{}
+
+	for name, info := range si.clusters {
+		if len(info.endpointRecords) == 0 {
+			continue
+		}
+
+		for _, port := range info.ports {
+			key := servicePortKey(port)
+			contributors[key] = append(contributors[key], name)
+		}
+	}
+
+	for _, clusters := range contributors {
+		sort.Strings(clusters)
+	}
+
+	return contributors
+}
+
+func (si *serviceInfo) clusterUniquePorts() map[string][]mcsv1a1.ServicePort {
+	merged := make(map[string]bool, len(si.ports))
+	for i := range si.ports {
+		merged[servicePortKey(si.ports[i])] = true
+	}
+
+	unique := map[string][]mcsv1a1.ServicePort{}
+
+	for name, info := range si.clusters {
+		if len(info.endpointRecords) == 0 {
+			continue
+		}
+
+		for _, port := range info.ports {
+			if !merged[servicePortKey(port)] {
+				unique[name] = append(unique[name], port)
+			}
+		}
+	}
+
+	return unique
+}
+
+func servicePortsEquivalent(p1, p2 []mcsv1a1.ServicePort) bool {
+	if len(p1) != len(p2) {
+		return false
+	}
+
+	keys := make(map[string]bool, len(p1))
+	for i := range p1 {
+		keys[servicePortKey(p1[i])] = true
+	}
+
+	for i := range p2 {
+		if !keys[servicePortKey(p2[i])] {
+			return false
+		}
+	}
+
+	return true
+}
+
+func servicePortKey(p mcsv1a1.ServicePort) string {
+	return fmt.Sprintf("%s/%s/%d", p.Name, p.Protocol, p.Port)
+}
+
+// unionPorts returns the given ports with those of the other ports that aren't already present appended.
+func unionPorts(ports, other []mcsv1a1.ServicePort) []mcsv1a1.ServicePort {
+	present := make(map[string]bool, len(ports))
+	for _, p := range ports {
+		present[servicePortKey(p)] = true
+	}
+
+	for _, p := range other {
+		if !present[servicePortKey(p)] {
+			present[servicePortKey(p)] = true
+			ports = append(ports, p)
+		}
+	}
+
+	return ports
+}
+
+// acquire takes an in-flight selection lease for the given cluster if in-flight limits are enabled and the cluster has a
+// configured limit. If the limit has been reached, false is returned. A lease is held until it's released or expires.
+// This is called with only the read lock held so the leases are guarded by the cluster's own mutex.
+func (si *serviceInfo) acquire(clusterName string) bool {
+	limit, hasLimit := si.maxInFlight[clusterName]
+	if si.inFlightLease <= 0 || !hasLimit {
+		return true
+	}
+
+	info := si.clusters[clusterName]
+	now := si.clock.Now()
+
+	info.inFlightMutex.Lock()
+	defer info.inFlightMutex.Unlock()
+
+	info.expireInFlight(now)
+
+	if int64(len(info.inFlightExpiries)) >= limit {
+		return false
+	}
+
+	info.inFlightExpiries = append(info.inFlightExpiries, now.Add(si.inFlightLease))
+
+	return true
+}
+
+// release frees the oldest in-flight selection lease of the given cluster, if any.
+func (si *serviceInfo) release(clusterName string) {
+	info, found := si.clusters[clusterName]
+	if !found {
+		return
+	}
+
+	info.inFlightMutex.Lock()
+	defer info.inFlightMutex.Unlock()
+
+	if len(info.inFlightExpiries) > 0 {
+		info.inFlightExpiries = info.inFlightExpiries[1:]
+	}
+}
+
+// expireInFlight drops the in-flight selection leases that have expired. Leases are ordered by expiry as they all
+// have the same duration. The caller must hold the cluster's inFlightMutex.
+func (c *clusterInfo) expireInFlight(now time.Time) {
+	n := 0
+	for n < len(c.inFlightExpiries) && !c.inFlightExpiries[n].After(now) {
+		n++
+	}
+
+	c.inFlightExpiries = c.inFlightExpiries[n:]
+}
+
+// setEndpointsHealthy records whether the cluster has healthy endpoints, tracking the time since when they've been empty.
+func (c *clusterInfo) setEndpointsHealthy(healthy bool, now time.Time) {
+	c.endpointsHealthy = healthy
+
+	switch {
+	case healthy:
+		c.emptySince = time.Time{}
+	case c.emptySince.IsZero():
+		c.emptySince = now
+	}
+}
+
+// hasRecord returns whether the cluster has a record with an IP to serve. A cluster without one, eg from a legacy
+// ServiceImport without IPs, is never selected.
+func (c *clusterInfo) hasRecord() bool {
+	return len(c.endpointRecords) > 0 && c.endpointRecords[0].IP != ""
+}
+
+// isServing returns whether the cluster's endpoints are healthy and it isn't known to have no ready endpoints, eg
+// because its pods are all draining.
+func (c *clusterInfo) isServing() bool {
+	return c.endpointsHealthy && (!c.readyEndpointsKnown || c.readyEndpoints > 0)
+}
+
+// recordOfAddressType returns the cluster's first record whose IP is of the given address type, or nil if none.
+func (c *clusterInfo) recordOfAddressType(addressType discovery.AddressType) *DNSRecord {
+	for j := range c.endpointRecords {
+		if addressTypeOf(c.endpointRecords[j].IP) == addressType {
+			return &c.endpointRecords[j]
+		}
+	}
+
+	return nil
+}
+
+// allows returns whether the cluster has a record and satisfies the filter. A nil filter allows any such cluster.
+func (f *selectionFilter) allows(info *clusterInfo) bool {
+	if !info.hasRecord() {
+		return false
+	}
+
+	if f != nil && f.exclude[info.endpointRecords[0].ClusterName] {
+		return false
+	}
+
+	if f != nil && f.selector != nil && !f.selector.Matches(f.clusterLabels[info.endpointRecords[0].ClusterName]) {
+		return false
+	}
+
+	if f != nil && f.checkCluster != nil && !f.checkCluster(info.endpointRecords[0].ClusterName) {
+		return false
+	}
+
+	if f != nil && !hasPort(info.ports, f.portName, f.protocol) {
+		return false
+	}
+
+	return f.recordFrom(info) != nil
+}
+
+// recordsAllowed returns the given records of a headless service that satisfy the filter. A nil filter allows all of
+// them, which are returned as is.
+func (f *selectionFilter) recordsAllowed(records []DNSRecord) []DNSRecord {
+	if f == nil {
+		return records
+	}
+
+	var allowed []DNSRecord
+
+	for j := range records {
+		if f.allowsRecord(&records[j]) {
+			allowed = append(allowed, records[j])
+		}
+	}
+
+	return allowed
+}
+
+// allowsRecord returns whether the given record of a headless service satisfies the filter.
+func (f *selectionFilter) allowsRecord(record *DNSRecord) bool {
+	switch {
+	case f.exclude[record.ClusterName]:
+	case f.selector != nil && !f.selector.Matches(f.clusterLabels[record.ClusterName]):
+	case f.checkCluster != nil && !f.checkCluster(record.ClusterName):
+	case !hasPort(record.Ports, f.portName, f.protocol):
+	case f.addressType != "" && addressTypeOf(record.IP) != f.addressType:
+	default:
+		return true
+	}
+
+	return false
+}
+
+// hasPort returns whether the given ports contain one with the given name and protocol. An empty name or protocol
+// matches any.
+func hasPort(ports []mcsv1a1.ServicePort, name string, protocol corev1.Protocol) bool {
+	if name == "" && protocol == "" {
+		return true
+	}
+
+	for i := range ports {
+		if (name == "" || strings.EqualFold(ports[i].Name, name)) && (protocol == "" || ports[i].Protocol == protocol) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// hashKey returns the key for selecting a cluster via a hash based load balancer, if any.
+func (f *selectionFilter) hashKey() string {
+	if f == nil {
+		return ""
+	}
+
+	return f.clientIP
+}
+
+// recordFrom returns the cluster's record that satisfies the filter, or nil if none. A nil filter returns the primary
+// record.
+func (f *selectionFilter) recordFrom(info *clusterInfo) *DNSRecord {
+	if f == nil || f.addressType == "" {
+		return &info.endpointRecords[0]
+	}
+
+	return info.recordOfAddressType(f.addressType)
+}
+
+func addressTypeOf(ip string) discovery.AddressType {
+	parsed := net.ParseIP(ip)
+
+	switch {
+	case parsed == nil:
+		return ""
+	case parsed.To4() != nil:
+		return discovery.AddressTypeIPv4
+	default:
+		return discovery.AddressTypeIPv6
+	}
+}
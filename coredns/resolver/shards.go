@@ -0,0 +1,135 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+
+Copyright Contributors to the Submariner project.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package resolver
+
+import "sync"
+
+// serviceShardCount is the number of shards the services are split into.
+const serviceShardCount = 32
+
+// serviceShard holds the services whose keys hash to it. Per-service operations take the resolver's lock for reading
+// and then the lock of the service's shard, so operations on services in different shards proceed concurrently.
+// Operations spanning all services or changing the resolver's configuration take the resolver's lock for writing, which
+// excludes all per-service operations.
+type serviceShard struct {
+	services map[string]*serviceInfo
+	mutex    sync.RWMutex
+}
+
+func newServiceShards(count int) []*serviceShard {
+	shards := make([]*serviceShard, count)
+	for j := range shards {
+		shards[j] = &serviceShard{services: make(map[string]*serviceInfo)}
+	}
+
+	return shards
+}
+
+// shardIndex returns the index of the shard, out of the given count, of the service with the given key, chosen by its
+// FNV-1a hash.
+func shardIndex(key string, count int) int {
+	const (
+		offset32 = 2166136261
+		prime32  = 16777619
+	)
+
+	hash := uint32(offset32)
+	for j := 0; j < len(key); j++ {
+		hash ^= uint32(key[j])
+		hash *= prime32
+	}
+
+	return int(hash % uint32(count))
+}
+
+// shardFor returns the shard of the service with the given key. The caller must hold the resolver's lock.
+func (i *Interface) shardFor(key string) *serviceShard {
+	return i.shards[shardIndex(key, len(i.shards))]
+}
+
+// rlockService takes the resolver's lock and the lock of the shard of the given service, following any alias, for
+// reading and returns the service's key and shard. The locks are released via runlockShard.
+func (i *Interface) rlockService(namespace, name string) (string, *serviceShard) {
+	i.mutex.RLock()
+
+	key := i.serviceKey(namespace, name)
+	shard := i.shardFor(key)
+	shard.mutex.RLock()
+
+	return key, shard
+}
+
+// rlockShard takes the resolver's lock and the lock of the shard of the service with the given key for reading and
+// returns the shard. The locks are released via runlockShard.
+func (i *Interface) rlockShard(key string) *serviceShard {
+	i.mutex.RLock()
+
+	shard := i.shardFor(key)
+	shard.mutex.RLock()
+
+	return shard
+}
+
+func (i *Interface) runlockShard(shard *serviceShard) {
+	shard.mutex.RUnlock()
+	i.mutex.RUnlock()
+}
+
+// lockShard takes the resolver's lock for reading and the lock of the shard of the service with the given key for
+// writing and returns the shard. The locks are released via unlockShard.
+func (i *Interface) lockShard(key string) *serviceShard {
+	i.mutex.RLock()
+
+	shard := i.shardFor(key)
+	shard.mutex.Lock()
+
+	return shard
+}
+
+func (i *Interface) unlockShard(shard *serviceShard) {
+	shard.mutex.Unlock()
+	i.mutex.RUnlock()
+}
+
+// forEachService invokes the given function with each service, holding the lock of its shard for reading. The caller
+// must hold the resolver's lock, for writing if the function modifies the services.
+func (i *Interface) forEachService(f func(key string, serviceInfo *serviceInfo)) {
+	for _, shard := range i.shards {
+		shard.mutex.RLock()
+
+		for key, serviceInfo := range shard.services {
+			f(key, serviceInfo)
+		}
+
+		shard.mutex.RUnlock()
+	}
+}
+
+// serviceCount returns the number of services. The caller must hold the resolver's lock.
+func (i *Interface) serviceCount() int {
+	count := 0
+
+	for _, shard := range i.shards {
+		shard.mutex.RLock()
+		count += len(shard.services)
+		shard.mutex.RUnlock()
+	}
+
+	return count
+}
@@ -0,0 +1,244 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+
+Copyright Contributors to the Submariner project.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package resolver
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/gob"
+	"encoding/hex"
+	"encoding/json"
+	"time"
+
+	"github.com/pkg/errors"
+	"k8s.io/apimachinery/pkg/labels"
+	mcsv1a1 "sigs.k8s.io/mcs-api/pkg/apis/v1alpha1"
+)
+
+type serviceSnapshot struct {
+	IsHeadless        bool
+	FirstSeen         time.Time
+	Ports             []mcsv1a1.ServicePort
+	PortMerge         string
+	BalancerPolicy    string
+	Weights           map[string]int64
+	MaxInFlight       map[string]int64
+	Costs             map[string]int64
+	Regions           map[string]string
+	IgnoreLocal       bool
+	WeightByEndpoints bool
+	ServeLastResort   bool
+	OnAllDrained      string
+	Labels            map[string]labels.Set
+	MinShare          float64
+	RecordTTLs        map[string]uint32
+	RecordTTL         uint32
+	AffinityTTL       time.Duration
+	Clusters          map[string]clusterSnapshot
+}
+
+type clusterSnapshot struct {
+	EndpointRecords       []DNSRecord
+	EndpointRecordsByHost map[string][]DNSRecord
+	Weight                int64
+	EndpointsHealthy      bool
+	ReadyEndpoints        int
+	ReadyEndpointsKnown   bool
+}
+
+// MarshalBinary encodes the resolver's service state in a compact binary form suitable for transferring to, and
+// restoring via UnmarshalBinary in, another resolver instance. Transient state, eg selection statistics and in-flight
+// counts, is not included.
+func (i *Interface) MarshalBinary() ([]byte, error) {
+	i.mutex.Lock()
+
+	snapshot := make(map[string]serviceSnapshot, i.serviceCount())
+
+	i.forEachService(func(key string, serviceInfo *serviceInfo) {
+		snapshot[key] = newServiceSnapshot(serviceInfo)
+	})
+
+	var buf bytes.Buffer
+
+	// Encode while holding the lock for writing, which excludes all per-service operations, as the snapshot shares the
+	// records' slices and maps.
+	err := gob.NewEncoder(&buf).Encode(snapshot)
+
+	i.mutex.Unlock()
+
+	if err != nil {
+		return nil, errors.Wrap(err, "error encoding the resolver snapshot")
+	}
+
+	return buf.Bytes(), nil
+}
+
+// Fingerprint returns a hash of the given service's state that's relevant to its answers, ie its type, merged ports,
+// load balancing configuration and each cluster's records, weight and health. Callers can compare fingerprints to
+// detect whether anything about the service changed since it was last observed.
+func (i *Interface) Fingerprint(namespace, name string) (string, bool) {
+	key, shard := i.rlockService(namespace, name)
+	defer i.runlockShard(shard)
+
+	serviceInfo, found := shard.services[key]
+	if !found {
+		return "", false
+	}
+
+	// JSON encodes map keys in sorted order so the encoding is deterministic.
+	data, err := json.Marshal(newServiceSnapshot(serviceInfo))
+	if err != nil {
+		logger.Errorf(err, "Error encoding the state of service %q", key)
+		return "", false
+	}
+
+	sum := sha256.Sum256(data)
+
+	return hex.EncodeToString(sum[:]), true
+}
+
+func newServiceSnapshot(serviceInfo *serviceInfo) serviceSnapshot {
+	clusters := make(map[string]clusterSnapshot, len(serviceInfo.clusters))
+	for name, info := range serviceInfo.clusters {
+		clusters[name] = clusterSnapshot{
+			EndpointRecords:       info.endpointRecords,
+			EndpointRecordsByHost: info.endpointRecordsByHost,
+			Weight:                info.weight,
+			EndpointsHealthy:      info.endpointsHealthy,
+			ReadyEndpoints:        info.readyEndpoints,
+			ReadyEndpointsKnown:   info.readyEndpointsKnown,
+		}
+	}
+
+	return serviceSnapshot{
+		IsHeadless:        serviceInfo.isHeadless,
+		FirstSeen:         serviceInfo.firstSeen,
+		Ports:             serviceInfo.ports,
+		PortMerge:         serviceInfo.portMerge,
+		BalancerPolicy:    serviceInfo.balancerPolicy,
+		Weights:           serviceInfo.weights,
+		MaxInFlight:       serviceInfo.maxInFlight,
+		Costs:             serviceInfo.costs,
+		Regions:           serviceInfo.regions,
+		IgnoreLocal:       serviceInfo.ignoreLocal,
+		WeightByEndpoints: serviceInfo.weightByEndpoints,
+		ServeLastResort:   serviceInfo.serveLastResort,
+		OnAllDrained:      serviceInfo.onAllDrained,
+		Labels:            serviceInfo.clusterLabels,
+		MinShare:          serviceInfo.minShare,
+		RecordTTLs:        serviceInfo.recordTTLs,
+		RecordTTL:         serviceInfo.recordTTL,
+		AffinityTTL:       serviceInfo.affinity.timeout,
+		Clusters:          clusters,
+	}
+}
+
+// UnmarshalBinary replaces the resolver's service state with that encoded by MarshalBinary.
+func (i *Interface) UnmarshalBinary(data []byte) error {
+	var snapshot map[string]serviceSnapshot
+
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&snapshot); err != nil {
+		return errors.Wrap(err, "error decoding the resolver snapshot")
+	}
+
+	i.mutex.Lock()
+	defer i.mutex.Unlock()
+
+	i.negativeCache.clear()
+
+	now := i.clock.Now()
+	shards := newServiceShards(len(i.shards))
+
+	for key := range snapshot {
+		s := snapshot[key]
+
+		serviceInfo := &serviceInfo{
+			clusters:          make(map[string]*clusterInfo, len(s.Clusters)),
+			balancer:          i.newBalancerFor(s.BalancerPolicy),
+			balancerPolicy:    s.BalancerPolicy,
+			isHeadless:        s.IsHeadless,
+			ports:             s.Ports,
+			portMerge:         s.PortMerge,
+			weights:           s.Weights,
+			maxInFlight:       s.MaxInFlight,
+			costs:             s.Costs,
+			regions:           s.Regions,
+			ignoreLocal:       s.IgnoreLocal,
+			weightByEndpoints: s.WeightByEndpoints,
+			serveLastResort:   s.ServeLastResort,
+			onAllDrained:      s.OnAllDrained,
+			clusterLabels:     s.Labels,
+			minShare:          s.MinShare,
+			recordTTLs:        s.RecordTTLs,
+			recordTTL:         s.RecordTTL,
+			affinity:          sessionAffinity{timeout: s.AffinityTTL},
+			trafficShift:      &i.trafficShift,
+			balancerRetry:     &i.balancerRetry,
+			replicaID:         i.replicaID,
+			inFlightLease:     i.inFlightLease,
+			healthDecay:       i.healthDecay,
+			maxWeight:         i.maxWeight,
+			localWeight:       &i.localWeight,
+			random:            i.random,
+			clock:             i.clock,
+			lastChanged:       now,
+			firstSeen:         s.FirstSeen,
+		}
+
+		if serviceInfo.firstSeen.IsZero() {
+			serviceInfo.firstSeen = now
+		}
+
+		for name, c := range s.Clusters {
+			info := &clusterInfo{
+				endpointRecords:       c.EndpointRecords,
+				endpointRecordsByHost: c.EndpointRecordsByHost,
+				weight:                c.Weight,
+				readyEndpoints:        c.ReadyEndpoints,
+				readyEndpointsKnown:   c.ReadyEndpointsKnown,
+				addedAt:               now,
+			}
+
+			for j := range c.EndpointRecords {
+				info.ports = unionPorts(info.ports, c.EndpointRecords[j].Ports)
+			}
+
+			if info.endpointRecordsByHost == nil {
+				info.endpointRecordsByHost = make(map[string][]DNSRecord)
+			}
+
+			info.setEndpointsHealthy(c.EndpointsHealthy, now)
+			serviceInfo.clusters[name] = info
+		}
+
+		if !serviceInfo.isHeadless {
+			serviceInfo.portConflict = serviceInfo.hasPortConflict()
+			serviceInfo.resetLoadBalancing()
+		}
+
+		shards[shardIndex(key, len(shards))].services[key] = serviceInfo
+	}
+
+	i.shards = shards
+	i.rebuildIPIndex()
+
+	logger.Infof("Restored %d service(s) from a snapshot", len(snapshot))
+
+	return nil
+}
@@ -0,0 +1,251 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+
+Copyright Contributors to the Submariner project.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package resolver_test
+
+import (
+	"encoding/json"
+	"fmt"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/submariner-io/lighthouse/coredns/resolver"
+	"github.com/submariner-io/lighthouse/coredns/resolver/fake"
+	discovery "k8s.io/api/discovery/v1"
+	mcsv1a1 "sigs.k8s.io/mcs-api/pkg/apis/v1alpha1"
+)
+
+var _ = Describe("MarshalBinary/UnmarshalBinary", func() {
+	const headless = "headless"
+
+	t := newTestDriver()
+
+	var restored *resolver.Interface
+
+	BeforeEach(func() {
+		serviceImport := newAggregatedServiceImport(namespace1, service1)
+		setClusterWeight(serviceImport, clusterID1, 3)
+		setClusterWeight(serviceImport, clusterID2, 1)
+		t.resolver.PutServiceImport(serviceImport)
+
+		t.putEndpointSlice(newClusterIPEndpointSlice(namespace1, service1, clusterID1, serviceIP1, true, port1, port2))
+		t.putEndpointSlice(newClusterIPEndpointSlice(namespace1, service1, clusterID2, serviceIP2, true, port1))
+		t.putEndpointSlice(newClusterIPEndpointSlice(namespace1, service1, clusterID3, serviceIP3, false, port1))
+
+		t.resolver.PutServiceImport(newHeadlessAggregatedServiceImport(namespace1, headless))
+		t.putEndpointSlice(newEndpointSlice(namespace1, headless, clusterID1, []mcsv1a1.ServicePort{port1},
+			discovery.Endpoint{
+				Addresses:  []string{endpointIP1},
+				Hostname:   &hostName1,
+				Conditions: discovery.EndpointConditions{Ready: &ready},
+			},
+			discovery.Endpoint{
+				Addresses:  []string{endpointIP2},
+				Hostname:   &hostName2,
+				Conditions: discovery.EndpointConditions{Ready: &ready},
+			}))
+
+		data, err := t.resolver.MarshalBinary()
+		Expect(err).To(Succeed())
+
+		restored = resolver.New(fake.NewClusterStatus("", clusterID1, clusterID2, clusterID3), nil)
+		Expect(restored.UnmarshalBinary(data)).To(Succeed())
+	})
+
+	It("should restore the same ClusterIP service resolution", func() {
+		expected := map[string]int{}
+		actual := map[string]int{}
+
+		for i := 0; i < 8; i++ {
+			records, _, found := t.resolver.GetDNSRecords(namespace1, service1, "", "")
+			Expect(found).To(BeTrue())
+			expected[records[0].IP]++
+
+			records, _, found = restored.GetDNSRecords(namespace1, service1, "", "")
+			Expect(found).To(BeTrue())
+			actual[records[0].IP]++
+
+			Expect(records[0].Ports).To(Equal([]mcsv1a1.ServicePort{port1}))
+		}
+
+		Expect(actual).To(Equal(expected))
+		Expect(actual).To(Equal(map[string]int{serviceIP1: 6, serviceIP2: 2}))
+	})
+
+	It("should restore the time each service was first seen", func() {
+		expected, found := t.resolver.FirstSeen(namespace1, service1)
+		Expect(found).To(BeTrue())
+
+		firstSeen, found := restored.FirstSeen(namespace1, service1)
+		Expect(found).To(BeTrue())
+		Expect(firstSeen.Equal(expected)).To(BeTrue())
+	})
+
+	It("should restore the same headless service resolution", func() {
+		expected, _, _ := t.resolver.GetDNSRecords(namespace1, headless, clusterID1, hostName2)
+
+		records, isHeadless, found := restored.GetDNSRecords(namespace1, headless, clusterID1, hostName2)
+		Expect(found).To(BeTrue())
+		Expect(isHeadless).To(BeTrue())
+		Expect(records).To(Equal(expected))
+
+		expected, _, _ = t.resolver.GetDNSRecords(namespace1, headless, "", "")
+		records, _, _ = restored.GetDNSRecords(namespace1, headless, "", "")
+		Expect(records).To(ConsistOf(expected))
+	})
+
+	It("should replace any existing state", func() {
+		other := resolver.New(fake.NewClusterStatus(""), nil)
+		other.PutServiceImport(newAggregatedServiceImport(namespace2, service1))
+
+		data, err := restored.MarshalBinary()
+		Expect(err).To(Succeed())
+		Expect(other.UnmarshalBinary(data)).To(Succeed())
+
+		_, _, found := other.GetDNSRecords(namespace2, service1, "", "")
+		Expect(found).To(BeFalse())
+	})
+
+	It("should be more compact than the JSON encoding of the same records", func() {
+		r := resolver.New(fake.NewClusterStatus("", clusterID1, clusterID2, clusterID3), nil)
+
+		var records []resolver.DNSRecord
+
+		for i := 0; i < 50; i++ {
+			name := fmt.Sprintf("service-%d", i)
+			r.PutServiceImport(newAggregatedServiceImport(namespace1, name))
+
+			for _, clusterID := range []string{clusterID1, clusterID2, clusterID3} {
+				r.PutEndpointSlices(newClusterIPEndpointSlice(namespace1, name, clusterID, fmt.Sprintf("10.%d.0.1", i), true,
+					port1, port2))
+
+				found, _, _ := r.GetDNSRecords(namespace1, name, clusterID, "")
+				records = append(records, found...)
+			}
+		}
+
+		data, err := r.MarshalBinary()
+		Expect(err).To(Succeed())
+
+		jsonData, err := json.Marshal(records)
+		Expect(err).To(Succeed())
+
+		Expect(len(data)).To(BeNumerically("<", len(jsonData)))
+	})
+
+	When("the data is invalid", func() {
+		It("should return an error and retain the existing state", func() {
+			Expect(restored.UnmarshalBinary([]byte("invalid"))).ToNot(Succeed())
+
+			_, _, found := restored.GetDNSRecords(namespace1, service1, "", "")
+			Expect(found).To(BeTrue())
+		})
+	})
+})
+
+var _ = Describe("Fingerprint", func() {
+	t := newTestDriver()
+
+	BeforeEach(func() {
+		t.resolver.PutServiceImport(newAggregatedServiceImport(namespace1, service1))
+		t.putEndpointSlice(newClusterIPEndpointSlice(namespace1, service1, clusterID1, serviceIP1, true, port1))
+		t.putEndpointSlice(newClusterIPEndpointSlice(namespace1, service1, clusterID2, serviceIP2, true, port1))
+	})
+
+	fingerprint := func() string {
+		f, found := t.resolver.Fingerprint(namespace1, service1)
+		Expect(found).To(BeTrue())
+		Expect(f).ToNot(BeEmpty())
+
+		return f
+	}
+
+	It("should be stable while nothing changes", func() {
+		f := fingerprint()
+
+		t.getNonHeadlessDNSRecord(namespace1, service1, "")
+		t.putEndpointSlice(newClusterIPEndpointSlice(namespace1, service1, clusterID1, serviceIP1, true, port1))
+		t.resolver.PutServiceImport(newAggregatedServiceImport(namespace1, service1))
+
+		Expect(fingerprint()).To(Equal(f))
+	})
+
+	It("should change when a cluster's record changes", func() {
+		f := fingerprint()
+		t.putEndpointSlice(newClusterIPEndpointSlice(namespace1, service1, clusterID1, serviceIP3, true, port1))
+		Expect(fingerprint()).ToNot(Equal(f))
+	})
+
+	It("should change when the ports change", func() {
+		f := fingerprint()
+		t.putEndpointSlice(newClusterIPEndpointSlice(namespace1, service1, clusterID1, serviceIP1, true, port1, port2))
+		Expect(fingerprint()).ToNot(Equal(f))
+	})
+
+	It("should change when a weight changes", func() {
+		f := fingerprint()
+
+		serviceImport := newAggregatedServiceImport(namespace1, service1)
+		setClusterWeight(serviceImport, clusterID1, 5)
+		t.resolver.PutServiceImport(serviceImport)
+
+		Expect(fingerprint()).ToNot(Equal(f))
+	})
+
+	It("should change when a cluster's health changes", func() {
+		f := fingerprint()
+		t.putEndpointSlice(newClusterIPEndpointSlice(namespace1, service1, clusterID1, serviceIP1, false, port1))
+		Expect(fingerprint()).ToNot(Equal(f))
+	})
+
+	It("should change when a cluster is added or removed", func() {
+		f := fingerprint()
+
+		t.putEndpointSlice(newClusterIPEndpointSlice(namespace1, service1, clusterID3, serviceIP3, true, port1))
+		added := fingerprint()
+		Expect(added).ToNot(Equal(f))
+
+		t.resolver.RemoveEndpointSlice(newClusterIPEndpointSlice(namespace1, service1, clusterID3, serviceIP3, true, port1))
+		Expect(fingerprint()).To(Equal(f))
+	})
+
+	It("should change when the service type changes", func() {
+		f := fingerprint()
+
+		t.resolver.RemoveServiceImport(newAggregatedServiceImport(namespace1, service1))
+		t.resolver.PutServiceImport(newHeadlessAggregatedServiceImport(namespace1, service1))
+
+		Expect(fingerprint()).ToNot(Equal(f))
+	})
+
+	It("should differ for services with different state", func() {
+		t.resolver.PutServiceImport(newAggregatedServiceImport(namespace2, service1))
+		t.putEndpointSlice(newClusterIPEndpointSlice(namespace2, service1, clusterID1, serviceIP1, true, port1))
+
+		f, found := t.resolver.Fingerprint(namespace2, service1)
+		Expect(found).To(BeTrue())
+		Expect(f).ToNot(Equal(fingerprint()))
+	})
+
+	When("the service doesn't exist", func() {
+		It("should return not found", func() {
+			_, found := t.resolver.Fingerprint(namespace2, service1)
+			Expect(found).To(BeFalse())
+		})
+	})
+})
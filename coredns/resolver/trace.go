@@ -0,0 +1,77 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+
+Copyright Contributors to the Submariner project.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package resolver
+
+import (
+	"sort"
+	"time"
+)
+
+// SetTrace enables or disables emitting a structured log entry with the decision of every resolution of the given
+// ClusterIP service. The entries are logged at the info level to the trace logger, see WithTraceLogger.
+func (i *Interface) SetTrace(namespace, name string, enabled bool) {
+	i.mutex.Lock()
+	defer i.mutex.Unlock()
+
+	key := i.serviceKey(namespace, name)
+
+	if !enabled {
+		delete(i.traced, key)
+		return
+	}
+
+	if i.traced == nil {
+		i.traced = map[string]bool{}
+	}
+
+	i.traced[key] = true
+}
+
+// traceResolution logs the decision of a resolution of the given service if tracing is enabled for it. The candidates
+// are all the service's clusters and the skipped ones are those that weren't eligible for load balancing, ie
+// disconnected, without healthy endpoints or drained by a zero load balancer weight, eg due to a traffic shift.
+func (i *Interface) traceResolution(key string, serviceInfo *serviceInfo, record *DNSRecord, reason ResolutionReason,
+	duration time.Duration,
+) {
+	if !i.traced[key] {
+		return
+	}
+
+	candidates := make([]string, 0, len(serviceInfo.clusters))
+	skipped := []string{}
+
+	for name, info := range serviceInfo.clusters {
+		candidates = append(candidates, name)
+
+		if !i.isClusterHealthy(name, info) || serviceInfo.balancedWeights[name] <= 0 {
+			skipped = append(skipped, name)
+		}
+	}
+
+	sort.Strings(candidates)
+	sort.Strings(skipped)
+
+	chosen := ""
+	if record != nil {
+		chosen = record.ClusterName
+	}
+
+	i.traceLogger.Info("Resolution decision", "service", key, "candidates", candidates, "skipped", skipped, "chosen", chosen,
+		"reason", string(reason), "duration", duration)
+}
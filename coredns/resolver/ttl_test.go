@@ -0,0 +1,216 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+
+Copyright Contributors to the Submariner project.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package resolver_test
+
+import (
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/submariner-io/lighthouse/coredns/constants"
+	"github.com/submariner-io/lighthouse/coredns/resolver"
+	testingclock "k8s.io/utils/clock/testing"
+)
+
+var _ = Describe("RecordTTL", func() {
+	const (
+		minTTL = 5 * time.Second
+		maxTTL = time.Minute
+	)
+
+	fakeClock := testingclock.NewFakeClock(time.Now())
+	t := newTestDriver(resolver.WithClock(fakeClock), resolver.WithDynamicTTL(minTTL, maxTTL))
+
+	BeforeEach(func() {
+		t.resolver.PutServiceImport(newAggregatedServiceImport(namespace1, service1))
+		t.putEndpointSlice(newClusterIPEndpointSlice(namespace1, service1, clusterID1, serviceIP1, true, port1))
+	})
+
+	When("the service has just changed", func() {
+		It("should return the minimum TTL", func() {
+			Expect(t.getRecordTTL()).To(Equal(minTTL))
+		})
+	})
+
+	When("the service has been stable for a while", func() {
+		It("should return a TTL that grows with the stable period", func() {
+			fakeClock.Step(20 * time.Second)
+			Expect(t.getRecordTTL()).To(Equal(20 * time.Second))
+
+			fakeClock.Step(20 * time.Second)
+			Expect(t.getRecordTTL()).To(Equal(40 * time.Second))
+		})
+	})
+
+	When("the service has been stable for longer than the maximum TTL", func() {
+		It("should return the maximum TTL", func() {
+			fakeClock.Step(time.Hour)
+			Expect(t.getRecordTTL()).To(Equal(maxTTL))
+		})
+	})
+
+	When("an EndpointSlice is put after a stable period", func() {
+		It("should shorten the TTL", func() {
+			fakeClock.Step(time.Hour)
+			t.putEndpointSlice(newClusterIPEndpointSlice(namespace1, service1, clusterID2, serviceIP2, true, port1))
+			Expect(t.getRecordTTL()).To(Equal(minTTL))
+		})
+	})
+
+	When("an EndpointSlice is removed after a stable period", func() {
+		It("should shorten the TTL", func() {
+			fakeClock.Step(time.Hour)
+			t.resolver.RemoveEndpointSlice(newClusterIPEndpointSlice(namespace1, service1, clusterID1, serviceIP1, true))
+			Expect(t.getRecordTTL()).To(Equal(minTTL))
+		})
+	})
+
+	When("the service doesn't exist", func() {
+		It("should return false", func() {
+			_, ok := t.resolver.RecordTTL(namespace2, service1)
+			Expect(ok).To(BeFalse())
+		})
+	})
+})
+
+var _ = Describe("RecordTTL when dynamic TTLs aren't enabled", func() {
+	t := newTestDriver()
+
+	BeforeEach(func() {
+		t.resolver.PutServiceImport(newAggregatedServiceImport(namespace1, service1))
+	})
+
+	It("should return false", func() {
+		_, ok := t.resolver.RecordTTL(namespace1, service1)
+		Expect(ok).To(BeFalse())
+	})
+})
+
+var _ = Describe("RecordTypeTTL", func() {
+	t := newTestDriver()
+
+	BeforeEach(func() {
+		serviceImport := newAggregatedServiceImport(namespace1, service1)
+		serviceImport.Annotations = map[string]string{
+			constants.RecordTTLAnnotationPrefix + "/a":   "300",
+			constants.RecordTTLAnnotationPrefix + "/SRV": "10",
+			constants.RecordTTLAnnotationPrefix + "/TXT": "invalid",
+		}
+
+		t.resolver.PutServiceImport(serviceImport)
+	})
+
+	It("should return the TTL configured for each record type", func() {
+		ttl, found := t.resolver.RecordTypeTTL(namespace1, service1, "A")
+		Expect(found).To(BeTrue())
+		Expect(ttl).To(Equal(uint32(300)))
+
+		ttl, found = t.resolver.RecordTypeTTL(namespace1, service1, "srv")
+		Expect(found).To(BeTrue())
+		Expect(ttl).To(Equal(uint32(10)))
+	})
+
+	It("should not return a TTL for a record type that isn't validly configured", func() {
+		_, found := t.resolver.RecordTypeTTL(namespace1, service1, "TXT")
+		Expect(found).To(BeFalse())
+
+		_, found = t.resolver.RecordTypeTTL(namespace1, service1, "AAAA")
+		Expect(found).To(BeFalse())
+	})
+
+	When("the ServiceImport annotations are removed", func() {
+		BeforeEach(func() {
+			t.resolver.PutServiceImport(newAggregatedServiceImport(namespace1, service1))
+		})
+
+		It("should no longer return the TTLs", func() {
+			_, found := t.resolver.RecordTypeTTL(namespace1, service1, "A")
+			Expect(found).To(BeFalse())
+		})
+	})
+
+	When("the service doesn't exist", func() {
+		It("should return false", func() {
+			_, found := t.resolver.RecordTypeTTL(namespace2, service1, "A")
+			Expect(found).To(BeFalse())
+		})
+	})
+})
+
+var _ = Describe("DNSRecord TTL", func() {
+	t := newTestDriver()
+
+	putServiceImport := func(annotations map[string]string) {
+		serviceImport := newAggregatedServiceImport(namespace1, service1)
+		serviceImport.Annotations = annotations
+		t.resolver.PutServiceImport(serviceImport)
+	}
+
+	JustBeforeEach(func() {
+		t.putEndpointSlice(newClusterIPEndpointSlice(namespace1, service1, clusterID1, serviceIP1, true, port1))
+	})
+
+	When("the ServiceImport specifies a TTL", func() {
+		BeforeEach(func() {
+			putServiceImport(map[string]string{constants.RecordTTLAnnotation: "30"})
+		})
+
+		It("should return records with the TTL", func() {
+			Expect(t.getNonHeadlessDNSRecord(namespace1, service1, "").TTL).To(Equal(uint32(30)))
+			Expect(t.getNonHeadlessDNSRecord(namespace1, service1, clusterID1).TTL).To(Equal(uint32(30)))
+		})
+
+		Context("and it's subsequently changed", func() {
+			It("should return records with the new TTL", func() {
+				previous := t.getNonHeadlessDNSRecord(namespace1, service1, "")
+
+				putServiceImport(map[string]string{constants.RecordTTLAnnotation: "1"})
+				Expect(t.getNonHeadlessDNSRecord(namespace1, service1, "").TTL).To(Equal(uint32(1)))
+				Expect(previous.TTL).To(Equal(uint32(30)))
+			})
+		})
+	})
+
+	When("the ServiceImport doesn't specify a TTL", func() {
+		BeforeEach(func() {
+			putServiceImport(nil)
+		})
+
+		It("should return records without a TTL", func() {
+			Expect(t.getNonHeadlessDNSRecord(namespace1, service1, "").TTL).To(BeZero())
+		})
+	})
+
+	When("the ServiceImport specifies an invalid TTL", func() {
+		BeforeEach(func() {
+			putServiceImport(map[string]string{constants.RecordTTLAnnotation: "invalid"})
+		})
+
+		It("should return records without a TTL", func() {
+			Expect(t.getNonHeadlessDNSRecord(namespace1, service1, "").TTL).To(BeZero())
+		})
+	})
+})
+
+func (t *testDriver) getRecordTTL() time.Duration {
+	ttl, ok := t.resolver.RecordTTL(namespace1, service1)
+	Expect(ok).To(BeTrue())
+
+	return ttl
+}
@@ -19,18 +19,60 @@ limitations under the License.
 package resolver
 
 import (
+	"context"
 	"sync"
+	"time"
 
+	"github.com/go-logr/logr"
 	"github.com/submariner-io/lighthouse/coredns/loadbalancer"
+	corev1 "k8s.io/api/core/v1"
+	discovery "k8s.io/api/discovery/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/wait"
 	"k8s.io/client-go/dynamic"
+	"k8s.io/utils/clock"
 	mcsv1a1 "sigs.k8s.io/mcs-api/pkg/apis/v1alpha1"
 )
 
 type Interface struct {
-	serviceMap    map[string]*serviceInfo
-	clusterStatus ClusterStatus
-	client        dynamic.Interface
-	mutex         sync.RWMutex
+	shards                   []*serviceShard
+	servicesByIP             map[string]map[string]int
+	ipIndexMutex             sync.Mutex
+	clusterStatus            ClusterStatus
+	client                   dynamic.Interface
+	resolutionSink           ResolutionSink
+	resolutions              chan ResolutionOutcome
+	droppedResolutions       uint64
+	requestedClusterFallback bool
+	normalizeClusterNames    bool
+	emptyEndpointsEviction   time.Duration
+	recencyPenalty           float64
+	recencyPenaltyDecay      time.Duration
+	recencyBoostIdlePeriod   time.Duration
+	selectionSubset          int
+	maxCandidates            int
+	costAwareSelection       bool
+	localRegion              string
+	healthDecay              healthDecay
+	maxWeight                int64
+	localWeight              localWeight
+	replicaID                string
+	random                   *randomSource
+	negativeCache            *negativeCache
+	portsEmptyCallback       func(namespace, name string, empty bool)
+	changeHandler            func(key string, clusters []string)
+	localClusterID           string
+	trafficShift             trafficShift
+	aliases                  map[string]string
+	traced                   map[string]bool
+	traceLogger              logr.Logger
+	newBalancer              func() loadbalancer.Interface
+	balancerRetry            balancerRetry
+	minTTL                   time.Duration
+	maxTTL                   time.Duration
+	clock                    clock.PassiveClock
+	inFlightLease            time.Duration
+	mutex                    sync.RWMutex
 }
 
 type ClusterStatus interface {
@@ -38,23 +80,268 @@ type ClusterStatus interface {
 	GetLocalClusterID() string
 }
 
+type ResolutionReason string
+
+const (
+	// ResolvedLocal indicates the local cluster's record was returned.
+	ResolvedLocal ResolutionReason = "local"
+	// ResolvedBalanced indicates the record was selected by the load balancer.
+	ResolvedBalanced ResolutionReason = "balanced"
+	// ResolvedClusterPinned indicates the record of the specifically requested cluster was returned.
+	ResolvedClusterPinned ResolutionReason = "cluster-pinned"
+	// ResolvedPinned indicates the record of the cluster the service was pinned to via Pin was returned.
+	ResolvedPinned ResolutionReason = "pinned"
+	// ResolvedPreferred indicates the record of a cluster in the caller's preference order was returned.
+	ResolvedPreferred ResolutionReason = "preferred"
+	// ResolvedLastResort indicates no cluster was healthy and a record was returned regardless, as configured via the
+	// "lighthouse.submariner.io/on-no-healthy" annotation.
+	ResolvedLastResort ResolutionReason = "last-resort"
+	// ResolvedLeastDrained indicates every healthy cluster was drained and the least drained one's record was returned,
+	// as configured via the "lighthouse.submariner.io/on-all-drained" annotation.
+	ResolvedLeastDrained ResolutionReason = "least-drained"
+	// ResolvedNone indicates no record was returned.
+	ResolvedNone ResolutionReason = "none"
+)
+
+// LookupStatus is the outcome of looking up a service's records via LookupDNSRecords.
+type LookupStatus string
+
+const (
+	// LookupFound indicates records were returned.
+	LookupFound LookupStatus = "found"
+	// LookupNotFound indicates the service, or the requested cluster or host name, doesn't exist.
+	LookupNotFound LookupStatus = "not-found"
+	// LookupEmpty indicates the service exists but has no records available, eg because its clusters are disconnected.
+	LookupEmpty LookupStatus = "empty"
+	// LookupUnhealthy indicates the ClusterIP service exists but the endpoints of all its connected clusters are
+	// unhealthy.
+	LookupUnhealthy LookupStatus = "unhealthy"
+)
+
+type ResolutionOutcome struct {
+	Namespace string
+	Name      string
+	Cluster   string
+	Reason    ResolutionReason
+	Latency   time.Duration
+}
+
+// Resolution is the structured result of resolving a ClusterIP service.
+type Resolution struct {
+	// Record is the selected cluster's record, or nil if none was selected.
+	Record *DNSRecord
+	// Cluster is the name of the selected cluster, or empty if none was selected.
+	Cluster string
+	Reason  ResolutionReason
+	// CacheKey identifies the answer. It changes whenever the answer could change, so clients may cache by it.
+	CacheKey string
+}
+
+// EndpointsCheck checks the endpoints of the given clusters of a service at once and returns whether each is serving.
+// Clusters missing from the result are considered not serving.
+type EndpointsCheck func(namespace, name string, clusterIDs []string) map[string]bool
+
+// ContextEndpointCheck checks whether the endpoints of the given cluster of a service are serving. It should return
+// promptly once the given context is done.
+type ContextEndpointCheck func(ctx context.Context, namespace, name, clusterID string) bool
+
+type ResolutionSink interface {
+	Report(outcome ResolutionOutcome)
+}
+
+// DNSRecord is a resolved record. For a ClusterIP service, IPs contains all of the service's IPs, one per IP family for a
+// dual-stack service, with the primary first, and HostName is the cluster-qualified "<cluster>.<service>.<namespace>"
+// name and TTL is the service's record TTL in seconds, or zero if the service doesn't specify one, in which case the
+// server's configured TTL applies. For a headless service, HostName is the endpoint's host name, if any.
 type DNSRecord struct {
 	IP          string
+	IPs         []string
 	Ports       []mcsv1a1.ServicePort
 	HostName    string
 	ClusterName string
+	TTL         uint32
+}
+
+// IPv4 returns the record's first IPv4 address, or an empty string if it has none. Callers that only handle IPv4 can
+// use this regardless of whether the service is dual-stack.
+func (r *DNSRecord) IPv4() string {
+	return r.ipOfAddressType(discovery.AddressTypeIPv4)
+}
+
+// IPv6 returns the record's first IPv6 address, or an empty string if it has none.
+func (r *DNSRecord) IPv6() string {
+	return r.ipOfAddressType(discovery.AddressTypeIPv6)
+}
+
+// clone returns a copy of the record that shares no state with it, so a record prepared by the resolver can be handed
+// to callers.
+func (r *DNSRecord) clone() *DNSRecord {
+	c := *r
+	c.IPs = append([]string(nil), r.IPs...)
+	c.Ports = append([]mcsv1a1.ServicePort(nil), r.Ports...)
+
+	return &c
+}
+
+func (r *DNSRecord) ipOfAddressType(addressType discovery.AddressType) string {
+	ips := r.IPs
+	if len(ips) == 0 {
+		ips = []string{r.IP}
+	}
+
+	for _, ip := range ips {
+		if addressTypeOf(ip) == addressType {
+			return ip
+		}
+	}
+
+	return ""
 }
 
 type clusterInfo struct {
-	endpointRecords       []DNSRecord
+	endpointRecords []DNSRecord
+	// mergedRecords are copies of the endpointRecords with the service's merged ports, returned by lookups so they don't
+	// allocate. They're replaced rather than modified when recomputed so records already returned remain unchanged.
+	mergedRecords         []DNSRecord
+	ports                 []mcsv1a1.ServicePort
 	endpointRecordsByHost map[string][]DNSRecord
 	weight                int64
+	inFlightMutex         sync.Mutex
+	inFlightExpiries      []time.Time
+	lastSelected          int64
+	selectionSeq          int64
 	endpointsHealthy      bool
+	emptySince            time.Time
+	addedAt               time.Time
+	checkFailures         int
+	// readyEndpoints is the number of ready endpoints backing the cluster's service, if readyEndpointsKnown, as reported
+	// via UpdateEndpointCount.
+	readyEndpoints      int
+	readyEndpointsKnown bool
+}
+
+// healthDecay configures lowering the load balancing weight of clusters that fail endpoint checks. Each consecutive
+// failure multiplies the weight by the factor and each success forgives the given number of failures.
+type healthDecay struct {
+	factor   float64
+	recovery int
+}
+
+// balancerRetry configures retrying adding clusters to a service's load balancer. The mutex is the resolver's, which
+// is taken to add the clusters.
+type balancerRetry struct {
+	backoff wait.Backoff
+	mutex   *sync.RWMutex
+}
+
+// localWeight configures scaling the load balancing weight of the local cluster by the multiplier. The local cluster is
+// looked up whenever the weights are computed as it may change.
+type localWeight struct {
+	multiplier int64
+	cluster    func() string
+}
+
+type trafficShift struct {
+	cluster string
+	percent int64
+}
+
+// selectionFilter restricts the clusters and records eligible when resolving a ClusterIP service.
+type selectionFilter struct {
+	addressType   discovery.AddressType
+	selector      labels.Selector
+	clusterLabels map[string]labels.Set
+	clientIP      string
+	checkCluster  func(clusterID string) bool
+	exclude       map[string]bool
+	portName      string
+	protocol      corev1.Protocol
+	preferred     []string
 }
 
 type serviceInfo struct {
-	clusters   map[string]*clusterInfo
-	balancer   loadbalancer.Interface
-	isHeadless bool
-	ports      []mcsv1a1.ServicePort
+	clusters map[string]*clusterInfo
+	balancer loadbalancer.Interface
+	// balancerMutex serializes lookups' use of the balancer, whose selection state Next, Skip and ItemsFor mutate, as
+	// lookups only hold the shard's lock for reading. Changes to the balancer hold the shard's lock for writing.
+	balancerMutex         sync.Mutex
+	balancerPolicy        string
+	isHeadless            bool
+	ports                 []mcsv1a1.ServicePort
+	portMerge             string
+	portsVersion          uint64
+	selectionSeq          int64
+	lastChanged           time.Time
+	firstSeen             time.Time
+	version               uint64
+	remoteOnlyResolutions int64
+	localSelections       int64
+	remoteSelections      int64
+	queryCount            uint64
+	trafficShift          *trafficShift
+	recordTTLs            map[string]uint32
+	recordTTL             uint32
+	weights               map[string]int64
+	maxInFlight           map[string]int64
+	inFlightLease         time.Duration
+	healthDecay           healthDecay
+	maxWeight             int64
+	localWeight           *localWeight
+	clock                 clock.PassiveClock
+	costs                 map[string]int64
+	regions               map[string]string
+	ignoreLocal           bool
+	weightByEndpoints     bool
+	serveLastResort       bool
+	onAllDrained          string
+	clusterLabels         map[string]labels.Set
+	minShare              float64
+	balancerRetry         *balancerRetry
+	balancerGeneration    uint64
+	unbalancedClusters    []string
+	balancedWeights       map[string]int64
+	pinnedCluster         string
+	portsEmpty            bool
+	portConflict          bool
+	affinity              sessionAffinity
+	replicaID             string
+	random                *randomSource
+}
+
+// GlobalStats summarizes the health of all services. A cluster backing multiple services is counted once per service.
+type GlobalStats struct {
+	Services             int
+	HeadlessServices     int
+	ClusterSetIPServices int
+	Clusters             int
+	HealthyClusters      int
+	UnhealthyClusters    int
+}
+
+// ServiceStats counts the services and the DNS records of their clusters, as returned by Stats.
+type ServiceStats struct {
+	Services             int
+	HeadlessServices     int
+	ClusterSetIPServices int
+	ClusterRecords       int
+}
+
+type ClusterWeight struct {
+	Cluster string
+	Weight  int64
+}
+
+// ClusterInfo describes a cluster backing a service as returned by ListClusters.
+type ClusterInfo struct {
+	Cluster string
+	Weight  int64
+	IsLocal bool
+}
+
+// ClusterDump is the state of a service's cluster as returned by Dump.
+type ClusterDump struct {
+	Cluster          string
+	Weight           int64
+	EndpointsHealthy bool
+	Records          []DNSRecord
 }
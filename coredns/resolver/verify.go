@@ -0,0 +1,138 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+
+Copyright Contributors to the Submariner project.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package resolver
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Verify checks the consistency of the resolver's state, returning an error describing any problems found. Currently
+// this detects the same service IP being used by more than one ClusterIP service, which makes routing ambiguous and
+// typically indicates a misconfigured IP allocation.
+func (i *Interface) Verify() error {
+	i.ipIndexMutex.Lock()
+	defer i.ipIndexMutex.Unlock()
+
+	ips := make([]string, 0)
+	for ip, services := range i.servicesByIP {
+		if len(services) > 1 {
+			ips = append(ips, ip)
+		}
+	}
+
+	if len(ips) == 0 {
+		return nil
+	}
+
+	sort.Strings(ips)
+
+	problems := make([]string, len(ips))
+	for j, ip := range ips {
+		problems[j] = fmt.Sprintf("IP %q is used by services %s", ip, strings.Join(i.servicesUsingIP(ip), ", "))
+	}
+
+	return fmt.Errorf("service IP reuse detected: %s", strings.Join(problems, "; "))
+}
+
+// updateIPIndex updates the index of the ClusterIP services using each service IP for a cluster of the given service
+// whose records changed from previous to records, and logs an error for each of the new records whose IP is also used
+// by another ClusterIP service. The caller must hold the lock of the service's shard.
+func (i *Interface) updateIPIndex(key string, previous, records []DNSRecord) {
+	i.ipIndexMutex.Lock()
+	defer i.ipIndexMutex.Unlock()
+
+	for j := range previous {
+		ip := previous[j].IP
+
+		i.servicesByIP[ip][key]--
+		if i.servicesByIP[ip][key] <= 0 {
+			delete(i.servicesByIP[ip], key)
+		}
+
+		if len(i.servicesByIP[ip]) == 0 {
+			delete(i.servicesByIP, ip)
+		}
+	}
+
+	for j := range records {
+		ip := records[j].IP
+		i.indexIP(ip, key)
+
+		for _, otherKey := range i.servicesUsingIP(ip) {
+			if otherKey != key {
+				logger.Errorf(nil, "Service IP %q of %q on cluster %q is also used by service %q - routing will be ambiguous",
+					ip, key, records[j].ClusterName, otherKey)
+			}
+		}
+	}
+}
+
+// removeFromIPIndex removes the records of all the given ClusterIP service's clusters from the IP index. The caller must
+// hold the lock of the service's shard.
+func (i *Interface) removeFromIPIndex(key string, serviceInfo *serviceInfo) {
+	if serviceInfo.isHeadless {
+		return
+	}
+
+	for _, info := range serviceInfo.clusters {
+		i.updateIPIndex(key, info.endpointRecords, nil)
+	}
+}
+
+// rebuildIPIndex rebuilds the IP index from the services. The caller must hold the resolver's lock for writing.
+func (i *Interface) rebuildIPIndex() {
+	i.ipIndexMutex.Lock()
+	defer i.ipIndexMutex.Unlock()
+
+	i.servicesByIP = map[string]map[string]int{}
+
+	i.forEachService(func(key string, serviceInfo *serviceInfo) {
+		if serviceInfo.isHeadless {
+			return
+		}
+
+		for _, info := range serviceInfo.clusters {
+			for j := range info.endpointRecords {
+				i.indexIP(info.endpointRecords[j].IP, key)
+			}
+		}
+	})
+}
+
+func (i *Interface) indexIP(ip, key string) {
+	if i.servicesByIP[ip] == nil {
+		i.servicesByIP[ip] = map[string]int{}
+	}
+
+	i.servicesByIP[ip][key]++
+}
+
+// servicesUsingIP returns the sorted keys of the ClusterIP services using the given service IP.
+func (i *Interface) servicesUsingIP(ip string) []string {
+	services := make([]string, 0, len(i.servicesByIP[ip]))
+	for key := range i.servicesByIP[ip] {
+		services = append(services, key)
+	}
+
+	sort.Strings(services)
+
+	return services
+}
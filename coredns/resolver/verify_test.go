@@ -0,0 +1,115 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+
+Copyright Contributors to the Submariner project.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package resolver_test
+
+import (
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/submariner-io/lighthouse/coredns/resolver"
+	"github.com/submariner-io/lighthouse/coredns/resolver/fake"
+)
+
+var _ = Describe("Verify", func() {
+	const service2 = "service2"
+
+	t := newTestDriver()
+
+	BeforeEach(func() {
+		t.resolver.PutServiceImport(newAggregatedServiceImport(namespace1, service1))
+		t.putEndpointSlice(newClusterIPEndpointSlice(namespace1, service1, clusterID1, serviceIP1, true, port1))
+		t.putEndpointSlice(newClusterIPEndpointSlice(namespace1, service1, clusterID2, serviceIP2, true, port1))
+
+		t.resolver.PutServiceImport(newAggregatedServiceImport(namespace1, service2))
+	})
+
+	When("no IP is shared between services", func() {
+		BeforeEach(func() {
+			t.putEndpointSlice(newClusterIPEndpointSlice(namespace1, service2, clusterID1, serviceIP3, true, port1))
+		})
+
+		It("should succeed", func() {
+			Expect(t.resolver.Verify()).To(Succeed())
+		})
+	})
+
+	When("two services share an IP", func() {
+		BeforeEach(func() {
+			t.putEndpointSlice(newClusterIPEndpointSlice(namespace1, service2, clusterID3, serviceIP2, true, port1))
+		})
+
+		It("should return an error identifying the IP and services", func() {
+			err := t.resolver.Verify()
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring(serviceIP2))
+			Expect(err.Error()).To(ContainSubstring(namespace1 + "/" + service1 + ", " + namespace1 + "/" + service2))
+			Expect(err.Error()).ToNot(ContainSubstring(serviceIP1))
+		})
+
+		Context("and the conflicting cluster is removed", func() {
+			BeforeEach(func() {
+				t.resolver.RemoveEndpointSlice(newClusterIPEndpointSlice(namespace1, service2, clusterID3, serviceIP2, true))
+			})
+
+			It("should succeed", func() {
+				Expect(t.resolver.Verify()).To(Succeed())
+			})
+		})
+
+		Context("and the conflicting service is removed", func() {
+			BeforeEach(func() {
+				t.resolver.RemoveServiceImport(newAggregatedServiceImport(namespace1, service2))
+			})
+
+			It("should succeed", func() {
+				Expect(t.resolver.Verify()).To(Succeed())
+			})
+		})
+
+		Context("and the conflicting cluster's IP changes", func() {
+			BeforeEach(func() {
+				t.putEndpointSlice(newClusterIPEndpointSlice(namespace1, service2, clusterID3, serviceIP3, true, port1))
+			})
+
+			It("should succeed", func() {
+				Expect(t.resolver.Verify()).To(Succeed())
+			})
+		})
+
+		Context("and the state is restored from a snapshot", func() {
+			It("should still return an error", func() {
+				data, err := t.resolver.MarshalBinary()
+				Expect(err).To(Succeed())
+
+				restored := resolver.New(fake.NewClusterStatus(""), nil)
+				Expect(restored.UnmarshalBinary(data)).To(Succeed())
+				Expect(restored.Verify()).To(HaveOccurred())
+			})
+		})
+	})
+
+	When("the same IP is put again for the same service", func() {
+		BeforeEach(func() {
+			t.putEndpointSlice(newClusterIPEndpointSlice(namespace1, service1, clusterID1, serviceIP1, false, port1))
+		})
+
+		It("should succeed", func() {
+			Expect(t.resolver.Verify()).To(Succeed())
+		})
+	})
+})